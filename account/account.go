@@ -0,0 +1,107 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores anything past 72 bytes.
+	maxPasswordLength = 72
+)
+
+// List of account errors.
+var (
+	ErrNotFound           = errors.New("account not found")
+	ErrExists             = errors.New("an account with this name or a similar one already exists")
+	ErrInvalidCredentials = errors.New("invalid name or password")
+	ErrPasswordLength     = fmt.Errorf("password must be between %d and %d characters", minPasswordLength, maxPasswordLength)
+)
+
+// Account holds the credentials of a registered user.
+type Account struct {
+	ID           xid.ID
+	Name         string
+	PasswordHash []byte
+	Role         user.Role
+	CreatedAt    time.Time
+}
+
+// New creates a new Account with a hashed password.
+func New(name, password string) (*Account, error) {
+	name, err := user.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, ErrPasswordLength
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	return &Account{
+		ID:           xid.New(),
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC(),
+	}, nil
+}
+
+// CheckPassword checks if the password matches the account.
+func (a *Account) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)) == nil
+}
+
+// User returns the chat user of the account.
+func (a *Account) User() *user.User {
+	return &user.User{
+		ID:   a.ID,
+		Name: a.Name,
+		Role: a.Role,
+	}
+}
+
+// Store persists accounts.
+type Store interface {
+	// Create adds a new account.
+	// It returns ErrExists if an account with a look-alike name exists.
+	Create(ctx context.Context, a *Account) error
+	// Get finds an account by ID.
+	Get(ctx context.Context, id xid.ID) (*Account, error)
+	// FindByName finds an account by its name (or a look-alike name).
+	FindByName(ctx context.Context, name string) (*Account, error)
+	// Update replaces an existing account.
+	Update(ctx context.Context, a *Account) error
+}
+
+// Authenticate finds the account matching the credentials.
+func Authenticate(ctx context.Context, s Store, name, password string) (*Account, error) {
+	a, err := s.FindByName(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		// Compare against a dummy hash to not leak which names exist through timing.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return a, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
@@ -0,0 +1,93 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		password string
+		wantName string
+		wantErr  error
+	}{
+		{name: "valid", input: "  Alice ", password: "correct horse", wantName: "Alice"},
+		{name: "longest password", input: "Alice", password: strings.Repeat("a", maxPasswordLength), wantName: "Alice"},
+		{name: "invalid name", input: "<Alice>", password: "correct horse", wantErr: user.ErrNameCharacters},
+		{name: "reserved name", input: "m0d", password: "correct horse", wantErr: user.ErrNameReserved},
+		{name: "short password", input: "Alice", password: "short", wantErr: ErrPasswordLength},
+		{name: "long password", input: "Alice", password: strings.Repeat("a", maxPasswordLength+1), wantErr: ErrPasswordLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.input, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if a.Name != tt.wantName {
+				t.Fatalf("got name %q, want %q", a.Name, tt.wantName)
+			}
+			if a.Role != user.RoleMember {
+				t.Fatalf("got role %q, want %q", a.Role, user.RoleMember)
+			}
+			if string(a.PasswordHash) == tt.password {
+				t.Fatal("password is not hashed")
+			}
+			if !a.CheckPassword(tt.password) {
+				t.Fatal("password does not match")
+			}
+			if a.CheckPassword("x" + tt.password) {
+				t.Fatal("wrong password matches")
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s, err := NewFileStore(t.TempDir() + "/accounts.json")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	alice, err := New("Alice", "correct horse")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := s.Create(context.Background(), alice); err != nil {
+		t.Fatalf("store account: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		password string
+		wantErr  error
+	}{
+		{name: "valid", input: "Alice", password: "correct horse"},
+		{name: "look-alike name", input: "ALlCE", password: "correct horse"},
+		{name: "wrong password", input: "Alice", password: "wrong horse", wantErr: ErrInvalidCredentials},
+		{name: "unknown name", input: "Bob", password: "correct horse", wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Authenticate(context.Background(), s, tt.input, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && a.ID != alice.ID {
+				t.Fatalf("got account %s, want %s", a.Name, alice.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// FileStore is a Store which keeps accounts in memory
+// and persists them as a JSON file on every change.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	accounts map[string]*Account
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a new FileStore loading existing accounts from path.
+// The file is created on the first change if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:     path,
+		accounts: make(map[string]*Account),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read accounts file: %w", err)
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("decode accounts file: %w", err)
+	}
+
+	for _, a := range accounts {
+		s.accounts[a.ID.String()] = a
+	}
+
+	return s, nil
+}
+
+// Create implements the Store interface.
+func (s *FileStore) Create(_ context.Context, a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findByName(a.Name) != nil {
+		return ErrExists
+	}
+
+	cp := *a
+	s.accounts[a.ID.String()] = &cp
+
+	return s.save()
+}
+
+// Get implements the Store interface.
+func (s *FileStore) Get(_ context.Context, id xid.ID) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, found := s.accounts[id.String()]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	cp := *a
+	return &cp, nil
+}
+
+// FindByName implements the Store interface.
+func (s *FileStore) FindByName(_ context.Context, name string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a := s.findByName(name)
+	if a == nil {
+		return nil, ErrNotFound
+	}
+
+	cp := *a
+	return &cp, nil
+}
+
+// Update implements the Store interface.
+func (s *FileStore) Update(_ context.Context, a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.accounts[a.ID.String()]; !found {
+		return ErrNotFound
+	}
+
+	cp := *a
+	s.accounts[a.ID.String()] = &cp
+
+	return s.save()
+}
+
+func (s *FileStore) findByName(name string) *Account {
+	skeleton := user.Skeleton(name)
+	for _, a := range s.accounts {
+		if user.Skeleton(a.Name) == skeleton {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// save writes all the accounts to a temporary file and renames it
+// so that the accounts file is never partially written.
+func (s *FileStore) save() error {
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode accounts: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary accounts file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary accounts file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary accounts file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename temporary accounts file: %w", err)
+	}
+
+	return nil
+}
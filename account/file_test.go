@@ -0,0 +1,111 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file created before any change: %v", err)
+	}
+
+	alice, err := New("Alice", "correct horse")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := s.Create(ctx, alice); err != nil {
+		t.Fatalf("store account: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "same name", input: "Alice"},
+		{name: "other case", input: "alice"},
+		{name: "look-alike", input: "ALlCE"},
+		{name: "other name", input: "Bob", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := s.FindByName(ctx, tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("find: got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && a.ID != alice.ID {
+				t.Fatalf("found %s, want %s", a.Name, alice.Name)
+			}
+
+			if err == nil {
+				dup, err := New(tt.input, "correct horse")
+				if err != nil {
+					t.Fatalf("create account: %v", err)
+				}
+				if err := s.Create(ctx, dup); !errors.Is(err, ErrExists) {
+					t.Fatalf("create: got error %v, want %v", err, ErrExists)
+				}
+			}
+		})
+	}
+
+	// Accounts are copied so that changes are only kept through Update.
+	a, err := s.Get(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	a.Role = user.RoleModerator
+	if a, _ := s.Get(ctx, alice.ID); a.Role != user.RoleMember {
+		t.Fatal("account changed without an update")
+	}
+	if err := s.Update(ctx, a); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := s.Get(ctx, xid.New()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get unknown: got error %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Update(ctx, &Account{ID: xid.New(), Name: "Bob"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update unknown: got error %v, want %v", err, ErrNotFound)
+	}
+
+	// Accounts are loaded back, and the temporary files are removed.
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reload store: %v", err)
+	}
+	a, err = s.Get(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("get after reload: %v", err)
+	}
+	if a.Name != alice.Name || a.Role != user.RoleModerator || !a.CheckPassword("correct horse") {
+		t.Fatalf("got %+v after reload", a)
+	}
+	if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+		t.Fatalf("temporary files left: %v", files)
+	}
+}
+
+func TestNewFileStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := NewFileStore(path); err == nil {
+		t.Fatal("corrupted file loaded")
+	}
+}
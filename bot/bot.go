@@ -0,0 +1,297 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// TokenPrefix marks the API keys of bots so that they are not mistaken for session tokens.
+const TokenPrefix = "bot_"
+
+// lastUseResolution is the precision at which the last use of a key is recorded
+// to avoid persisting the store on every request.
+const lastUseResolution = time.Minute
+
+type botContextKey string
+
+const botCtxKey botContextKey = "bot"
+
+// List of bot errors.
+var (
+	ErrNotFound       = errors.New("bot not found")
+	ErrKeyNotFound    = errors.New("api key not found")
+	ErrExists         = errors.New("a bot with this name or a similar one already exists")
+	ErrInvalidKey     = errors.New("invalid api key")
+	ErrInvalidScope   = errors.New("api key scope must name at least one room and one action")
+	ErrUnknownAction  = errors.New("unknown action")
+	ErrInvalidLimit   = errors.New("rate limit burst and interval must be positive")
+	ErrAlreadyRevoked = errors.New("api key already revoked")
+)
+
+// Action is something a bot can do with an API key.
+type Action string
+
+// List of actions.
+const (
+	ActionPost  Action = "post"
+	ActionRead  Action = "read"
+	ActionReact Action = "react"
+)
+
+// Actions is the list of all actions.
+var Actions = []Action{ActionPost, ActionRead, ActionReact}
+
+// AllRooms is the room scope granting access to every room.
+const AllRooms = "*"
+
+// Scope restricts what an API key can do.
+type Scope struct {
+	Rooms   []string
+	Actions []Action
+}
+
+// Validate checks that the scope grants something and only known actions.
+func (s Scope) Validate() error {
+	if len(s.Rooms) == 0 || len(s.Actions) == 0 {
+		return ErrInvalidScope
+	}
+
+	for _, a := range s.Actions {
+		if !slices.Contains(Actions, a) {
+			return fmt.Errorf("%w: %q", ErrUnknownAction, a)
+		}
+	}
+
+	return nil
+}
+
+// Allows checks if the scope grants the action in the room.
+func (s Scope) Allows(room string, a Action) bool {
+	return s.HasRoom(room) && slices.Contains(s.Actions, a)
+}
+
+// HasRoom checks if the scope grants any action in the room.
+func (s Scope) HasRoom(room string) bool {
+	return slices.Contains(s.Rooms, AllRooms) || slices.Contains(s.Rooms, room)
+}
+
+// RateLimit is the rate limiting policy of a bot: Burst requests per Interval.
+type RateLimit struct {
+	Burst    int64
+	Interval time.Duration
+}
+
+// Key is an API key of a bot. Only the hash of its secret is kept.
+type Key struct {
+	ID         xid.ID
+	Hash       []byte
+	Scope      Scope
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// IsRevoked checks if the key has been revoked.
+func (k *Key) IsRevoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// Bot is an automated user posting through the API with its own keys.
+type Bot struct {
+	ID        xid.ID
+	Name      string
+	CreatedBy xid.ID
+	// RateLimit is the policy of the bot, nil when the bot is not rate limited.
+	RateLimit *RateLimit
+	Keys      []*Key
+	CreatedAt time.Time
+}
+
+// New creates a new Bot without any key.
+func New(name string, createdBy xid.ID, limit *RateLimit) (*Bot, error) {
+	name, err := user.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit != nil && (limit.Burst <= 0 || limit.Interval <= 0) {
+		return nil, ErrInvalidLimit
+	}
+
+	return &Bot{
+		ID:        xid.New(),
+		Name:      name,
+		CreatedBy: createdBy,
+		RateLimit: limit,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// User returns the chat user of the bot.
+func (b *Bot) User() *user.User {
+	return &user.User{
+		ID:   b.ID,
+		Name: b.Name,
+		Role: user.RoleMember,
+		Bot:  true,
+	}
+}
+
+// NewKey adds a new API key to the bot.
+// The returned token is the only time the secret of the key is available.
+func (b *Bot) NewKey(scope Scope) (*Key, string, error) {
+	if err := scope.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("generate api key: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	k := &Key{
+		ID:        xid.New(),
+		Hash:      hash(encoded),
+		Scope:     scope,
+		CreatedAt: time.Now().UTC(),
+	}
+	b.Keys = append(b.Keys, k)
+
+	return k, TokenPrefix + k.ID.String() + "_" + encoded, nil
+}
+
+// Key finds a key of the bot by ID.
+func (b *Bot) Key(id xid.ID) (*Key, bool) {
+	i := slices.IndexFunc(b.Keys, func(k *Key) bool { return k.ID == id })
+	if i == -1 {
+		return nil, false
+	}
+
+	return b.Keys[i], true
+}
+
+// RevokeKey revokes a key of the bot.
+func (b *Bot) RevokeKey(id xid.ID) error {
+	k, found := b.Key(id)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	if k.IsRevoked() {
+		return ErrAlreadyRevoked
+	}
+	k.RevokedAt = time.Now().UTC()
+
+	return nil
+}
+
+// clone deep copies the bot so that changing the copy does not affect the original.
+func (b *Bot) clone() *Bot {
+	cp := *b
+	if b.RateLimit != nil {
+		limit := *b.RateLimit
+		cp.RateLimit = &limit
+	}
+
+	cp.Keys = make([]*Key, 0, len(b.Keys))
+	for _, k := range b.Keys {
+		kc := *k
+		kc.Scope.Rooms = slices.Clone(k.Scope.Rooms)
+		kc.Scope.Actions = slices.Clone(k.Scope.Actions)
+		cp.Keys = append(cp.Keys, &kc)
+	}
+
+	return &cp
+}
+
+// Store persists bots.
+type Store interface {
+	// Create adds a new bot.
+	// It returns ErrExists if a bot with a look-alike name exists.
+	Create(ctx context.Context, b *Bot) error
+	// Get finds a bot by ID.
+	Get(ctx context.Context, id xid.ID) (*Bot, error)
+	// List returns all the bots.
+	List(ctx context.Context) ([]*Bot, error)
+	// FindByKey finds the bot owning an API key.
+	FindByKey(ctx context.Context, keyID xid.ID) (*Bot, error)
+	// Update replaces an existing bot.
+	Update(ctx context.Context, b *Bot) error
+}
+
+// Authenticate finds the bot and the key matching an API key token
+// and records the use of the key.
+func Authenticate(ctx context.Context, s Store, token string) (*Bot, *Key, error) {
+	rawID, secret, found := strings.Cut(strings.TrimPrefix(token, TokenPrefix), "_")
+	if !found || !IsToken(token) {
+		return nil, nil, ErrInvalidKey
+	}
+
+	id, err := xid.FromString(rawID)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	b, err := s.FindByKey(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k, _ := b.Key(id)
+	if subtle.ConstantTimeCompare(k.Hash, hash(secret)) != 1 || k.IsRevoked() {
+		return nil, nil, ErrInvalidKey
+	}
+
+	if now := time.Now().UTC(); now.Sub(k.LastUsedAt) >= lastUseResolution {
+		k.LastUsedAt = now
+		if err := s.Update(ctx, b); err != nil {
+			return nil, nil, fmt.Errorf("record api key use: %w", err)
+		}
+	}
+
+	return b, k, nil
+}
+
+// IsToken checks if a bearer token is an API key rather than a session token.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+// authenticated is a bot authenticated with one of its keys.
+type authenticated struct {
+	bot *Bot
+	key *Key
+}
+
+// AddToContext adds a bot and the API key authenticating a request to the context.
+func AddToContext(ctx context.Context, b *Bot, k *Key) context.Context {
+	return context.WithValue(ctx, botCtxKey, authenticated{bot: b, key: k})
+}
+
+// FromContext retrieves the bot and the API key authenticating a request from the context.
+// It returns nils for requests which are not authenticated by a bot.
+func FromContext(ctx context.Context) (*Bot, *Key) {
+	a, _ := ctx.Value(botCtxKey).(authenticated)
+	return a.bot, a.key
+}
+
+// hash hashes the secret of a key. Secrets are random so a fast hash is enough.
+func hash(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
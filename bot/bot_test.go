@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		limit   *RateLimit
+		wantErr error
+	}{
+		{name: "valid", input: " Relay  Bot "},
+		{name: "rate limited", input: "Relay", limit: &RateLimit{Burst: 5, Interval: time.Minute}},
+		{name: "reserved name", input: "Admin", wantErr: user.ErrNameReserved},
+		{name: "too short", input: "R", wantErr: user.ErrNameLength},
+		{name: "no burst", input: "Relay", limit: &RateLimit{Interval: time.Minute}, wantErr: ErrInvalidLimit},
+		{name: "negative interval", input: "Relay", limit: &RateLimit{Burst: 5, Interval: -time.Minute}, wantErr: ErrInvalidLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(tt.input, xid.New(), tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if want, _ := user.NormalizeName(tt.input); b.Name != want {
+				t.Fatalf("got name %q, want %q", b.Name, want)
+			}
+			if u := b.User(); !u.Bot || u.ID != b.ID || u.Role != user.RoleMember {
+				t.Fatalf("got user %+v", u)
+			}
+		})
+	}
+}
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		scope      Scope
+		wantErr    error
+		allowed    []string
+		disallowed []string
+	}{
+		{
+			name:       "one room",
+			scope:      Scope{Rooms: []string{"general"}, Actions: []Action{ActionRead, ActionPost}},
+			allowed:    []string{"general/read", "general/post"},
+			disallowed: []string{"general/react", "random/read"},
+		},
+		{
+			name:       "all rooms",
+			scope:      Scope{Rooms: []string{AllRooms}, Actions: []Action{ActionReact}},
+			allowed:    []string{"general/react", "random/react"},
+			disallowed: []string{"general/post"},
+		},
+		{name: "no room", scope: Scope{Actions: []Action{ActionRead}}, wantErr: ErrInvalidScope},
+		{name: "no action", scope: Scope{Rooms: []string{"general"}}, wantErr: ErrInvalidScope},
+		{name: "unknown action", scope: Scope{Rooms: []string{"general"}, Actions: []Action{"delete"}}, wantErr: ErrUnknownAction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.scope.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			for _, ra := range tt.allowed {
+				room, a, _ := strings.Cut(ra, "/")
+				if !tt.scope.Allows(room, Action(a)) || !tt.scope.HasRoom(room) {
+					t.Errorf("%s is not allowed", ra)
+				}
+			}
+			for _, ra := range tt.disallowed {
+				room, a, _ := strings.Cut(ra, "/")
+				if tt.scope.Allows(room, Action(a)) {
+					t.Errorf("%s is allowed", ra)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	b, err := New("Relay", xid.New(), nil)
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	k, _, err := b.NewKey(Scope{Rooms: []string{"general"}, Actions: []Action{ActionRead}})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		id      xid.ID
+		wantErr error
+	}{
+		{name: "key", id: k.ID},
+		{name: "revoked key", id: k.ID, wantErr: ErrAlreadyRevoked},
+		{name: "unknown key", id: xid.New(), wantErr: ErrKeyNotFound},
+	}
+
+	// The cases run in order since revoking a key changes the bot.
+	for _, tt := range tests {
+		if err := b.RevokeKey(tt.id); !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if !k.IsRevoked() {
+		t.Fatal("key is not revoked")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// token changes the token of a valid key, returning it as is when nil.
+		token   func(token string) string
+		revoke  bool
+		wantErr error
+	}{
+		{name: "valid"},
+		{name: "wrong secret", token: func(token string) string { return token + "x" }, wantErr: ErrInvalidKey},
+		{name: "revoked", revoke: true, wantErr: ErrInvalidKey},
+		{name: "session token", token: func(token string) string { return strings.TrimPrefix(token, TokenPrefix) }, wantErr: ErrInvalidKey},
+		{name: "no secret", token: func(string) string { return TokenPrefix + xid.New().String() }, wantErr: ErrInvalidKey},
+		{name: "malformed key id", token: func(string) string { return TokenPrefix + "nope_secret" }, wantErr: ErrInvalidKey},
+		{name: "unknown key", token: func(string) string { return TokenPrefix + xid.New().String() + "_secret" }, wantErr: ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewFileStore("")
+			if err != nil {
+				t.Fatalf("create store: %v", err)
+			}
+			b, err := New("Relay", xid.New(), nil)
+			if err != nil {
+				t.Fatalf("create bot: %v", err)
+			}
+			k, token, err := b.NewKey(Scope{Rooms: []string{"general"}, Actions: []Action{ActionPost}})
+			if err != nil {
+				t.Fatalf("create key: %v", err)
+			}
+			if want := TokenPrefix + k.ID.String() + "_"; !strings.HasPrefix(token, want) || !IsToken(token) {
+				t.Fatalf("got token %q, want prefix %q", token, want)
+			}
+			if tt.revoke {
+				if err := b.RevokeKey(k.ID); err != nil {
+					t.Fatalf("revoke: %v", err)
+				}
+			}
+			if err := s.Create(ctx, b); err != nil {
+				t.Fatalf("store bot: %v", err)
+			}
+
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+			gotBot, gotKey, err := Authenticate(ctx, s, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if gotBot.ID != b.ID || gotKey.ID != k.ID {
+				t.Fatalf("got bot %s and key %s, want %s and %s", gotBot.ID, gotKey.ID, b.ID, k.ID)
+			}
+
+			// The use of the key is recorded, at most once per resolution.
+			stored, _ := s.Get(ctx, b.ID)
+			used, _ := stored.Key(k.ID)
+			if used.LastUsedAt.IsZero() {
+				t.Fatal("use of the key is not recorded")
+			}
+			if _, again, err := Authenticate(ctx, s, token); err != nil || !again.LastUsedAt.Equal(used.LastUsedAt) {
+				t.Fatalf("got last use %v and error %v, want %v", again.LastUsedAt, err, used.LastUsedAt)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if b, k := FromContext(ctx); b != nil || k != nil {
+		t.Fatal("bot found in an empty context")
+	}
+
+	b := &Bot{ID: xid.New()}
+	k := &Key{ID: xid.New()}
+	if gotBot, gotKey := FromContext(AddToContext(ctx, b, k)); gotBot != b || gotKey != k {
+		t.Fatal("bot not found in the context")
+	}
+}
@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// FileStore is a Store which keeps bots in memory
+// and persists them as a JSON file on every change.
+type FileStore struct {
+	mu   sync.RWMutex
+	path string
+	bots map[string]*Bot
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a new FileStore loading existing bots from path.
+// The file is created on the first change if it does not exist.
+// An empty path keeps the bots in memory only.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path: path,
+		bots: make(map[string]*Bot),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read bots file: %w", err)
+	}
+
+	var bots []*Bot
+	if err := json.Unmarshal(data, &bots); err != nil {
+		return nil, fmt.Errorf("decode bots file: %w", err)
+	}
+
+	for _, b := range bots {
+		s.bots[b.ID.String()] = b
+	}
+
+	return s, nil
+}
+
+// Create implements the Store interface.
+func (s *FileStore) Create(_ context.Context, b *Bot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	skeleton := user.Skeleton(b.Name)
+	for _, existing := range s.bots {
+		if user.Skeleton(existing.Name) == skeleton {
+			return ErrExists
+		}
+	}
+
+	s.bots[b.ID.String()] = b.clone()
+
+	return s.save()
+}
+
+// Get implements the Store interface.
+func (s *FileStore) Get(_ context.Context, id xid.ID) (*Bot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, found := s.bots[id.String()]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return b.clone(), nil
+}
+
+// List implements the Store interface.
+func (s *FileStore) List(_ context.Context) ([]*Bot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bots := make([]*Bot, 0, len(s.bots))
+	for _, b := range s.bots {
+		bots = append(bots, b.clone())
+	}
+
+	return bots, nil
+}
+
+// FindByKey implements the Store interface.
+func (s *FileStore) FindByKey(_ context.Context, keyID xid.ID) (*Bot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, b := range s.bots {
+		if _, found := b.Key(keyID); found {
+			return b.clone(), nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// Update implements the Store interface.
+func (s *FileStore) Update(_ context.Context, b *Bot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.bots[b.ID.String()]; !found {
+		return ErrNotFound
+	}
+
+	s.bots[b.ID.String()] = b.clone()
+
+	return s.save()
+}
+
+// save writes all the bots to a temporary file and renames it
+// so that the bots file is never partially written.
+func (s *FileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	bots := make([]*Bot, 0, len(s.bots))
+	for _, b := range s.bots {
+		bots = append(bots, b)
+	}
+
+	data, err := json.MarshalIndent(bots, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode bots: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary bots file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary bots file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary bots file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename temporary bots file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		path func(t *testing.T) string
+	}{
+		{name: "file", path: func(t *testing.T) string { return filepath.Join(t.TempDir(), "bots.json") }},
+		{name: "memory", path: func(*testing.T) string { return "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path(t)
+			s, err := NewFileStore(path)
+			if err != nil {
+				t.Fatalf("create store: %v", err)
+			}
+
+			relay, err := New("Relay", xid.New(), nil)
+			if err != nil {
+				t.Fatalf("create bot: %v", err)
+			}
+			if err := s.Create(ctx, relay); err != nil {
+				t.Fatalf("store bot: %v", err)
+			}
+			for _, name := range []string{"Relay", "relay", "RE1AY"} {
+				dup, err := New(name, xid.New(), nil)
+				if err != nil {
+					t.Fatalf("create bot: %v", err)
+				}
+				if err := s.Create(ctx, dup); !errors.Is(err, ErrExists) {
+					t.Fatalf("create %q: got error %v, want %v", name, err, ErrExists)
+				}
+			}
+
+			// Bots are copied so that changes are only kept through Update.
+			b, err := s.Get(ctx, relay.ID)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			k, _, err := b.NewKey(Scope{Rooms: []string{"general"}, Actions: []Action{ActionRead}})
+			if err != nil {
+				t.Fatalf("create key: %v", err)
+			}
+			if _, err := s.FindByKey(ctx, k.ID); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("find key before update: got error %v, want %v", err, ErrNotFound)
+			}
+			if err := s.Update(ctx, b); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			k.Scope.Rooms[0] = "random"
+			found, err := s.FindByKey(ctx, k.ID)
+			if err != nil {
+				t.Fatalf("find key: %v", err)
+			}
+			if stored, _ := found.Key(k.ID); found.ID != relay.ID || stored.Scope.Rooms[0] != "general" {
+				t.Fatalf("got bot %s with scope %v", found.Name, stored.Scope)
+			}
+
+			if _, err := s.Get(ctx, xid.New()); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("get unknown: got error %v, want %v", err, ErrNotFound)
+			}
+			if err := s.Update(ctx, &Bot{ID: xid.New(), Name: "Other"}); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("update unknown: got error %v, want %v", err, ErrNotFound)
+			}
+			if bots, _ := s.List(ctx); len(bots) != 1 {
+				t.Fatalf("got %d bots, want 1", len(bots))
+			}
+
+			if path == "" {
+				return
+			}
+
+			// Bots are loaded back, and the temporary files are removed.
+			s, err = NewFileStore(path)
+			if err != nil {
+				t.Fatalf("reload store: %v", err)
+			}
+			found, err = s.FindByKey(ctx, k.ID)
+			if err != nil {
+				t.Fatalf("find key after reload: %v", err)
+			}
+			if stored, _ := found.Key(k.ID); found.Name != relay.Name || string(stored.Hash) != string(k.Hash) {
+				t.Fatalf("got %+v after reload", found)
+			}
+			if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+				t.Fatalf("temporary files left: %v", files)
+			}
+		})
+	}
+}
+
+func TestNewFileStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := NewFileStore(path); err == nil {
+		t.Fatal("corrupted file loaded")
+	}
+}
@@ -2,7 +2,9 @@ package chat
 
 import (
 	"container/ring"
+	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -12,7 +14,6 @@ import (
 	"github.com/mgjules/chat-demo/user"
 	"github.com/rs/xid"
 	"golang.org/x/exp/slog"
-	"golang.org/x/net/websocket"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -30,6 +31,15 @@ var (
 	ErrMessageEmpty    = NewError(ErrorSeverityError, false, "message content cannot be empty")
 	ErrExistingSession = NewError(ErrorSeverityError, true, "you already have a running session for this room")
 	ErrRoomFull        = NewError(ErrorSeverityError, true, "the room is full")
+	ErrMuted           = NewError(ErrorSeverityError, false, "you have been muted")
+	ErrKicked          = NewError(ErrorSeverityError, true, "you have been kicked from the room")
+	ErrNameTaken       = NewError(ErrorSeverityError, false, "this name or a similar one is already in use")
+	ErrUserNotFound    = NewError(ErrorSeverityError, false, "no such user in the room")
+	ErrSessionRevoked  = NewError(ErrorSeverityError, true, "your session has been revoked")
+	ErrSessionExpired  = NewError(ErrorSeverityError, true, "your session has expired")
+	ErrMessageNotFound = NewError(ErrorSeverityError, false, "message not found")
+	ErrInvalidReaction = NewError(ErrorSeverityError, false, "invalid reaction")
+	ErrNotAllowed      = NewError(ErrorSeverityError, false, "you are not allowed to do this in this room")
 )
 
 // ErrorSeverity is the severity of an error.
@@ -67,15 +77,61 @@ func (e Error) Error() string {
 	return e.err
 }
 
+// MessageKind is the kind of a chat message.
+type MessageKind uint8
+
+// List of message kinds.
+const (
+	MessageKindText MessageKind = iota
+	MessageKindAction
+	MessageKindSystem
+)
+
 // Message represents a single chat message.
+// Messages are read without locks: edits replace them with a new version.
 type Message struct {
+	ID      xid.ID
 	User    *user.User
+	Kind    MessageKind
 	Content string
 	Time    time.Time
+	// Edited is when the content was last edited, zero if it never was.
+	Edited time.Time
+
+	// state is shared by the versions of the message.
+	state *messageState
 }
 
+// messageState holds what changes without a new version of a message.
+type messageState struct {
+	// mu guards the reactions and the readers.
+	mu        sync.RWMutex
+	reactions []*Reaction
+	readers   map[xid.ID]struct{}
+}
+
+// IsAction checks if the message is an action (e.g. /me).
+func (m *Message) IsAction() bool { return m.Kind == MessageKindAction }
+
+// IsSystem checks if the message is a system notice.
+func (m *Message) IsSystem() bool { return m.Kind == MessageKindSystem }
+
 // NewMessage creates a new Message.
 func NewMessage(u *user.User, content string) (*Message, error) {
+	return newMessage(u, MessageKindText, content)
+}
+
+// NewAction creates a new action Message (e.g. "/me waves").
+func NewAction(u *user.User, content string) (*Message, error) {
+	return newMessage(u, MessageKindAction, content)
+}
+
+// NewSystemMessage creates a new system Message which is not authored by any user.
+func NewSystemMessage(content string) (*Message, error) {
+	return newMessage(nil, MessageKindSystem, content)
+}
+
+func newMessage(u *user.User, kind MessageKind, content string) (*Message, error) {
 	content = strings.TrimSpace(content)
 	if content == "" {
 		return nil, ErrMessageEmpty
@@ -89,16 +145,35 @@ func NewMessage(u *user.User, content string) (*Message, error) {
 	content = goaway.Censor(emoji.Parse(content))
 
 	return &Message{
+		ID:      xid.New(),
 		User:    u,
+		Kind:    kind,
 		Content: content,
 		Time:    time.Now().UTC(),
+		state:   &messageState{},
 	}, nil
 }
 
-// Client represents the relationship between a user and websocket connections.
+// canEdit checks if a user can edit the message: only authors edit their messages.
+func (m *Message) canEdit(u *user.User) bool {
+	return m.User != nil && m.User.ID == u.ID
+}
+
+// canDelete checks if a user can delete the message: authors and moderators of a lower role.
+func (m *Message) canDelete(u *user.User) bool {
+	if m.canEdit(u) {
+		return true
+	}
+
+	return u.HasRole(user.RoleModerator) && (m.User == nil || !m.User.HasRole(u.Role))
+}
+
+// Client represents the relationship between a user and its connection.
 type Client struct {
+	// user is the current version of the user of the connection (e.g. renamed).
 	user *user.User
-	conn *websocket.Conn
+	conn Conn
+	enc  Encoder
 }
 
 // Room holds the state of a single chat room.
@@ -109,40 +184,89 @@ type Room struct {
 	muMessages sync.RWMutex
 	messages   *ring.Ring
 	sem        *semaphore.Weighted
+
+	muRestrictions sync.RWMutex
+	muted          map[string]time.Time
+	kicked         map[string]time.Time
+
+	muObservers sync.RWMutex
+	observers   []Observer
+
+	muMetrics sync.RWMutex
+	metrics   Metrics
+
+	maxClients uint64
+	nameOwner  func(name string) (xid.ID, bool)
+}
+
+// RoomOptions configures a room.
+type RoomOptions struct {
+	// MaxClients is the number of clients the room holds, 1000 when zero.
+	MaxClients uint64
+	// NameOwner finds the user owning a name outside of the room (e.g. a registered account).
+	// Other users cannot take the name nor a look-alike one. Names are only owned by connected users when nil.
+	NameOwner func(name string) (xid.ID, bool)
 }
 
 // NewRoom creates a new Room.
-func NewRoom() *Room {
+func NewRoom(opts RoomOptions) *Room {
+	if opts.MaxClients == 0 {
+		opts.MaxClients = uint64(maxClients)
+	}
+	if opts.NameOwner == nil {
+		opts.NameOwner = func(string) (xid.ID, bool) { return xid.NilID(), false }
+	}
+
 	return &Room{
-		clients:  make(map[string]*Client),
-		messages: ring.New(100),
-		sem:      semaphore.NewWeighted(int64(maxSendWorker)),
+		metrics:    nopMetrics{},
+		maxClients: opts.MaxClients,
+		nameOwner:  opts.NameOwner,
+		clients:    make(map[string]*Client),
+		muted:      make(map[string]time.Time),
+		kicked:     make(map[string]time.Time),
+		messages:   ring.New(100),
+		sem:        semaphore.NewWeighted(int64(maxSendWorker)),
 	}
 }
 
-// AddClient adds a client along with its websocket connection.
-func (r *Room) AddClient(u *user.User, ws *websocket.Conn) error {
-	if _, found := r.GetClient(u.ID); found {
+// AddClient adds the client of a connection along with the encoder of the events sent to it.
+// The user must not be connected already, nor have a name taken by another user (see IsNameTaken).
+func (r *Room) AddClient(conn Conn, enc Encoder) error {
+	u := conn.User()
+	if r.IsKicked(u.ID) {
+		return ErrKicked
+	}
+
+	// Checked under the same lock as the insert so that concurrent joins
+	// cannot exceed the capacity nor take the same name.
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if _, found := r.clients[u.ID.String()]; found {
 		return ErrExistingSession
 	}
 
-	if r.IsAtCapacity() {
+	if uint64(len(r.clients)) >= r.maxClients {
 		return ErrRoomFull
 	}
 
-	r.muClients.Lock()
+	if r.isNameTaken(u.Name, u.ID) {
+		return ErrNameTaken
+	}
+
 	r.clients[u.ID.String()] = &Client{
 		user: u,
-		conn: ws,
+		conn: conn,
+		enc:  enc,
 	}
-	r.muClients.Unlock()
+	r.recorder().ClientAdded()
 
 	return nil
 }
 
 // IsAtCapacity returns true if the room is at capacity.
 func (r *Room) IsAtCapacity() bool {
-	return r.NumUsers() >= uint64(maxClients)
+	return r.NumUsers() >= r.maxClients
 }
 
 // GetClient gets a client.
@@ -155,13 +279,164 @@ func (r *Room) GetClient(id xid.ID) (*Client, bool) {
 
 // RemoveClient removes a client.
 func (r *Room) RemoveClient(id xid.ID) bool {
-	if _, found := r.GetClient(id); !found {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	if _, found := r.clients[id.String()]; !found {
 		return false
 	}
 
-	r.muClients.Lock()
 	delete(r.clients, id.String())
-	r.muClients.Unlock()
+	r.recorder().ClientRemoved()
+
+	return true
+}
+
+// Users returns the users currently connected to the room.
+func (r *Room) Users() []*user.User {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	users := make([]*user.User, 0, len(r.clients))
+	for _, c := range r.clients {
+		users = append(users, c.user)
+	}
+
+	return users
+}
+
+// User returns the current version of a connected user.
+func (r *Room) User(id xid.ID) (*user.User, bool) {
+	client, found := r.GetClient(id)
+	if !found {
+		return nil, false
+	}
+
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	return client.user, true
+}
+
+// IsNameTaken checks if a user other than the given one, connected or owning
+// the name outside of the room, has a name which looks like name.
+func (r *Room) IsNameTaken(name string, except xid.ID) bool {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	return r.isNameTaken(name, except)
+}
+
+// isNameTaken must be called with the clients locked.
+func (r *Room) isNameTaken(name string, except xid.ID) bool {
+	if owner, found := r.nameOwner(name); found && owner != except {
+		return true
+	}
+
+	skeleton := user.Skeleton(name)
+	for _, c := range r.clients {
+		if c.user.ID != except && user.Skeleton(c.user.Name) == skeleton {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FindUser finds a connected user by name (case-insensitive).
+func (r *Room) FindUser(name string) (*user.User, bool) {
+	r.muClients.RLock()
+	defer r.muClients.RUnlock()
+
+	for _, c := range r.clients {
+		if strings.EqualFold(c.user.Name, name) {
+			return c.user, true
+		}
+	}
+
+	return nil, false
+}
+
+// Rename changes the name of a connected user unless it is taken (see IsNameTaken).
+// The user is copied so that existing messages keep the name the author had.
+func (r *Room) Rename(id xid.ID, name string) (*user.User, error) {
+	r.muClients.Lock()
+	defer r.muClients.Unlock()
+
+	client, found := r.clients[id.String()]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	// Checked under the same lock as the rename so that two users cannot take the same name.
+	if r.isNameTaken(name, id) {
+		return nil, ErrNameTaken
+	}
+
+	renamed := *client.user
+	renamed.Name = name
+	client.user = &renamed
+
+	return &renamed, nil
+}
+
+// Kick closes the connection of a client and prevents it from
+// joining the room again for the duration d.
+func (r *Room) Kick(id xid.ID, d time.Duration) bool {
+	client, found := r.GetClient(id)
+	if !found {
+		return false
+	}
+
+	r.muRestrictions.Lock()
+	r.kicked[id.String()] = time.Now().Add(d)
+	r.muRestrictions.Unlock()
+
+	if err := client.conn.Close(); err != nil {
+		slog.Warn("close kicked client connection", "err", err, "user.id", id)
+	}
+
+	return true
+}
+
+// Mute prevents a user from sending messages for the duration d.
+// A zero duration unmutes the user.
+func (r *Room) Mute(id xid.ID, d time.Duration) {
+	r.muRestrictions.Lock()
+	defer r.muRestrictions.Unlock()
+
+	if d <= 0 {
+		delete(r.muted, id.String())
+		return
+	}
+
+	r.muted[id.String()] = time.Now().Add(d)
+}
+
+// IsKicked checks if a user is currently kicked.
+func (r *Room) IsKicked(id xid.ID) bool {
+	return r.isRestricted(r.kicked, id)
+}
+
+// IsMuted checks if a user is currently muted.
+func (r *Room) IsMuted(id xid.ID) bool {
+	return r.isRestricted(r.muted, id)
+}
+
+func (r *Room) isRestricted(restrictions map[string]time.Time, id xid.ID) bool {
+	r.muRestrictions.RLock()
+	until, found := restrictions[id.String()]
+	r.muRestrictions.RUnlock()
+	if !found {
+		return false
+	}
+
+	if time.Now().After(until) {
+		r.muRestrictions.Lock()
+		delete(restrictions, id.String())
+		r.muRestrictions.Unlock()
+		return false
+	}
 
 	return true
 }
@@ -180,46 +455,174 @@ func (r *Room) AddMessage(m *Message) {
 	r.messages.Value = m
 	r.messages = r.messages.Next()
 	r.muMessages.Unlock()
+	r.recorder().MessageAdded()
+}
+
+// Message returns a message of the room by ID.
+func (r *Room) Message(id xid.ID) (*Message, bool) {
+	r.muMessages.RLock()
+	defer r.muMessages.RUnlock()
+
+	var found *Message
+	r.messages.Do(func(m any) {
+		if msg, ok := m.(*Message); ok && msg.ID == id {
+			found = msg
+		}
+	})
+
+	return found, found != nil
+}
+
+// EditMessage replaces the content of a message by its author and returns its new version.
+func (r *Room) EditMessage(id xid.ID, by *user.User, content string) (*Message, error) {
+	r.muMessages.Lock()
+	defer r.muMessages.Unlock()
+
+	e, found := r.messageElement(id)
+	if !found {
+		return nil, ErrMessageNotFound
+	}
+
+	prev := e.Value.(*Message)
+	if !prev.canEdit(by) {
+		return nil, ErrNotAllowed
+	}
+
+	msg, err := newMessage(prev.User, prev.Kind, content)
+	if err != nil {
+		return nil, err
+	}
+	msg.ID, msg.Time, msg.Edited, msg.state = prev.ID, prev.Time, msg.Time, prev.state
+	e.Value = msg
+
+	return msg, nil
 }
 
-// Messages returns the list of messages.
+// DeleteMessage removes a message by its author or a moderator and returns it.
+func (r *Room) DeleteMessage(id xid.ID, by *user.User) (*Message, error) {
+	r.muMessages.Lock()
+	defer r.muMessages.Unlock()
+
+	e, found := r.messageElement(id)
+	if !found {
+		return nil, ErrMessageNotFound
+	}
+
+	msg := e.Value.(*Message)
+	if !msg.canDelete(by) {
+		return nil, ErrNotAllowed
+	}
+	e.Value = nil
+
+	return msg, nil
+}
+
+// messageElement finds the element of the ring holding a message.
+// It must be called with the messages locked.
+func (r *Room) messageElement(id xid.ID) (*ring.Ring, bool) {
+	e := r.messages
+	for i := 0; i < e.Len(); i, e = i+1, e.Next() {
+		if msg, ok := e.Value.(*Message); ok && msg.ID == id {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Messages returns the list of messages, oldest first.
 func (r *Room) Messages() []*Message {
 	r.muMessages.RLock()
 	defer r.muMessages.RUnlock()
 
 	messages := make([]*Message, 0)
 	r.messages.Do(func(m any) {
-		messages = append(messages, m.(*Message))
+		// The slots of a ring which is not full yet are empty.
+		if msg, ok := m.(*Message); ok {
+			messages = append(messages, msg)
+		}
 	})
 
 	return messages
 }
 
-// Write implements the io.Writer interface.
-func (r *Room) Write(p []byte) (int, error) {
-	r.IterateClients(func(u *user.User, conn *websocket.Conn) error {
-		if _, err := conn.Write(p); err != nil {
-			return fmt.Errorf("write: %w", err)
+// Observe registers an observer of the events broadcast in the room.
+func (r *Room) Observe(o Observer) {
+	r.muObservers.Lock()
+	r.observers = append(r.observers, o)
+	r.muObservers.Unlock()
+}
+
+// Instrument records the activity of the room with m from now on.
+func (r *Room) Instrument(m Metrics) {
+	r.muMetrics.Lock()
+	r.metrics = m
+	r.muMetrics.Unlock()
+}
+
+func (r *Room) recorder() Metrics {
+	r.muMetrics.RLock()
+	defer r.muMetrics.RUnlock()
+
+	return r.metrics
+}
+
+// Broadcast sends an event to all the clients except the given users.
+func (r *Room) Broadcast(ctx context.Context, e Event, except ...xid.ID) {
+	r.muObservers.RLock()
+	for _, o := range r.observers {
+		o(ctx, e)
+	}
+	r.muObservers.RUnlock()
+
+	start := time.Now()
+	r.iterate(func(c *Client) error {
+		if slices.Contains(except, c.user.ID) {
+			return nil
 		}
 
-		return nil
+		return c.enc.Encode(ctx, c.conn, c.user, e)
 	})
+	r.recorder().Broadcast(e, time.Since(start))
+}
+
+// Send sends an event to the client of a user.
+func (r *Room) Send(ctx context.Context, id xid.ID, e Event) error {
+	client, found := r.GetClient(id)
+	if !found {
+		return fmt.Errorf("%s: client not found", id)
+	}
+
+	r.muClients.RLock()
+	u := client.user
+	r.muClients.RUnlock()
 
-	return len(p), nil
+	return client.enc.Encode(ctx, client.conn, u, e)
 }
 
 // IterateClients executes a function fn
 // (e.g. a custom send mechanism or personalized messages per client) for all the clients.
-func (r *Room) IterateClients(fn func(u *user.User, conn *websocket.Conn) error) {
+func (r *Room) IterateClients(fn func(u *user.User, conn Conn) error) {
+	r.iterate(func(c *Client) error {
+		return fn(c.user, c.conn)
+	})
+}
+
+func (r *Room) iterate(fn func(c *Client) error) {
 	r.muClients.RLock()
 	defer r.muClients.RUnlock()
 
+	m := r.recorder()
+
 	var wg sync.WaitGroup
 	for _, c := range r.clients {
-		if err := r.sem.Acquire(c.conn.Request().Context(), 1); err != nil {
-			slog.WarnContext(c.conn.Request().Context(), "acquire lock", "err", err, "user.id", c.user.ID)
+		start := time.Now()
+		if err := r.sem.Acquire(c.conn.Context(), 1); err != nil {
+			slog.WarnContext(c.conn.Context(), "acquire lock", "err", err, "user.id", c.user.ID)
+			m.SendFailed()
 			continue
 		}
+		m.SemaphoreWait(time.Since(start))
 
 		wg.Add(1)
 		go func(c *Client) {
@@ -228,8 +631,9 @@ func (r *Room) IterateClients(fn func(u *user.User, conn *websocket.Conn) error)
 				wg.Done()
 			}()
 
-			if err := fn(c.user, c.conn); err != nil {
-				slog.WarnContext(c.conn.Request().Context(), "send message", "err", "user.id", c.user.ID)
+			if err := fn(c); err != nil {
+				slog.WarnContext(c.conn.Context(), "send message", "err", err, "user.id", c.user.ID)
+				m.SendFailed()
 			}
 		}(c)
 	}
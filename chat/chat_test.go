@@ -0,0 +1,413 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestRoomMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		added int
+		want  int
+	}{
+		{name: "empty", added: 0, want: 0},
+		{name: "partly filled", added: 3, want: 3},
+		{name: "full", added: 100, want: 100},
+		{name: "wrapped", added: 150, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{})
+			u := user.New()
+			for i := 0; i < tt.added; i++ {
+				msg, err := NewMessage(u, strconv.Itoa(i))
+				if err != nil {
+					t.Fatalf("new message: %v", err)
+				}
+				room.AddMessage(msg)
+			}
+
+			messages := room.Messages()
+			if len(messages) != tt.want {
+				t.Fatalf("got %d messages, want %d", len(messages), tt.want)
+			}
+
+			// Oldest first, the oldest ones being dropped once the room is full.
+			for i, msg := range messages {
+				if want := strconv.Itoa(tt.added - tt.want + i); msg.Content != want {
+					t.Fatalf("message %d is %q, want %q", i, msg.Content, want)
+				}
+			}
+
+			for _, msg := range messages {
+				if _, found := room.Message(msg.ID); !found {
+					t.Fatalf("message %s not found by ID", msg.ID)
+				}
+			}
+		})
+	}
+}
+
+type nopEncoder struct{}
+
+func (nopEncoder) Encode(context.Context, io.Writer, *user.User, Event) error { return nil }
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+// join connects a new user to the room.
+func join(t *testing.T, room *Room, name string) *user.User {
+	t.Helper()
+
+	u := user.NewNamed(name)
+	if err := room.AddClient(NewConn(context.Background(), u, nopWriteCloser{}), nopEncoder{}); err != nil {
+		t.Fatalf("join %s: %v", name, err)
+	}
+
+	return u
+}
+
+func TestRoomAddClient(t *testing.T) {
+	owner := xid.New()
+
+	tests := []struct {
+		name string
+		// user returns the user joining the room once Bob joined it.
+		user    func(bob *user.User) *user.User
+		wantErr error
+	}{
+		{name: "free name", user: func(*user.User) *user.User { return user.NewNamed("Alice") }},
+		{name: "existing session", user: func(bob *user.User) *user.User { return bob }, wantErr: ErrExistingSession},
+		{name: "connected user", user: func(*user.User) *user.User { return user.NewNamed("Bob") }, wantErr: ErrNameTaken},
+		{name: "look-alike of a connected user", user: func(*user.User) *user.User { return user.NewNamed("B0B") }, wantErr: ErrNameTaken},
+		{name: "owned name", user: func(*user.User) *user.User { return user.NewNamed("Dave") }, wantErr: ErrNameTaken},
+		{name: "own name", user: func(*user.User) *user.User { return &user.User{ID: owner, Name: "Dave"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{
+				NameOwner: func(name string) (xid.ID, bool) {
+					return owner, user.Skeleton(name) == user.Skeleton("Dave")
+				},
+			})
+			bob := join(t, room, "Bob")
+
+			err := room.AddClient(NewConn(context.Background(), tt.user(bob), nopWriteCloser{}), nopEncoder{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			want := uint64(2)
+			if err != nil {
+				want = 1
+			}
+			if n := room.NumUsers(); n != want {
+				t.Fatalf("got %d users, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestRoomAddClientConcurrently(t *testing.T) {
+	tests := []struct {
+		name string
+		// userName returns the name of the i-th user joining.
+		userName   func(i int) string
+		maxClients uint64
+		wantErr    error
+	}{
+		{name: "capacity", userName: func(i int) string { return "User" + strconv.Itoa(i) }, maxClients: 5, wantErr: ErrRoomFull},
+		{name: "same name", userName: func(int) string { return "Alice" }, wantErr: ErrNameTaken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{MaxClients: tt.maxClients})
+
+			// Joins checked at once never let more users in than allowed.
+			var (
+				wg       sync.WaitGroup
+				joined   atomic.Int32
+				rejected atomic.Int32
+			)
+			for i := range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := room.AddClient(NewConn(context.Background(), user.NewNamed(tt.userName(i)), nopWriteCloser{}), nopEncoder{})
+					switch {
+					case err == nil:
+						joined.Add(1)
+					case errors.Is(err, tt.wantErr):
+						rejected.Add(1)
+					default:
+						t.Errorf("got error %v, want %v", err, tt.wantErr)
+					}
+				}()
+			}
+			wg.Wait()
+
+			want := int32(1)
+			if tt.maxClients > 0 {
+				want = int32(tt.maxClients)
+			}
+			if n := joined.Load(); n != want || rejected.Load() != 20-want {
+				t.Fatalf("%d users joined, want %d", n, want)
+			}
+			if n := room.NumUsers(); n != uint64(want) {
+				t.Fatalf("got %d users, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestRoomRename(t *testing.T) {
+	owner := xid.New()
+
+	tests := []struct {
+		name    string
+		rename  string
+		self    bool
+		wantErr error
+	}{
+		{name: "free name", rename: "Carol"},
+		{name: "same name", rename: "Alice"},
+		{name: "other case of the same name", rename: "ALICE"},
+		{name: "connected user", rename: "Bob", wantErr: ErrNameTaken},
+		{name: "look-alike of a connected user", rename: "B0b", wantErr: ErrNameTaken},
+		{name: "owned name", rename: "Dave", wantErr: ErrNameTaken},
+		{name: "look-alike of an owned name", rename: "dave", wantErr: ErrNameTaken},
+		{name: "own name", rename: "Dave", self: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{
+				NameOwner: func(name string) (xid.ID, bool) {
+					return owner, user.Skeleton(name) == user.Skeleton("Dave")
+				},
+			})
+
+			var alice *user.User
+			if tt.self {
+				alice = &user.User{ID: owner, Name: "Alice"}
+				if err := room.AddClient(NewConn(context.Background(), alice, nopWriteCloser{}), nopEncoder{}); err != nil {
+					t.Fatalf("join: %v", err)
+				}
+			} else {
+				alice = join(t, room, "Alice")
+			}
+			join(t, room, "Bob")
+
+			renamed, err := room.Rename(alice.ID, tt.rename)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			want := tt.rename
+			if err != nil {
+				want = "Alice"
+			} else if renamed.Name != tt.rename {
+				t.Fatalf("renamed to %q, want %q", renamed.Name, tt.rename)
+			}
+
+			if u, _ := room.User(alice.ID); u.Name != want {
+				t.Fatalf("user is named %q, want %q", u.Name, want)
+			}
+			if alice.Name != "Alice" {
+				t.Fatal("the previous version of the user was changed")
+			}
+		})
+	}
+
+	t.Run("disconnected user", func(t *testing.T) {
+		room := NewRoom(RoomOptions{})
+		if _, err := room.Rename(xid.New(), "Alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("got error %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestRoomRenameConcurrently(t *testing.T) {
+	room := NewRoom(RoomOptions{})
+	users := make([]*user.User, 20)
+	for i := range users {
+		users[i] = join(t, room, "User"+strconv.Itoa(i))
+	}
+
+	// Only one of the users renaming themselves to the same name at once gets it.
+	var (
+		wg      sync.WaitGroup
+		renamed atomic.Int32
+	)
+	for _, u := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := room.Rename(u.ID, "Alice"); err == nil {
+				renamed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := renamed.Load(); n != 1 {
+		t.Fatalf("%d users were renamed, want 1", n)
+	}
+}
+
+func TestRoomEditMessage(t *testing.T) {
+	alice, bob := user.NewNamed("Alice"), user.NewNamed("Bob")
+	admin := user.NewNamed("Carol")
+	admin.Role = user.RoleAdmin
+
+	tests := []struct {
+		name    string
+		by      *user.User
+		content string
+		unknown bool
+		wantErr error
+	}{
+		{name: "author", by: alice, content: " hello world "},
+		{name: "other user", by: bob, content: "hello world", wantErr: ErrNotAllowed},
+		{name: "admin", by: admin, content: "hello world", wantErr: ErrNotAllowed},
+		{name: "empty", by: alice, content: "  ", wantErr: ErrMessageEmpty},
+		{name: "unknown message", by: alice, content: "hello world", unknown: true, wantErr: ErrMessageNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{})
+			msg, err := NewMessage(alice, "hello")
+			if err != nil {
+				t.Fatalf("new message: %v", err)
+			}
+			room.AddMessage(msg)
+			if err := msg.ToggleReaction(bob.ID, Reactions[0]); err != nil {
+				t.Fatalf("react: %v", err)
+			}
+
+			id := msg.ID
+			if tt.unknown {
+				id = xid.New()
+			}
+			edited, err := room.EditMessage(id, tt.by, tt.content)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			got, _ := room.Message(msg.ID)
+			if err != nil {
+				if got != msg {
+					t.Fatal("message was replaced")
+				}
+				return
+			}
+
+			if got != edited || edited.Content != "hello world" || edited.Edited.IsZero() || edited.Time != msg.Time {
+				t.Fatalf("got message %+v after the edit", got)
+			}
+			if msg.Content != "hello" || !msg.Edited.IsZero() {
+				t.Fatal("the previous version of the message was changed")
+			}
+
+			// The reactions are shared by the versions of the message.
+			if err := msg.ToggleReaction(alice.ID, Reactions[1]); err != nil {
+				t.Fatalf("react: %v", err)
+			}
+			if n := len(edited.Reactions()); n != 2 {
+				t.Fatalf("edited message has %d reactions, want 2", n)
+			}
+		})
+	}
+}
+
+func TestRoomDeleteMessage(t *testing.T) {
+	member := user.NewNamed("Alice")
+	moderator := user.NewNamed("Bob")
+	moderator.Role = user.RoleModerator
+	admin := user.NewNamed("Carol")
+	admin.Role = user.RoleAdmin
+
+	tests := []struct {
+		name    string
+		author  *user.User
+		by      *user.User
+		wantErr error
+	}{
+		{name: "author", author: member, by: member},
+		{name: "moderator deletes member", author: member, by: moderator},
+		{name: "admin deletes moderator", author: moderator, by: admin},
+		{name: "moderator deletes system message", by: moderator},
+		{name: "member deletes other user", author: moderator, by: member, wantErr: ErrNotAllowed},
+		{name: "moderator deletes admin", author: admin, by: moderator, wantErr: ErrNotAllowed},
+		{name: "member deletes system message", by: member, wantErr: ErrNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{})
+			msg, err := newMessage(tt.author, MessageKindText, "hello")
+			if err != nil {
+				t.Fatalf("new message: %v", err)
+			}
+			room.AddMessage(msg)
+
+			_, err = room.DeleteMessage(msg.ID, tt.by)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			_, found := room.Message(msg.ID)
+			if found != (err != nil) {
+				t.Fatalf("message found %t after the deletion", found)
+			}
+			if _, err := room.DeleteMessage(msg.ID, tt.by); err == nil {
+				t.Fatal("message deleted twice")
+			}
+		})
+	}
+
+	t.Run("oldest messages", func(t *testing.T) {
+		room := NewRoom(RoomOptions{})
+		var middle *Message
+		for i := 0; i < 101; i++ {
+			msg, err := NewMessage(member, strconv.Itoa(i))
+			if err != nil {
+				t.Fatalf("new message: %v", err)
+			}
+			if i == 50 {
+				middle = msg
+			}
+			room.AddMessage(msg)
+		}
+
+		// The deleted slot is reused once the older messages were dropped.
+		if _, err := room.DeleteMessage(middle.ID, member); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if n := len(room.Messages()); n != 99 {
+			t.Fatalf("got %d messages, want 99", n)
+		}
+		for i := 0; i < 60; i++ {
+			msg, _ := NewMessage(member, "new")
+			room.AddMessage(msg)
+		}
+		if n := len(room.Messages()); n != 100 {
+			t.Fatalf("got %d messages, want 100", n)
+		}
+	})
+}
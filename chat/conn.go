@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"context"
+	"io"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+// Conn is the connection of a client to a room, whatever its transport
+// (e.g. a websocket, an event stream, an SSH session or an in-memory client).
+type Conn interface {
+	// Write sends a frame to the client, every write being a frame.
+	Write(p []byte) (int, error)
+	// Close disconnects the client (e.g. when it is kicked).
+	Close() error
+	// Context is done once the client disconnects.
+	Context() context.Context
+	// User is the user of the client when it joined the room.
+	User() *user.User
+}
+
+// NewConn adapts the connection of a user, done once ctx is, to the rooms.
+func NewConn(ctx context.Context, u *user.User, rw io.WriteCloser) Conn {
+	return &conn{
+		WriteCloser: rw,
+		ctx:         ctx,
+		user:        u,
+	}
+}
+
+type conn struct {
+	io.WriteCloser
+	ctx  context.Context
+	user *user.User
+}
+
+// Context implements the Conn interface.
+func (c *conn) Context() context.Context { return c.ctx }
+
+// User implements the Conn interface.
+func (c *conn) User() *user.User { return c.user }
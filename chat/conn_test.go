@@ -0,0 +1,195 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+// fakeConn is a connection recording the frames written to it, implemented without NewConn.
+type fakeConn struct {
+	ctx  context.Context
+	user *user.User
+
+	mu     sync.Mutex
+	frames []string
+	closed bool
+}
+
+func newFakeConn(name string) *fakeConn {
+	return &fakeConn{ctx: context.Background(), user: user.NewNamed(name)}
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.frames = append(c.frames, string(p))
+
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *fakeConn) Context() context.Context { return c.ctx }
+func (c *fakeConn) User() *user.User         { return c.user }
+
+func (c *fakeConn) state() ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.frames), c.closed
+}
+
+// typeEncoder writes the type of the events and the name of their recipient.
+type typeEncoder struct{}
+
+func (typeEncoder) Encode(_ context.Context, w io.Writer, to *user.User, e Event) error {
+	_, err := fmt.Fprintf(w, "%T to %s", e, to.Name)
+	return err
+}
+
+func TestRoomConn(t *testing.T) {
+	tests := []struct {
+		name string
+		// do acts on the room once Alice and Bob joined it.
+		do        func(room *Room, alice, bob *fakeConn) error
+		wantErr   error
+		wantAlice []string
+		wantBob   []string
+		// wantClosed is whether the connection of Bob is closed.
+		wantClosed bool
+	}{
+		{
+			name: "broadcast",
+			do: func(room *Room, _, bob *fakeConn) error {
+				room.Broadcast(context.Background(), JoinEvent{User: bob.user})
+				return nil
+			},
+			wantAlice: []string{"chat.JoinEvent to Alice"},
+			wantBob:   []string{"chat.JoinEvent to Bob"},
+		},
+		{
+			name: "broadcast except",
+			do: func(room *Room, _, bob *fakeConn) error {
+				room.Broadcast(context.Background(), JoinEvent{User: bob.user}, bob.user.ID)
+				return nil
+			},
+			wantAlice: []string{"chat.JoinEvent to Alice"},
+		},
+		{
+			name: "send to renamed user",
+			do: func(room *Room, _, bob *fakeConn) error {
+				if _, err := room.Rename(bob.user.ID, "Robert"); err != nil {
+					return err
+				}
+				return room.Send(context.Background(), bob.user.ID, NoticeEvent{})
+			},
+			wantBob: []string{"chat.NoticeEvent to Robert"},
+		},
+		{
+			name: "iterate",
+			do: func(room *Room, _, _ *fakeConn) error {
+				room.IterateClients(func(u *user.User, conn Conn) error {
+					if conn.User().ID != u.ID {
+						return errors.New("user of another connection")
+					}
+					_, err := io.WriteString(conn, "hi "+u.Name)
+					return err
+				})
+				return nil
+			},
+			wantAlice: []string{"hi Alice"},
+			wantBob:   []string{"hi Bob"},
+		},
+		{
+			name: "kick",
+			do: func(room *Room, _, bob *fakeConn) error {
+				if !room.Kick(bob.user.ID, time.Minute) {
+					return ErrUserNotFound
+				}
+				room.RemoveClient(bob.user.ID)
+				return room.AddClient(bob, typeEncoder{})
+			},
+			wantErr:    ErrKicked,
+			wantClosed: true,
+		},
+		{
+			name: "existing session",
+			do: func(room *Room, _, bob *fakeConn) error {
+				return room.AddClient(&fakeConn{ctx: context.Background(), user: bob.user}, typeEncoder{})
+			},
+			wantErr: ErrExistingSession,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom(RoomOptions{})
+			alice, bob := newFakeConn("Alice"), newFakeConn("Bob")
+			for _, c := range []*fakeConn{alice, bob} {
+				if err := room.AddClient(c, typeEncoder{}); err != nil {
+					t.Fatalf("join %s: %v", c.user.Name, err)
+				}
+			}
+
+			if err := tt.do(room, alice, bob); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if frames, _ := alice.state(); !slices.Equal(frames, tt.wantAlice) {
+				t.Fatalf("Alice got frames %q, want %q", frames, tt.wantAlice)
+			}
+			frames, closed := bob.state()
+			if !slices.Equal(frames, tt.wantBob) {
+				t.Fatalf("Bob got frames %q, want %q", frames, tt.wantBob)
+			}
+			if closed != tt.wantClosed {
+				t.Fatalf("Bob connection closed %t, want %t", closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestNewConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	u := user.NewNamed("Alice")
+	w := newFakeConn("Alice")
+
+	conn := NewConn(ctx, u, w)
+	if conn.User() != u || conn.Context() != ctx {
+		t.Fatal("got another user or context")
+	}
+
+	// Writes and closes go to the underlying connection.
+	if _, err := io.WriteString(conn, "hello"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if frames, closed := w.state(); !slices.Equal(frames, []string{"hello"}) || !closed {
+		t.Fatalf("got frames %q, closed %t", frames, closed)
+	}
+
+	cancel()
+	if conn.Context().Err() == nil {
+		t.Fatal("context not done once canceled")
+	}
+}
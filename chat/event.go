@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+// Event is something which happened in the room and which clients are informed about.
+type Event interface {
+	event()
+}
+
+// MessageEvent is sent when a message is posted to the room.
+type MessageEvent struct {
+	Message *Message
+}
+
+// MessageEditedEvent is sent when the content of a message is edited.
+type MessageEditedEvent struct {
+	// Message is the new version of the message.
+	Message *Message
+}
+
+// MessageDeletedEvent is sent when a message is deleted.
+type MessageDeletedEvent struct {
+	Message *Message
+	// By is the user who deleted the message: its author or a moderator.
+	By   *user.User
+	Time time.Time
+}
+
+// JoinEvent is sent when a user joins the room.
+type JoinEvent struct {
+	User *user.User
+	Time time.Time
+}
+
+// LeaveEvent is sent when a user leaves the room.
+type LeaveEvent struct {
+	User *user.User
+	Time time.Time
+}
+
+// NumUsersEvent is sent when the number of users in the room changes.
+type NumUsersEvent struct {
+	NumUsers uint64
+}
+
+// ReadyEvent is sent to a client once it joined the room and can send messages.
+type ReadyEvent struct {
+	User *user.User
+}
+
+// UserEvent is sent to a client when its user changes (e.g. renamed).
+type UserEvent struct {
+	User *user.User
+}
+
+// NoticeEvent is sent privately to a client (e.g. the output of a command).
+type NoticeEvent struct {
+	Lines []string
+}
+
+// TypingEvent is sent when a user is typing.
+type TypingEvent struct {
+	User *user.User
+}
+
+// ReactionEvent is sent when the reactions to a message change.
+type ReactionEvent struct {
+	Message *Message
+}
+
+// ReadEvent is sent to the author of a message when it is read by another user.
+type ReadEvent struct {
+	Message *Message
+}
+
+// ModerationAction is the action of a moderator on a user.
+type ModerationAction string
+
+// List of moderation actions.
+const (
+	ModerationKick   ModerationAction = "kick"
+	ModerationMute   ModerationAction = "mute"
+	ModerationUnmute ModerationAction = "unmute"
+)
+
+// ModerationEvent is sent when a moderator acts on a user.
+type ModerationEvent struct {
+	Action    ModerationAction
+	Target    *user.User
+	Moderator *user.User
+	// Duration is how long the action lasts, zero for unmutes.
+	Duration time.Duration
+	Time     time.Time
+}
+
+// ErrorEvent is sent to a client when one of its requests failed
+// or when it is about to be disconnected.
+type ErrorEvent struct {
+	// RequestID is the ID of the request which failed, if any.
+	RequestID string
+	Err       error
+}
+
+func (MessageEvent) event()        {}
+func (MessageEditedEvent) event()  {}
+func (MessageDeletedEvent) event() {}
+func (JoinEvent) event()           {}
+func (LeaveEvent) event()          {}
+func (NumUsersEvent) event()       {}
+func (ReadyEvent) event()          {}
+func (UserEvent) event()           {}
+func (NoticeEvent) event()         {}
+func (TypingEvent) event()         {}
+func (ReactionEvent) event()       {}
+func (ReadEvent) event()           {}
+func (ModerationEvent) event()     {}
+func (ErrorEvent) event()          {}
+
+// Encoder encodes events in the format of the transport of a client (e.g. HTML or JSON).
+// Events may be personalized for the user receiving them.
+// Every write to w is sent as a frame. Events which the transport does not support are skipped.
+type Encoder interface {
+	Encode(ctx context.Context, w io.Writer, to *user.User, e Event) error
+}
+
+// Observer is notified of the events broadcast in a room (e.g. to forward them elsewhere).
+// It is called on the path of the broadcast so it must not block.
+type Observer func(ctx context.Context, e Event)
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+// recordingEncoder records the type of the events encoded for each user.
+type recordingEncoder struct {
+	mu     sync.Mutex
+	events map[string][]string
+}
+
+func (enc *recordingEncoder) Encode(_ context.Context, _ io.Writer, to *user.User, e Event) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	enc.events[to.Name] = append(enc.events[to.Name], fmt.Sprintf("%T", e))
+	return nil
+}
+
+func TestRoomBroadcast(t *testing.T) {
+	room := NewRoom(RoomOptions{})
+	enc := &recordingEncoder{events: make(map[string][]string)}
+	users := make(map[string]*user.User)
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		u := user.NewNamed(name)
+		if err := room.AddClient(NewConn(context.Background(), u, nopWriteCloser{}), enc); err != nil {
+			t.Fatalf("join %s: %v", name, err)
+		}
+		users[name] = u
+	}
+
+	var observed []string
+	room.Observe(func(_ context.Context, e Event) { observed = append(observed, fmt.Sprintf("%T", e)) })
+
+	ctx := context.Background()
+	room.Broadcast(ctx, JoinEvent{User: users["Alice"]}, users["Alice"].ID)
+	room.Broadcast(ctx, TypingEvent{User: users["Bob"]}, users["Bob"].ID, users["Carol"].ID)
+	room.Broadcast(ctx, NumUsersEvent{NumUsers: 3})
+	if err := room.Send(ctx, users["Carol"].ID, NoticeEvent{Lines: []string{"hello"}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	want := map[string][]string{
+		"Alice": {"chat.TypingEvent", "chat.NumUsersEvent"},
+		"Bob":   {"chat.JoinEvent", "chat.NumUsersEvent"},
+		"Carol": {"chat.JoinEvent", "chat.NumUsersEvent", "chat.NoticeEvent"},
+	}
+	for name, events := range want {
+		if got := enc.events[name]; !slices.Equal(got, events) {
+			t.Fatalf("%s got events %v, want %v", name, got, events)
+		}
+	}
+
+	// Observers see every broadcast event, whoever it was sent to, but not the private ones.
+	if want := []string{"chat.JoinEvent", "chat.TypingEvent", "chat.NumUsersEvent"}; !slices.Equal(observed, want) {
+		t.Fatalf("observed %v, want %v", observed, want)
+	}
+}
@@ -0,0 +1,29 @@
+package chat
+
+import "time"
+
+// Metrics records the activity of a room (e.g. for Prometheus).
+type Metrics interface {
+	// ClientAdded is called when a client joins the room.
+	ClientAdded()
+	// ClientRemoved is called when a client leaves the room.
+	ClientRemoved()
+	// MessageAdded is called for every message posted in the room.
+	MessageAdded()
+	// Broadcast records how long an event took to reach all the clients of the room.
+	Broadcast(e Event, d time.Duration)
+	// SemaphoreWait records how long a send to a client waited for a send worker.
+	SemaphoreWait(d time.Duration)
+	// SendFailed is called when an event cannot be sent to a client.
+	SendFailed()
+}
+
+// nopMetrics discards the activity of the rooms which are not instrumented.
+type nopMetrics struct{}
+
+func (nopMetrics) ClientAdded()                   {}
+func (nopMetrics) ClientRemoved()                 {}
+func (nopMetrics) MessageAdded()                  {}
+func (nopMetrics) Broadcast(Event, time.Duration) {}
+func (nopMetrics) SemaphoreWait(time.Duration)    {}
+func (nopMetrics) SendFailed()                    {}
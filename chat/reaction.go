@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"slices"
+
+	"github.com/rs/xid"
+)
+
+// Reactions is the list of emojis users can react with.
+var Reactions = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// Reaction is an emoji reaction to a message and the users who reacted with it.
+type Reaction struct {
+	Emoji string
+	Users []xid.ID
+}
+
+// HasUser checks if a user reacted with the emoji.
+func (r Reaction) HasUser(id xid.ID) bool {
+	return slices.Contains(r.Users, id)
+}
+
+// ToggleReaction adds the reaction of a user to the message or removes it if it was already there.
+func (m *Message) ToggleReaction(userID xid.ID, emoji string) error {
+	if !slices.Contains(Reactions, emoji) {
+		return ErrInvalidReaction
+	}
+
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	i := slices.IndexFunc(m.state.reactions, func(r *Reaction) bool { return r.Emoji == emoji })
+	if i == -1 {
+		m.state.reactions = append(m.state.reactions, &Reaction{Emoji: emoji, Users: []xid.ID{userID}})
+		return nil
+	}
+
+	r := m.state.reactions[i]
+	if j := slices.Index(r.Users, userID); j != -1 {
+		r.Users = slices.Delete(r.Users, j, j+1)
+		if len(r.Users) == 0 {
+			m.state.reactions = slices.Delete(m.state.reactions, i, i+1)
+		}
+		return nil
+	}
+	r.Users = append(r.Users, userID)
+
+	return nil
+}
+
+// Reactions returns a copy of the reactions to the message, in the order they were first added.
+func (m *Message) Reactions() []Reaction {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	reactions := make([]Reaction, 0, len(m.state.reactions))
+	for _, r := range m.state.reactions {
+		reactions = append(reactions, Reaction{Emoji: r.Emoji, Users: slices.Clone(r.Users)})
+	}
+
+	return reactions
+}
+
+// MarkRead records that a user read the message.
+// It reports whether the read is new. Authors do not read their own messages.
+func (m *Message) MarkRead(userID xid.ID) bool {
+	if m.User != nil && m.User.ID == userID {
+		return false
+	}
+
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	if _, found := m.state.readers[userID]; found {
+		return false
+	}
+	if m.state.readers == nil {
+		m.state.readers = make(map[xid.ID]struct{})
+	}
+	m.state.readers[userID] = struct{}{}
+
+	return true
+}
+
+// ReadCount returns the number of users who read the message.
+func (m *Message) ReadCount() int {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	return len(m.state.readers)
+}
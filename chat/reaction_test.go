@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestToggleReaction(t *testing.T) {
+	type toggle struct {
+		user  xid.ID
+		emoji string
+	}
+	alice, bob := xid.New(), xid.New()
+
+	tests := []struct {
+		name    string
+		toggles []toggle
+		want    []Reaction
+		wantErr error
+	}{
+		{
+			name:    "first reaction",
+			toggles: []toggle{{alice, "👍"}},
+			want:    []Reaction{{Emoji: "👍", Users: []xid.ID{alice}}},
+		},
+		{
+			name:    "same emoji by two users",
+			toggles: []toggle{{alice, "👍"}, {bob, "👍"}},
+			want:    []Reaction{{Emoji: "👍", Users: []xid.ID{alice, bob}}},
+		},
+		{
+			name:    "in the order first added",
+			toggles: []toggle{{alice, "🎉"}, {bob, "👍"}, {alice, "👍"}},
+			want:    []Reaction{{Emoji: "🎉", Users: []xid.ID{alice}}, {Emoji: "👍", Users: []xid.ID{bob, alice}}},
+		},
+		{
+			name:    "removed by reacting again",
+			toggles: []toggle{{alice, "👍"}, {bob, "👍"}, {alice, "👍"}},
+			want:    []Reaction{{Emoji: "👍", Users: []xid.ID{bob}}},
+		},
+		{
+			name:    "last user removed",
+			toggles: []toggle{{alice, "👍"}, {alice, "🎉"}, {alice, "👍"}},
+			want:    []Reaction{{Emoji: "🎉", Users: []xid.ID{alice}}},
+		},
+		{
+			name:    "unknown emoji",
+			toggles: []toggle{{alice, "🍕"}},
+			want:    []Reaction{},
+			wantErr: ErrInvalidReaction,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewMessage(user.NewNamed("Carol"), "hello")
+			if err != nil {
+				t.Fatalf("new message: %v", err)
+			}
+
+			for _, tg := range tt.toggles {
+				err = msg.ToggleReaction(tg.user, tg.emoji)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			got := msg.Reactions()
+			if !slices.EqualFunc(got, tt.want, func(a, b Reaction) bool {
+				return a.Emoji == b.Emoji && slices.Equal(a.Users, b.Users)
+			}) {
+				t.Fatalf("got reactions %v, want %v", got, tt.want)
+			}
+
+			// Reactions are copies.
+			if len(got) > 0 {
+				got[0].Users[0] = xid.New()
+				if msg.Reactions()[0].Users[0] != tt.want[0].Users[0] {
+					t.Fatal("reactions changed through a copy")
+				}
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	author := user.NewNamed("Alice")
+	msg, err := NewMessage(author, "hello")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	bob, carol := xid.New(), xid.New()
+
+	for _, read := range []struct {
+		user xid.ID
+		want bool
+	}{
+		{user: author.ID, want: false},
+		{user: bob, want: true},
+		{user: bob, want: false},
+		{user: carol, want: true},
+	} {
+		if got := msg.MarkRead(read.user); got != read.want {
+			t.Fatalf("read by %s: got new read %t, want %t", read.user, got, read.want)
+		}
+	}
+
+	if got := msg.ReadCount(); got != 2 {
+		t.Fatalf("got %d reads, want 2", got)
+	}
+}
@@ -0,0 +1,258 @@
+// Package chatbot is a small framework to write bots on top of chatclient.
+//
+// Bots route the messages starting with their prefix to command handlers,
+// wrapped by middleware (e.g. to recover from panics or to restrict commands to moderators).
+//
+//	b := chatbot.New(client, chatbot.Options{})
+//	b.Use(chatbot.Recover())
+//	b.Handle("ping", "replies with pong", func(c *chatbot.Context) error {
+//		return c.Reply("pong")
+//	})
+//	err := b.Run(ctx)
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+)
+
+// DefaultPrefix marks the commands of bots. It differs from the prefix of
+// the slash commands of the chat so that the server does not run them.
+const DefaultPrefix = "!"
+
+// ErrForbidden is returned by RequireRole when the author of a command is not allowed to run it.
+var ErrForbidden = errors.New("you are not allowed to use this command")
+
+// HandlerFunc handles a message.
+type HandlerFunc func(c *Context) error
+
+// Middleware wraps a handler (e.g. to log, authorize or recover).
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Context is the message being handled along with the client to answer it.
+type Context struct {
+	context.Context
+	Client  *chatclient.Client
+	Message chatclient.MessageEvent
+	// Command is the name of the command, empty for other messages.
+	Command string
+	// Args are the space separated arguments of the command.
+	Args []string
+}
+
+// Reply answers the message by mentioning its author.
+func (c *Context) Reply(content string) error {
+	_, err := c.Client.Reply(c.Message, content)
+	return err
+}
+
+// Send posts a message in the room.
+func (c *Context) Send(content string) error {
+	_, err := c.Client.Send(content)
+	return err
+}
+
+// React toggles a reaction to the message.
+func (c *Context) React(emoji string) error {
+	_, err := c.Client.React(c.Message.ID, emoji)
+	return err
+}
+
+// Options configures a Bot.
+type Options struct {
+	// Prefix marks the commands of the bot. Defaults to DefaultPrefix.
+	Prefix string
+	// OnError handles the errors of the handlers. Defaults to replying with the error.
+	OnError func(c *Context, err error)
+	// Logger logs the events of the bot. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// command is a command of a bot.
+type command struct {
+	help    string
+	handler HandlerFunc
+}
+
+// Bot routes the messages of a room to its handlers.
+// Handlers and middleware must be registered before running the bot.
+type Bot struct {
+	client     *chatclient.Client
+	opts       Options
+	commands   map[string]command
+	middleware []Middleware
+	fallback   HandlerFunc
+}
+
+// New creates a new Bot with a help command listing its commands.
+func New(client *chatclient.Client, opts Options) *Bot {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	b := &Bot{
+		client:   client,
+		opts:     opts,
+		commands: make(map[string]command),
+	}
+	if b.opts.OnError == nil {
+		b.opts.OnError = b.replyError
+	}
+	b.Handle("help", "lists the commands", b.help)
+
+	return b
+}
+
+// Use adds middleware wrapping all the handlers, in order.
+func (b *Bot) Use(mw ...Middleware) {
+	b.middleware = append(b.middleware, mw...)
+}
+
+// Handle registers the handler of a command, replacing any previous one.
+// Names are case insensitive.
+func (b *Bot) Handle(name, help string, h HandlerFunc) {
+	b.commands[strings.ToLower(name)] = command{help: help, handler: h}
+}
+
+// HandleMessage registers the handler of the messages which are not commands.
+func (b *Bot) HandleMessage(h HandlerFunc) {
+	b.fallback = h
+}
+
+// Run runs the client and handles the messages of the room until the context is done
+// or the client gives up. Each message is handled in its own goroutine.
+func (b *Bot) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	errc := make(chan error, 1)
+	go func() { errc <- b.client.Run(ctx) }()
+
+	for e := range b.client.Events() {
+		switch e := e.(type) {
+		case chatclient.ReadyEvent:
+			b.opts.Logger.InfoContext(ctx, "bot connected", "user.name", e.User.Name)
+		case chatclient.DisconnectedEvent:
+			b.opts.Logger.WarnContext(ctx, "bot disconnected", "err", e.Err, "retry", e.Retry)
+		case chatclient.ErrorEvent:
+			b.opts.Logger.WarnContext(ctx, "bot request failed", "err", e, "request.id", e.RequestID)
+		case chatclient.MessageEvent:
+			c, h := b.route(ctx, e)
+			if h == nil {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := h(c); err != nil {
+					b.opts.OnError(c, err)
+				}
+			}()
+		}
+	}
+
+	return <-errc
+}
+
+// route returns the context and the handler of a message, nil if the bot ignores it.
+func (b *Bot) route(ctx context.Context, msg chatclient.MessageEvent) (*Context, HandlerFunc) {
+	// Skip system messages, actions (e.g. /me) and the messages of the bot itself.
+	self, _ := b.client.User()
+	if msg.User == nil || msg.User.ID == self.ID || msg.Kind != "text" {
+		return nil, nil
+	}
+
+	c := &Context{Context: ctx, Client: b.client, Message: msg}
+
+	h := b.fallback
+	if input, found := strings.CutPrefix(msg.Content, b.opts.Prefix); found {
+		fields := strings.Fields(input)
+		if len(fields) == 0 {
+			return nil, nil
+		}
+
+		c.Command, c.Args = strings.ToLower(fields[0]), fields[1:]
+		cmd, found := b.commands[c.Command]
+		if !found {
+			h = func(c *Context) error {
+				return fmt.Errorf("unknown command %s%s, try %shelp", b.opts.Prefix, c.Command, b.opts.Prefix)
+			}
+		} else {
+			h = cmd.handler
+		}
+	}
+	if h == nil {
+		return nil, nil
+	}
+
+	// The first middleware is the outermost one.
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		h = b.middleware[i](h)
+	}
+
+	return c, h
+}
+
+func (b *Bot) help(c *Context) error {
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, b.opts.Prefix+name+": "+b.commands[name].help)
+	}
+
+	return c.Reply(strings.Join(lines, " | "))
+}
+
+// replyError replies with the error of a handler.
+func (b *Bot) replyError(c *Context, err error) {
+	b.opts.Logger.WarnContext(c, "bot handler failed", "err", err, "command", c.Command)
+	if err := c.Reply(err.Error()); err != nil {
+		b.opts.Logger.ErrorContext(c, "reply error", "err", err)
+	}
+}
+
+// Recover turns the panics of handlers into errors.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+// RequireRole restricts handlers to the authors having at least a role.
+func RequireRole(r user.Role) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			role, _ := user.ParseRole(c.Message.User.Role)
+			if role < r {
+				return ErrForbidden
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// newTestBot creates a bot whose client is never connected, with a ping command and a message handler.
+// Handlers return errors describing what they handled so that tests can tell them apart.
+func newTestBot(t *testing.T, opts Options) *Bot {
+	t.Helper()
+
+	client, err := chatclient.New(chatclient.Config{URL: "http://localhost", Token: "token"})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	b := New(client, opts)
+	b.Handle("Ping", "replies with pong", func(c *Context) error {
+		return errors.New("ping " + strings.Join(c.Args, ","))
+	})
+	b.HandleMessage(func(c *Context) error { return errors.New("message") })
+
+	return b
+}
+
+func message(content string) chatclient.MessageEvent {
+	return chatclient.MessageEvent{MessageEvent: protocol.MessageEvent{
+		ID:      "m1",
+		Kind:    "text",
+		User:    &protocol.User{ID: "u1", Name: "Alice", Role: user.RoleMember.String()},
+		Content: content,
+	}}
+}
+
+func TestRoute(t *testing.T) {
+	system := message("hello")
+	system.User = nil
+	action := message("waves")
+	action.Kind = "action"
+
+	tests := []struct {
+		name    string
+		prefix  string
+		msg     chatclient.MessageEvent
+		wantCmd string
+		// wantErr is the error of the handler, empty when the message is ignored.
+		wantErr string
+	}{
+		{name: "command", msg: message("!ping a b"), wantCmd: "ping", wantErr: "ping a,b"},
+		{name: "command in another case", msg: message("!PING"), wantCmd: "ping", wantErr: "ping "},
+		{name: "other prefix", prefix: "?", msg: message("?ping"), wantCmd: "ping", wantErr: "ping "},
+		{name: "unknown command", msg: message("!nope"), wantCmd: "nope", wantErr: "unknown command !nope, try !help"},
+		{name: "message", msg: message("hello"), wantErr: "message"},
+		{name: "slash command", msg: message("/ping"), wantErr: "message"},
+		{name: "prefix only", msg: message("! ")},
+		{name: "system message", msg: system},
+		{name: "action", msg: action},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t, Options{Prefix: tt.prefix})
+
+			c, h := b.route(context.Background(), tt.msg)
+			if h == nil {
+				if tt.wantErr != "" {
+					t.Fatal("message ignored")
+				}
+				return
+			}
+			if tt.wantErr == "" {
+				t.Fatal("message handled")
+			}
+
+			if c.Command != tt.wantCmd {
+				t.Fatalf("got command %q, want %q", c.Command, tt.wantCmd)
+			}
+			if err := h(c); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(c *Context) error {
+				calls = append(calls, name)
+				return next(c)
+			}
+		}
+	}
+
+	b := newTestBot(t, Options{})
+	b.Use(trace("first"), trace("second"))
+	b.Use(Recover())
+	b.Handle("panic", "panics", func(*Context) error { panic("boom") })
+
+	c, h := b.route(context.Background(), message("!panic"))
+	if err := h(c); err == nil || err.Error() != "panic: boom" {
+		t.Fatalf("got error %v, want the panic", err)
+	}
+	if !slices.Equal(calls, []string{"first", "second"}) {
+		t.Fatalf("middleware called in order %v", calls)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    user.Role
+		wantErr error
+	}{
+		{name: "member", role: user.RoleMember, wantErr: ErrForbidden},
+		{name: "moderator", role: user.RoleModerator},
+		{name: "admin", role: user.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := message("!kick Bob")
+			msg.User.Role = tt.role.String()
+
+			var called bool
+			h := RequireRole(user.RoleModerator)(func(*Context) error {
+				called = true
+				return nil
+			})
+			if err := h(&Context{Context: context.Background(), Message: msg}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if called != (tt.wantErr == nil) {
+				t.Fatalf("handler called %t", called)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	alice := srv.Login("Alice").Connect()
+
+	client, err := chatclient.New(chatclient.Config{URL: srv.URL, Token: srv.Login("Echo").Token(), HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	b := New(client, Options{})
+	b.Handle("ping", "replies with pong", func(c *Context) error { return c.Reply("pong") })
+	b.Handle("fail", "fails", func(*Context) error { return errors.New("it failed") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- b.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	})
+	alice.ExpectEvent(protocol.TypeJoin)
+
+	tests := []struct {
+		send string
+		want string
+	}{
+		{send: "!ping", want: "@Alice pong"},
+		{send: "!help", want: "@Alice !fail: fails | !help: lists the commands | !ping: replies with pong"},
+		{send: "!fail", want: "@Alice it failed"},
+	}
+	for _, tt := range tests {
+		// Every exchange posts two messages: the clock moves on to stay below the rate limit.
+		srv.Clock.Advance(5 * time.Second)
+		alice.Send(tt.send)
+		if msg := alice.ExpectMessage(tt.want); msg.User.Name != "Echo" {
+			t.Fatalf("%s: got reply of %s", tt.send, msg.User.Name)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package chatclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/user"
+)
+
+const password = "correct horse battery"
+
+// run runs a client with the token of a chattest client until the test ends.
+// It returns the client and the error of Run once it returned.
+func run(t *testing.T, srv *chattest.Server, token string) (*chatclient.Client, <-chan error) {
+	t.Helper()
+
+	c, err := chatclient.New(chatclient.Config{URL: srv.URL, Token: token, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		for range c.Events() {
+		}
+	})
+
+	return c, errc
+}
+
+// next waits for the next event of a type, skipping the other ones.
+func next[E chatclient.Event](t *testing.T, c *chatclient.Client) E {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				var zero E
+				t.Fatalf("events closed, expected %T", zero)
+			}
+			if e, ok := e.(E); ok {
+				return e
+			}
+		case <-timeout:
+			var zero E
+			t.Fatalf("expected %T", zero)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	srv := chattest.NewServer(t, chattest.Options{})
+	bob := srv.Login("Bob").Connect()
+	c, _ := run(t, srv, srv.Login("Alice").Token())
+
+	if ready := next[chatclient.ReadyEvent](t, c); ready.User.Name != "Alice" {
+		t.Fatalf("connected as %q, want Alice", ready.User.Name)
+	}
+	if u, ok := c.User(); !ok || u.Name != "Alice" {
+		t.Fatalf("got user %+v", u)
+	}
+
+	// Requests are sent over the websocket.
+	bob.Send("hello")
+	msg := next[chatclient.MessageEvent](t, c)
+	if msg.Content != "hello" || msg.User.Name != "Bob" {
+		t.Fatalf("got message %q of %s", msg.Content, msg.User.Name)
+	}
+	if _, err := c.Reply(msg, "hi"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	bob.ExpectMessage("@Bob hi")
+	if _, err := c.React(msg.ID, "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	if e := next[chatclient.ReactionEvent](t, c); e.MessageID != msg.ID {
+		t.Fatalf("got reaction to %s, want %s", e.MessageID, msg.ID)
+	}
+
+	// Rejected requests are matched by their ID.
+	id, err := c.Send(" ")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if e := next[chatclient.ErrorEvent](t, c); e.RequestID != id || e.Code != "message_empty" {
+		t.Fatalf("got error %+v for request %s", e, id)
+	}
+
+	// The REST API is called with the same token, sharing the rate limit of the websocket.
+	srv.Clock.Advance(5 * time.Second)
+	posted, err := c.PostMessage(ctx, "from the api")
+	if err != nil {
+		t.Fatalf("post message: %v", err)
+	}
+	bob.ExpectMessage("from the api")
+	page, err := c.Messages(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("list messages: %v", err)
+	}
+	if n := len(page.Messages); n != 2 || page.Messages[1].ID != posted.ID || page.Before == "" {
+		t.Fatalf("got page %+v", page)
+	}
+	if users, err := c.Users(ctx); err != nil || len(users) != 2 {
+		t.Fatalf("got users %+v and error %v", users, err)
+	}
+	if err := c.DeleteMessage(ctx, msg.ID); !errors.As(err, new(*chatclient.APIError)) {
+		t.Fatalf("delete the message of Bob: got error %v, want an api error", err)
+	}
+}
+
+func TestClientGivesUp(t *testing.T) {
+	tests := []struct {
+		name string
+		// token returns the token of the client of Alice.
+		token func(alice *chattest.Client) string
+		// act acts on Alice once the client connected.
+		act func(srv *chattest.Server, alice *chattest.Client)
+		// wantRetry is whether the client keeps reconnecting after the error of code wantCode.
+		wantRetry bool
+		wantErr   error
+		wantCode  string
+	}{
+		{
+			name:    "invalid token",
+			token:   func(*chattest.Client) string { return "invalid" },
+			wantErr: chatclient.ErrUnauthorized,
+		},
+		{
+			name:  "revoked",
+			token: (*chattest.Client).Token,
+			act: func(_ *chattest.Server, alice *chattest.Client) {
+				alice.Do(http.MethodPost, "/logout", nil)
+			},
+			wantCode: "session_revoked",
+		},
+		{
+			name:      "existing session",
+			token:     func(alice *chattest.Client) string { return alice.Connect().Token() },
+			wantRetry: true,
+			wantCode:  "existing_session",
+		},
+		{
+			name:  "kicked",
+			token: (*chattest.Client).Token,
+			act: func(srv *chattest.Server, _ *chattest.Client) {
+				srv.LoginAccount("Carol", password).Connect().Command("/kick Alice")
+			},
+			wantRetry: true,
+			wantCode:  "kicked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleModerator}},
+			})
+			alice := srv.Login("Alice")
+			c, errc := run(t, srv, tt.token(alice))
+			if tt.act != nil {
+				next[chatclient.ReadyEvent](t, c)
+				tt.act(srv, alice)
+			}
+
+			if tt.wantRetry {
+				// The client is told why it may not join, then retries with backoff.
+				if e := next[chatclient.ErrorEvent](t, c); e.Code != tt.wantCode {
+					t.Fatalf("got error %v, want code %s", e, tt.wantCode)
+				}
+				next[chatclient.DisconnectedEvent](t, c)
+				select {
+				case err := <-errc:
+					t.Fatalf("client gave up: %v", err)
+				default:
+				}
+				return
+			}
+
+			var err error
+			select {
+			case err = <-errc:
+			case <-time.After(5 * time.Second):
+				t.Fatal("client still running")
+			}
+
+			var eErr chatclient.ErrorEvent
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			case tt.wantCode != "" && (!errors.As(err, &eErr) || eErr.Code != tt.wantCode):
+				t.Fatalf("got error %v, want code %s", err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+// Package chatclient is a Go client of the chat.
+//
+// A Client streams the events of the room over the websocket of the chat,
+// using the JSON subprotocol, and reconnects with backoff when the connection is lost.
+// It also wraps the REST API of the room (e.g. Messages, PostMessage).
+// Bots use their API key as token while users log in with Login, whose Session renews their tokens.
+//
+//	c, err := chatclient.New(chatclient.Config{URL: "http://localhost:8080", Token: "bot_..."})
+//	if err != nil {
+//		return err
+//	}
+//	go c.Run(ctx)
+//
+//	for e := range c.Events() {
+//		switch e := e.(type) {
+//		case chatclient.MessageEvent:
+//			fmt.Println(e.User.Name, e.Content)
+//		}
+//	}
+package chatclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultRoom is the room of the REST API calls when none is configured.
+const DefaultRoom = "general"
+
+// eventsBuffer is the number of events buffered before the client stops reading the websocket.
+const eventsBuffer = 64
+
+// reauthInterval is how often the token source is checked for renewed tokens while connected.
+const reauthInterval = 10 * time.Second
+
+// fatalCodes are the error codes after which reconnecting would fail the same way.
+// Kicked clients and those of users connected elsewhere may join again later, so they retry.
+var fatalCodes = []string{"session_revoked"}
+
+// List of client errors.
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Config configures a Client.
+type Config struct {
+	// URL is the base URL of the chat (e.g. "http://localhost:8080").
+	URL string
+	// Token is a session access token or the API key of a bot.
+	Token string
+	// TokenSource returns the token before every connection and API call when set,
+	// instead of Token (e.g. to renew session tokens which expire).
+	TokenSource func(ctx context.Context) (string, error)
+	// Room is the room of the REST API calls. Defaults to DefaultRoom.
+	Room string
+	// HTTPClient makes the API calls. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// MinBackoff is the delay before the first reconnection, doubled after every failure. Defaults to 500ms.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two reconnections. Defaults to 30s.
+	MaxBackoff time.Duration
+	// Logger logs the frames which cannot be decoded. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Client is a client of the chat.
+type Client struct {
+	cfg    Config
+	base   *url.URL
+	events chan Event
+	nextID atomic.Uint64
+
+	// mu guards the connection and the user of the client.
+	mu   sync.Mutex
+	ws   *websocket.Conn
+	user *protocol.User
+}
+
+// New creates a new Client.
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid chat url %q", cfg.URL)
+	}
+
+	if cfg.Token == "" && cfg.TokenSource == nil {
+		return nil, errors.New("missing token")
+	}
+	if cfg.Room == "" {
+		cfg.Room = DefaultRoom
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &Client{
+		cfg:    cfg,
+		base:   base,
+		events: make(chan Event, eventsBuffer),
+	}, nil
+}
+
+// Events returns the stream of events of the room. It is closed once Run returns.
+// Events must be consumed: the client stops reading the websocket while the stream is full.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// User returns the user of the client, known once connected.
+func (c *Client) User() (protocol.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.user == nil {
+		return protocol.User{}, false
+	}
+
+	return *c.user, true
+}
+
+// Run connects to the room and streams its events until the context is done,
+// reconnecting with backoff when the connection is lost.
+// It gives up when the token is rejected or the session revoked.
+func (c *Client) Run(ctx context.Context) error {
+	defer close(c.events)
+
+	backoff := c.cfg.MinBackoff
+	for {
+		connected, err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var eErr ErrorEvent
+		if errors.Is(err, ErrUnauthorized) || (errors.As(err, &eErr) && slices.Contains(fatalCodes, eErr.Code)) {
+			return err
+		}
+
+		if connected {
+			backoff = c.cfg.MinBackoff
+		}
+		// Jitter spreads the reconnections of clients disconnected at the same time.
+		retry := backoff/2 + rand.N(backoff/2+1)
+		backoff = min(backoff*2, c.cfg.MaxBackoff)
+
+		if !c.emit(ctx, DisconnectedEvent{Err: err, Retry: retry}) {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// connect streams the events of a connection until it is lost.
+// It reports whether the connection was ready before being lost.
+func (c *Client) connect(ctx context.Context) (bool, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	ws, err := c.dial(ctx, token)
+	if err != nil {
+		// The handshake does not tell why it failed but the API does.
+		if _, merr := c.me(ctx, token); errors.Is(merr, ErrUnauthorized) {
+			return false, merr
+		}
+
+		return false, fmt.Errorf("dial websocket: %w", err)
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	c.mu.Lock()
+	c.ws = ws
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.ws = nil
+		c.mu.Unlock()
+	}()
+
+	// Tokens renewed by the token source re-authenticate the connection before the previous one expires.
+	if c.cfg.TokenSource != nil {
+		renewCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go c.reauthenticate(renewCtx, token)
+	}
+
+	var (
+		ready bool
+		fatal error
+	)
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			if fatal != nil {
+				return ready, fatal
+			}
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return ready, fmt.Errorf("receive frame: %w", err)
+		}
+
+		e, err := decodeEvent(data)
+		if err != nil {
+			c.cfg.Logger.WarnContext(ctx, "skip invalid frame", "err", err)
+			continue
+		}
+
+		switch ev := e.(type) {
+		case nil:
+			continue
+		case ReadyEvent:
+			ready = true
+			c.setUser(ev.User)
+		case UserEvent:
+			c.setUser(ev.User)
+		case ErrorEvent:
+			// The server closes the connection after fatal errors.
+			if slices.Contains(fatalCodes, ev.Code) {
+				fatal = ev
+			}
+		}
+
+		if !c.emit(ctx, e) {
+			return ready, ctx.Err()
+		}
+	}
+}
+
+// reauthenticate sends the tokens renewed by the token source over the connection.
+func (c *Client) reauthenticate(ctx context.Context, token string) {
+	ticker := time.NewTicker(reauthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := c.token(ctx)
+		if err != nil {
+			c.cfg.Logger.WarnContext(ctx, "renew token", "err", err)
+			continue
+		}
+		if renewed == token {
+			continue
+		}
+
+		if _, err := c.Reauth(renewed); err != nil {
+			c.cfg.Logger.WarnContext(ctx, "reauthenticate", "err", err)
+			continue
+		}
+		token = renewed
+	}
+}
+
+// dial opens the websocket of the room with the JSON subprotocol.
+func (c *Client) dial(ctx context.Context, token string) (*websocket.Conn, error) {
+	return dial(ctx, c.base, token, protocol.SubprotocolJSON)
+}
+
+// Dial opens the websocket of the room of the chat at baseURL with a subprotocol, for clients
+// handling the frames themselves (e.g. load tests). Unlike a Client, it never reconnects.
+func Dial(ctx context.Context, baseURL, token, subprotocol string) (*websocket.Conn, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid chat url %q", baseURL)
+	}
+
+	return dial(ctx, base, token, subprotocol)
+}
+
+func dial(ctx context.Context, base *url.URL, token, subprotocol string) (*websocket.Conn, error) {
+	u := base.JoinPath("chatroom")
+	u.Scheme = "ws"
+	if base.Scheme == "https" {
+		u.Scheme = "wss"
+	}
+	u.RawQuery = url.Values{"v": {strconv.Itoa(protocol.Version)}}.Encode()
+
+	cfg, err := websocket.NewConfig(u.String(), base.String())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Protocol = []string{subprotocol}
+	cfg.Header = http.Header{"Authorization": {"Bearer " + token}}
+
+	return cfg.DialContext(ctx)
+}
+
+// emit hands an event over to the stream, unless the context is done first.
+func (c *Client) emit(ctx context.Context, e Event) bool {
+	select {
+	case c.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *Client) setUser(u protocol.User) {
+	c.mu.Lock()
+	c.user = &u
+	c.mu.Unlock()
+}
+
+// token returns the token of the next connection or API call.
+func (c *Client) token(ctx context.Context) (string, error) {
+	if c.cfg.TokenSource == nil {
+		return c.cfg.Token, nil
+	}
+
+	token, err := c.cfg.TokenSource(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Send posts a message in the room.
+// The returned ID matches the ErrorEvent received if the message is rejected.
+func (c *Client) Send(content string) (string, error) {
+	return c.send(protocol.TypeMessage, protocol.MessagePayload{Content: content})
+}
+
+// Reply answers a message by mentioning its author, the chat having no threads.
+func (c *Client) Reply(to MessageEvent, content string) (string, error) {
+	if to.User == nil {
+		return c.Send(content)
+	}
+
+	return c.Send("@" + to.User.Name + " " + content)
+}
+
+// Command runs a slash command (e.g. "/who").
+func (c *Client) Command(input string) (string, error) {
+	return c.send(protocol.TypeCommand, protocol.CommandPayload{Input: input})
+}
+
+// React toggles a reaction to a message.
+func (c *Client) React(messageID, emoji string) (string, error) {
+	return c.send(protocol.TypeReaction, protocol.ReactionPayload{MessageID: messageID, Emoji: emoji})
+}
+
+// MarkRead sends a read receipt of a message to its author.
+func (c *Client) MarkRead(messageID string) (string, error) {
+	return c.send(protocol.TypeRead, protocol.ReadPayload{MessageID: messageID})
+}
+
+// Typing notifies the other users that the client is typing.
+func (c *Client) Typing() (string, error) {
+	return c.send(protocol.TypeTyping, struct{}{})
+}
+
+// Reauth replaces the access token of the connection before it expires.
+// Clients with a TokenSource re-authenticate on their own.
+func (c *Client) Reauth(token string) (string, error) {
+	return c.send(protocol.TypeReauth, protocol.ReauthPayload{Token: token})
+}
+
+// send sends a request frame over the websocket.
+func (c *Client) send(t protocol.Type, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("marshal %s payload: %w", t, err)
+	}
+
+	id := strconv.FormatUint(c.nextID.Add(1), 10)
+	frame, err := json.Marshal(protocol.Envelope{Type: t, ID: id, Payload: data})
+	if err != nil {
+		return "", fmt.Errorf("marshal %s frame: %w", t, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ws == nil {
+		return "", ErrNotConnected
+	}
+
+	if err := websocket.Message.Send(c.ws, string(frame)); err != nil {
+		return "", fmt.Errorf("send %s frame: %w", t, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,134 @@
+package chatclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "token", cfg: Config{URL: "http://localhost:8080/", Token: "bot_x"}},
+		{name: "token source", cfg: Config{URL: "https://chat.example.com", TokenSource: func(context.Context) (string, error) { return "", nil }}},
+		{name: "missing token", cfg: Config{URL: "http://localhost:8080"}, wantErr: true},
+		{name: "other scheme", cfg: Config{URL: "ws://localhost:8080", Token: "bot_x"}, wantErr: true},
+		{name: "missing host", cfg: Config{URL: "http://", Token: "bot_x"}, wantErr: true},
+		{name: "relative url", cfg: Config{URL: "/chat", Token: "bot_x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.cfg.Room != DefaultRoom || c.roomPath("messages") != "rooms/general/messages" {
+				t.Fatalf("got room %q", c.cfg.Room)
+			}
+			if _, err := c.Send("hello"); !errors.Is(err, ErrNotConnected) {
+				t.Fatalf("send before connecting: got error %v, want %v", err, ErrNotConnected)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	const minBackoff = 40 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		status int
+		// tokenErr makes the token source fail.
+		tokenErr bool
+		// wantErr is the error of Run, nil when the client keeps reconnecting.
+		wantErr error
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, wantErr: ErrUnauthorized},
+		{name: "unavailable", status: http.StatusServiceUnavailable},
+		{name: "token source failing", status: http.StatusOK, tokenErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			t.Cleanup(srv.Close)
+
+			cfg := Config{URL: srv.URL, Token: "token", MinBackoff: minBackoff, MaxBackoff: 2 * minBackoff}
+			if tt.tokenErr {
+				cfg.TokenSource = func(context.Context) (string, error) { return "", errors.New("offline") }
+			}
+			c, err := New(cfg)
+			if err != nil {
+				t.Fatalf("create client: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() { errc <- c.Run(ctx) }()
+
+			if tt.wantErr != nil {
+				if err := <-errc; !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if _, open := <-c.Events(); open {
+					t.Fatal("events not closed")
+				}
+				return
+			}
+
+			// The delay before reconnecting doubles, with jitter, up to the maximum.
+			for _, maxRetry := range []time.Duration{minBackoff, 2 * minBackoff, 2 * minBackoff} {
+				e, ok := (<-c.Events()).(DisconnectedEvent)
+				if !ok || e.Err == nil {
+					t.Fatalf("got %#v, want a disconnection", e)
+				}
+				if e.Retry < maxRetry/2 || e.Retry > maxRetry {
+					t.Fatalf("retrying after %v, want between %v and %v", e.Retry, maxRetry/2, maxRetry)
+				}
+			}
+
+			cancel()
+			for range c.Events() {
+			}
+			if err := <-errc; !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestDial(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "other scheme", url: "ws://localhost:8080"},
+		{name: "missing host", url: "http://"},
+		{name: "relative url", url: "/chat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ws, err := Dial(context.Background(), tt.url, "token", protocol.SubprotocolJSON); err == nil {
+				ws.Close()
+				t.Fatal("websocket opened")
+			}
+		})
+	}
+}
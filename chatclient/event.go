@@ -0,0 +1,141 @@
+package chatclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+// Event is an event received from the room.
+// Use a type switch to handle the events a client is interested in.
+type Event interface {
+	event()
+}
+
+// ReadyEvent is received once connected, with the user of the client.
+type ReadyEvent struct{ protocol.ReadyEvent }
+
+// MessageEvent is received for every message posted in the room, including the ones of the client.
+type MessageEvent struct{ protocol.MessageEvent }
+
+// MessageEditedEvent is received with the new version of an edited message.
+type MessageEditedEvent struct{ protocol.MessageEvent }
+
+// MessageDeletedEvent is received when a message is deleted.
+type MessageDeletedEvent struct{ protocol.MessageDeletedEvent }
+
+// JoinEvent is received when another user joins the room.
+type JoinEvent struct{ protocol.PresenceEvent }
+
+// LeaveEvent is received when a user leaves the room.
+type LeaveEvent struct{ protocol.PresenceEvent }
+
+// NumUsersEvent is received when the number of users of the room changes.
+type NumUsersEvent struct{ protocol.NumUsersEvent }
+
+// UserEvent is received when the user of the client changes (e.g. after /nick).
+type UserEvent struct{ protocol.UserEvent }
+
+// NoticeEvent is received in reply to commands.
+type NoticeEvent struct{ protocol.NoticeEvent }
+
+// TypingEvent is received when another user is typing.
+type TypingEvent struct{ protocol.TypingEvent }
+
+// ReactionEvent is received when the reactions of a message change.
+type ReactionEvent struct{ protocol.ReactionEvent }
+
+// ReadEvent is received when a message of the client is read.
+type ReadEvent struct{ protocol.ReadEvent }
+
+// ModerationEvent is received when a user is kicked, muted or unmuted.
+type ModerationEvent struct{ protocol.ModerationEvent }
+
+// ErrorEvent is received when a request of the client fails.
+// RequestID is the ID returned by the method which sent the request, if any.
+type ErrorEvent struct {
+	RequestID string
+	protocol.ErrorPayload
+}
+
+// Error implements the error interface.
+func (e ErrorEvent) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// DisconnectedEvent is received when the connection is lost.
+// The client reconnects after Retry.
+type DisconnectedEvent struct {
+	Err   error
+	Retry time.Duration
+}
+
+func (ReadyEvent) event()          {}
+func (MessageEvent) event()        {}
+func (MessageEditedEvent) event()  {}
+func (MessageDeletedEvent) event() {}
+func (JoinEvent) event()           {}
+func (LeaveEvent) event()          {}
+func (NumUsersEvent) event()       {}
+func (UserEvent) event()           {}
+func (NoticeEvent) event()         {}
+func (TypingEvent) event()         {}
+func (ReactionEvent) event()       {}
+func (ReadEvent) event()           {}
+func (ModerationEvent) event()     {}
+func (ErrorEvent) event()          {}
+func (DisconnectedEvent) event()   {}
+
+// decodeEvent decodes a frame sent by the server.
+// Frames of unknown types (e.g. from a newer server) are skipped with a nil event.
+func decodeEvent(data []byte) (Event, error) {
+	var env protocol.Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+
+	switch env.Type {
+	case protocol.TypeReady:
+		return decode(env, func(p protocol.ReadyEvent) Event { return ReadyEvent{p} })
+	case protocol.TypeMessage:
+		return decode(env, func(p protocol.MessageEvent) Event { return MessageEvent{p} })
+	case protocol.TypeMessageEdited:
+		return decode(env, func(p protocol.MessageEvent) Event { return MessageEditedEvent{p} })
+	case protocol.TypeMessageDeleted:
+		return decode(env, func(p protocol.MessageDeletedEvent) Event { return MessageDeletedEvent{p} })
+	case protocol.TypeJoin:
+		return decode(env, func(p protocol.PresenceEvent) Event { return JoinEvent{p} })
+	case protocol.TypeLeave:
+		return decode(env, func(p protocol.PresenceEvent) Event { return LeaveEvent{p} })
+	case protocol.TypeNumUsers:
+		return decode(env, func(p protocol.NumUsersEvent) Event { return NumUsersEvent{p} })
+	case protocol.TypeUser:
+		return decode(env, func(p protocol.UserEvent) Event { return UserEvent{p} })
+	case protocol.TypeNotice:
+		return decode(env, func(p protocol.NoticeEvent) Event { return NoticeEvent{p} })
+	case protocol.TypeTyping:
+		return decode(env, func(p protocol.TypingEvent) Event { return TypingEvent{p} })
+	case protocol.TypeReaction:
+		return decode(env, func(p protocol.ReactionEvent) Event { return ReactionEvent{p} })
+	case protocol.TypeRead:
+		return decode(env, func(p protocol.ReadEvent) Event { return ReadEvent{p} })
+	case protocol.TypeModeration:
+		return decode(env, func(p protocol.ModerationEvent) Event { return ModerationEvent{p} })
+	case protocol.TypeError:
+		return decode(env, func(p protocol.ErrorPayload) Event { return ErrorEvent{RequestID: env.ID, ErrorPayload: p} })
+	default:
+		return nil, nil
+	}
+}
+
+// decode decodes the payload of a frame and wraps it in its event.
+func decode[P any](env protocol.Envelope, wrap func(P) Event) (Event, error) {
+	var p P
+	if err := json.Unmarshal(env.Payload, &p); err != nil {
+		return nil, fmt.Errorf("decode %s payload: %w", env.Type, err)
+	}
+
+	return wrap(p), nil
+}
@@ -0,0 +1,55 @@
+package chatclient
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	alice := &protocol.User{ID: "1", Name: "Alice", Role: "member"}
+
+	tests := []struct {
+		name    string
+		frame   string
+		want    Event
+		wantErr bool
+	}{
+		{
+			name:  "message",
+			frame: `{"type":"message","payload":{"id":"m1","kind":"text","user":{"id":"1","name":"Alice","role":"member"},"content":"hello"}}`,
+			want:  MessageEvent{protocol.MessageEvent{ID: "m1", Kind: "text", User: alice, Content: "hello"}},
+		},
+		{
+			name:  "edited message",
+			frame: `{"type":"message_edited","payload":{"id":"m1","content":"hello world"}}`,
+			want:  MessageEditedEvent{protocol.MessageEvent{ID: "m1", Content: "hello world"}},
+		},
+		{
+			name:  "number of users",
+			frame: `{"type":"users","payload":{"count":3}}`,
+			want:  NumUsersEvent{protocol.NumUsersEvent{Count: 3}},
+		},
+		{
+			name:  "error of a request",
+			frame: `{"type":"error","id":"7","payload":{"code":"rate_limited","message":"slow down"}}`,
+			want:  ErrorEvent{RequestID: "7", ErrorPayload: protocol.ErrorPayload{Code: "rate_limited", Message: "slow down"}},
+		},
+		{name: "unknown type", frame: `{"type":"poll","payload":{}}`},
+		{name: "invalid frame", frame: `{`, wantErr: true},
+		{name: "invalid payload", frame: `{"type":"users","payload":{"count":"three"}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeEvent([]byte(tt.frame))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
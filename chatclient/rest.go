@@ -0,0 +1,208 @@
+package chatclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+// APIError is an error returned by the REST API.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Is makes rejected tokens match ErrUnauthorized.
+func (e *APIError) Is(target error) bool {
+	return target == ErrUnauthorized && e.Status == http.StatusUnauthorized
+}
+
+// Room is a room of the chat.
+type Room struct {
+	ID       string `json:"id"`
+	NumUsers uint64 `json:"num_users"`
+}
+
+// Page is a page of messages, from the oldest to the newest.
+type Page struct {
+	Messages []protocol.MessageEvent `json:"messages"`
+	// Before is the cursor of the previous page, empty on the first page.
+	Before string `json:"before,omitempty"`
+}
+
+// Me returns the user of the token.
+func (c *Client) Me(ctx context.Context) (protocol.User, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return protocol.User{}, err
+	}
+
+	return c.me(ctx, token)
+}
+
+func (c *Client) me(ctx context.Context, token string) (protocol.User, error) {
+	var u protocol.User
+	err := c.do(ctx, token, http.MethodGet, "me", nil, &u)
+	return u, err
+}
+
+// Rooms returns the rooms of the chat.
+func (c *Client) Rooms(ctx context.Context) ([]Room, error) {
+	var rooms []Room
+	err := c.call(ctx, http.MethodGet, "rooms", nil, &rooms)
+	return rooms, err
+}
+
+// Room returns the room of the client.
+func (c *Client) Room(ctx context.Context) (Room, error) {
+	var room Room
+	err := c.call(ctx, http.MethodGet, c.roomPath(), nil, &room)
+	return room, err
+}
+
+// Users returns the users connected to the room.
+func (c *Client) Users(ctx context.Context) ([]protocol.User, error) {
+	var users []protocol.User
+	err := c.call(ctx, http.MethodGet, c.roomPath("users"), nil, &users)
+	return users, err
+}
+
+// Messages returns a page of the messages of the room.
+// The first page holds the latest messages, older pages are fetched with the Before cursor of the previous page.
+// A zero limit uses the default page size of the server.
+func (c *Client) Messages(ctx context.Context, before string, limit int) (Page, error) {
+	q := url.Values{}
+	if before != "" {
+		q.Set("before", before)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := c.roomPath("messages")
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var page Page
+	err := c.call(ctx, http.MethodGet, path, nil, &page)
+	return page, err
+}
+
+// PostMessage posts a message in the room through the API, without a websocket.
+// Commands are rejected, they can only be run on the websocket.
+func (c *Client) PostMessage(ctx context.Context, content string) (protocol.MessageEvent, error) {
+	var msg protocol.MessageEvent
+	err := c.call(ctx, http.MethodPost, c.roomPath("messages"), protocol.MessagePayload{Content: content}, &msg)
+	return msg, err
+}
+
+// EditMessage replaces the content of a message of the client through the API.
+func (c *Client) EditMessage(ctx context.Context, messageID, content string) (protocol.MessageEvent, error) {
+	var msg protocol.MessageEvent
+	err := c.call(ctx, http.MethodPatch, c.roomPath("messages", messageID), protocol.MessagePayload{Content: content}, &msg)
+	return msg, err
+}
+
+// DeleteMessage deletes a message through the API, of the client or of another user for moderators.
+func (c *Client) DeleteMessage(ctx context.Context, messageID string) error {
+	return c.call(ctx, http.MethodDelete, c.roomPath("messages", messageID), nil, nil)
+}
+
+// PostReaction toggles a reaction to a message through the API, without a websocket.
+func (c *Client) PostReaction(ctx context.Context, messageID, emoji string) (protocol.MessageEvent, error) {
+	var msg protocol.MessageEvent
+	body := map[string]string{"emoji": emoji}
+	err := c.call(ctx, http.MethodPost, c.roomPath("messages", messageID, "reactions"), body, &msg)
+	return msg, err
+}
+
+func (c *Client) roomPath(elem ...string) string {
+	path := "rooms/" + url.PathEscape(c.cfg.Room)
+	for _, e := range elem {
+		path += "/" + url.PathEscape(e)
+	}
+
+	return path
+}
+
+// call calls the API with the token of the client.
+func (c *Client) call(ctx context.Context, method, path string, body, out any) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, token, method, path, body, out)
+}
+
+// do calls the API and decodes its response into out.
+func (c *Client) do(ctx context.Context, token, method, path string, body, out any) error {
+	return request(ctx, c.cfg.HTTPClient, method, c.base.String()+"/api/v1/"+path, token, body, out)
+}
+
+// request calls an API endpoint and decodes its response into out.
+// The token is optional since some endpoints (e.g. Login) are not authenticated.
+func request(ctx context.Context, hc *http.Client, method, endpoint, token string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, r)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{Status: resp.StatusCode}
+		var e struct {
+			Error protocol.ErrorPayload `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err == nil {
+			apiErr.Code, apiErr.Message = e.Error.Code, e.Error.Message
+		}
+
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
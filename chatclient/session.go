@@ -0,0 +1,99 @@
+package chatclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+// refreshMargin is how long before its expiry an access token is refreshed,
+// so that it does not expire on its way to the server.
+const refreshMargin = 30 * time.Second
+
+// Session is a session of a user started with Login, for clients which cannot keep cookies
+// (e.g. terminal clients). Its Token method can be used as the TokenSource of a Client.
+type Session struct {
+	base string
+	hc   *http.Client
+
+	// mu guards the tokens and the user of the session.
+	mu   sync.Mutex
+	resp sessionResponse
+}
+
+// sessionResponse is the body of the responses of the session endpoints.
+type sessionResponse struct {
+	Token        string        `json:"token"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+	RefreshToken string        `json:"refresh_token"`
+	User         protocol.User `json:"user"`
+}
+
+// Login starts a session as a guest picking a name, or with an account when a password is given.
+// A nil HTTP client uses a client with a 10s timeout.
+func Login(ctx context.Context, baseURL, name, password string, hc *http.Client) (*Session, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid chat url %q", baseURL)
+	}
+
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	s := &Session{base: base.String(), hc: hc}
+	body := map[string]string{"name": name, "password": password}
+	if err := request(ctx, hc, http.MethodPost, s.base+"/api/v1/sessions", "", body, &s.resp); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// User returns the user of the session, as of the last refresh.
+func (s *Session) User() protocol.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resp.User
+}
+
+// Token returns the access token of the session, refreshing it when it is about to expire.
+func (s *Session) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Until(s.resp.ExpiresAt) > refreshMargin {
+		return s.resp.Token, nil
+	}
+
+	// Refresh tokens are single use so the lock is held while refreshing.
+	var resp sessionResponse
+	body := map[string]string{"refresh_token": s.resp.RefreshToken}
+	if err := request(ctx, s.hc, http.MethodPost, s.base+"/api/v1/sessions/refresh", "", body, &resp); err != nil {
+		return "", fmt.Errorf("refresh session: %w", err)
+	}
+	s.resp = resp
+
+	return s.resp.Token, nil
+}
+
+// Logout revokes the session, whose tokens are rejected from then on.
+func (s *Session) Logout(ctx context.Context) error {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := request(ctx, s.hc, http.MethodDelete, s.base+"/api/v1/sessions/current", token, nil, nil); err != nil {
+		return fmt.Errorf("log out: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package chattest boots the chat in-process for tests.
+//
+// A Server serves the full router of the chat on an httptest.Server, configured by its
+// Options rather than by the environment, with seeded accounts and a Clock driving the
+// rate limits. Clients log in, open the websocket of the room and assert on the
+// events (JSON subprotocol) or fragments (HTML subprotocol) they receive.
+//
+//	func TestRateLimit(t *testing.T) {
+//		srv := chattest.NewServer(t, chattest.Options{})
+//		c := srv.Login("Alice").Connect()
+//
+//		for i := 0; i < 3; i++ {
+//			c.Send("hello")
+//			c.ExpectEvent(protocol.TypeMessage)
+//		}
+//		c.Send("hello")
+//		c.ExpectError("rate_limited")
+//
+//		srv.Clock.Advance(5 * time.Second)
+//		c.Send("hello")
+//		c.ExpectEvent(protocol.TypeMessage)
+//	}
+//
+// Only the rate limits and the SSO login attempts follow the Clock: sessions, messages and
+// timeouts use the wall clock.
+package chattest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/server"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// DefaultTimeout is how long clients wait for a frame when no timeout is configured.
+const DefaultTimeout = 5 * time.Second
+
+// epoch is the initial time of the clock when none is configured.
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Account is an account registered before the server starts.
+type Account struct {
+	Name     string
+	Password string
+	Role     user.Role
+}
+
+// Options configures a Server.
+type Options struct {
+	// Env holds the environment variables of the server (e.g. AUTH_GUESTS).
+	// A random JWT_SECRET is generated unless set, and the environment of the process is ignored.
+	Env map[string]string
+	// Accounts are registered before the server starts, enabling the login with an account.
+	Accounts []Account
+	// SeedMessages is the number of random messages the room starts with.
+	SeedMessages int
+	// MaxClients is the number of clients the room holds, the default of the rooms when zero.
+	MaxClients uint64
+	// Now is the initial time of the clock. Defaults to 2024-01-01 UTC.
+	Now time.Time
+	// Timeout is how long clients wait for a frame. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Server is a chat served in-process. It is closed at the end of the test.
+type Server struct {
+	*httptest.Server
+
+	// Clock drives the rate limits of the server.
+	Clock *Clock
+
+	tb      testing.TB
+	timeout time.Duration
+}
+
+// NewServer starts a chat configured by opts, failing the test if it cannot start.
+func NewServer(tb testing.TB, opts Options) *Server {
+	tb.Helper()
+
+	env := map[string]string{
+		"JWT_SECRET":    randomSecret(tb),
+		"COOKIE_SECURE": "false",
+	}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+
+	// The server is only started once created, but its URL is needed by the SSO.
+	ts := httptest.NewUnstartedServer(nil)
+	if _, ok := env["OIDC_REDIRECT_URL"]; !ok {
+		env["OIDC_REDIRECT_URL"] = "http://" + ts.Listener.Addr().String() + "/login/oidc/callback"
+	}
+
+	if len(opts.Accounts) > 0 {
+		path, ok := env["AUTH_ACCOUNTS_FILE"]
+		if !ok {
+			path = filepath.Join(tb.TempDir(), "accounts.json")
+			env["AUTH_ACCOUNTS_FILE"] = path
+		}
+		seedAccounts(tb, path, opts.Accounts)
+	}
+
+	if opts.Now.IsZero() {
+		opts.Now = epoch
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := NewClock(opts.Now)
+	srv, err := server.New(ctx, server.Options{
+		Getenv:       func(key string) string { return env[key] },
+		Clock:        clock,
+		SeedMessages: opts.SeedMessages,
+		MaxClients:   opts.MaxClients,
+	})
+	if err != nil {
+		cancel()
+		ts.Close()
+		tb.Fatalf("create server: %v", err)
+	}
+	ts.Config.Handler = srv
+	ts.Start()
+
+	s := &Server{
+		Server:  ts,
+		Clock:   clock,
+		tb:      tb,
+		timeout: opts.Timeout,
+	}
+	tb.Cleanup(func() {
+		// Connections still open (e.g. websockets) are closed by the server.
+		s.CloseClientConnections()
+		s.Close()
+		cancel()
+	})
+
+	return s
+}
+
+func seedAccounts(tb testing.TB, path string, accounts []Account) {
+	tb.Helper()
+
+	store, err := account.NewFileStore(path)
+	if err != nil {
+		tb.Fatalf("load accounts: %v", err)
+	}
+
+	for _, a := range accounts {
+		acc, err := account.New(a.Name, a.Password)
+		if err != nil {
+			tb.Fatalf("create account %s: %v", a.Name, err)
+		}
+		acc.Role = a.Role
+
+		if err := store.Create(context.Background(), acc); err != nil {
+			tb.Fatalf("create account %s: %v", a.Name, err)
+		}
+	}
+}
+
+func randomSecret(tb testing.TB) string {
+	tb.Helper()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		tb.Fatalf("generate jwt secret: %v", err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Clock is a clock which only moves when told to.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock stopped at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
@@ -0,0 +1,182 @@
+package chattest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+)
+
+const password = "correct horse battery"
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      chattest.Options
+		connected []string
+		login     string
+		password  string
+		wantCode  string
+	}{
+		{
+			name:  "guest",
+			login: "Alice",
+		},
+		{
+			name:     "account",
+			opts:     chattest.Options{Accounts: []chattest.Account{{Name: "Boss", Password: password, Role: user.RoleAdmin}}},
+			login:    "Boss",
+			password: password,
+		},
+		{
+			name:     "wrong password",
+			opts:     chattest.Options{Accounts: []chattest.Account{{Name: "Boss", Password: password}}},
+			login:    "Boss",
+			password: "wrong password",
+			wantCode: "invalid_credentials",
+		},
+		{
+			name:     "guests disabled",
+			opts:     chattest.Options{Env: map[string]string{"AUTH_GUESTS": "false"}, Accounts: []chattest.Account{{Name: "Boss", Password: password}}},
+			login:    "Alice",
+			wantCode: "forbidden",
+		},
+		{
+			name:      "name of a connected user",
+			connected: []string{"Alice"},
+			login:     "alice",
+			wantCode:  "invalid_request",
+		},
+		{
+			name:     "invalid name",
+			login:    "a",
+			wantCode: "invalid_name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, tt.opts)
+			for _, name := range tt.connected {
+				srv.Login(name).Connect()
+			}
+
+			session, err := chatclient.Login(context.Background(), srv.URL, tt.login, tt.password, srv.Client())
+			if tt.wantCode != "" {
+				var apiErr *chatclient.APIError
+				if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode {
+					t.Fatalf("got error %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("log in: %v", err)
+			}
+
+			if got := session.User().Name; got != tt.login {
+				t.Fatalf("logged in as %q, want %q", got, tt.login)
+			}
+		})
+	}
+}
+
+func TestPost(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	alice := srv.Login("Alice").Connect()
+	bob := srv.Login("Bob").Connect()
+
+	alice.Send("hello")
+	for _, c := range []*chattest.Client{alice, bob} {
+		if msg := c.ExpectMessage("hello"); msg.User.Name != "Alice" {
+			t.Fatalf("%s received a message of %s, want Alice", c.Name(), msg.User.Name)
+		}
+	}
+
+	alice.Send("")
+	alice.ExpectError("message_empty")
+}
+
+func TestHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		seeded int
+		posted int
+		want   int
+	}{
+		{name: "empty room", want: 0},
+		{name: "partly filled room", posted: 2, want: 2},
+		{name: "seeded room", seeded: 1000, want: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{SeedMessages: tt.seeded})
+			c := srv.Login("Alice").Connect()
+			for i := 0; i < tt.posted; i++ {
+				c.Send(strconv.Itoa(i))
+				c.ExpectMessage(strconv.Itoa(i))
+			}
+
+			resp := c.Do(http.MethodGet, "/api/v1/rooms/general/messages", nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("list messages: got status %d", resp.StatusCode)
+			}
+			var page struct {
+				Messages []protocol.MessageEvent `json:"messages"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("decode messages: %v", err)
+			}
+			if len(page.Messages) != tt.want {
+				t.Fatalf("got %d messages, want %d", len(page.Messages), tt.want)
+			}
+
+			// The page renders the history too.
+			if resp := c.Do(http.MethodGet, "/", nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("index: got status %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	c := srv.Login("Alice").Connect()
+
+	for i := 0; i < 3; i++ {
+		c.Send(strconv.Itoa(i))
+		c.ExpectMessage(strconv.Itoa(i))
+	}
+	c.Send("too fast")
+	c.ExpectError("rate_limited")
+
+	srv.Clock.Advance(5 * time.Second)
+	c.Send("again")
+	c.ExpectMessage("again")
+}
+
+func TestCapacity(t *testing.T) {
+	for _, max := range []uint64{1, 3} {
+		t.Run(strconv.FormatUint(max, 10), func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{MaxClients: max})
+			for i := uint64(0); i < max; i++ {
+				srv.Login("User" + strconv.FormatUint(i, 10)).Connect()
+			}
+
+			c := srv.Login("Latecomer")
+			if err := c.Open(protocol.SubprotocolJSON); err != nil {
+				t.Fatalf("open websocket: %v", err)
+			}
+			c.ExpectError("room_full")
+			c.ExpectClosed()
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package chattest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/net/websocket"
+)
+
+// framesBuffer is the number of frames buffered before a client stops reading its websocket.
+const framesBuffer = 256
+
+// Client is a user of a Server. Its methods fail the test instead of returning errors,
+// except Open whose failures (e.g. a rejected handshake) may be expected.
+type Client struct {
+	srv     *Server
+	tb      testing.TB
+	session *chatclient.Session
+
+	subprotocol string
+	ws          *websocket.Conn
+	frames      chan []byte
+	nextID      int
+}
+
+// Login logs in as a guest picking name.
+func (s *Server) Login(name string) *Client {
+	s.tb.Helper()
+
+	return s.login(name, "")
+}
+
+// LoginAccount logs in with an account.
+func (s *Server) LoginAccount(name, password string) *Client {
+	s.tb.Helper()
+
+	return s.login(name, password)
+}
+
+func (s *Server) login(name, password string) *Client {
+	s.tb.Helper()
+
+	session, err := chatclient.Login(context.Background(), s.URL, name, password, s.Client())
+	if err != nil {
+		s.tb.Fatalf("log in as %s: %v", name, err)
+	}
+
+	return &Client{srv: s, tb: s.tb, session: session}
+}
+
+// Token returns the access token of the client, e.g. to call the API.
+func (c *Client) Token() string {
+	c.tb.Helper()
+
+	token, err := c.session.Token(context.Background())
+	if err != nil {
+		c.tb.Fatalf("get token: %v", err)
+	}
+
+	return token
+}
+
+// Name returns the name of the user of the client.
+func (c *Client) Name() string {
+	return c.session.User().Name
+}
+
+// Open opens the websocket of the room with a subprotocol
+// (protocol.SubprotocolJSON or protocol.SubprotocolHTML).
+// It is closed at the end of the test.
+func (c *Client) Open(subprotocol string) error {
+	c.tb.Helper()
+
+	ws, err := chatclient.Dial(context.Background(), c.srv.URL, c.Token(), subprotocol)
+	if err != nil {
+		return err
+	}
+	c.tb.Cleanup(func() { ws.Close() })
+
+	c.subprotocol = subprotocol
+	c.ws = ws
+	c.frames = make(chan []byte, framesBuffer)
+	go c.read(ws, c.frames)
+
+	return nil
+}
+
+// Connect opens the websocket of the room with the JSON subprotocol and waits for it to be ready.
+func (c *Client) Connect() *Client {
+	c.tb.Helper()
+
+	if err := c.Open(protocol.SubprotocolJSON); err != nil {
+		c.tb.Fatalf("%s: open websocket: %v", c.Name(), err)
+	}
+	c.ExpectEvent(protocol.TypeReady)
+
+	return c
+}
+
+// ConnectHTML opens the websocket of the room with the HTML subprotocol of the browsers.
+// The room is ready once the loading error is cleared, so wait for the fragments expected.
+func (c *Client) ConnectHTML() *Client {
+	c.tb.Helper()
+
+	if err := c.Open(protocol.SubprotocolHTML); err != nil {
+		c.tb.Fatalf("%s: open websocket: %v", c.Name(), err)
+	}
+
+	return c
+}
+
+// Close closes the websocket of the client.
+func (c *Client) Close() {
+	if c.ws != nil {
+		c.ws.Close()
+	}
+}
+
+func (c *Client) read(ws *websocket.Conn, frames chan<- []byte) {
+	defer close(frames)
+
+	for {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			return
+		}
+
+		frames <- frame
+	}
+}
+
+// Send posts a message in the room, like the form of the page when using the HTML subprotocol.
+// The returned ID matches the error frame of a rejected message in JSON.
+func (c *Client) Send(content string) string {
+	c.tb.Helper()
+
+	if c.subprotocol == protocol.SubprotocolHTML {
+		c.SendForm(map[string]string{"chat_message": content})
+		return ""
+	}
+
+	return c.SendFrame(protocol.TypeMessage, protocol.MessagePayload{Content: content})
+}
+
+// Command runs a slash command (e.g. "/who").
+func (c *Client) Command(input string) string {
+	c.tb.Helper()
+
+	if c.subprotocol == protocol.SubprotocolHTML {
+		c.SendForm(map[string]string{"chat_message": input})
+		return ""
+	}
+
+	return c.SendFrame(protocol.TypeCommand, protocol.CommandPayload{Input: input})
+}
+
+// SendFrame sends a JSON frame and returns its ID.
+func (c *Client) SendFrame(t protocol.Type, payload any) string {
+	c.tb.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.tb.Fatalf("marshal %s payload: %v", t, err)
+	}
+
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	frame, err := json.Marshal(protocol.Envelope{Type: t, ID: id, Payload: data})
+	if err != nil {
+		c.tb.Fatalf("marshal %s frame: %v", t, err)
+	}
+
+	c.SendRaw(frame)
+
+	return id
+}
+
+// SendForm sends the values of a form, like the htmx ws-send extension.
+func (c *Client) SendForm(values map[string]string) {
+	c.tb.Helper()
+
+	fields := map[string]any{"HEADERS": map[string]string{"HX-Request": "true"}}
+	for k, v := range values {
+		fields[k] = v
+	}
+
+	frame, err := json.Marshal(fields)
+	if err != nil {
+		c.tb.Fatalf("marshal form: %v", err)
+	}
+
+	c.SendRaw(frame)
+}
+
+// SendRaw sends a frame as is, e.g. to test invalid frames.
+func (c *Client) SendRaw(frame []byte) {
+	c.tb.Helper()
+
+	if c.ws == nil {
+		c.tb.Fatalf("%s: send frame: %v", c.Name(), chatclient.ErrNotConnected)
+	}
+
+	if err := websocket.Message.Send(c.ws, string(frame)); err != nil {
+		c.tb.Fatalf("%s: send frame: %v", c.Name(), err)
+	}
+}
+
+// Next returns the next frame received, failing the test if none is received in time
+// or if the websocket is closed.
+func (c *Client) Next() []byte {
+	c.tb.Helper()
+
+	frame, err := c.next()
+	if err != nil {
+		c.tb.Fatalf("%s: %v", c.Name(), err)
+	}
+
+	return frame
+}
+
+var errClosed = errors.New("websocket closed")
+
+func (c *Client) next() ([]byte, error) {
+	if c.frames == nil {
+		return nil, chatclient.ErrNotConnected
+	}
+
+	timer := time.NewTimer(c.srv.timeout)
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-c.frames:
+		if !ok {
+			return nil, errClosed
+		}
+		return frame, nil
+	case <-timer.C:
+		return nil, errors.New("no frame received in " + c.srv.timeout.String())
+	}
+}
+
+// Expect skips frames until one matches, failing the test if none does in time.
+func (c *Client) Expect(what string, match func(frame []byte) bool) []byte {
+	c.tb.Helper()
+
+	var skipped []string
+	for {
+		frame, err := c.next()
+		if err != nil {
+			c.tb.Fatalf("%s: expected %s: %v\nskipped frames:\n%s", c.Name(), what, err, strings.Join(skipped, "\n"))
+		}
+
+		if match(frame) {
+			return frame
+		}
+		skipped = append(skipped, string(frame))
+	}
+}
+
+// ExpectEvent skips frames until one of type t is received and returns it.
+func (c *Client) ExpectEvent(t protocol.Type) *protocol.Envelope {
+	c.tb.Helper()
+
+	var env protocol.Envelope
+	c.Expect("a "+string(t)+" event", func(frame []byte) bool {
+		return json.Unmarshal(frame, &env) == nil && env.Type == t
+	})
+
+	return &env
+}
+
+// ExpectMessage skips frames until a message with content is received and returns it.
+func (c *Client) ExpectMessage(content string) protocol.MessageEvent {
+	c.tb.Helper()
+
+	var msg protocol.MessageEvent
+	c.Expect("message "+strconv.Quote(content), func(frame []byte) bool {
+		var env protocol.Envelope
+		return json.Unmarshal(frame, &env) == nil && env.Type == protocol.TypeMessage &&
+			json.Unmarshal(env.Payload, &msg) == nil && msg.Content == content
+	})
+
+	return msg
+}
+
+// ExpectError skips frames until an error with code is received and returns it.
+func (c *Client) ExpectError(code string) protocol.ErrorPayload {
+	c.tb.Helper()
+
+	var p protocol.ErrorPayload
+	c.Expect("error "+code, func(frame []byte) bool {
+		var env protocol.Envelope
+		return json.Unmarshal(frame, &env) == nil && env.Type == protocol.TypeError &&
+			json.Unmarshal(env.Payload, &p) == nil && p.Code == code
+	})
+
+	return p
+}
+
+// ExpectFragment skips frames until a fragment containing substr is received and returns it.
+func (c *Client) ExpectFragment(substr string) string {
+	c.tb.Helper()
+
+	frame := c.Expect("a fragment containing "+strconv.Quote(substr), func(frame []byte) bool {
+		return strings.Contains(string(frame), substr)
+	})
+
+	return string(frame)
+}
+
+// ExpectClosed skips frames until the server closes the websocket (e.g. after kicking the client).
+func (c *Client) ExpectClosed() {
+	c.tb.Helper()
+
+	var skipped []string
+	for {
+		frame, err := c.next()
+		if errors.Is(err, errClosed) {
+			return
+		}
+		if err != nil {
+			c.tb.Fatalf("%s: expected the websocket to close: %v\nskipped frames:\n%s", c.Name(), err, strings.Join(skipped, "\n"))
+		}
+		skipped = append(skipped, string(frame))
+	}
+}
+
+// Do calls the server as the client, e.g. to call the API or to assert on rendered pages.
+func (c *Client) Do(method, path string, body io.Reader) *http.Response {
+	c.tb.Helper()
+
+	req, err := http.NewRequest(method, c.srv.URL+path, body)
+	if err != nil {
+		c.tb.Fatalf("create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.tb.Fatalf("%s %s: %v", method, path, err)
+	}
+	c.tb.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
@@ -0,0 +1,218 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+)
+
+const kickDuration = 1 * time.Minute
+
+// ErrInvalidDuration is returned when a duration argument is not a positive number.
+var ErrInvalidDuration = chat.NewError(chat.ErrorSeverityError, false, "duration must be a positive number of minutes")
+
+// Builtins returns the list of built-in commands.
+func Builtins() []*Command {
+	return []*Command{
+		{
+			Name: "help",
+			Args: []Arg{{Name: "command", Optional: true}},
+			Help: "List the available commands or show the usage of a command.",
+			Run:  help,
+		},
+		{
+			Name:  "me",
+			Args:  []Arg{{Name: "action", Variadic: true}},
+			Posts: true,
+			Help:  "Describe what you are doing.",
+			Run:   me,
+		},
+		{
+			Name: "nick",
+			Args: []Arg{{Name: "name", Variadic: true}},
+			Help: "Change your display name.",
+			Run:  nick,
+		},
+		{
+			Name: "who",
+			Help: "List the users in the room.",
+			Run:  who,
+		},
+		{
+			Name: "kick",
+			Args: []Arg{{Name: "user", Variadic: true}},
+			Role: user.RoleModerator,
+			Help: "Disconnect a user from the room for a minute.",
+			Run:  kick,
+		},
+		{
+			Name: "mute",
+			Args: []Arg{{Name: "minutes"}, {Name: "user", Variadic: true}},
+			Role: user.RoleModerator,
+			Help: "Prevent a user from sending messages for a number of minutes.",
+			Run:  mute,
+		},
+		{
+			Name: "unmute",
+			Args: []Arg{{Name: "user", Variadic: true}},
+			Role: user.RoleModerator,
+			Help: "Allow a muted user to send messages again.",
+			Run:  unmute,
+		},
+	}
+}
+
+func help(ctx context.Context, env *Env, args []string) error {
+	if len(args) == 1 {
+		cmd, found := env.Registry.Lookup(strings.TrimPrefix(args[0], Prefix))
+		if !found || !env.User.HasRole(cmd.Role) {
+			return ErrUnknownCommand
+		}
+
+		return env.Reply(ctx, cmd.Usage(), cmd.Help)
+	}
+
+	cmds := env.Registry.Commands(env.User)
+	lines := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		lines = append(lines, cmd.Usage()+" - "+cmd.Help)
+	}
+
+	return env.Reply(ctx, lines...)
+}
+
+func me(ctx context.Context, env *Env, args []string) error {
+	msg, err := chat.NewAction(env.User, args[0])
+	if err != nil {
+		return err
+	}
+
+	return env.Broadcast(ctx, msg)
+}
+
+func nick(ctx context.Context, env *Env, args []string) error {
+	name, err := user.NormalizeName(args[0])
+	if err != nil {
+		return chat.NewError(chat.ErrorSeverityError, false, err.Error())
+	}
+
+	previous := env.User.Name
+	renamed, err := env.Room.Rename(env.User.ID, name)
+	if err != nil {
+		return err
+	}
+	env.User = renamed
+
+	if err := env.UpdateUser(ctx, renamed); err != nil {
+		return err
+	}
+
+	msg, err := chat.NewSystemMessage(fmt.Sprintf("%s is now known as %s", previous, name))
+	if err != nil {
+		return err
+	}
+
+	return env.Broadcast(ctx, msg)
+}
+
+func who(ctx context.Context, env *Env, _ []string) error {
+	users := env.Room.Users()
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		name := u.Name
+		if u.Role > user.RoleMember {
+			name += " (" + u.Role.String() + ")"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return env.Reply(ctx, fmt.Sprintf("%d online: %s", len(names), strings.Join(names, ", ")))
+}
+
+func kick(ctx context.Context, env *Env, args []string) error {
+	target, found := env.Room.FindUser(args[0])
+	if !found {
+		return ErrUserNotFound
+	}
+
+	if target.HasRole(env.User.Role) {
+		return ErrForbidden
+	}
+
+	env.Room.Kick(target.ID, kickDuration)
+	env.Room.Broadcast(ctx, chat.ModerationEvent{
+		Action:    chat.ModerationKick,
+		Target:    target,
+		Moderator: env.User,
+		Duration:  kickDuration,
+		Time:      time.Now().UTC(),
+	})
+
+	msg, err := chat.NewSystemMessage(fmt.Sprintf("%s was kicked by %s", target.Name, env.User.Name))
+	if err != nil {
+		return err
+	}
+
+	return env.Broadcast(ctx, msg)
+}
+
+func mute(ctx context.Context, env *Env, args []string) error {
+	minutes, err := strconv.Atoi(args[0])
+	if err != nil || minutes <= 0 {
+		return ErrInvalidDuration
+	}
+	d := time.Duration(minutes) * time.Minute
+
+	target, found := env.Room.FindUser(args[1])
+	if !found {
+		return ErrUserNotFound
+	}
+
+	if target.HasRole(env.User.Role) {
+		return ErrForbidden
+	}
+
+	env.Room.Mute(target.ID, d)
+	env.Room.Broadcast(ctx, chat.ModerationEvent{
+		Action:    chat.ModerationMute,
+		Target:    target,
+		Moderator: env.User,
+		Duration:  d,
+		Time:      time.Now().UTC(),
+	})
+
+	msg, err := chat.NewSystemMessage(fmt.Sprintf("%s was muted for %s by %s", target.Name, d, env.User.Name))
+	if err != nil {
+		return err
+	}
+
+	return env.Broadcast(ctx, msg)
+}
+
+func unmute(ctx context.Context, env *Env, args []string) error {
+	target, found := env.Room.FindUser(args[0])
+	if !found {
+		return ErrUserNotFound
+	}
+
+	if target.HasRole(env.User.Role) {
+		return ErrForbidden
+	}
+
+	env.Room.Mute(target.ID, 0)
+	env.Room.Broadcast(ctx, chat.ModerationEvent{
+		Action:    chat.ModerationUnmute,
+		Target:    target,
+		Moderator: env.User,
+		Time:      time.Now().UTC(),
+	})
+
+	return env.Reply(ctx, target.Name+" can send messages again")
+}
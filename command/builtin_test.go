@@ -0,0 +1,277 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// responder records the output of the commands.
+type responder struct {
+	replies    []string
+	broadcasts []string
+	updated    *user.User
+}
+
+func (r *responder) Reply(_ context.Context, lines ...string) error {
+	r.replies = append(r.replies, lines...)
+	return nil
+}
+
+func (r *responder) Broadcast(_ context.Context, msg *chat.Message) error {
+	r.broadcasts = append(r.broadcasts, msg.Content)
+	return nil
+}
+
+func (r *responder) UpdateUser(_ context.Context, u *user.User) error {
+	r.updated = u
+	return nil
+}
+
+type nopEncoder struct{}
+
+func (nopEncoder) Encode(context.Context, io.Writer, *user.User, chat.Event) error { return nil }
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+// join connects a new user to the room.
+func join(t *testing.T, room *chat.Room, name string, role user.Role) *user.User {
+	t.Helper()
+
+	u := user.NewNamed(name)
+	u.Role = role
+	if err := room.AddClient(chat.NewConn(context.Background(), u, nopWriteCloser{}), nopEncoder{}); err != nil {
+		t.Fatalf("join %s: %v", name, err)
+	}
+
+	return u
+}
+
+// run runs the input as u with the builtin commands.
+func run(t *testing.T, room *chat.Room, u *user.User, input string) (*responder, error) {
+	t.Helper()
+
+	r := NewRegistry()
+	if err := r.Register(Builtins()...); err != nil {
+		t.Fatalf("register builtins: %v", err)
+	}
+
+	resp := &responder{}
+	err := r.Execute(context.Background(), &Env{User: u, Room: room, Registry: r, Responder: resp}, input)
+
+	return resp, err
+}
+
+func TestModeration(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		role       user.Role
+		targetRole user.Role
+		muted      bool
+		wantErr    error
+		// want checks the target after the command succeeded.
+		want func(room *chat.Room, target *user.User) bool
+	}{
+		{
+			name: "moderator kicks member", command: "/kick Bob", role: user.RoleModerator,
+			want: func(room *chat.Room, target *user.User) bool {
+				// The transport removes the client once its connection is closed.
+				room.RemoveClient(target.ID)
+				err := room.AddClient(chat.NewConn(context.Background(), target, nopWriteCloser{}), nopEncoder{})
+				return errors.Is(err, chat.ErrKicked)
+			},
+		},
+		{name: "moderator kicks moderator", command: "/kick Bob", role: user.RoleModerator, targetRole: user.RoleModerator, wantErr: ErrForbidden},
+		{name: "moderator kicks admin", command: "/kick Bob", role: user.RoleModerator, targetRole: user.RoleAdmin, wantErr: ErrForbidden},
+		{name: "member kicks", command: "/kick Bob", wantErr: ErrForbidden},
+		{name: "kick unknown user", command: "/kick Nobody", role: user.RoleModerator, wantErr: ErrUserNotFound},
+		{
+			name: "admin mutes moderator", command: "/mute 5 Bob", role: user.RoleAdmin, targetRole: user.RoleModerator,
+			want: func(room *chat.Room, target *user.User) bool { return room.IsMuted(target.ID) },
+		},
+		{name: "moderator mutes admin", command: "/mute 5 Bob", role: user.RoleModerator, targetRole: user.RoleAdmin, wantErr: ErrForbidden},
+		{name: "mute for no time", command: "/mute 0 Bob", role: user.RoleModerator, wantErr: ErrInvalidDuration},
+		{name: "mute for nonsense", command: "/mute soon Bob", role: user.RoleModerator, wantErr: ErrInvalidDuration},
+		{
+			name: "moderator unmutes member", command: "/unmute Bob", role: user.RoleModerator, muted: true,
+			want: func(room *chat.Room, target *user.User) bool { return !room.IsMuted(target.ID) },
+		},
+		{name: "moderator unmutes moderator", command: "/unmute Bob", role: user.RoleModerator, targetRole: user.RoleModerator, muted: true, wantErr: ErrForbidden},
+		{name: "moderator unmutes admin", command: "/unmute Bob", role: user.RoleModerator, targetRole: user.RoleAdmin, muted: true, wantErr: ErrForbidden},
+		{name: "member unmutes", command: "/unmute Bob", muted: true, wantErr: ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := chat.NewRoom(chat.RoomOptions{})
+			moderator := join(t, room, "Alice", tt.role)
+			target := join(t, room, "Bob", tt.targetRole)
+			if tt.muted {
+				room.Mute(target.ID, kickDuration)
+			}
+
+			_, err := run(t, room, moderator, tt.command)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				// Nothing changed.
+				if _, found := room.GetClient(target.ID); !found || room.IsMuted(target.ID) != tt.muted {
+					t.Fatal("target was moderated")
+				}
+				return
+			}
+
+			if !tt.want(room, target) {
+				t.Fatal("target was not moderated")
+			}
+		})
+	}
+}
+
+func TestHelp(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    user.Role
+		input   string
+		want    []string
+		notWant []string
+		wantErr error
+	}{
+		{name: "member", input: "/help", want: []string{"/me", "/nick", "/who"}, notWant: []string{"/kick"}},
+		{name: "moderator", role: user.RoleModerator, input: "/help", want: []string{"/kick", "/mute", "/unmute"}},
+		{name: "usage", input: "/help /me", want: []string{"/me <action...>"}},
+		{name: "usage without prefix", input: "/help who", want: []string{"/who"}},
+		{name: "usage of a forbidden command", input: "/help kick", wantErr: ErrUnknownCommand},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := chat.NewRoom(chat.RoomOptions{})
+			u := join(t, room, "Alice", tt.role)
+
+			resp, err := run(t, room, u, tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			out := strings.Join(resp.replies, "\n")
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("help does not mention %s:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(out, s) {
+					t.Errorf("help mentions %s:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestWho(t *testing.T) {
+	room := chat.NewRoom(chat.RoomOptions{})
+	alice := join(t, room, "Alice", user.RoleMember)
+	join(t, room, "Bob", user.RoleModerator)
+
+	resp, err := run(t, room, alice, "/who")
+	if err != nil {
+		t.Fatalf("who: %v", err)
+	}
+
+	if want := []string{"2 online: Alice, Bob (moderator)"}; !slices.Equal(resp.replies, want) {
+		t.Fatalf("got %q, want %q", resp.replies, want)
+	}
+}
+
+func TestMe(t *testing.T) {
+	tests := []struct {
+		name    string
+		muted   bool
+		want    []string
+		wantErr error
+	}{
+		{name: "action", want: []string{"waves hello"}},
+		{name: "muted", muted: true, wantErr: chat.ErrMuted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := chat.NewRoom(chat.RoomOptions{})
+			alice := join(t, room, "Alice", user.RoleMember)
+			if tt.muted {
+				room.Mute(alice.ID, time.Minute)
+			}
+
+			resp, err := run(t, room, alice, "/me waves  hello")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !slices.Equal(resp.broadcasts, tt.want) {
+				t.Fatalf("broadcast %q, want %q", resp.broadcasts, tt.want)
+			}
+		})
+	}
+}
+
+func TestNick(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "free name", input: "/nick Carol", want: "Carol"},
+		{name: "name with spaces", input: "/nick Jean  Pierre", want: "Jean Pierre"},
+		{name: "connected user", input: "/nick bob", wantErr: chat.ErrNameTaken},
+		{name: "registered account", input: "/nick Dave", wantErr: chat.ErrNameTaken},
+		{name: "invalid name", input: "/nick a", wantErr: chat.NewError(chat.ErrorSeverityError, false, user.ErrNameLength.Error())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := chat.NewRoom(chat.RoomOptions{
+				NameOwner: func(name string) (xid.ID, bool) {
+					return xid.New(), user.Skeleton(name) == user.Skeleton("Dave")
+				},
+			})
+			alice := join(t, room, "Alice", user.RoleMember)
+			join(t, room, "Bob", user.RoleMember)
+
+			resp, err := run(t, room, alice, tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			u, _ := room.User(alice.ID)
+			if tt.wantErr != nil {
+				if u.Name != "Alice" || resp.updated != nil || len(resp.broadcasts) > 0 {
+					t.Fatalf("user was renamed to %q", u.Name)
+				}
+				return
+			}
+
+			if u.Name != tt.want || resp.updated == nil || resp.updated.Name != tt.want {
+				t.Fatalf("user is named %q, want %q", u.Name, tt.want)
+			}
+			if want := []string{"Alice is now known as " + tt.want}; !slices.Equal(resp.broadcasts, want) {
+				t.Fatalf("broadcast %q, want %q", resp.broadcasts, want)
+			}
+		})
+	}
+}
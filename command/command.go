@@ -0,0 +1,201 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// Prefix is the prefix which marks a message as a command.
+const Prefix = "/"
+
+// List of command errors.
+var (
+	ErrUnknownCommand = chat.NewError(chat.ErrorSeverityError, false, "unknown command, try /help")
+	ErrForbidden      = chat.NewError(chat.ErrorSeverityError, false, "you are not allowed to use this command")
+	ErrUserNotFound   = chat.ErrUserNotFound
+)
+
+// ErrDuplicateCommand is returned when registering a command name twice.
+var ErrDuplicateCommand = errors.New("command already registered")
+
+// Responder sends the output of a command back to the caller or to the whole room.
+type Responder interface {
+	// Reply sends lines of text privately to the caller.
+	Reply(ctx context.Context, lines ...string) error
+	// Broadcast adds a message to the room and sends it to all the clients.
+	Broadcast(ctx context.Context, msg *chat.Message) error
+	// UpdateUser informs the caller that its user changed (e.g. to reissue its session).
+	UpdateUser(ctx context.Context, u *user.User) error
+}
+
+// Env is the environment a command runs in.
+type Env struct {
+	User     *user.User
+	Room     *chat.Room
+	Registry *Registry
+	Responder
+}
+
+// Arg describes a single command argument.
+type Arg struct {
+	Name     string
+	Optional bool
+	// Variadic consumes the remaining input, spaces included.
+	// It must be the last argument.
+	Variadic bool
+}
+
+// Command is a single slash command.
+type Command struct {
+	Name string
+	Args []Arg
+	// Role is the minimum role required to run the command.
+	Role user.Role
+	// Posts marks the commands posting messages in the room, which muted users cannot run.
+	Posts bool
+	Help  string
+	Run   func(ctx context.Context, env *Env, args []string) error
+}
+
+// Usage returns the usage line of the command (e.g. "/kick <user...>").
+func (c *Command) Usage() string {
+	var sb strings.Builder
+	sb.WriteString(Prefix + c.Name)
+	for _, a := range c.Args {
+		name := a.Name
+		if a.Variadic {
+			name += "..."
+		}
+
+		if a.Optional {
+			sb.WriteString(" [" + name + "]")
+		} else {
+			sb.WriteString(" <" + name + ">")
+		}
+	}
+
+	return sb.String()
+}
+
+// parse splits the input into arguments according to the command schema.
+func (c *Command) parse(input string) ([]string, error) {
+	fields := strings.Fields(input)
+	args := make([]string, 0, len(c.Args))
+	for i, a := range c.Args {
+		if i >= len(fields) {
+			if !a.Optional {
+				return nil, c.usageError()
+			}
+
+			break
+		}
+
+		if a.Variadic {
+			args = append(args, strings.Join(fields[i:], " "))
+			return args, nil
+		}
+
+		args = append(args, fields[i])
+	}
+
+	if len(fields) > len(c.Args) {
+		return nil, c.usageError()
+	}
+
+	return args, nil
+}
+
+func (c *Command) usageError() error {
+	return chat.NewError(chat.ErrorSeverityError, false, "usage: "+c.Usage())
+}
+
+// Registry holds a set of commands.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Register adds commands to the registry.
+func (r *Registry) Register(cmds ...*Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, cmd := range cmds {
+		name := strings.ToLower(cmd.Name)
+		if _, found := r.commands[name]; found {
+			return fmt.Errorf("%s: %w", name, ErrDuplicateCommand)
+		}
+
+		r.commands[name] = cmd
+	}
+
+	return nil
+}
+
+// Lookup finds a command by name.
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, found := r.commands[strings.ToLower(name)]
+	return cmd, found
+}
+
+// Commands returns the commands available to a user sorted by name.
+func (r *Registry) Commands(u *user.User) []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmds := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		if u.HasRole(cmd.Role) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+
+	return cmds
+}
+
+// IsCommand checks if the input should be handled as a command.
+func IsCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), Prefix)
+}
+
+// Execute parses the input, checks permissions and runs the matching command.
+func (r *Registry) Execute(ctx context.Context, env *Env, input string) error {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(input), Prefix), " ")
+	cmd, found := r.Lookup(name)
+	if !found {
+		return ErrUnknownCommand
+	}
+
+	if !env.User.HasRole(cmd.Role) {
+		return ErrForbidden
+	}
+
+	if cmd.Posts && env.Room.IsMuted(env.User.ID) {
+		return chat.ErrMuted
+	}
+
+	args, err := cmd.parse(rest)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Run(ctx, env, args)
+}
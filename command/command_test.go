@@ -0,0 +1,155 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+)
+
+func TestCommandUsage(t *testing.T) {
+	tests := []struct {
+		args []Arg
+		want string
+	}{
+		{args: nil, want: "/cmd"},
+		{args: []Arg{{Name: "a"}}, want: "/cmd <a>"},
+		{args: []Arg{{Name: "a", Optional: true}}, want: "/cmd [a]"},
+		{args: []Arg{{Name: "a"}, {Name: "b", Variadic: true}}, want: "/cmd <a> <b...>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			cmd := &Command{Name: "cmd", Args: tt.args}
+			if got := cmd.Usage(); got != tt.want {
+				t.Fatalf("got usage %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []Arg
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no args", input: "", want: []string{}},
+		{name: "unexpected arg", input: "x", wantErr: true},
+		{name: "required arg", args: []Arg{{Name: "a"}}, input: "x", want: []string{"x"}},
+		{name: "missing required arg", args: []Arg{{Name: "a"}}, input: "  ", wantErr: true},
+		{name: "missing optional arg", args: []Arg{{Name: "a", Optional: true}}, input: "", want: []string{}},
+		{name: "too many args", args: []Arg{{Name: "a"}}, input: "x y", wantErr: true},
+		{name: "variadic", args: []Arg{{Name: "a"}, {Name: "b", Variadic: true}}, input: "5  Jean   Pierre", want: []string{"5", "Jean Pierre"}},
+		{name: "missing variadic", args: []Arg{{Name: "a"}, {Name: "b", Variadic: true}}, input: "5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &Command{Name: "cmd", Args: tt.args}
+			got, err := cmd.parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got args %q, want a usage error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got args %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register(&Command{Name: "help"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := r.Register(&Command{Name: "HELP"}); !errors.Is(err, ErrDuplicateCommand) {
+		t.Fatalf("got error %v, want %v", err, ErrDuplicateCommand)
+	}
+
+	if _, found := r.Lookup("Help"); !found {
+		t.Fatal("lookup is not case-insensitive")
+	}
+}
+
+func TestRegistryExecute(t *testing.T) {
+	var ran []string
+	r := NewRegistry()
+	err := r.Register(
+		&Command{
+			Name: "echo",
+			Args: []Arg{{Name: "text", Variadic: true}},
+			Run: func(_ context.Context, _ *Env, args []string) error {
+				ran = args
+				return nil
+			},
+		},
+		&Command{
+			Name: "ban",
+			Role: user.RoleModerator,
+			Run:  func(context.Context, *Env, []string) error { return nil },
+		},
+	)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		role    user.Role
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{name: "run", input: "/echo hello  world", want: []string{"hello world"}},
+		{name: "surrounding spaces", input: "  /ECHO hi ", want: []string{"hi"}},
+		{name: "unknown", input: "/nope", wantErr: ErrUnknownCommand},
+		{name: "forbidden", input: "/ban", wantErr: ErrForbidden},
+		{name: "allowed", role: user.RoleModerator, input: "/ban"},
+		{name: "higher role", role: user.RoleAdmin, input: "/ban"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			env := &Env{User: &user.User{Name: "Alice", Role: tt.role}, Registry: r}
+			err := r.Execute(context.Background(), env, tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !slices.Equal(ran, tt.want) {
+				t.Fatalf("ran with %q, want %q", ran, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCommand(t *testing.T) {
+	tests := map[string]bool{
+		"/help":     true,
+		"  /me hi":  true,
+		"hello":     false,
+		"a /b":      false,
+		"":          false,
+		"// hello?": true,
+	}
+
+	for input, want := range tests {
+		if got := IsCommand(input); got != want {
+			t.Errorf("IsCommand(%q) = %t, want %t", input, got, want)
+		}
+	}
+}
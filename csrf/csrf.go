@@ -0,0 +1,101 @@
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type csrfContextKey string
+
+const tokenCtxKey csrfContextKey = "token"
+
+// FieldName is the name of the form field holding the token.
+const FieldName = "csrf_token"
+
+// HeaderName is the name of the header holding the token (e.g. for htmx or fetch requests).
+const HeaderName = "X-CSRF-Token"
+
+// List of csrf errors.
+var (
+	ErrInvalidToken    = errors.New("invalid csrf token")
+	ErrUntrustedOrigin = errors.New("untrusted origin")
+)
+
+// Protect rejects state-changing requests which do not submit the token
+// of their csrf cookie (double-submit) or which come from an untrusted origin.
+//
+// The cookie is a template for the csrf cookie (name, domain, secure...).
+// Requests authenticated with an Authorization header are not protected
+// since browsers never send that header on their own.
+func Protect(cookie http.Cookie, trusted func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var token string
+			if c, err := r.Cookie(cookie.Name); err == nil && c.Value != "" {
+				token = c.Value
+			} else {
+				var err error
+				if token, err = newToken(); err != nil {
+					http.Error(w, "failed to generate csrf token", http.StatusInternalServerError)
+					return
+				}
+
+				// The cookie lives as long as the browser session so that
+				// it does not expire while a form is open.
+				c := cookie
+				c.Value = token
+				c.Expires = time.Time{}
+				c.MaxAge = 0
+				c.HttpOnly = true
+				http.SetCookie(w, &c)
+			}
+
+			if !isSafe(r.Method) && r.Header.Get("Authorization") == "" {
+				if r.Header.Get("Origin") != "" && !trusted(r) {
+					http.Error(w, ErrUntrustedOrigin.Error(), http.StatusForbidden)
+					return
+				}
+
+				submitted := r.Header.Get(HeaderName)
+				if submitted == "" {
+					submitted = r.PostFormValue(FieldName)
+				}
+				if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+					http.Error(w, ErrInvalidToken.Error(), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenCtxKey, token)))
+		})
+	}
+}
+
+// Token retrieves the csrf token of the request from the context.
+func Token(ctx context.Context) string {
+	token, _ := ctx.Value(tokenCtxKey).(string)
+	return token
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
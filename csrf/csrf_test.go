@@ -0,0 +1,97 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	const token = "token-of-the-cookie"
+
+	tests := []struct {
+		name   string
+		method string
+		// cookie is the csrf cookie sent, none if empty.
+		cookie string
+		form   url.Values
+		header http.Header
+		// trusted is the result of the origin check.
+		trusted    bool
+		wantStatus int
+	}{
+		{name: "safe request", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "form token", method: http.MethodPost, cookie: token, form: url.Values{FieldName: {token}}, wantStatus: http.StatusOK},
+		{name: "header token", method: http.MethodDelete, cookie: token, header: http.Header{HeaderName: {token}}, wantStatus: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, cookie: token, wantStatus: http.StatusForbidden},
+		{name: "wrong token", method: http.MethodPost, cookie: token, form: url.Values{FieldName: {"forged"}}, wantStatus: http.StatusForbidden},
+		{name: "missing cookie", method: http.MethodPost, form: url.Values{FieldName: {token}}, wantStatus: http.StatusForbidden},
+		{
+			name: "trusted origin", method: http.MethodPost, cookie: token, form: url.Values{FieldName: {token}},
+			header: http.Header{"Origin": {"https://chat.example.com"}}, trusted: true, wantStatus: http.StatusOK,
+		},
+		{
+			name: "untrusted origin", method: http.MethodPost, cookie: token, form: url.Values{FieldName: {token}},
+			header: http.Header{"Origin": {"https://evil.example.com"}}, wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "authorization header", method: http.MethodPost,
+			header: http.Header{"Authorization": {"Bearer api-token"}, "Origin": {"https://evil.example.com"}}, wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := Protect(http.Cookie{Name: "csrf", Path: "/", Secure: true}, func(*http.Request) bool { return tt.trusted })(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = Token(r.Context()) }),
+			)
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.form.Encode()))
+			if tt.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v[0])
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "csrf", Value: tt.cookie})
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			// A token is issued to browsers without one, for the whole browser session.
+			cookies := rec.Result().Cookies()
+			if tt.cookie != "" {
+				if len(cookies) != 0 {
+					t.Fatalf("got cookies %v, want the token of the request kept", cookies)
+				}
+			} else {
+				if len(cookies) != 1 || cookies[0].Name != "csrf" || cookies[0].Value == "" {
+					t.Fatalf("got cookies %v, want a new csrf cookie", cookies)
+				}
+				if c := cookies[0]; !c.HttpOnly || !c.Secure || !c.Expires.IsZero() || c.MaxAge != 0 {
+					t.Fatalf("got cookie %v, want a secure session cookie hidden from scripts", c)
+				}
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			want := tt.cookie
+			if want == "" {
+				want = cookies[0].Value
+			}
+			if got != want {
+				t.Fatalf("got token %q in the context, want %q", got, want)
+			}
+		})
+	}
+}
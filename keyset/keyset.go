@@ -0,0 +1,333 @@
+package keyset
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"golang.org/x/exp/slog"
+)
+
+// List of supported signing algorithms.
+const (
+	HS256 = jwa.HS256
+	RS256 = jwa.RS256
+	EdDSA = jwa.EdDSA
+)
+
+// List of keyset errors.
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported key type")
+)
+
+// KeySet signs tokens with a single key and verifies them against all active keys.
+// Keys retired by a rotation keep verifying tokens until those expire.
+type KeySet struct {
+	mu      sync.RWMutex
+	alg     jwa.SignatureAlgorithm
+	ttl     time.Duration
+	signing jwk.Key
+	// keys holds the verification keys: public keys and shared secrets.
+	keys    jwk.Set
+	retired map[string]time.Time
+}
+
+// New creates a new empty KeySet issuing tokens valid for ttl.
+// Keys generated on rotation use the algorithm alg.
+func New(alg jwa.SignatureAlgorithm, ttl time.Duration) (*KeySet, error) {
+	switch alg {
+	case HS256, RS256, EdDSA:
+	default:
+		return nil, fmt.Errorf("%s: %w", alg, ErrUnsupportedAlgorithm)
+	}
+
+	return &KeySet{
+		alg:     alg,
+		ttl:     ttl,
+		keys:    jwk.NewSet(),
+		retired: make(map[string]time.Time),
+	}, nil
+}
+
+// TTL returns the lifetime of the tokens issued by the KeySet.
+func (ks *KeySet) TTL() time.Duration {
+	return ks.ttl
+}
+
+// Add adds a key to the set. The last key added with signing set is used to sign new tokens.
+func (ks *KeySet) Add(key jwk.Key, signing bool) error {
+	alg, err := algorithmOf(key)
+	if err != nil {
+		return err
+	}
+
+	if err := key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return fmt.Errorf("set key algorithm: %w", err)
+	}
+
+	if key.KeyID() == "" {
+		if err := jwk.AssignKeyID(key); err != nil {
+			return fmt.Errorf("assign key id: %w", err)
+		}
+	}
+
+	// Signatures of asymmetric keys are verified with their public key.
+	verify := key
+	if key.KeyType() != jwa.OctetSeq {
+		if verify, err = key.PublicKey(); err != nil {
+			return fmt.Errorf("public key: %w", err)
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.keys.AddKey(verify); err != nil {
+		return fmt.Errorf("add key: %w", err)
+	}
+
+	if signing {
+		ks.retire()
+		ks.signing = key
+	}
+
+	return nil
+}
+
+// AddSecret adds a HS256 shared secret.
+func (ks *KeySet) AddSecret(secret []byte, signing bool) error {
+	key, err := jwk.FromRaw(secret)
+	if err != nil {
+		return fmt.Errorf("create secret key: %w", err)
+	}
+
+	// Derive the key ID from the secret so that it is stable across restarts
+	// without exposing the secret itself.
+	sum := sha256.Sum256(append([]byte("kid:"), secret...))
+	if err := key.Set(jwk.KeyIDKey, base64.RawURLEncoding.EncodeToString(sum[:8])); err != nil {
+		return fmt.Errorf("set key id: %w", err)
+	}
+
+	return ks.Add(key, signing)
+}
+
+// Rotate generates a new signing key and retires the current one.
+func (ks *KeySet) Rotate() error {
+	key, err := generate(ks.alg)
+	if err != nil {
+		return err
+	}
+
+	return ks.Add(key, true)
+}
+
+// RotateEvery rotates the signing key at every interval until ctx is done.
+func (ks *KeySet) RotateEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Rotate(); err != nil {
+				slog.ErrorContext(ctx, "rotate signing key", "err", err)
+				continue
+			}
+			slog.InfoContext(ctx, "rotated signing key", "kid", ks.SigningKeyID())
+		}
+	}
+}
+
+// SigningKeyID returns the ID of the current signing key.
+func (ks *KeySet) SigningKeyID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if ks.signing == nil {
+		return ""
+	}
+
+	return ks.signing.KeyID()
+}
+
+// Encode signs a new token with the claims, expiring after the TTL of the KeySet.
+func (ks *KeySet) Encode(claims map[string]any) (jwt.Token, string, error) {
+	ks.mu.RLock()
+	key := ks.signing
+	ks.mu.RUnlock()
+	if key == nil {
+		return nil, "", errors.New("no signing key")
+	}
+
+	now := time.Now()
+	t := jwt.New()
+	for k, v := range claims {
+		if err := t.Set(k, v); err != nil {
+			return nil, "", fmt.Errorf("set claim %q: %w", k, err)
+		}
+	}
+	if _, found := t.Get(jwt.ExpirationKey); !found {
+		if err := t.Set(jwt.ExpirationKey, now.Add(ks.ttl)); err != nil {
+			return nil, "", fmt.Errorf("set expiration: %w", err)
+		}
+	}
+	if err := t.Set(jwt.IssuedAtKey, now); err != nil {
+		return nil, "", fmt.Errorf("set issued at: %w", err)
+	}
+
+	// The kid header is taken from the key.
+	signed, err := jwt.Sign(t, jwt.WithKey(key.Algorithm(), key))
+	if err != nil {
+		return nil, "", fmt.Errorf("sign token: %w", err)
+	}
+
+	return t, string(signed), nil
+}
+
+// Decode verifies the signature of a token against the active keys.
+// Claims (e.g. expiration) are not validated.
+func (ks *KeySet) Decode(token string) (jwt.Token, error) {
+	ks.mu.Lock()
+	ks.prune()
+	ks.mu.Unlock()
+
+	// Tokens issued before key IDs were introduced have no kid header.
+	return jwt.ParseString(token,
+		jwt.WithKeySet(ks.keys, jws.WithRequireKid(false)),
+		jwt.WithValidate(false),
+	)
+}
+
+// PublicSet returns the public keys of the asymmetric keys, including the retired ones.
+// Shared secrets are never published.
+func (ks *KeySet) PublicSet() (jwk.Set, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.prune()
+
+	set := jwk.NewSet()
+	for i := 0; i < ks.keys.Len(); i++ {
+		key, _ := ks.keys.Key(i)
+		if key.KeyType() == jwa.OctetSeq {
+			continue
+		}
+
+		if err := set.AddKey(key); err != nil {
+			return nil, fmt.Errorf("add public key %q: %w", key.KeyID(), err)
+		}
+	}
+
+	return set, nil
+}
+
+// retire marks the current signing key as retired.
+// It must be called with the lock held.
+func (ks *KeySet) retire() {
+	if ks.signing != nil {
+		ks.retired[ks.signing.KeyID()] = time.Now()
+	}
+}
+
+// prune removes the retired keys which cannot have signed an unexpired token anymore.
+// It must be called with the lock held.
+func (ks *KeySet) prune() {
+	for kid, at := range ks.retired {
+		if time.Since(at) < ks.ttl {
+			continue
+		}
+
+		if key, found := ks.keys.LookupKeyID(kid); found {
+			ks.keys.RemoveKey(key)
+		}
+		delete(ks.retired, kid)
+	}
+}
+
+// LoadFile loads a key from a file which can either contain
+// a PEM encoded private key (RSA or Ed25519), a JWK or a raw HS256 secret.
+func LoadFile(path string) (jwk.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	data = bytes.TrimSpace(data)
+
+	var key jwk.Key
+	switch {
+	case bytes.HasPrefix(data, []byte("-----BEGIN")):
+		key, err = jwk.ParseKey(data, jwk.WithPEM(true))
+	case bytes.HasPrefix(data, []byte("{")):
+		key, err = jwk.ParseKey(data)
+	default:
+		key, err = jwk.FromRaw(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key file %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+func generate(alg jwa.SignatureAlgorithm) (jwk.Key, error) {
+	var raw any
+	switch alg {
+	case HS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate secret: %w", err)
+		}
+		raw = secret
+	case RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("generate rsa key: %w", err)
+		}
+		raw = key
+	case EdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate ed25519 key: %w", err)
+		}
+		raw = key
+	default:
+		return nil, fmt.Errorf("%s: %w", alg, ErrUnsupportedAlgorithm)
+	}
+
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, fmt.Errorf("create jwk: %w", err)
+	}
+
+	return key, nil
+}
+
+// algorithmOf returns the signing algorithm matching the type of a private key.
+func algorithmOf(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	switch key.(type) {
+	case jwk.SymmetricKey:
+		return HS256, nil
+	case jwk.RSAPrivateKey:
+		return RS256, nil
+	case jwk.OKPPrivateKey:
+		return EdDSA, nil
+	default:
+		return "", fmt.Errorf("%s: %w", key.KeyType(), ErrUnsupportedKey)
+	}
+}
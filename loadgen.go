@@ -0,0 +1,75 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/mgjules/chat-demo/loadgen"
+	"golang.org/x/exp/slog"
+)
+
+// runLoadgen runs a load test against a chat and prints its report as JSON.
+func runLoadgen(args []string) error {
+	// Load .env file is present.
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:"+cmp.Or(os.Getenv("HTTP_PORT"), "8080"), "base URL of the chat")
+	clients := fs.Int("clients", 100, "number of clients connected to the room")
+	rampUp := fs.Duration("ramp-up", 10*time.Second, "how long it takes to start all the clients")
+	rate := fs.Float64("rate", 10, "messages sent per second by all the clients together")
+	duration := fs.Duration("duration", 30*time.Second, "how long the clients send messages once all started")
+	drain := fs.Duration("drain", 5*time.Second, "how long deliveries are awaited once the clients stop sending")
+	out := fs.String("out", "", "file to write the report to (default stdout)")
+	verbose := fs.Bool("v", false, "log the failures of the clients to stderr")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	var w io.Writer = io.Discard
+	if *verbose {
+		w = os.Stderr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := loadgen.Run(ctx, loadgen.Options{
+		URL:      *url,
+		Clients:  *clients,
+		RampUp:   *rampUp,
+		Rate:     *rate,
+		Duration: *duration,
+		Drain:    *drain,
+		Logger:   slog.New(slog.NewTextHandler(w, nil)),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	data = append(data, '\n')
+
+	if *out != "" {
+		return os.WriteFile(*out, data, 0o644)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
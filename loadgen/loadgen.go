@@ -0,0 +1,450 @@
+// Package loadgen measures how many clients and messages a chat sustains.
+//
+// Run starts the sessions of guests with the API, ramps their websocket connections up
+// and has them post messages with the API at a target rate. Every client receives the
+// broadcast of every message, told apart by the ID the API returned for it, so the report
+// counts how many deliveries were expected, how many arrived and how long they took.
+//
+// The server limits every user to 3 messages every 5 seconds: keep the rate per client
+// below 0.6 messages per second or the extra messages are rejected as rate limited.
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/websocket"
+)
+
+// Options configures a load test.
+type Options struct {
+	// URL is the base URL of the chat (e.g. "http://localhost:8080").
+	URL string
+	// Clients is the number of clients connected to the room.
+	Clients int
+	// RampUp is how long it takes to start all the clients, which start evenly spread over it.
+	RampUp time.Duration
+	// Rate is the number of messages sent per second by all the clients together.
+	Rate float64
+	// Duration is how long the clients send messages once they are all started.
+	Duration time.Duration
+	// Drain is how long deliveries are awaited once the clients stop sending. Defaults to 5s.
+	Drain time.Duration
+	// HTTPClient logs the clients in and posts their messages. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// Logger logs the failures of the clients. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Report is the outcome of a load test, meant to be compared between builds.
+type Report struct {
+	URL      string       `json:"url"`
+	Clients  int          `json:"clients"`
+	RampUp   string       `json:"ramp_up"`
+	Rate     float64      `json:"rate"`
+	Duration string       `json:"duration"`
+	Elapsed  string       `json:"elapsed"`
+	Connects ConnectStats `json:"connects"`
+	Messages MessageStats `json:"messages"`
+	// Errors counts the errors by kind: "login", "dial", "send", "disconnected", "timeout"
+	// or the code of the errors of the chat (e.g. "rate_limited").
+	Errors map[string]int `json:"errors"`
+}
+
+// ConnectStats are the outcomes of the connections of the clients.
+type ConnectStats struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Latency is the time from the login to the room being ready.
+	Latency Latency `json:"latency"`
+}
+
+// MessageStats are the outcomes of the messages sent by the clients.
+type MessageStats struct {
+	Sent int `json:"sent"`
+	// Rejected messages were refused by the API (e.g. rate limited).
+	Rejected int `json:"rejected"`
+	// Expected is the number of deliveries expected: every client connected
+	// when a message was sent should receive its broadcast.
+	Expected int `json:"expected"`
+	// Delivered is the number of deliveries received, including by clients
+	// which connected after a message was sent.
+	Delivered int `json:"delivered"`
+	// Dropped is the number of expected deliveries never received.
+	Dropped int `json:"dropped"`
+	// Latency is the time from posting a message to receiving its broadcast.
+	Latency Latency `json:"latency"`
+}
+
+// Latency summarizes a distribution of durations, in milliseconds.
+type Latency struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// newLatency computes the percentiles of durations.
+func newLatency(durations []time.Duration) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+
+	slices.Sort(durations)
+	at := func(p float64) float64 {
+		d := durations[int(p*float64(len(durations)-1))]
+		return float64(d.Microseconds()) / 1000
+	}
+
+	return Latency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: at(1)}
+}
+
+// message is a message posted by a client, awaiting its deliveries.
+type message struct {
+	sentAt   time.Time
+	expected int
+}
+
+// test is the state of a running load test.
+type test struct {
+	opts Options
+	// tag prefixes the names of the clients and the content of their messages
+	// so that they are told apart from the other users of the room.
+	tag string
+	// sending is done once the clients must stop sending messages.
+	sending context.Context
+
+	connected atomic.Int64
+	seq       atomic.Uint64
+
+	mu       sync.Mutex
+	connects []time.Duration
+	failed   int
+	// messages are the messages posted by ID, rejected the number of those refused.
+	messages map[string]*message
+	rejected int
+	// arrivals are when the messages of the clients were received by ID,
+	// possibly before their post returned.
+	arrivals map[string][]time.Time
+	errors   map[string]int
+}
+
+// Run runs a load test and reports its outcome.
+// Cancelling ctx stops it early, still reporting what was measured.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Clients <= 0 {
+		return nil, errors.New("clients must be positive")
+	}
+	if opts.Rate < 0 || opts.RampUp < 0 || opts.Duration < 0 {
+		return nil, errors.New("rate, ramp-up and duration cannot be negative")
+	}
+	if opts.Drain <= 0 {
+		opts.Drain = 5 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	start := time.Now()
+	sending, stop := context.WithDeadline(ctx, start.Add(opts.RampUp+opts.Duration))
+	defer stop()
+
+	t := &test{
+		opts:     opts,
+		tag:      "lg" + xid.New().String()[14:],
+		sending:  sending,
+		messages: make(map[string]*message),
+		arrivals: make(map[string][]time.Time),
+		errors:   make(map[string]int),
+	}
+
+	// Clients stay connected until the deliveries are drained so that they still count.
+	conns, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Clients; i++ {
+		delay := time.Duration(int64(opts.RampUp) * int64(i) / int64(opts.Clients))
+		if !sleep(sending, time.Until(start.Add(delay))) {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.client(conns, i)
+		}()
+	}
+
+	<-sending.Done()
+	sleep(ctx, opts.Drain)
+	cancel()
+	wg.Wait()
+
+	return t.report(start), nil
+}
+
+// client runs a client of the load test until ctx is done.
+func (t *test) client(ctx context.Context, i int) {
+	name := fmt.Sprintf("%s-%d", t.tag, i)
+	logger := t.opts.Logger.With("client", name)
+	started := time.Now()
+
+	session, err := chatclient.Login(ctx, t.opts.URL, name, "", t.opts.HTTPClient)
+	if err != nil {
+		logger.Warn("log in", "err", err)
+		t.fail("login")
+		return
+	}
+
+	token, err := session.Token(ctx)
+	if err != nil {
+		logger.Warn("get token", "err", err)
+		t.fail("login")
+		return
+	}
+
+	api, err := chatclient.New(chatclient.Config{URL: t.opts.URL, Token: token, HTTPClient: t.opts.HTTPClient})
+	if err != nil {
+		logger.Warn("create client", "err", err)
+		t.fail("login")
+		return
+	}
+
+	ws, err := chatclient.Dial(ctx, t.opts.URL, token, protocol.SubprotocolJSON)
+	if err != nil {
+		logger.Warn("open websocket", "err", err)
+		t.fail("dial")
+		return
+	}
+	defer ws.Close()
+
+	// Closing the websocket stops both the reading and the sending.
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	// The room is ready once its history and users are sent.
+	for {
+		env, err := receive(ws)
+		if err != nil {
+			if ctx.Err() != nil {
+				t.fail("timeout")
+				return
+			}
+			logger.Warn("wait for the room", "err", err)
+			t.fail("disconnected")
+			return
+		}
+
+		if env.Type == protocol.TypeError {
+			var p protocol.ErrorPayload
+			_ = json.Unmarshal(env.Payload, &p)
+			logger.Warn("join the room", "code", p.Code, "message", p.Message)
+			t.fail(p.Code)
+			return
+		}
+
+		if env.Type == protocol.TypeReady {
+			break
+		}
+	}
+	t.ready(time.Since(started))
+	defer t.connected.Add(-1)
+
+	go t.send(ctx, api, logger)
+
+	for {
+		env, err := receive(ws)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("receive frame", "err", err)
+				t.count("disconnected")
+			}
+			return
+		}
+
+		t.handle(env)
+	}
+}
+
+// send posts messages at the rate of a client until the clients must stop sending.
+func (t *test) send(ctx context.Context, api *chatclient.Client, logger *slog.Logger) {
+	if t.opts.Rate == 0 {
+		return
+	}
+	interval := max(time.Duration(float64(t.opts.Clients)/t.opts.Rate*float64(time.Second)), time.Millisecond)
+
+	// Spread the clients over the interval rather than have them send at once.
+	if !sleep(t.sending, rand.N(interval)) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m := &message{sentAt: time.Now(), expected: int(t.connected.Load())}
+		msg, err := api.PostMessage(ctx, t.tag+"-"+strconv.FormatUint(t.seq.Add(1), 10))
+		var apiErr *chatclient.APIError
+		switch {
+		case errors.As(err, &apiErr):
+			t.reject(apiErr.Code)
+		case err != nil:
+			if ctx.Err() == nil {
+				logger.Warn("post message", "err", err)
+				t.count("send")
+			}
+			return
+		default:
+			t.sent(msg.ID, m)
+		}
+
+		select {
+		case <-t.sending.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handle records the deliveries of the messages of the test and the errors.
+func (t *test) handle(env *protocol.Envelope) {
+	switch env.Type {
+	case protocol.TypeMessage:
+		var p protocol.MessageEvent
+		if err := json.Unmarshal(env.Payload, &p); err != nil || p.User == nil || !strings.HasPrefix(p.User.Name, t.tag+"-") {
+			return
+		}
+
+		t.mu.Lock()
+		t.arrivals[p.ID] = append(t.arrivals[p.ID], time.Now())
+		t.mu.Unlock()
+	case protocol.TypeError:
+		var p protocol.ErrorPayload
+		_ = json.Unmarshal(env.Payload, &p)
+		t.count(p.Code)
+	}
+}
+
+func (t *test) sent(id string, m *message) {
+	t.mu.Lock()
+	t.messages[id] = m
+	t.mu.Unlock()
+}
+
+// reject records a message refused by the API.
+func (t *test) reject(code string) {
+	t.mu.Lock()
+	t.rejected++
+	t.errors[code]++
+	t.mu.Unlock()
+}
+
+func (t *test) ready(latency time.Duration) {
+	t.connected.Add(1)
+
+	t.mu.Lock()
+	t.connects = append(t.connects, latency)
+	t.mu.Unlock()
+}
+
+// fail records a client which failed to connect.
+func (t *test) fail(kind string) {
+	t.mu.Lock()
+	t.failed++
+	t.errors[kind]++
+	t.mu.Unlock()
+}
+
+func (t *test) count(kind string) {
+	t.mu.Lock()
+	t.errors[kind]++
+	t.mu.Unlock()
+}
+
+func (t *test) report(start time.Time) *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := &Report{
+		URL:      t.opts.URL,
+		Clients:  t.opts.Clients,
+		RampUp:   t.opts.RampUp.String(),
+		Rate:     t.opts.Rate,
+		Duration: t.opts.Duration.String(),
+		Elapsed:  time.Since(start).Round(time.Millisecond).String(),
+		Connects: ConnectStats{
+			Succeeded: len(t.connects),
+			Failed:    t.failed,
+			Latency:   newLatency(t.connects),
+		},
+		Errors: t.errors,
+	}
+
+	r.Messages.Sent, r.Messages.Rejected = len(t.messages)+t.rejected, t.rejected
+	var latencies []time.Duration
+	for id, m := range t.messages {
+		arrivals := t.arrivals[id]
+		for _, at := range arrivals {
+			latencies = append(latencies, at.Sub(m.sentAt))
+		}
+
+		r.Messages.Expected += m.expected
+		r.Messages.Delivered += len(arrivals)
+		r.Messages.Dropped += max(m.expected-len(arrivals), 0)
+	}
+	r.Messages.Latency = newLatency(latencies)
+
+	return r
+}
+
+// receive receives the next frame of the websocket.
+func receive(ws *websocket.Conn) (*protocol.Envelope, error) {
+	var data []byte
+	if err := websocket.Message.Receive(ws, &data); err != nil {
+		return nil, err
+	}
+
+	var env protocol.Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+
+	return &env, nil
+}
+
+// sleep waits for d unless ctx is done first, reporting if it waited.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
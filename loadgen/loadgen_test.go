@@ -0,0 +1,181 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/exp/slog"
+)
+
+func TestNewLatency(t *testing.T) {
+	ms := func(ds ...int) []time.Duration {
+		res := make([]time.Duration, 0, len(ds))
+		for _, d := range ds {
+			res = append(res, time.Duration(d)*time.Millisecond)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      Latency
+	}{
+		{name: "none", want: Latency{}},
+		{name: "one", durations: ms(7), want: Latency{P50: 7, P90: 7, P99: 7, Max: 7}},
+		{name: "unsorted", durations: ms(10, 1, 9, 2, 8, 3, 7, 4, 6, 5, 11), want: Latency{P50: 6, P90: 10, P99: 10, Max: 11}},
+		{name: "sub-millisecond", durations: []time.Duration{1500 * time.Microsecond}, want: Latency{P50: 1.5, P90: 1.5, P99: 1.5, Max: 1.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newLatency(tt.durations); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportMessages(t *testing.T) {
+	const tag = "lgtest"
+
+	// frame is the broadcast of a message of ID by a user.
+	frame := func(id, name string) *protocol.Envelope {
+		payload, _ := json.Marshal(protocol.MessageEvent{ID: id, User: &protocol.User{Name: name}, Content: "***"})
+		return &protocol.Envelope{Type: protocol.TypeMessage, Payload: payload}
+	}
+
+	tests := []struct {
+		name string
+		// before are the frames received before message "a", expected by 2 clients, is posted.
+		before []*protocol.Envelope
+		after  []*protocol.Envelope
+		want   MessageStats
+	}{
+		{
+			name:  "delivered",
+			after: []*protocol.Envelope{frame("a", tag+"-0"), frame("a", tag+"-1")},
+			want:  MessageStats{Sent: 1, Expected: 2, Delivered: 2},
+		},
+		{
+			name:   "delivered before the post returned",
+			before: []*protocol.Envelope{frame("a", tag+"-0")},
+			after:  []*protocol.Envelope{frame("a", tag+"-1")},
+			want:   MessageStats{Sent: 1, Expected: 2, Delivered: 2},
+		},
+		{
+			name:  "dropped",
+			after: []*protocol.Envelope{frame("a", tag+"-0")},
+			want:  MessageStats{Sent: 1, Expected: 2, Delivered: 1, Dropped: 1},
+		},
+		{
+			// The content of messages (e.g. censored) does not matter, nor the messages of other users.
+			name:  "other users",
+			after: []*protocol.Envelope{frame("a", tag+"-0"), frame("b", "Alice"), frame("a", tag+"-1")},
+			want:  MessageStats{Sent: 1, Expected: 2, Delivered: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentAt := time.Now()
+			lt := &test{tag: tag, messages: make(map[string]*message), arrivals: make(map[string][]time.Time), errors: make(map[string]int)}
+			for _, env := range tt.before {
+				lt.handle(env)
+			}
+			lt.sent("a", &message{sentAt: sentAt, expected: 2})
+			for _, env := range tt.after {
+				lt.handle(env)
+			}
+
+			got := lt.report(time.Now()).Messages
+			got.Latency = Latency{}
+			if got != tt.want {
+				t.Fatalf("got messages %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "no clients", opts: Options{URL: "http://localhost"}},
+		{name: "negative rate", opts: Options{URL: "http://localhost", Clients: 1, Rate: -1}},
+		{name: "negative ramp-up", opts: Options{URL: "http://localhost", Clients: 1, RampUp: -time.Second}},
+		{name: "negative duration", opts: Options{URL: "http://localhost", Clients: 1, Duration: -time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Run(context.Background(), tt.opts); err == nil {
+				t.Fatal("load test ran")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxClients uint64
+		// url overrides the URL of the chat.
+		url           string
+		rate          float64
+		wantSucceeded int
+		wantFailed    int
+		// wantError is the kind of error of the clients which failed to connect, if any.
+		wantError string
+	}{
+		// The server holds still, so every client stays below 3 messages over the test.
+		{name: "messages", rate: 3, wantSucceeded: 3},
+		{name: "connections only", wantSucceeded: 3},
+		{name: "room full", maxClients: 2, wantSucceeded: 2, wantFailed: 1, wantError: "room_full"},
+		{name: "unreachable", url: "http://127.0.0.1:1", wantFailed: 3, wantError: "login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{MaxClients: tt.maxClients})
+			url := srv.URL
+			if tt.url != "" {
+				url = tt.url
+			}
+
+			r, err := Run(context.Background(), Options{
+				URL:        url,
+				Clients:    3,
+				RampUp:     100 * time.Millisecond,
+				Rate:       tt.rate,
+				Duration:   time.Second,
+				Drain:      500 * time.Millisecond,
+				HTTPClient: srv.Client(),
+				Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+
+			if r.Connects.Succeeded != tt.wantSucceeded || r.Connects.Failed != tt.wantFailed {
+				t.Fatalf("got %d clients connected and %d failed, want %d and %d",
+					r.Connects.Succeeded, r.Connects.Failed, tt.wantSucceeded, tt.wantFailed)
+			}
+			if tt.wantError != "" && r.Errors[tt.wantError] != tt.wantFailed {
+				t.Fatalf("got errors %v, want %d %s", r.Errors, tt.wantFailed, tt.wantError)
+			}
+
+			// Clients joining while a message is broadcast may receive it without being expected to.
+			m := r.Messages
+			if (m.Sent > 0) != (tt.rate > 0) || m.Rejected != 0 || m.Dropped != 0 || m.Delivered < m.Expected {
+				t.Fatalf("got messages %+v", m)
+			}
+		})
+	}
+}
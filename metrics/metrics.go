@@ -0,0 +1,237 @@
+// Package metrics exposes the activity of the chat to Prometheus.
+//
+// A nil *Metrics records nothing so that code paths without metrics
+// (e.g. requests of other servers) need no checks.
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type ctxKey int
+
+const metricsCtxKey ctxKey = iota
+
+// Metrics holds the metrics of a server.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	clients       *prometheus.GaugeVec
+	connects      *prometheus.CounterVec
+	disconnects   *prometheus.CounterVec
+	messages      *prometheus.CounterVec
+	broadcasts    *prometheus.CounterVec
+	fanOut        *prometheus.HistogramVec
+	semaphoreWait *prometheus.HistogramVec
+	sendFailures  *prometheus.CounterVec
+	rateLimited   *prometheus.CounterVec
+	renders       *prometheus.HistogramVec
+	requests      *prometheus.HistogramVec
+}
+
+// New creates the metrics of a server, along with the metrics of the Go runtime and of the process.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		clients: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "chat_clients",
+			Help: "Number of clients connected to a room.",
+		}, []string{"room"}),
+		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_connects_total",
+			Help: "Number of clients which joined a room.",
+		}, []string{"room"}),
+		disconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_disconnects_total",
+			Help: "Number of clients which left a room.",
+		}, []string{"room"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_messages_received_total",
+			Help: "Number of messages posted in a room.",
+		}, []string{"room"}),
+		broadcasts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_broadcasts_total",
+			Help: "Number of events broadcast to the clients of a room, by type (e.g. message).",
+		}, []string{"room", "event"}),
+		fanOut: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chat_broadcast_duration_seconds",
+			Help:    "Time taken by a broadcast to reach all the clients of a room.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"room"}),
+		semaphoreWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chat_send_worker_wait_seconds",
+			Help:    "Time waited for a send worker before sending an event to a client.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"room"}),
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_send_failures_total",
+			Help: "Number of events which could not be sent to a client.",
+		}, []string{"room"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_rate_limit_rejections_total",
+			Help: "Number of requests rejected by a rate limit, by scope (user, bot, hook, ip or account).",
+		}, []string{"scope"}),
+		renders: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chat_template_render_duration_seconds",
+			Help:    "Time taken to render a template.",
+			Buckets: prometheus.ExponentialBuckets(0.00005, 4, 10),
+		}, []string{"template"}),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chat_http_request_duration_seconds",
+			Help:    "Duration of the HTTP requests by route. Websockets and event streams last as long as their connection.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.clients, m.connects, m.disconnects, m.messages, m.broadcasts, m.fanOut,
+		m.semaphoreWait, m.sendFailures, m.rateLimited, m.renders, m.requests,
+	)
+
+	return m
+}
+
+// Handler serves the metrics, only to requests bearing token if it is not empty.
+func (m *Metrics) Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Middleware records the HTTP requests by route and adds the metrics to their context.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(AddToContext(r.Context(), m)))
+
+		// The route is only known once the request is routed.
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			// Hijacked connections (e.g. websockets) write no status through the writer.
+			status = http.StatusSwitchingProtocols
+		}
+
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Room returns the metrics of a room, to instrument it with.
+func (m *Metrics) Room(id string) chat.Metrics {
+	return &room{m: m, id: id}
+}
+
+// RateLimited records a request rejected by a rate limit.
+func (m *Metrics) RateLimited(scope string) {
+	if m == nil {
+		return
+	}
+
+	m.rateLimited.WithLabelValues(scope).Inc()
+}
+
+// ObserveRender records the time taken to render a template.
+func (m *Metrics) ObserveRender(template string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.renders.WithLabelValues(template).Observe(d.Seconds())
+}
+
+// room records the activity of a room.
+type room struct {
+	m  *Metrics
+	id string
+}
+
+// ClientAdded implements the chat.Metrics interface.
+func (r *room) ClientAdded() {
+	r.m.clients.WithLabelValues(r.id).Inc()
+	r.m.connects.WithLabelValues(r.id).Inc()
+}
+
+// ClientRemoved implements the chat.Metrics interface.
+func (r *room) ClientRemoved() {
+	r.m.clients.WithLabelValues(r.id).Dec()
+	r.m.disconnects.WithLabelValues(r.id).Inc()
+}
+
+// MessageAdded implements the chat.Metrics interface.
+func (r *room) MessageAdded() {
+	r.m.messages.WithLabelValues(r.id).Inc()
+}
+
+// Broadcast implements the chat.Metrics interface.
+func (r *room) Broadcast(e chat.Event, d time.Duration) {
+	r.m.broadcasts.WithLabelValues(r.id, eventName(e)).Inc()
+	r.m.fanOut.WithLabelValues(r.id).Observe(d.Seconds())
+}
+
+// SemaphoreWait implements the chat.Metrics interface.
+func (r *room) SemaphoreWait(d time.Duration) {
+	r.m.semaphoreWait.WithLabelValues(r.id).Observe(d.Seconds())
+}
+
+// SendFailed implements the chat.Metrics interface.
+func (r *room) SendFailed() {
+	r.m.sendFailures.WithLabelValues(r.id).Inc()
+}
+
+// eventName returns the name of the type of an event (e.g. "message" for a chat.MessageEvent).
+func eventName(e chat.Event) string {
+	t := reflect.TypeOf(e)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return strings.ToLower(strings.TrimSuffix(t.Name(), "Event"))
+}
+
+// AddToContext adds the metrics to the context.
+func AddToContext(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsCtxKey, m)
+}
+
+// FromContext retrieves the metrics from the context, nil if there are none.
+func FromContext(ctx context.Context) *Metrics {
+	m, ok := ctx.Value(metricsCtxKey).(*Metrics)
+	if !ok {
+		return nil
+	}
+
+	return m
+}
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// value returns the value of a metric with labels, the number of observations of histograms.
+func value(t *testing.T, m *Metrics, name string, labels ...string) float64 {
+	t.Helper()
+
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	want := strings.Join(labels, ",")
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+
+		for _, metric := range f.GetMetric() {
+			var got []string
+			for _, l := range metric.GetLabel() {
+				got = append(got, l.GetName()+"="+l.GetValue())
+			}
+			if strings.Join(got, ",") != want {
+				continue
+			}
+
+			switch {
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue()
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue()
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	t.Fatalf("metric %s{%s} not found", name, want)
+	return 0
+}
+
+func TestEventName(t *testing.T) {
+	tests := []struct {
+		e    chat.Event
+		want string
+	}{
+		{e: chat.MessageEvent{}, want: "message"},
+		{e: chat.JoinEvent{}, want: "join"},
+		{e: &chat.ModerationEvent{}, want: "moderation"},
+		{e: chat.NumUsersEvent{}, want: "numusers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := eventName(tt.e); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+type nopEncoder struct{}
+
+func (nopEncoder) Encode(context.Context, io.Writer, *user.User, chat.Event) error { return nil }
+
+func TestRoom(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	room := chat.NewRoom(chat.RoomOptions{})
+	room.Instrument(m.Room("general"))
+
+	alice, bob := user.NewNamed("Alice"), user.NewNamed("Bob")
+	for _, u := range []*user.User{alice, bob} {
+		if err := room.AddClient(chat.NewConn(ctx, u, nopWriteCloser{}), nopEncoder{}); err != nil {
+			t.Fatalf("join %s: %v", u.Name, err)
+		}
+	}
+	room.RemoveClient(bob.ID)
+
+	msg, err := chat.NewMessage(alice, "hello")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	room.AddMessage(msg)
+	room.Broadcast(ctx, chat.MessageEvent{Message: msg})
+	room.Broadcast(ctx, chat.JoinEvent{User: alice})
+
+	tests := []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{name: "chat_clients", labels: []string{"room=general"}, want: 1},
+		{name: "chat_connects_total", labels: []string{"room=general"}, want: 2},
+		{name: "chat_disconnects_total", labels: []string{"room=general"}, want: 1},
+		{name: "chat_messages_received_total", labels: []string{"room=general"}, want: 1},
+		{name: "chat_broadcasts_total", labels: []string{"event=message", "room=general"}, want: 1},
+		{name: "chat_broadcasts_total", labels: []string{"event=join", "room=general"}, want: 1},
+		{name: "chat_broadcast_duration_seconds", labels: []string{"room=general"}, want: 2},
+		// Every broadcast waits once for a worker for the only client left.
+		{name: "chat_send_worker_wait_seconds", labels: []string{"room=general"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+strings.Join(tt.labels, ","), func(t *testing.T) {
+			if got := value(t, m, tt.name, tt.labels...); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+	}{
+		{name: "public", wantStatus: http.StatusOK},
+		{name: "token", token: "secret", header: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "missing token", token: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer secre", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", token: "secret", header: "Basic secret", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			m.RateLimited("ip")
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			m.Handler(tt.token).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(rec.Body.String(), `chat_rate_limit_rejections_total{scope="ip"} 1`) {
+				t.Fatalf("metrics not served:\n%s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// Handlers record their metrics through the context.
+		FromContext(r.Context()).ObserveRender("user", time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{name: "chat_http_request_duration_seconds", labels: []string{"method=GET", "route=/users/{id}", "status=204"}, want: 2},
+		{name: "chat_http_request_duration_seconds", labels: []string{"method=GET", "route=unmatched", "status=404"}, want: 1},
+		{name: "chat_template_render_duration_seconds", labels: []string{"template=user"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+strings.Join(tt.labels, ","), func(t *testing.T) {
+			if got := value(t, m, tt.name, tt.labels...); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	m := FromContext(context.Background())
+	if m != nil {
+		t.Fatal("got metrics from an empty context")
+	}
+
+	// Recording without metrics is a no-op.
+	m.RateLimited("user")
+	m.ObserveRender("index", time.Millisecond)
+}
@@ -0,0 +1,275 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// List of OIDC errors.
+var (
+	ErrMissingIDToken = errors.New("token response has no id_token")
+	ErrNonceMismatch  = errors.New("id token nonce does not match")
+)
+
+// Config configures a Provider.
+type Config struct {
+	// Issuer is the URL of the identity provider used for discovery.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested in addition to "openid".
+	Scopes []string
+	// RoleClaim is the claim listing the groups or roles of a user (e.g. "groups").
+	RoleClaim string
+	// AdminValues and ModeratorValues are the RoleClaim values mapped to chat roles.
+	AdminValues     []string
+	ModeratorValues []string
+	// HTTPClient is used for all requests to the identity provider.
+	HTTPClient *http.Client
+}
+
+// metadata is the subset of the discovery document we rely on.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against an identity provider.
+type Provider struct {
+	cfg  Config
+	meta metadata
+	keys jwk.Set
+}
+
+// NewProvider creates a new Provider using the discovery document of the issuer.
+// The signing keys are cached and refreshed in the background until ctx is done.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	discoveryURL := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create discovery request: %w", err)
+	}
+
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch discovery document: unexpected status %d", resp.StatusCode)
+	}
+
+	var meta metadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("decode discovery document: %w", err)
+	}
+
+	if meta.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", meta.Issuer, cfg.Issuer)
+	}
+
+	cache := jwk.NewCache(ctx)
+	if err := cache.Register(meta.JWKSURI, jwk.WithHTTPClient(cfg.HTTPClient), jwk.WithMinRefreshInterval(15*time.Minute)); err != nil {
+		return nil, fmt.Errorf("register jwks: %w", err)
+	}
+	if _, err := cache.Refresh(ctx, meta.JWKSURI); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	return &Provider{
+		cfg:  cfg,
+		meta: meta,
+		keys: jwk.NewCachedSet(cache, meta.JWKSURI),
+	}, nil
+}
+
+// Session holds the secrets of a single login attempt which must be
+// kept by the client between the redirect and the callback.
+type Session struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewSession generates a new login Session.
+func NewSession() (*Session, error) {
+	var s Session
+	for _, v := range []*string{&s.State, &s.Nonce, &s.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate random value: %w", err)
+		}
+		*v = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	return &s, nil
+}
+
+// AuthCodeURL returns the URL of the identity provider to redirect the user to.
+func (p *Provider) AuthCodeURL(s *Session) string {
+	challenge := sha256.Sum256([]byte(s.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {s.State},
+		"nonce":                 {s.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange trades an authorization code for a verified ID token.
+func (p *Provider) Exchange(ctx context.Context, s *Session, code string) (jwt.Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {s.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request token: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode token response: %w", err)
+	}
+	if body.IDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	return p.Verify(ctx, body.IDToken, s.Nonce)
+}
+
+// Verify checks the signature and claims of an ID token.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (jwt.Token, error) {
+	tok, err := jwt.ParseString(raw,
+		jwt.WithContext(ctx),
+		jwt.WithKeySet(p.keys, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(p.meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithAcceptableSkew(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+
+	if n, _ := tok.Get("nonce"); n != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return tok, nil
+}
+
+// User maps the claims of an ID token to a chat user.
+// The ID is derived from the issuer and subject so that it is stable across logins.
+func (p *Provider) User(tok jwt.Token) *user.User {
+	sum := sha256.Sum256([]byte(tok.Issuer() + "\x00" + tok.Subject()))
+	id, _ := xid.FromBytes(sum[:12])
+
+	u := &user.User{
+		ID:   id,
+		Name: "User " + id.String()[:8],
+	}
+
+	for _, claim := range []string{"name", "preferred_username", "email"} {
+		v, _ := tok.Get(claim)
+		s, _ := v.(string)
+		if claim == "email" {
+			s, _, _ = strings.Cut(s, "@")
+		}
+
+		if name, err := user.NormalizeName(s); err == nil {
+			u.Name = name
+			break
+		}
+	}
+
+	if p.cfg.RoleClaim == "" {
+		return u
+	}
+
+	v, _ := tok.Get(p.cfg.RoleClaim)
+	for _, role := range claimValues(v) {
+		switch {
+		case slices.Contains(p.cfg.AdminValues, role):
+			u.Role = user.RoleAdmin
+		case slices.Contains(p.cfg.ModeratorValues, role) && u.Role < user.RoleModerator:
+			u.Role = user.RoleModerator
+		}
+	}
+
+	return u
+}
+
+// claimValues normalizes a claim which can either be a string or a list of strings.
+func claimValues(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
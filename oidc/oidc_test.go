@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/oidc/oidctest"
+	"github.com/mgjules/chat-demo/user"
+)
+
+const redirectURL = "https://chat.example.com/login/oidc/callback"
+
+// newTestProvider creates a Provider for a mock identity provider logging in users with the claims.
+func newTestProvider(t *testing.T, claims map[string]any) *Provider {
+	t.Helper()
+
+	idp := oidctest.NewProvider(claims)
+	t.Cleanup(idp.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	p, err := NewProvider(ctx, Config{
+		Issuer:      idp.Issuer(),
+		ClientID:    oidctest.ClientID,
+		RedirectURL: redirectURL,
+		Scopes:      []string{"profile", "email"},
+	})
+	if err != nil {
+		t.Fatalf("create provider: %v", err)
+	}
+
+	return p
+}
+
+// authorize follows the authorization URL of the session and returns the code sent to the callback.
+func authorize(t *testing.T, p *Provider, s *Session) string {
+	t.Helper()
+
+	hc := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := hc.Get(p.AuthCodeURL(s))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse callback: %v", err)
+	}
+	if got := callback.Query().Get("state"); got != s.State {
+		t.Fatalf("got state %q, want %q", got, s.State)
+	}
+
+	return callback.Query().Get("code")
+}
+
+func TestNewProviderIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider(nil)
+	defer idp.Close()
+
+	if _, err := NewProvider(context.Background(), Config{Issuer: idp.Issuer() + "/", ClientID: oidctest.ClientID}); err == nil {
+		t.Fatal("accepted a discovery document of another issuer")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newTestProvider(t, nil)
+	s, err := NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+
+	u, err := url.Parse(p.AuthCodeURL(s))
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	challenge := sha256.Sum256([]byte(s.Verifier))
+	q := u.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             oidctest.ClientID,
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid profile email",
+		"state":                 s.State,
+		"nonce":                 s.Nonce,
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("got %s %q, want %q", param, got, want)
+		}
+	}
+	if q.Has("code_verifier") {
+		t.Error("the verifier is sent to the identity provider")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the session or the code before they are exchanged.
+		tamper  func(s *Session, code string) string
+		wantErr bool
+		wantIs  error
+	}{
+		{name: "valid"},
+		{
+			name:    "pkce mismatch",
+			tamper:  func(s *Session, code string) string { s.Verifier = "forged"; return code },
+			wantErr: true,
+		},
+		{
+			name:    "nonce mismatch",
+			tamper:  func(s *Session, code string) string { s.Nonce = "forged"; return code },
+			wantErr: true, wantIs: ErrNonceMismatch,
+		},
+		{
+			name:    "unknown code",
+			tamper:  func(_ *Session, code string) string { return code + "x" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, map[string]any{"sub": "alice-id", "name": "Alice"})
+			s, err := NewSession()
+			if err != nil {
+				t.Fatalf("new session: %v", err)
+			}
+
+			code := authorize(t, p, s)
+			if tt.tamper != nil {
+				code = tt.tamper(s, code)
+			}
+
+			tok, err := p.Exchange(context.Background(), s, code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Fatalf("got error %v, want %v", err, tt.wantIs)
+			}
+			if err != nil {
+				return
+			}
+
+			if tok.Subject() != "alice-id" {
+				t.Fatalf("got subject %q, want %q", tok.Subject(), "alice-id")
+			}
+
+			// Codes can only be used once.
+			if _, err := p.Exchange(context.Background(), s, code); err == nil {
+				t.Fatal("code exchanged twice")
+			}
+		})
+	}
+}
+
+func TestUser(t *testing.T) {
+	p := &Provider{cfg: Config{
+		RoleClaim:       "groups",
+		AdminValues:     []string{"chat-admins"},
+		ModeratorValues: []string{"chat-mods", "staff"},
+	}}
+
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		wantName string
+		wantRole user.Role
+	}{
+		{name: "name", claims: map[string]any{"name": "Alice", "preferred_username": "alice42"}, wantName: "Alice"},
+		{name: "invalid name", claims: map[string]any{"name": "<Alice>", "preferred_username": "alice42"}, wantName: "alice42"},
+		{name: "email", claims: map[string]any{"email": "alice@example.com"}, wantName: "alice"},
+		{name: "no name", claims: map[string]any{"email": "@example.com"}},
+		{name: "moderator", claims: map[string]any{"name": "Alice", "groups": []any{"users", "staff"}}, wantName: "Alice", wantRole: user.RoleModerator},
+		{name: "admin", claims: map[string]any{"name": "Alice", "groups": []any{"chat-admins", "chat-mods"}}, wantName: "Alice", wantRole: user.RoleAdmin},
+		{name: "admin listed first", claims: map[string]any{"name": "Alice", "groups": []any{"chat-mods", "chat-admins"}}, wantName: "Alice", wantRole: user.RoleAdmin},
+		{name: "space separated roles", claims: map[string]any{"name": "Alice", "groups": "users chat-mods"}, wantName: "Alice", wantRole: user.RoleModerator},
+		{name: "unknown roles", claims: map[string]any{"name": "Alice", "groups": []any{"chat-admins-old"}}, wantName: "Alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := jwt.New()
+			_ = tok.Set(jwt.IssuerKey, "https://idp.example.com")
+			_ = tok.Set(jwt.SubjectKey, "alice-id")
+			for k, v := range tt.claims {
+				if err := tok.Set(k, v); err != nil {
+					t.Fatalf("set claim %s: %v", k, err)
+				}
+			}
+
+			u := p.User(tok)
+			if tt.wantName == "" {
+				tt.wantName = "User " + u.ID.String()[:8]
+			}
+			if u.Name != tt.wantName || u.Role != tt.wantRole {
+				t.Fatalf("got %s (%s), want %s (%s)", u.Name, u.Role, tt.wantName, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestUserID(t *testing.T) {
+	p := &Provider{}
+	token := func(iss, sub string) jwt.Token {
+		tok := jwt.New()
+		_ = tok.Set(jwt.IssuerKey, iss)
+		_ = tok.Set(jwt.SubjectKey, sub)
+		return tok
+	}
+
+	alice := p.User(token("https://idp.example.com", "alice-id")).ID
+	if id := p.User(token("https://idp.example.com", "alice-id")).ID; id != alice {
+		t.Fatalf("got ID %s on the next login, want %s", id, alice)
+	}
+	if id := p.User(token("https://idp.example.com", "bob-id")).ID; id == alice {
+		t.Fatal("another subject got the same ID")
+	}
+	if id := p.User(token("https://other.example.com", "alice-id")).ID; id == alice {
+		t.Fatal("the same subject of another issuer got the same ID")
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim any
+		want  []string
+	}{
+		{name: "string", claim: "mods  admins", want: []string{"mods", "admins"}},
+		{name: "strings", claim: []string{"mods", "admins"}, want: []string{"mods", "admins"}},
+		{name: "json array", claim: []any{"mods", 42, "admins"}, want: []string{"mods", "admins"}},
+		{name: "missing", claim: nil},
+		{name: "other type", claim: 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimValues(tt.claim); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/mgjules/chat-demo/probe"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/exp/slog"
+)
+
+var errProbeFailed = errors.New("probe failed")
+
+// runProbe probes a chat periodically, exposing the results as metrics,
+// or once, failing if the probe does.
+func runProbe(args []string) error {
+	// Load .env file is present.
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:"+cmp.Or(os.Getenv("HTTP_PORT"), "8080"), "base URL of the chat")
+	name := fs.String("name", "Canary", "name of the canary user, an account if PROBE_PASSWORD is set")
+	interval := fs.Duration("interval", 30*time.Second, "time between two probes")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of a probe")
+	once := fs.Bool("once", false, "probe once, printing the result as JSON and exiting with an error if it fails")
+	metricsAddr := fs.String("metrics-addr", ":9100", "address serving the metrics on /metrics")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	opts := probe.Options{
+		URL:      *url,
+		Name:     *name,
+		Password: os.Getenv("PROBE_PASSWORD"),
+		Timeout:  *timeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		r := probe.Run(ctx, opts)
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			return fmt.Errorf("encode result: %w", err)
+		}
+		if !r.OK() {
+			return fmt.Errorf("%w: %s: %w", errProbeFailed, r.Phase, r.Err)
+		}
+		return nil
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	reg := prometheus.NewRegistry()
+	metrics := probe.NewMetrics(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{
+		Addr:         *metricsAddr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Info("Serving probe metrics...", "addr", "http://"+srv.Addr+"/metrics")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve probe metrics", "err", err)
+			stop()
+		}
+	}()
+	defer srv.Close()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		r := probe.Run(ctx, opts)
+		metrics.Observe(r)
+		if r.OK() {
+			logger.Info("probe succeeded", "durations", r.Durations)
+		} else if ctx.Err() == nil {
+			logger.Error("probe failed", "phase", r.Phase, "err", r.Err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
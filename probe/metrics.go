@@ -0,0 +1,68 @@
+package probe
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the results of the probes to Prometheus.
+type Metrics struct {
+	success     prometheus.Gauge
+	lastSuccess prometheus.Gauge
+	probes      prometheus.Counter
+	failures    *prometheus.CounterVec
+	durations   *prometheus.HistogramVec
+}
+
+// NewMetrics creates the metrics of the probes and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chat_probe_success",
+			Help: "Whether the last probe succeeded.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chat_probe_last_success_timestamp_seconds",
+			Help: "Time of the last successful probe.",
+		}),
+		probes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chat_probe_runs_total",
+			Help: "Number of probes run.",
+		}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_probe_failures_total",
+			Help: "Number of failed probes by the phase which failed.",
+		}, []string{"phase"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chat_probe_duration_seconds",
+			Help:    "Duration of the phases of the probes which succeeded.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"phase"}),
+	}
+
+	// Report every phase from the start rather than once it first fails.
+	for _, phase := range Phases {
+		m.failures.WithLabelValues(phase)
+	}
+
+	reg.MustRegister(m.success, m.lastSuccess, m.probes, m.failures, m.durations)
+
+	return m
+}
+
+// Observe records the result of a probe.
+func (m *Metrics) Observe(r Result) {
+	m.probes.Inc()
+
+	for phase, d := range r.Durations {
+		m.durations.WithLabelValues(phase).Observe(d.Seconds())
+	}
+
+	if !r.OK() {
+		m.success.Set(0)
+		m.failures.WithLabelValues(r.Phase).Inc()
+		return
+	}
+
+	m.success.Set(1)
+	m.lastSuccess.Set(float64(r.Time.Unix()))
+}
@@ -0,0 +1,198 @@
+// Package probe checks that a chat works end to end, the way its users do.
+//
+// A probe logs in as a canary user, connects to the room, posts a message with the API
+// and waits to receive its broadcast, told apart by the ID the API returned, before
+// logging out. Unlike the /ping heartbeat, which only proves that the HTTP server is
+// alive, it fails when any part of the chat is broken.
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/rs/xid"
+	"golang.org/x/net/websocket"
+)
+
+// List of the phases of a probe.
+const (
+	PhaseLogin     = "login"
+	PhaseConnect   = "connect"
+	PhaseRoundTrip = "round_trip"
+)
+
+// Phases are the phases of a probe, in order.
+var Phases = []string{PhaseLogin, PhaseConnect, PhaseRoundTrip}
+
+// Options configures a probe.
+type Options struct {
+	// URL is the base URL of the chat (e.g. "http://localhost:8080").
+	URL string
+	// Name is the name of the canary user.
+	Name string
+	// Password logs the canary in with an account rather than as a guest when set.
+	Password string
+	// Timeout bounds the whole probe. Defaults to 10s.
+	Timeout time.Duration
+	// HTTPClient logs the canary in and posts its message. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Result is the outcome of a probe.
+type Result struct {
+	Time time.Time
+	// Phase is the phase which failed, empty when the probe succeeded.
+	Phase string
+	Err   error
+	// Durations are the durations of the phases which succeeded.
+	Durations map[string]time.Duration
+}
+
+// OK checks if the probe succeeded.
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r Result) MarshalJSON() ([]byte, error) {
+	durations := make(map[string]float64, len(r.Durations))
+	for phase, d := range r.Durations {
+		durations[phase+"_ms"] = float64(d.Microseconds()) / 1000
+	}
+
+	var msg string
+	if r.Err != nil {
+		msg = r.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		Time      time.Time          `json:"time"`
+		OK        bool               `json:"ok"`
+		Phase     string             `json:"phase,omitempty"`
+		Error     string             `json:"error,omitempty"`
+		Durations map[string]float64 `json:"durations"`
+	}{r.Time, r.OK(), r.Phase, msg, durations})
+}
+
+// Run runs a probe.
+func Run(ctx context.Context, opts Options) Result {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	r := Result{
+		Time:      time.Now(),
+		Durations: make(map[string]time.Duration, len(Phases)),
+	}
+	fail := func(phase string, err error) Result {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", opts.Timeout, err)
+		}
+		r.Phase, r.Err = phase, err
+		return r
+	}
+
+	start := time.Now()
+	session, err := chatclient.Login(ctx, opts.URL, opts.Name, opts.Password, opts.HTTPClient)
+	if err != nil {
+		return fail(PhaseLogin, err)
+	}
+	// Every probe starts a session: end it rather than pile them up.
+	defer session.Logout(context.WithoutCancel(ctx))
+
+	token, err := session.Token(ctx)
+	if err != nil {
+		return fail(PhaseLogin, err)
+	}
+	api, err := chatclient.New(chatclient.Config{URL: opts.URL, Token: token, HTTPClient: opts.HTTPClient})
+	if err != nil {
+		return fail(PhaseLogin, err)
+	}
+	r.Durations[PhaseLogin] = time.Since(start)
+
+	start = time.Now()
+	ws, err := chatclient.Dial(ctx, opts.URL, token, protocol.SubprotocolJSON)
+	if err != nil {
+		return fail(PhaseConnect, err)
+	}
+	defer ws.Close()
+
+	// Reads block until the deadline of the probe.
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	if _, err := receive(ws, func(env *protocol.Envelope) (bool, error) {
+		return env.Type == protocol.TypeReady, nil
+	}); err != nil {
+		return fail(PhaseConnect, err)
+	}
+	r.Durations[PhaseConnect] = time.Since(start)
+
+	// The broadcast may arrive before the post returns, it then waits in the websocket.
+	start = time.Now()
+	posted, err := api.PostMessage(ctx, "probe "+xid.New().String())
+	if err != nil {
+		return fail(PhaseRoundTrip, fmt.Errorf("post message: %w", err))
+	}
+
+	if _, err := receive(ws, func(env *protocol.Envelope) (bool, error) {
+		if env.Type != protocol.TypeMessage {
+			return false, nil
+		}
+
+		var msg protocol.MessageEvent
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return false, fmt.Errorf("decode message: %w", err)
+		}
+
+		return msg.ID == posted.ID, nil
+	}); err != nil {
+		return fail(PhaseRoundTrip, err)
+	}
+	r.Durations[PhaseRoundTrip] = time.Since(start)
+
+	return r
+}
+
+// receive skips frames until one matches, failing on error frames.
+func receive(ws *websocket.Conn, match func(env *protocol.Envelope) (bool, error)) (*protocol.Envelope, error) {
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			return nil, fmt.Errorf("receive frame: %w", err)
+		}
+
+		var env protocol.Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("decode frame: %w", err)
+		}
+
+		if env.Type == protocol.TypeError {
+			var p protocol.ErrorPayload
+			_ = json.Unmarshal(env.Payload, &p)
+			return nil, errors.New(p.Code + ": " + p.Message)
+		}
+
+		ok, err := match(&env)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &env, nil
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const password = "correct horse battery"
+
+// recorder records the requests sent through it with the status of their responses.
+type recorder struct {
+	http.RoundTripper
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.RoundTripper.RoundTrip(req)
+	if err == nil {
+		r.mu.Lock()
+		r.requests = append(r.requests, req.Method+" "+req.URL.Path+" "+strconv.Itoa(resp.StatusCode))
+		r.mu.Unlock()
+	}
+
+	return resp, err
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       Options
+		maxClients uint64
+		// wantPhase is the phase failing, empty when the probe succeeds.
+		wantPhase string
+		wantErr   string
+	}{
+		{name: "guest", opts: Options{Name: "Sentinel"}},
+		{name: "account", opts: Options{Name: "Canary", Password: password}},
+		{name: "wrong password", opts: Options{Name: "Canary", Password: "wrong"}, wantPhase: PhaseLogin},
+		{name: "invalid url", opts: Options{URL: "ftp://localhost", Name: "Sentinel"}, wantPhase: PhaseLogin, wantErr: "invalid chat url"},
+		{name: "room full", opts: Options{Name: "Sentinel"}, maxClients: 1, wantPhase: PhaseConnect, wantErr: "room_full"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The account owns its name, which guests cannot take.
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts:   []chattest.Account{{Name: "Canary", Password: password}},
+				MaxClients: tt.maxClients,
+			})
+			if tt.maxClients > 0 {
+				srv.Login("Alice").Connect()
+			}
+
+			opts := tt.opts
+			if opts.URL == "" {
+				opts.URL = srv.URL
+			}
+			rec := &recorder{RoundTripper: srv.Client().Transport}
+			opts.HTTPClient = &http.Client{Transport: rec}
+
+			r := Run(context.Background(), opts)
+			if r.Phase != tt.wantPhase || r.OK() != (tt.wantPhase == "") {
+				t.Fatalf("got phase %q and error %v, want phase %q", r.Phase, r.Err, tt.wantPhase)
+			}
+			if tt.wantErr != "" && !strings.Contains(r.Err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", r.Err, tt.wantErr)
+			}
+
+			// Only the phases before the failing one are timed.
+			for _, phase := range Phases {
+				if phase == tt.wantPhase {
+					break
+				}
+				if _, found := r.Durations[phase]; !found {
+					t.Fatalf("phase %s not timed", phase)
+				}
+			}
+			if _, found := r.Durations[tt.wantPhase]; found {
+				t.Fatalf("failed phase %s timed", tt.wantPhase)
+			}
+
+			// The session is ended once started, whatever the outcome.
+			loggedIn := slices.Contains(rec.requests, "POST /api/v1/sessions 201")
+			if loggedOut := slices.Contains(rec.requests, "DELETE /api/v1/sessions/current 204"); loggedOut != loggedIn {
+				t.Fatalf("got requests %q, want a logout %t", rec.requests, loggedIn)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+
+	// The context is done before the probe starts.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	r := Run(ctx, Options{URL: srv.URL, Name: "Sentinel", HTTPClient: srv.Client()})
+	if r.Phase != PhaseLogin || !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Fatalf("got phase %q and error %v", r.Phase, r.Err)
+	}
+}
+
+func TestResultMarshalJSON(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		r    Result
+		want string
+	}{
+		{
+			name: "success",
+			r:    Result{Time: at, Durations: map[string]time.Duration{PhaseLogin: 1500 * time.Microsecond}},
+			want: `{"time":"2024-01-01T00:00:00Z","ok":true,"durations":{"login_ms":1.5}}`,
+		},
+		{
+			name: "failure",
+			r:    Result{Time: at, Phase: PhaseConnect, Err: errors.New("room_full: the room is full")},
+			want: `{"time":"2024-01-01T00:00:00Z","ok":false,"phase":"connect","error":"room_full: the room is full","durations":{}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.r)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		results []Result
+		// want are the values of the metrics by name and phase, if any.
+		want map[string]float64
+	}{
+		{
+			name: "none",
+			want: map[string]float64{
+				"chat_probe_runs_total":             0,
+				"chat_probe_failures_total/login":   0,
+				"chat_probe_failures_total/connect": 0,
+			},
+		},
+		{
+			name: "success",
+			results: []Result{
+				{Time: at, Durations: map[string]time.Duration{PhaseLogin: time.Millisecond, PhaseConnect: time.Millisecond, PhaseRoundTrip: time.Millisecond}},
+			},
+			want: map[string]float64{
+				"chat_probe_success":                        1,
+				"chat_probe_last_success_timestamp_seconds": float64(at.Unix()),
+				"chat_probe_runs_total":                     1,
+				"chat_probe_duration_seconds/round_trip":    1,
+			},
+		},
+		{
+			name: "failure after a success",
+			results: []Result{
+				{Time: at, Durations: map[string]time.Duration{PhaseLogin: time.Millisecond, PhaseConnect: time.Millisecond, PhaseRoundTrip: time.Millisecond}},
+				{Time: at.Add(time.Minute), Phase: PhaseConnect, Err: errors.New("room_full"), Durations: map[string]time.Duration{PhaseLogin: time.Millisecond}},
+			},
+			want: map[string]float64{
+				"chat_probe_success":                        0,
+				"chat_probe_last_success_timestamp_seconds": float64(at.Unix()),
+				"chat_probe_runs_total":                     2,
+				"chat_probe_failures_total/connect":         1,
+				"chat_probe_failures_total/round_trip":      0,
+				"chat_probe_duration_seconds/login":         2,
+				"chat_probe_duration_seconds/connect":       1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			m := NewMetrics(reg)
+			for _, r := range tt.results {
+				m.Observe(r)
+			}
+
+			families, err := reg.Gather()
+			if err != nil {
+				t.Fatalf("gather: %v", err)
+			}
+
+			// Histograms are compared by their number of observations.
+			got := make(map[string]float64)
+			for _, f := range families {
+				for _, metric := range f.GetMetric() {
+					key := f.GetName()
+					for _, l := range metric.GetLabel() {
+						key += "/" + l.GetValue()
+					}
+
+					switch {
+					case metric.GetGauge() != nil:
+						got[key] = metric.GetGauge().GetValue()
+					case metric.GetCounter() != nil:
+						got[key] = metric.GetCounter().GetValue()
+					case metric.GetHistogram() != nil:
+						got[key] = float64(metric.GetHistogram().GetSampleCount())
+					}
+				}
+			}
+
+			for key, want := range tt.want {
+				if v, found := got[key]; !found || v != want {
+					t.Fatalf("got %s %v, want %v", key, v, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// User is a user as seen by JSON clients.
+type User struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Bot  bool   `json:"bot,omitempty"`
+	// Webhook marks the users of the messages posted through incoming webhooks.
+	Webhook bool `json:"webhook,omitempty"`
+	// Icon is an emoji shown before the name.
+	Icon string `json:"icon,omitempty"`
+}
+
+// Reaction is a reaction to a message as seen by JSON clients.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// MessageEvent is the payload of a message frame sent by the server.
+type MessageEvent struct {
+	ID string `json:"id"`
+	// User is empty for system messages.
+	User    *User     `json:"user,omitempty"`
+	Kind    string    `json:"kind"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+	// Edited is when the content was last edited, missing if it never was.
+	Edited    *time.Time `json:"edited,omitempty"`
+	Reactions []Reaction `json:"reactions"`
+}
+
+// MessageDeletedEvent is the payload of a message_deleted frame.
+type MessageDeletedEvent struct {
+	MessageID string    `json:"message_id"`
+	By        User      `json:"by"`
+	Time      time.Time `json:"time"`
+}
+
+// ReadyEvent is the payload of a ready frame.
+type ReadyEvent struct {
+	User    User `json:"user"`
+	Version int  `json:"version"`
+}
+
+// PresenceEvent is the payload of join and leave frames.
+type PresenceEvent struct {
+	User User      `json:"user"`
+	Time time.Time `json:"time"`
+}
+
+// NumUsersEvent is the payload of a users frame.
+type NumUsersEvent struct {
+	Count uint64 `json:"count"`
+}
+
+// UserEvent is the payload of a user frame.
+type UserEvent struct {
+	User User `json:"user"`
+}
+
+// NoticeEvent is the payload of a notice frame.
+type NoticeEvent struct {
+	Lines []string `json:"lines"`
+}
+
+// TypingEvent is the payload of a typing frame sent by the server.
+type TypingEvent struct {
+	User User `json:"user"`
+}
+
+// ReactionEvent is the payload of a reaction frame sent by the server.
+type ReactionEvent struct {
+	MessageID string     `json:"message_id"`
+	Reactions []Reaction `json:"reactions"`
+}
+
+// ReadEvent is the payload of a read frame sent by the server.
+type ReadEvent struct {
+	MessageID string `json:"message_id"`
+	ReadCount int    `json:"read_count"`
+}
+
+// ModerationEvent is the payload of a moderation frame.
+type ModerationEvent struct {
+	Action    string `json:"action"`
+	Target    User   `json:"target"`
+	Moderator User   `json:"moderator"`
+	// Duration is a Go duration (e.g. "5m0s"), empty for unmutes.
+	Duration string    `json:"duration,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// JSONEncoder encodes the events of a room as JSON envelopes.
+type JSONEncoder struct{}
+
+// Encode implements the chat.Encoder interface.
+func (JSONEncoder) Encode(_ context.Context, w io.Writer, _ *user.User, e chat.Event) error {
+	env, err := NewEvent(e)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("marshal %s frame: %w", env.Type, err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("write %s frame: %w", env.Type, err)
+	}
+
+	return nil
+}
+
+// NewEvent creates the envelope of an event.
+func NewEvent(e chat.Event) (*Envelope, error) {
+	var (
+		t       Type
+		id      string
+		payload any
+	)
+	switch e := e.(type) {
+	case chat.MessageEvent:
+		t, payload = TypeMessage, NewMessageEvent(e.Message)
+	case chat.MessageEditedEvent:
+		t, payload = TypeMessageEdited, NewMessageEvent(e.Message)
+	case chat.MessageDeletedEvent:
+		t, payload = TypeMessageDeleted, NewMessageDeletedEvent(e)
+	case chat.ReadyEvent:
+		t, payload = TypeReady, ReadyEvent{User: NewUser(e.User), Version: Version}
+	case chat.JoinEvent:
+		t, payload = TypeJoin, PresenceEvent{User: NewUser(e.User), Time: e.Time}
+	case chat.LeaveEvent:
+		t, payload = TypeLeave, PresenceEvent{User: NewUser(e.User), Time: e.Time}
+	case chat.NumUsersEvent:
+		t, payload = TypeNumUsers, NumUsersEvent{Count: e.NumUsers}
+	case chat.UserEvent:
+		t, payload = TypeUser, UserEvent{User: NewUser(e.User)}
+	case chat.NoticeEvent:
+		t, payload = TypeNotice, NoticeEvent{Lines: e.Lines}
+	case chat.TypingEvent:
+		t, payload = TypeTyping, TypingEvent{User: NewUser(e.User)}
+	case chat.ReactionEvent:
+		t, payload = TypeReaction, ReactionEvent{
+			MessageID: e.Message.ID.String(),
+			Reactions: newReactions(e.Message.Reactions()),
+		}
+	case chat.ReadEvent:
+		t, payload = TypeRead, ReadEvent{MessageID: e.Message.ID.String(), ReadCount: e.Message.ReadCount()}
+	case chat.ModerationEvent:
+		t, payload = TypeModeration, NewModerationEvent(e)
+	case chat.ErrorEvent:
+		code, message := ErrorCode(e.Err)
+		t, id, payload = TypeError, e.RequestID, ErrorPayload{Code: code, Message: message}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnknownType, e)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s payload: %w", t, err)
+	}
+
+	return &Envelope{Type: t, ID: id, Payload: data}, nil
+}
+
+// ErrorCode returns the code and message of the error frame of an error.
+// Unexpected errors are not detailed and reported as internal errors.
+func ErrorCode(err error) (string, string) {
+	codes := []struct {
+		err  error
+		code string
+	}{
+		{ErrInvalidFrame, "invalid_frame"},
+		{ErrInvalidPayload, "invalid_payload"},
+		{ErrUnknownType, "unknown_type"},
+		{command.ErrUnknownCommand, "unknown_command"},
+		{command.ErrForbidden, "forbidden"},
+		{chat.ErrNotAllowed, "forbidden"},
+		{command.ErrUserNotFound, "user_not_found"},
+		{chat.ErrRateLimited, "rate_limited"},
+		{chat.ErrMessageEmpty, "message_empty"},
+		{chat.ErrMuted, "muted"},
+		{chat.ErrMessageNotFound, "message_not_found"},
+		{chat.ErrSessionRevoked, "session_revoked"},
+		{chat.ErrSessionExpired, "session_expired"},
+		{chat.ErrExistingSession, "existing_session"},
+		{chat.ErrRoomFull, "room_full"},
+		{chat.ErrKicked, "kicked"},
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code, err.Error()
+		}
+	}
+
+	var cErr chat.Error
+	if errors.As(err, &cErr) {
+		return "invalid_request", cErr.Error()
+	}
+
+	return "internal", "internal error"
+}
+
+// NewUser converts a user for JSON clients.
+func NewUser(u *user.User) User {
+	return User{
+		ID:      u.ID.String(),
+		Name:    u.Name,
+		Role:    u.Role.String(),
+		Bot:     u.Bot,
+		Webhook: u.Webhook,
+		Icon:    u.Icon,
+	}
+}
+
+// NewMessageEvent converts a message for JSON clients.
+func NewMessageEvent(m *chat.Message) MessageEvent {
+	e := MessageEvent{
+		ID:        m.ID.String(),
+		Kind:      "text",
+		Content:   m.Content,
+		Time:      m.Time,
+		Reactions: newReactions(m.Reactions()),
+	}
+	if m.User != nil {
+		u := NewUser(m.User)
+		e.User = &u
+	}
+	if !m.Edited.IsZero() {
+		e.Edited = &m.Edited
+	}
+	switch {
+	case m.IsAction():
+		e.Kind = "action"
+	case m.IsSystem():
+		e.Kind = "system"
+	}
+
+	return e
+}
+
+// NewMessageDeletedEvent converts a deletion for JSON clients.
+func NewMessageDeletedEvent(e chat.MessageDeletedEvent) MessageDeletedEvent {
+	return MessageDeletedEvent{MessageID: e.Message.ID.String(), By: NewUser(e.By), Time: e.Time}
+}
+
+// NewModerationEvent converts a moderation event for JSON clients.
+func NewModerationEvent(e chat.ModerationEvent) ModerationEvent {
+	m := ModerationEvent{
+		Action:    string(e.Action),
+		Target:    NewUser(e.Target),
+		Moderator: NewUser(e.Moderator),
+		Time:      e.Time,
+	}
+	if e.Duration > 0 {
+		m.Duration = e.Duration.String()
+	}
+
+	return m
+}
+
+func newReactions(reactions []chat.Reaction) []Reaction {
+	rs := make([]Reaction, 0, len(reactions))
+	for _, r := range reactions {
+		users := make([]string, 0, len(r.Users))
+		for _, id := range r.Users {
+			users = append(users, id.String())
+		}
+		rs = append(rs, Reaction{Emoji: r.Emoji, Users: users})
+	}
+
+	return rs
+}
@@ -0,0 +1,244 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestNegotiateSubprotocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      string
+		wantErr   bool
+	}{
+		{name: "none", want: SubprotocolHTML},
+		{name: "json", requested: []string{SubprotocolJSON}, want: SubprotocolJSON},
+		{name: "first supported", requested: []string{"chat-demo.xml", SubprotocolJSON, SubprotocolHTML}, want: SubprotocolJSON},
+		{name: "unsupported", requested: []string{"chat-demo.xml"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NegotiateSubprotocol(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUnsupportedSubprotocol) {
+				t.Fatalf("got error %v, want %v", err, ErrUnsupportedSubprotocol)
+			}
+			if got != tt.want {
+				t.Fatalf("got subprotocol %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	alice := &user.User{ID: xid.New(), Name: "Alice", Role: user.RoleModerator}
+	bob := &user.User{ID: xid.New(), Name: "Bob", Bot: true, Icon: "🤖"}
+	msg, err := chat.NewMessage(alice, "hello")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	msg.Time = at
+	if err := msg.ToggleReaction(bob.ID, "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	msg.MarkRead(bob.ID)
+
+	aliceJSON := fmt.Sprintf(`{"id":%q,"name":"Alice","role":"moderator"}`, alice.ID)
+	bobJSON := fmt.Sprintf(`{"id":%q,"name":"Bob","role":"member","bot":true,"icon":"🤖"}`, bob.ID)
+	reactionsJSON := fmt.Sprintf(`[{"emoji":"👍","users":[%q]}]`, bob.ID)
+
+	tests := []struct {
+		name        string
+		event       chat.Event
+		wantType    Type
+		wantID      string
+		wantPayload string
+	}{
+		{
+			name:        "message",
+			event:       chat.MessageEvent{Message: msg},
+			wantType:    TypeMessage,
+			wantPayload: fmt.Sprintf(`{"id":%q,"user":%s,"kind":"text","content":"hello","time":"2024-01-02T03:04:05Z","reactions":%s}`, msg.ID, aliceJSON, reactionsJSON),
+		},
+		{
+			name:        "deleted message",
+			event:       chat.MessageDeletedEvent{Message: msg, By: bob, Time: at},
+			wantType:    TypeMessageDeleted,
+			wantPayload: fmt.Sprintf(`{"message_id":%q,"by":%s,"time":"2024-01-02T03:04:05Z"}`, msg.ID, bobJSON),
+		},
+		{
+			name:        "ready",
+			event:       chat.ReadyEvent{User: alice},
+			wantType:    TypeReady,
+			wantPayload: fmt.Sprintf(`{"user":%s,"version":1}`, aliceJSON),
+		},
+		{
+			name:        "join",
+			event:       chat.JoinEvent{User: bob, Time: at},
+			wantType:    TypeJoin,
+			wantPayload: fmt.Sprintf(`{"user":%s,"time":"2024-01-02T03:04:05Z"}`, bobJSON),
+		},
+		{
+			name:        "leave",
+			event:       chat.LeaveEvent{User: bob, Time: at},
+			wantType:    TypeLeave,
+			wantPayload: fmt.Sprintf(`{"user":%s,"time":"2024-01-02T03:04:05Z"}`, bobJSON),
+		},
+		{
+			name:        "number of users",
+			event:       chat.NumUsersEvent{NumUsers: 3},
+			wantType:    TypeNumUsers,
+			wantPayload: `{"count":3}`,
+		},
+		{
+			name:        "user",
+			event:       chat.UserEvent{User: alice},
+			wantType:    TypeUser,
+			wantPayload: fmt.Sprintf(`{"user":%s}`, aliceJSON),
+		},
+		{
+			name:        "notice",
+			event:       chat.NoticeEvent{Lines: []string{"a", "b"}},
+			wantType:    TypeNotice,
+			wantPayload: `{"lines":["a","b"]}`,
+		},
+		{
+			name:        "typing",
+			event:       chat.TypingEvent{User: alice},
+			wantType:    TypeTyping,
+			wantPayload: fmt.Sprintf(`{"user":%s}`, aliceJSON),
+		},
+		{
+			name:        "reaction",
+			event:       chat.ReactionEvent{Message: msg},
+			wantType:    TypeReaction,
+			wantPayload: fmt.Sprintf(`{"message_id":%q,"reactions":%s}`, msg.ID, reactionsJSON),
+		},
+		{
+			name:        "read",
+			event:       chat.ReadEvent{Message: msg},
+			wantType:    TypeRead,
+			wantPayload: fmt.Sprintf(`{"message_id":%q,"read_count":1}`, msg.ID),
+		},
+		{
+			name:        "mute",
+			event:       chat.ModerationEvent{Action: chat.ModerationMute, Target: bob, Moderator: alice, Duration: 5 * time.Minute, Time: at},
+			wantType:    TypeModeration,
+			wantPayload: fmt.Sprintf(`{"action":"mute","target":%s,"moderator":%s,"duration":"5m0s","time":"2024-01-02T03:04:05Z"}`, bobJSON, aliceJSON),
+		},
+		{
+			name:        "unmute",
+			event:       chat.ModerationEvent{Action: chat.ModerationUnmute, Target: bob, Moderator: alice, Time: at},
+			wantType:    TypeModeration,
+			wantPayload: fmt.Sprintf(`{"action":"unmute","target":%s,"moderator":%s,"time":"2024-01-02T03:04:05Z"}`, bobJSON, aliceJSON),
+		},
+		{
+			name:        "error",
+			event:       chat.ErrorEvent{RequestID: "7", Err: chat.ErrRateLimited},
+			wantType:    TypeError,
+			wantID:      "7",
+			wantPayload: fmt.Sprintf(`{"code":"rate_limited","message":%q}`, chat.ErrRateLimited.Error()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := NewEvent(tt.event)
+			if err != nil {
+				t.Fatalf("new event: %v", err)
+			}
+
+			if env.Type != tt.wantType || env.ID != tt.wantID {
+				t.Fatalf("got %s frame %q, want %s frame %q", env.Type, env.ID, tt.wantType, tt.wantID)
+			}
+			if !sameJSON(t, env.Payload, []byte(tt.wantPayload)) {
+				t.Fatalf("got payload\n%s\nwant\n%s", env.Payload, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestNewMessageEvent(t *testing.T) {
+	alice := user.NewNamed("Alice")
+	action, _ := chat.NewAction(alice, "waves")
+	system, _ := chat.NewSystemMessage("Alice joined")
+	edited, _ := chat.NewMessage(alice, "hello")
+	edited.Edited = time.Now()
+
+	tests := []struct {
+		name       string
+		msg        *chat.Message
+		wantKind   string
+		wantUser   bool
+		wantEdited bool
+	}{
+		{name: "action", msg: action, wantKind: "action", wantUser: true},
+		{name: "system", msg: system, wantKind: "system"},
+		{name: "edited", msg: edited, wantKind: "text", wantUser: true, wantEdited: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewMessageEvent(tt.msg)
+			if e.Kind != tt.wantKind || (e.User != nil) != tt.wantUser || (e.Edited != nil) != tt.wantEdited {
+				t.Fatalf("got %s message by %v edited at %v", e.Kind, e.User, e.Edited)
+			}
+			if e.Reactions == nil {
+				t.Fatal("reactions must be an empty list rather than null")
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    string
+		wantMessage string
+	}{
+		{name: "protocol error", err: fmt.Errorf("%w: %q", ErrUnknownType, "dance"), wantCode: "unknown_type", wantMessage: `unknown frame type: "dance"`},
+		{name: "command error", err: command.ErrUnknownCommand, wantCode: "unknown_command", wantMessage: command.ErrUnknownCommand.Error()},
+		{name: "chat error", err: chat.ErrMuted, wantCode: "muted", wantMessage: chat.ErrMuted.Error()},
+		{name: "other chat error", err: chat.ErrInvalidReaction, wantCode: "invalid_request", wantMessage: chat.ErrInvalidReaction.Error()},
+		{name: "unexpected error", err: errors.New("database is down"), wantCode: "internal", wantMessage: "internal error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := ErrorCode(tt.err)
+			if code != tt.wantCode || message != tt.wantMessage {
+				t.Fatalf("got %s %q, want %s %q", code, message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (JSONEncoder{}).Encode(context.Background(), &buf, nil, chat.NumUsersEvent{NumUsers: 2}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if got, want := buf.String(), `{"type":"users","payload":{"count":2}}`; got != want {
+		t.Fatalf("got frame %s, want %s", got, want)
+	}
+
+	type unknownEvent struct{ chat.NoticeEvent }
+	if err := (JSONEncoder{}).Encode(context.Background(), &buf, nil, unknownEvent{}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("got error %v, want %v", err, ErrUnknownType)
+	}
+}
@@ -0,0 +1,266 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/mgjules/chat-demo/command"
+)
+
+// Version is the current version of the protocol.
+const Version = 1
+
+// SupportedVersions is the list of protocol versions the server speaks.
+var SupportedVersions = []int{1}
+
+// List of websocket subprotocols, negotiated with the Sec-WebSocket-Protocol header.
+const (
+	// SubprotocolHTML streams HTML fragments for htmx. It is used when a client does not request any subprotocol.
+	SubprotocolHTML = "chat-demo.html"
+	// SubprotocolJSON streams events as JSON envelopes.
+	SubprotocolJSON = "chat-demo.json"
+)
+
+// List of protocol errors.
+var (
+	ErrUnsupportedVersion     = errors.New("unsupported protocol version")
+	ErrUnsupportedSubprotocol = errors.New("unsupported subprotocol")
+	ErrInvalidFrame           = errors.New("invalid frame")
+	ErrInvalidPayload         = errors.New("invalid payload")
+	ErrUnknownType            = errors.New("unknown frame type")
+)
+
+// Type is the type of a frame.
+type Type string
+
+// List of frame types sent by clients.
+const (
+	TypeMessage  Type = "message"
+	TypeCommand  Type = "command"
+	TypeTyping   Type = "typing"
+	TypeReaction Type = "reaction"
+	TypeRead     Type = "read"
+	TypeReauth   Type = "reauth"
+)
+
+// List of frame types sent by the server to JSON clients.
+// Reaction, read and typing frames share their type with the requests which caused them.
+const (
+	TypeReady          Type = "ready"
+	TypeMessageEdited  Type = "message_edited"
+	TypeMessageDeleted Type = "message_deleted"
+	TypeJoin           Type = "join"
+	TypeLeave          Type = "leave"
+	TypeNumUsers       Type = "users"
+	TypeUser           Type = "user"
+	TypeNotice         Type = "notice"
+	TypeModeration     Type = "moderation"
+	// TypeError is the type of the frames replying to a failed request.
+	TypeError Type = "error"
+)
+
+// Envelope wraps every frame exchanged over the websocket.
+type Envelope struct {
+	Type Type `json:"type"`
+	// ID is chosen by the client to match replies (e.g. errors) to its requests.
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	form bool
+}
+
+// IsForm checks if the frame was mapped from a htmx form.
+func (e *Envelope) IsForm() bool {
+	return e.form
+}
+
+// MessagePayload is the payload of a message frame.
+type MessagePayload struct {
+	Content string `json:"content"`
+}
+
+// CommandPayload is the payload of a command frame.
+type CommandPayload struct {
+	Input string `json:"input"`
+}
+
+// ReactionPayload is the payload of a reaction frame.
+// Reacting twice with the same emoji removes the reaction.
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReadPayload is the payload of a read receipt frame.
+type ReadPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// ReauthPayload is the payload of a re-authentication frame.
+type ReauthPayload struct {
+	Token string `json:"token"`
+}
+
+// ErrorPayload is the payload of an error frame.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Negotiate returns the protocol version to speak given the version requested by a client.
+// Clients which do not request a version speak the first one.
+func Negotiate(requested string) (int, error) {
+	if requested == "" {
+		return SupportedVersions[0], nil
+	}
+
+	v, err := strconv.Atoi(requested)
+	if err != nil || !slices.Contains(SupportedVersions, v) {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, requested)
+	}
+
+	return v, nil
+}
+
+// NegotiateSubprotocol picks the first supported subprotocol among the ones requested by a client.
+func NegotiateSubprotocol(requested []string) (string, error) {
+	if len(requested) == 0 {
+		return SubprotocolHTML, nil
+	}
+
+	for _, p := range requested {
+		if p == SubprotocolHTML || p == SubprotocolJSON {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedSubprotocol, requested)
+}
+
+// Decode decodes a frame sent by a client.
+//
+// Frames sent by the htmx ws-send extension are flat JSON objects of the form values
+// along with a HEADERS object. They are mapped into an envelope:
+// the "type" field picks the type (defaulting to a message, or a command if the
+// chat_message field is a command) and the other fields become the payload.
+func Decode(data []byte) (*Envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	if _, htmx := fields["HEADERS"]; !htmx {
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+		}
+		if env.Type == "" {
+			return nil, fmt.Errorf("%w: missing type", ErrInvalidFrame)
+		}
+
+		return &env, nil
+	}
+
+	values := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if k == "HEADERS" {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, fmt.Errorf("%w: field %q is not a string", ErrInvalidFrame, k)
+		}
+		values[k] = s
+	}
+
+	env := &Envelope{
+		Type: Type(values["type"]),
+		ID:   values["id"],
+		form: true,
+	}
+	delete(values, "type")
+	delete(values, "id")
+
+	var payload any = values
+	if msg, found := values["chat_message"]; found && env.Type == "" {
+		if command.IsCommand(msg) {
+			env.Type = TypeCommand
+			payload = CommandPayload{Input: msg}
+		} else {
+			env.Type = TypeMessage
+			payload = MessagePayload{Content: msg}
+		}
+	}
+	if env.Type == "" {
+		return nil, fmt.Errorf("%w: missing type", ErrInvalidFrame)
+	}
+
+	var err error
+	if env.Payload, err = json.Marshal(payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	return env, nil
+}
+
+// DecodePayload decodes the payload of the envelope into v, rejecting unknown fields.
+func (e *Envelope) DecodePayload(v any) error {
+	if len(e.Payload) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(e.Payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+
+	return nil
+}
+
+// NewError creates an error frame replying to the request with the given ID.
+func NewError(id, code, message string) *Envelope {
+	payload, _ := json.Marshal(ErrorPayload{Code: code, Message: message})
+
+	return &Envelope{
+		Type:    TypeError,
+		ID:      id,
+		Payload: payload,
+	}
+}
+
+// Handler handles a frame of a given type.
+type Handler func(ctx context.Context, env *Envelope) error
+
+// Registry dispatches frames to the handler of their type.
+type Registry struct {
+	handlers map[Type]Handler
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[Type]Handler),
+	}
+}
+
+// Handle registers the handler of a frame type, replacing any previous one.
+func (r *Registry) Handle(t Type, h Handler) {
+	r.handlers[t] = h
+}
+
+// Dispatch calls the handler of the type of the frame.
+func (r *Registry) Dispatch(ctx context.Context, env *Envelope) error {
+	h, found := r.handlers[env.Type]
+	if !found {
+		return fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+
+	return h(ctx, env)
+}
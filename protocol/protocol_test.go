@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sameJSON checks if two JSON documents hold the same values, whatever the order of their fields.
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("unmarshal %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("unmarshal %s: %v", b, err)
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		requested string
+		want      int
+		wantErr   bool
+	}{
+		{requested: "", want: 1},
+		{requested: "1", want: 1},
+		{requested: "2", wantErr: true},
+		{requested: "v1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, err := Negotiate(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUnsupportedVersion) {
+				t.Fatalf("got error %v, want %v", err, ErrUnsupportedVersion)
+			}
+			if got != tt.want {
+				t.Fatalf("got version %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		frame       string
+		wantType    Type
+		wantID      string
+		wantPayload string
+		wantForm    bool
+		wantErr     error
+	}{
+		{
+			name:     "envelope",
+			frame:    `{"type":"reaction","id":"7","payload":{"message_id":"abc","emoji":"🎉"}}`,
+			wantType: TypeReaction, wantID: "7", wantPayload: `{"message_id":"abc","emoji":"🎉"}`,
+		},
+		{
+			name:     "envelope without payload",
+			frame:    `{"type":"typing"}`,
+			wantType: TypeTyping,
+		},
+		{
+			name:     "form message",
+			frame:    `{"chat_message":"hello","HEADERS":{"HX-Request":"true"}}`,
+			wantType: TypeMessage, wantPayload: `{"content":"hello"}`, wantForm: true,
+		},
+		{
+			name:     "form command",
+			frame:    `{"chat_message":"/nick Bob","HEADERS":{}}`,
+			wantType: TypeCommand, wantPayload: `{"input":"/nick Bob"}`, wantForm: true,
+		},
+		{
+			name:     "form with a type",
+			frame:    `{"type":"read","id":"3","message_id":"abc","HEADERS":{}}`,
+			wantType: TypeRead, wantID: "3", wantPayload: `{"message_id":"abc"}`, wantForm: true,
+		},
+		{name: "not json", frame: `hello`, wantErr: ErrInvalidFrame},
+		{name: "not an object", frame: `["message"]`, wantErr: ErrInvalidFrame},
+		{name: "missing type", frame: `{"payload":{"content":"hello"}}`, wantErr: ErrInvalidFrame},
+		{name: "invalid type", frame: `{"type":42}`, wantErr: ErrInvalidFrame},
+		{name: "form without type", frame: `{"emoji":"🎉","HEADERS":{}}`, wantErr: ErrInvalidFrame},
+		{name: "form with a field which is not a string", frame: `{"chat_message":42,"HEADERS":{}}`, wantErr: ErrInvalidFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Decode([]byte(tt.frame))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if env.Type != tt.wantType || env.ID != tt.wantID || env.IsForm() != tt.wantForm {
+				t.Fatalf("got %s frame %q (form %t), want %s frame %q (form %t)", env.Type, env.ID, env.IsForm(), tt.wantType, tt.wantID, tt.wantForm)
+			}
+			if tt.wantPayload == "" {
+				if len(env.Payload) != 0 {
+					t.Fatalf("got payload %s, want none", env.Payload)
+				}
+				return
+			}
+
+			if !sameJSON(t, env.Payload, []byte(tt.wantPayload)) {
+				t.Fatalf("got payload %s, want %s", env.Payload, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    ReactionPayload
+		wantErr bool
+	}{
+		{name: "valid", payload: `{"message_id":"abc","emoji":"🎉"}`, want: ReactionPayload{MessageID: "abc", Emoji: "🎉"}},
+		{name: "missing", payload: ``},
+		{name: "unknown field", payload: `{"message_id":"abc","emoji":"🎉","extra":true}`, wantErr: true},
+		{name: "wrong type", payload: `{"message_id":42}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ReactionPayload
+			err := (&Envelope{Type: TypeReaction, Payload: json.RawMessage(tt.payload)}).DecodePayload(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPayload) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidPayload)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("got payload %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	var got []string
+	r.Handle(TypeMessage, func(_ context.Context, env *Envelope) error {
+		got = append(got, "first "+env.ID)
+		return nil
+	})
+	r.Handle(TypeMessage, func(_ context.Context, env *Envelope) error {
+		got = append(got, "second "+env.ID)
+		return nil
+	})
+	errTyping := errors.New("typing failed")
+	r.Handle(TypeTyping, func(context.Context, *Envelope) error { return errTyping })
+
+	if err := r.Dispatch(context.Background(), &Envelope{Type: TypeMessage, ID: "1"}); err != nil {
+		t.Fatalf("dispatch message: %v", err)
+	}
+	if len(got) != 1 || got[0] != "second 1" {
+		t.Fatalf("got calls %q, want the last handler registered", got)
+	}
+	if err := r.Dispatch(context.Background(), &Envelope{Type: TypeTyping}); !errors.Is(err, errTyping) {
+		t.Fatalf("got error %v, want the error of the handler", err)
+	}
+	if err := r.Dispatch(context.Background(), &Envelope{Type: "unknown"}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("got error %v, want %v", err, ErrUnknownType)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	env := NewError("7", "rate_limited", "slow down")
+	if env.Type != TypeError || env.ID != "7" {
+		t.Fatalf("got %s frame %q, want an error replying to 7", env.Type, env.ID)
+	}
+
+	var p ErrorPayload
+	if err := env.DecodePayload(&p); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if p != (ErrorPayload{Code: "rate_limited", Message: "slow down"}) {
+		t.Fatalf("got payload %+v", p)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/templates"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+)
+
+var errTooManyAttempts = errors.New("too many login attempts, please try again later")
+
+func loginAccount(a *auth, opts *authOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		view := templates.LoginView{
+			Guests:      opts.guests,
+			Accounts:    true,
+			SSOName:     opts.ssoName,
+			AccountName: r.PostFormValue("name"),
+		}
+		if opts.guests {
+			view.Name = user.RandomName()
+		}
+
+		// Throttle attempts per IP to slow down credential stuffing
+		// and per account to slow down guessing a single password.
+		if throttled(r, opts.throttle, "ip:"+clientIP(r), time.Minute/10, 10) ||
+			throttled(r, opts.throttle, "account:"+user.Skeleton(view.AccountName), time.Minute/2, 5) {
+			view.AccountError = errTooManyAttempts.Error()
+			w.Header().Set("Retry-After", "60")
+			renderLogin(w, r, http.StatusTooManyRequests, view)
+			return
+		}
+
+		acc, err := account.Authenticate(ctx, opts.accounts, view.AccountName, r.PostFormValue("password"))
+		if err != nil {
+			if !errors.Is(err, account.ErrInvalidCredentials) {
+				slog.ErrorContext(ctx, "authenticate account", "err", err)
+			}
+			view.AccountError = account.ErrInvalidCredentials.Error()
+			renderLogin(w, r, http.StatusUnprocessableEntity, view)
+			return
+		}
+
+		if err := a.startSession(w, r, accountUser(acc, opts)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func register(a *auth, opts *authOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Method != http.MethodPost {
+			renderRegister(w, r, http.StatusOK, "", "")
+			return
+		}
+
+		name := r.PostFormValue("name")
+		if throttled(r, opts.throttle, "ip:"+clientIP(r), time.Minute/10, 10) {
+			w.Header().Set("Retry-After", "60")
+			renderRegister(w, r, http.StatusTooManyRequests, name, errTooManyAttempts.Error())
+			return
+		}
+
+		acc, err := account.New(name, r.PostFormValue("password"))
+		if err != nil {
+			renderRegister(w, r, http.StatusUnprocessableEntity, name, err.Error())
+			return
+		}
+
+		if err := opts.accounts.Create(ctx, acc); err != nil {
+			if !errors.Is(err, account.ErrExists) {
+				slog.ErrorContext(ctx, "create account", "err", err)
+				http.Error(w, "failed to create account", http.StatusInternalServerError)
+				return
+			}
+
+			renderRegister(w, r, http.StatusUnprocessableEntity, name, err.Error())
+			return
+		}
+
+		if err := a.startSession(w, r, accountUser(acc, opts)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func renderRegister(w http.ResponseWriter, r *http.Request, status int, name, errMsg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := render(r.Context(), w, "RegisterPage", templates.RegisterPage(name, errMsg)); err != nil {
+		slog.ErrorContext(r.Context(), "render register template", "err", err)
+		w.Write([]byte("failed to render register template"))
+	}
+}
+
+// accountUser returns the user of an account, granting the admin and moderator
+// roles to the accounts configured as such.
+func accountUser(a *account.Account, opts *authOptions) *user.User {
+	u := a.User()
+	for _, name := range opts.moderators {
+		if user.Skeleton(name) == user.Skeleton(a.Name) && u.Role < user.RoleModerator {
+			u.Role = user.RoleModerator
+		}
+	}
+	for _, name := range opts.admins {
+		if user.Skeleton(name) == user.Skeleton(a.Name) {
+			u.Role = user.RoleAdmin
+		}
+	}
+
+	return u
+}
+
+// nameOwner finds the account owning a name so that no one else can use it, even while it is offline.
+func (o *authOptions) nameOwner(name string) (xid.ID, bool) {
+	if o.accounts == nil {
+		return xid.NilID(), false
+	}
+
+	acc, err := o.accounts.FindByName(context.Background(), name)
+	if errors.Is(err, account.ErrNotFound) {
+		return xid.NilID(), false
+	}
+	if err != nil {
+		// Rather refuse the name than let an account be impersonated.
+		slog.Error("find account by name", "err", err)
+		return xid.NilID(), true
+	}
+
+	return acc.ID, true
+}
+
+// throttled consumes a token from the limiter of key and checks if it is exhausted.
+func throttled(r *http.Request, lims *limiters, key string, d time.Duration, b int64) bool {
+	_, err := lims.get(key, d, b).Limit(r.Context())
+	return errors.Is(err, mlimiters.ErrLimitExhausted)
+}
+
+// clientIP returns the IP of the client.
+// It relies on middleware.RealIP to resolve proxied requests.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
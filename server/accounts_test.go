@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/user"
+)
+
+func TestAccountUser(t *testing.T) {
+	opts := &authOptions{
+		admins:     []string{"Alice"},
+		moderators: []string{"Bob", "alice"},
+	}
+
+	tests := []struct {
+		name   string
+		stored user.Role
+		want   user.Role
+	}{
+		{name: "Alice", want: user.RoleAdmin},
+		{name: "ALICE", want: user.RoleAdmin},
+		{name: "Bob", want: user.RoleModerator},
+		{name: "B0b", want: user.RoleModerator},
+		{name: "Carol", want: user.RoleMember},
+		{name: "Dave", stored: user.RoleModerator, want: user.RoleModerator},
+		{name: "bob", stored: user.RoleAdmin, want: user.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &account.Account{Name: tt.name, Role: tt.stored}
+			if got := accountUser(acc, opts).Role; got != tt.want {
+				t.Fatalf("got role %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameOwner(t *testing.T) {
+	store, err := account.NewFileStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	acc, err := account.New("Alice", "correct horse battery")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := store.Create(context.Background(), acc); err != nil {
+		t.Fatalf("store account: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      *authOptions
+		wantOwner bool
+	}{
+		{name: "Alice", opts: &authOptions{accounts: store}, wantOwner: true},
+		{name: "ALlCE", opts: &authOptions{accounts: store}, wantOwner: true},
+		{name: "Bob", opts: &authOptions{accounts: store}},
+		{name: "Alice", opts: &authOptions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, found := tt.opts.nameOwner(tt.name)
+			if found != tt.wantOwner {
+				t.Fatalf("got owned %t, want %t", found, tt.wantOwner)
+			}
+			if found && owner != acc.ID {
+				t.Fatalf("got owner %s, want %s", owner, acc.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/mgjules/chat-demo/webhook"
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+)
+
+// List of limits of the message pages of the API.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// List of API errors.
+var (
+	errRoomNotFound  = errors.New("room not found")
+	errCommand       = errors.New("commands can only be run from the chat")
+	errOutOfScope    = errors.New("api key not allowed to do this in this room")
+	errAdminOnly     = errors.New("only admins can do this")
+	errInvalidCursor = chat.NewError(chat.ErrorSeverityError, false, "invalid pagination cursor")
+	errInvalidLimit  = chat.NewError(chat.ErrorSeverityError, false, "limit must be between 1 and 100")
+	errInvalidBody   = chat.NewError(chat.ErrorSeverityError, false, "invalid request body")
+)
+
+// apiErrorCode is the HTTP status and code of an error.
+type apiErrorCode struct {
+	err    error
+	status int
+	code   string
+}
+
+// apiErrors maps the errors specific to the API to HTTP statuses and codes.
+var apiErrors = []apiErrorCode{
+	{errRoomNotFound, http.StatusNotFound, "room_not_found"},
+	{errOutOfScope, http.StatusForbidden, "forbidden"},
+	{errAdminOnly, http.StatusForbidden, "forbidden"},
+	{errCommand, http.StatusBadRequest, "command_not_supported"},
+	{bot.ErrInvalidKey, http.StatusUnauthorized, "unauthorized"},
+	{bot.ErrNotFound, http.StatusNotFound, "bot_not_found"},
+	{bot.ErrKeyNotFound, http.StatusNotFound, "key_not_found"},
+	{bot.ErrExists, http.StatusConflict, "bot_exists"},
+	{bot.ErrAlreadyRevoked, http.StatusConflict, "key_revoked"},
+	{bot.ErrInvalidScope, http.StatusBadRequest, "invalid_scope"},
+	{bot.ErrUnknownAction, http.StatusBadRequest, "invalid_scope"},
+	{bot.ErrInvalidLimit, http.StatusBadRequest, "invalid_rate_limit"},
+	{webhook.ErrNotFound, http.StatusNotFound, "webhook_not_found"},
+	{webhook.ErrDeliveryNotFound, http.StatusNotFound, "delivery_not_found"},
+	{webhook.ErrInvalidURL, http.StatusBadRequest, "invalid_url"},
+	{webhook.ErrUnknownEvent, http.StatusBadRequest, "invalid_events"},
+	{webhook.ErrIncomingNotFound, http.StatusNotFound, "webhook_not_found"},
+	{webhook.ErrDisabled, http.StatusForbidden, "webhook_disabled"},
+	{webhook.ErrInvalidIcon, http.StatusBadRequest, "invalid_icon"},
+	{errGuestsDisabled, http.StatusForbidden, "forbidden"},
+	{errAccountsDisabled, http.StatusForbidden, "forbidden"},
+	{errNoSession, http.StatusForbidden, "forbidden"},
+	{errTooManyAttempts, http.StatusTooManyRequests, "rate_limited"},
+	{account.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{user.ErrNameLength, http.StatusBadRequest, "invalid_name"},
+	{user.ErrNameCharacters, http.StatusBadRequest, "invalid_name"},
+	{user.ErrNameScripts, http.StatusBadRequest, "invalid_name"},
+	{user.ErrNameProfane, http.StatusBadRequest, "invalid_name"},
+	{user.ErrNameReserved, http.StatusBadRequest, "invalid_name"},
+}
+
+// apiStatuses maps the error codes shared with the websocket protocol to HTTP statuses.
+// Other chat errors are bad requests.
+var apiStatuses = map[string]int{
+	"forbidden":         http.StatusForbidden,
+	"muted":             http.StatusForbidden,
+	"kicked":            http.StatusForbidden,
+	"rate_limited":      http.StatusTooManyRequests,
+	"message_not_found": http.StatusNotFound,
+	"user_not_found":    http.StatusNotFound,
+	"room_full":         http.StatusServiceUnavailable,
+	"internal":          http.StatusInternalServerError,
+}
+
+// apiRoom is a room as seen by API clients.
+type apiRoom struct {
+	ID       string `json:"id"`
+	NumUsers uint64 `json:"num_users"`
+}
+
+// apiMessages is a page of messages, from the oldest to the newest.
+type apiMessages struct {
+	Messages []protocol.MessageEvent `json:"messages"`
+	// Before is the cursor of the previous page, empty on the first page.
+	Before string `json:"before,omitempty"`
+}
+
+// api serves the versioned JSON HTTP API.
+type api struct {
+	auth       *auth
+	opts       *authOptions
+	bots       bot.Store
+	webhooks   webhook.Store
+	incoming   webhook.IncomingStore
+	dispatcher *webhook.Dispatcher
+	rooms      map[string]*chat.Room
+	lims       *limiters
+}
+
+// routes mounts the routes of the API.
+func (a *api) routes(r chi.Router) {
+	// Clients without cookies start their sessions with the API.
+	r.Post("/sessions", a.startSession)
+	r.Post("/sessions/refresh", a.refreshSession)
+
+	r.Group(func(r chi.Router) {
+		r.Use(a.authenticate)
+
+		r.Delete("/sessions/current", a.endSession)
+		r.Get("/me", a.me)
+		r.Get("/rooms", a.listRooms)
+		r.Route("/rooms/{room}", func(r chi.Router) {
+			r.Get("/", a.getRoom)
+			r.Get("/users", a.listUsers)
+			r.Get("/messages", a.listMessages)
+			r.Post("/messages", a.postMessage)
+			r.Patch("/messages/{message}", a.editMessage)
+			r.Delete("/messages/{message}", a.deleteMessage)
+			r.Post("/messages/{message}/reactions", a.react)
+		})
+		r.Route("/bots", func(r chi.Router) {
+			r.Use(a.adminOnly)
+
+			r.Get("/", a.listBots)
+			r.Post("/", a.createBot)
+			r.Post("/{bot}/keys", a.createKey)
+			r.Delete("/{bot}/keys/{key}", a.revokeKey)
+		})
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Use(a.adminOnly)
+
+			r.Get("/", a.listWebhooks)
+			r.Post("/", a.createWebhook)
+			r.Delete("/{webhook}", a.deleteWebhook)
+			r.Get("/{webhook}/deliveries", a.listAttempts)
+			r.Get("/dead", a.listDead)
+			r.Post("/dead/{delivery}/retry", a.retryDead)
+			r.Route("/incoming", func(r chi.Router) {
+				r.Get("/", a.listIncoming)
+				r.Post("/", a.createIncoming)
+				r.Patch("/{incoming}", a.updateIncoming)
+				r.Post("/{incoming}/rotate", a.rotateIncoming)
+				r.Delete("/{incoming}", a.deleteIncoming)
+			})
+		})
+	})
+}
+
+// authenticate accepts the session cookie, a bearer token or the API key of a bot.
+// Unlike pages, expired sessions are never renewed: clients refresh their tokens themselves.
+func (a *api) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := jwtauth.TokenFromHeader(r); bot.IsToken(token) {
+			b, k, err := bot.Authenticate(r.Context(), a.bots, token)
+			if err != nil {
+				apiError(w, r, err)
+				return
+			}
+
+			ctx := bot.AddToContext(r.Context(), b, k)
+			ctx = user.AddToContext(ctx, b.User())
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		claims, err := a.auth.authenticate(r)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+
+		a.auth.sessions.Touch(claims, r.UserAgent(), clientIP(r))
+
+		ctx := session.AddToContext(r.Context(), claims)
+		ctx = user.AddToContext(ctx, claims.User)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminOnly restricts routes to admins. Bots are never admins.
+func (a *api) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := user.FromContext(r.Context()); u.Bot || !u.HasRole(user.RoleAdmin) {
+			apiError(w, r, errAdminOnly)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// room finds the room of the request and checks that the API key of bots
+// allows the action in the room.
+func (a *api) room(w http.ResponseWriter, r *http.Request, action bot.Action) (*chat.Room, bool) {
+	id := chi.URLParam(r, "room")
+	room, found := a.rooms[id]
+	if !found {
+		apiError(w, r, errRoomNotFound)
+		return nil, false
+	}
+
+	if _, k := bot.FromContext(r.Context()); k != nil && !k.Scope.Allows(id, action) {
+		apiError(w, r, errOutOfScope)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// throttle rate limits the actions of the user of the request.
+// Users share the rate limit of their websocket while bots have their own policy, if any.
+func (a *api) throttle(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+
+	var lim *limiter
+	if b, _ := bot.FromContext(ctx); b != nil {
+		if b.RateLimit == nil {
+			return false
+		}
+		lim = a.lims.get("bot:"+b.ID.String(), b.RateLimit.Interval, b.RateLimit.Burst)
+	} else {
+		// The limiter is only held by the websocket, requests getting it.
+		lim = a.lims.get(user.FromContext(ctx).ID.String(), 5*time.Second, 3)
+	}
+
+	return a.exhausted(w, r, lim)
+}
+
+// exhausted takes a token from the limiter, telling the client when
+// to retry if the limit is exhausted.
+func (a *api) exhausted(w http.ResponseWriter, r *http.Request, lim *limiter) bool {
+	wait, err := lim.Limit(r.Context())
+	if !errors.Is(err, mlimiters.ErrLimitExhausted) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+	apiError(w, r, chat.ErrRateLimited)
+
+	return true
+}
+
+func (a *api) me(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, protocol.NewUser(user.FromContext(r.Context())))
+}
+
+func (a *api) listRooms(w http.ResponseWriter, r *http.Request) {
+	_, k := bot.FromContext(r.Context())
+
+	rooms := make([]apiRoom, 0, len(a.rooms))
+	for id, room := range a.rooms {
+		if k == nil || k.Scope.HasRoom(id) {
+			rooms = append(rooms, apiRoom{ID: id, NumUsers: room.NumUsers()})
+		}
+	}
+	slices.SortFunc(rooms, func(a, b apiRoom) int { return cmp.Compare(a.ID, b.ID) })
+
+	writeJSON(w, r, http.StatusOK, rooms)
+}
+
+func (a *api) getRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := a.room(w, r, bot.ActionRead)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, apiRoom{ID: chi.URLParam(r, "room"), NumUsers: room.NumUsers()})
+}
+
+func (a *api) listUsers(w http.ResponseWriter, r *http.Request) {
+	room, ok := a.room(w, r, bot.ActionRead)
+	if !ok {
+		return
+	}
+
+	users := make([]protocol.User, 0)
+	for _, u := range room.Users() {
+		users = append(users, protocol.NewUser(u))
+	}
+	slices.SortFunc(users, func(a, b protocol.User) int { return cmp.Compare(a.Name, b.Name) })
+
+	writeJSON(w, r, http.StatusOK, users)
+}
+
+// listMessages returns the latest messages of a room.
+// Older messages are paginated with the before cursor, which is the ID of a message.
+func (a *api) listMessages(w http.ResponseWriter, r *http.Request) {
+	room, ok := a.room(w, r, bot.ActionRead)
+	if !ok {
+		return
+	}
+
+	limit := defaultPageSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxPageSize {
+			apiError(w, r, errInvalidLimit)
+			return
+		}
+	}
+
+	messages := room.Messages()
+	if b := r.URL.Query().Get("before"); b != "" {
+		before, err := xid.FromString(b)
+		if err != nil {
+			apiError(w, r, errInvalidCursor)
+			return
+		}
+
+		// Messages are stored from the oldest to the newest.
+		i := slices.IndexFunc(messages, func(m *chat.Message) bool { return m.ID == before })
+		if i == -1 {
+			apiError(w, r, chat.ErrMessageNotFound)
+			return
+		}
+		messages = messages[:i]
+	}
+
+	var page apiMessages
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+		page.Before = messages[0].ID.String()
+	}
+	page.Messages = make([]protocol.MessageEvent, 0, len(messages))
+	for _, m := range messages {
+		page.Messages = append(page.Messages, protocol.NewMessageEvent(m))
+	}
+
+	writeJSON(w, r, http.StatusOK, page)
+}
+
+// postMessage posts a message to a room on behalf of the user.
+func (a *api) postMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	usr := user.FromContext(ctx)
+
+	room, ok := a.room(w, r, bot.ActionPost)
+	if !ok {
+		return
+	}
+
+	var body protocol.MessagePayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	// Commands reply to the connection running them, which API clients do not have.
+	if command.IsCommand(body.Content) {
+		apiError(w, r, errCommand)
+		return
+	}
+
+	if a.throttle(w, r) {
+		return
+	}
+
+	// Kicked users are kept off the room until they may join again, like their connections.
+	if room.IsKicked(usr.ID) {
+		apiError(w, r, chat.ErrKicked)
+		return
+	}
+
+	if room.IsMuted(usr.ID) {
+		apiError(w, r, chat.ErrMuted)
+		return
+	}
+
+	// The name of the user may have changed while connected to the room.
+	if u, found := room.User(usr.ID); found {
+		usr = u
+	}
+
+	msg, err := chat.NewMessage(usr, body.Content)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	broadcast(ctx, room, msg)
+
+	writeJSON(w, r, http.StatusCreated, protocol.NewMessageEvent(msg))
+}
+
+// editMessage replaces the content of a message of the user.
+func (a *api) editMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	usr := user.FromContext(ctx)
+
+	room, ok := a.room(w, r, bot.ActionPost)
+	if !ok {
+		return
+	}
+
+	var body protocol.MessagePayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	id, err := xid.FromString(chi.URLParam(r, "message"))
+	if err != nil {
+		apiError(w, r, chat.ErrMessageNotFound)
+		return
+	}
+
+	if a.throttle(w, r) {
+		return
+	}
+
+	if room.IsKicked(usr.ID) {
+		apiError(w, r, chat.ErrKicked)
+		return
+	}
+
+	if room.IsMuted(usr.ID) {
+		apiError(w, r, chat.ErrMuted)
+		return
+	}
+
+	msg, err := room.EditMessage(id, usr, body.Content)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	room.Broadcast(ctx, chat.MessageEditedEvent{Message: msg})
+
+	writeJSON(w, r, http.StatusOK, protocol.NewMessageEvent(msg))
+}
+
+// deleteMessage deletes a message of the user, or of another user for moderators.
+func (a *api) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	usr := user.FromContext(ctx)
+
+	room, ok := a.room(w, r, bot.ActionPost)
+	if !ok {
+		return
+	}
+
+	id, err := xid.FromString(chi.URLParam(r, "message"))
+	if err != nil {
+		apiError(w, r, chat.ErrMessageNotFound)
+		return
+	}
+
+	if a.throttle(w, r) {
+		return
+	}
+
+	msg, err := room.DeleteMessage(id, usr)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	room.Broadcast(ctx, chat.MessageDeletedEvent{Message: msg, By: usr, Time: time.Now().UTC()})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// react toggles a reaction of the user to a message.
+func (a *api) react(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	usr := user.FromContext(ctx)
+
+	room, ok := a.room(w, r, bot.ActionReact)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Emoji string `json:"emoji"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	id, err := xid.FromString(chi.URLParam(r, "message"))
+	if err != nil {
+		apiError(w, r, chat.ErrMessageNotFound)
+		return
+	}
+	msg, found := room.Message(id)
+	if !found {
+		apiError(w, r, chat.ErrMessageNotFound)
+		return
+	}
+
+	if a.throttle(w, r) {
+		return
+	}
+
+	if err := msg.ToggleReaction(usr.ID, body.Emoji); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	room.Broadcast(ctx, chat.ReactionEvent{Message: msg})
+
+	writeJSON(w, r, http.StatusOK, protocol.NewMessageEvent(msg))
+}
+
+// apiError writes an error body with a stable code.
+// Error codes are shared with the error frames of the websocket protocol.
+func apiError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		status int
+		body   = protocol.ErrorPayload{Message: err.Error()}
+	)
+	if i := slices.IndexFunc(apiErrors, func(e apiErrorCode) bool { return errors.Is(err, e.err) }); i != -1 {
+		status, body.Code = apiErrors[i].status, apiErrors[i].code
+	} else if reason := sessionReason(err); reason != nil {
+		status, body = http.StatusUnauthorized, protocol.ErrorPayload{Code: "unauthorized", Message: reason.Error()}
+	} else {
+		body.Code, body.Message = protocol.ErrorCode(err)
+		if body.Code == "internal" {
+			slog.ErrorContext(r.Context(), "unexpected api error", "err", err, "path", r.URL.Path)
+		}
+
+		var found bool
+		if status, found = apiStatuses[body.Code]; !found {
+			status = http.StatusBadRequest
+		}
+	}
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+	}
+
+	writeJSON(w, r, status, map[string]protocol.ErrorPayload{"error": body})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "encode api response", "err", err, "path", r.URL.Path)
+	}
+}
@@ -0,0 +1,195 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// kick has Carol, a moderator, kick a connected user out of the room.
+func kick(srv *chattest.Server, name string) {
+	carol := srv.LoginAccount("Carol", password).Connect()
+	carol.Command("/kick " + name)
+	carol.ExpectEvent(protocol.TypeModeration)
+	carol.Close()
+}
+
+func TestPostMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// kicked is whether Alice is kicked by Carol, a moderator, before posting.
+		kicked     bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "message", content: "hello", wantStatus: http.StatusCreated},
+		{name: "slash inside", content: "and/or", wantStatus: http.StatusCreated},
+		{name: "command", content: "/me waves", wantStatus: http.StatusBadRequest, wantCode: "command_not_supported"},
+		{name: "command after spaces", content: "  /nick Bob", wantStatus: http.StatusBadRequest, wantCode: "command_not_supported"},
+		{name: "unknown command", content: "/nope", wantStatus: http.StatusBadRequest, wantCode: "command_not_supported"},
+		{name: "empty", content: "  ", wantStatus: http.StatusBadRequest, wantCode: "message_empty"},
+		{name: "kicked", content: "hello", kicked: true, wantStatus: http.StatusForbidden, wantCode: "kicked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleModerator}},
+			})
+			c := srv.Login("Alice")
+			if tt.kicked {
+				c.Connect()
+				kick(srv, "Alice")
+			}
+
+			body, _ := json.Marshal(protocol.MessagePayload{Content: tt.content})
+			resp := c.Do(http.MethodPost, "/api/v1/rooms/general/messages", bytes.NewReader(body))
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantCode != "" {
+				var apiErr struct {
+					Error protocol.ErrorPayload `json:"error"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+					t.Fatalf("decode error: %v", err)
+				}
+				if apiErr.Error.Code != tt.wantCode {
+					t.Fatalf("got code %q, want %q", apiErr.Error.Code, tt.wantCode)
+				}
+			}
+
+			resp = c.Do(http.MethodGet, "/api/v1/rooms/general/messages", nil)
+			var page struct {
+				Messages []protocol.MessageEvent `json:"messages"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("decode messages: %v", err)
+			}
+			// System messages (e.g. about the kick) are not posted by Alice.
+			var n int
+			for _, m := range page.Messages {
+				if m.User != nil {
+					n++
+				}
+			}
+			posted := tt.wantStatus == http.StatusCreated
+			if (n == 1) != posted {
+				t.Fatalf("got %d messages, want posted %t", n, posted)
+			}
+		})
+	}
+}
+
+func TestEditAndDeleteMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		// by is the user changing the message of Alice, Carol being a moderator.
+		by      string
+		method  string
+		content string
+		unknown bool
+		// kicked is whether the user is kicked before changing the message.
+		kicked     bool
+		wantStatus int
+		wantEvent  protocol.Type
+	}{
+		{name: "author edits", by: "Alice", method: http.MethodPatch, content: "hello world", wantStatus: http.StatusOK, wantEvent: protocol.TypeMessageEdited},
+		{name: "other user edits", by: "Bob", method: http.MethodPatch, content: "hello world", wantStatus: http.StatusForbidden},
+		{name: "moderator edits", by: "Carol", method: http.MethodPatch, content: "hello world", wantStatus: http.StatusForbidden},
+		{name: "edit to nothing", by: "Alice", method: http.MethodPatch, content: " ", wantStatus: http.StatusBadRequest},
+		{name: "author deletes", by: "Alice", method: http.MethodDelete, wantStatus: http.StatusNoContent, wantEvent: protocol.TypeMessageDeleted},
+		{name: "moderator deletes", by: "Carol", method: http.MethodDelete, wantStatus: http.StatusNoContent, wantEvent: protocol.TypeMessageDeleted},
+		{name: "other user deletes", by: "Bob", method: http.MethodDelete, wantStatus: http.StatusForbidden},
+		{name: "unknown message", by: "Alice", method: http.MethodDelete, unknown: true, wantStatus: http.StatusNotFound},
+		{name: "kicked author edits", by: "Alice", method: http.MethodPatch, content: "hello world", kicked: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleModerator}},
+			})
+			users := map[string]*chattest.Client{
+				"Alice": srv.Login("Alice").Connect(),
+				"Bob":   srv.Login("Bob").Connect(),
+				"Carol": srv.LoginAccount("Carol", password),
+			}
+			alice, bob := users["Alice"], users["Bob"]
+
+			alice.Send("hello")
+			id := bob.ExpectMessage("hello").ID
+			if tt.unknown {
+				id = xid.New().String()
+			}
+			if tt.kicked {
+				kick(srv, tt.by)
+			}
+
+			var body io.Reader
+			if tt.method == http.MethodPatch {
+				data, _ := json.Marshal(protocol.MessagePayload{Content: tt.content})
+				body = bytes.NewReader(data)
+			}
+			resp := users[tt.by].Do(tt.method, "/api/v1/rooms/general/messages/"+id, body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantEvent == "" {
+				// The message is unchanged: the next frame about a message is the next message.
+				bob.Send("next")
+				bob.Expect("the next message", func(frame []byte) bool {
+					var env protocol.Envelope
+					if json.Unmarshal(frame, &env) != nil {
+						return false
+					}
+					if env.Type == protocol.TypeMessageEdited || env.Type == protocol.TypeMessageDeleted {
+						t.Fatalf("got %s frame", frame)
+					}
+					return env.Type == protocol.TypeMessage
+				})
+				return
+			}
+
+			env := bob.ExpectEvent(tt.wantEvent)
+			var got struct {
+				ID        string `json:"id"`
+				MessageID string `json:"message_id"`
+				Content   string `json:"content"`
+			}
+			if err := json.Unmarshal(env.Payload, &got); err != nil {
+				t.Fatalf("decode %s payload: %v", env.Type, err)
+			}
+			if got.ID+got.MessageID != id || got.Content != tt.content {
+				t.Fatalf("got %s payload %s", env.Type, env.Payload)
+			}
+
+			resp = alice.Do(http.MethodGet, "/api/v1/rooms/general/messages", nil)
+			var page struct {
+				Messages []protocol.MessageEvent `json:"messages"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("decode messages: %v", err)
+			}
+			if tt.wantEvent == protocol.TypeMessageDeleted {
+				if len(page.Messages) != 0 {
+					t.Fatalf("got %d messages after the deletion", len(page.Messages))
+				}
+				return
+			}
+			if len(page.Messages) != 1 || page.Messages[0].Content != tt.content || page.Messages[0].Edited == nil {
+				t.Fatalf("got messages %+v after the edit", page.Messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/keyset"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// List of session cookie names, before prefixing.
+const (
+	accessCookieName  = "jwt"
+	refreshCookieName = "refresh"
+)
+
+// auth issues and verifies the session tokens of the users.
+type auth struct {
+	keys     *keyset.KeySet
+	sessions *session.Manager
+	cookies  *cookieConfig
+}
+
+// verifier looks for a jwt token in the Authorization header then in the access token cookie,
+// verifies it against the keyset and stores the result for jwtauth.FromContext.
+func (a *auth) verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token jwt.Token
+		err := jwtauth.ErrNoTokenFound
+
+		raw := jwtauth.TokenFromHeader(r)
+		if raw == "" {
+			raw, _ = a.cookies.value(r, accessCookieName)
+		}
+		if raw != "" {
+			token, err = a.keys.Decode(raw)
+		}
+
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, err)))
+	})
+}
+
+// authenticate decodes the session claims of a request verified by the verifier middleware
+// and checks that the session has not been revoked.
+func (a *auth) authenticate(r *http.Request) (*session.Claims, error) {
+	token, _, err := jwtauth.FromContext(r.Context())
+	if errors.Is(err, jwtauth.ErrNoTokenFound) {
+		return nil, session.ErrMissing
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", session.ErrMalformed, err)
+	}
+
+	return a.decodeClaims(token)
+}
+
+// reauthenticate verifies an access token sent over the websocket of a session.
+func (a *auth) reauthenticate(current *session.Claims, raw string) (*session.Claims, error) {
+	token, err := a.keys.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", session.ErrMalformed, err)
+	}
+
+	claims, err := a.decodeClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ID != current.ID || claims.User.ID != current.User.ID {
+		return nil, fmt.Errorf("%w: token of another session", session.ErrMalformed)
+	}
+
+	return claims, nil
+}
+
+// decodeClaims decodes the session claims of a verified token
+// and checks that the session has not been revoked.
+func (a *auth) decodeClaims(token jwt.Token) (*session.Claims, error) {
+	claims, err := session.Decode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.sessions.IsRevoked(claims.ID) {
+		return nil, session.ErrRevoked
+	}
+
+	return claims, nil
+}
+
+// startSession starts a new session for the user on the device of the request
+// and stores its access and refresh tokens in cookies.
+func (a *auth) startSession(w http.ResponseWriter, r *http.Request, u *user.User) error {
+	s, refresh, err := a.sessions.Start(u, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return fmt.Errorf("start session: %w", err)
+	}
+
+	if _, _, err := a.issueToken(w, session.NewClaims(s.ID, u)); err != nil {
+		return err
+	}
+	a.cookies.set(w, refreshCookieName, refresh, time.Now().Add(a.sessions.RefreshTTL()))
+
+	return nil
+}
+
+// issueToken encodes the claims of a session in a jwt token and stores it in a cookie.
+func (a *auth) issueToken(w http.ResponseWriter, claims *session.Claims) (jwt.Token, string, error) {
+	token, t, err := a.encode(claims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cookie := a.cookies.cookie(accessCookieName, t, token.Expiration())
+	cookie.HttpOnly = a.cookies.httpOnly
+	http.SetCookie(w, cookie)
+
+	return token, t, nil
+}
+
+// encode encodes the claims of a session in a jwt token.
+func (a *auth) encode(claims *session.Claims) (jwt.Token, string, error) {
+	token, t, err := a.keys.Encode(claims.Map())
+	if err != nil {
+		return nil, "", fmt.Errorf("encode token: %w", err)
+	}
+
+	return token, t, nil
+}
+
+// renew exchanges the refresh token of the request for a new access token and a new refresh token.
+func (a *auth) renew(w http.ResponseWriter, r *http.Request, room *chat.Room) (*session.Claims, string, error) {
+	refresh, found := a.cookies.value(r, refreshCookieName)
+	if !found {
+		return nil, "", session.ErrMissing
+	}
+
+	s, refresh, err := a.sessions.Refresh(refresh, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The user may have changed (e.g. renamed) while connected to the room.
+	u, found := room.User(s.User.ID)
+	if !found {
+		u = s.User
+	}
+
+	claims := session.NewClaims(s.ID, u)
+	token, t, err := a.issueToken(w, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	claims.IssuedAt = token.IssuedAt()
+	claims.ExpiresAt = token.Expiration()
+	a.cookies.set(w, refreshCookieName, refresh, time.Now().Add(a.sessions.RefreshTTL()))
+
+	return claims, t, nil
+}
+
+// clearCookies removes the session cookies.
+func (a *auth) clearCookies(w http.ResponseWriter) {
+	a.cookies.clear(w, accessCookieName)
+	a.cookies.clear(w, refreshCookieName)
+}
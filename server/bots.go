@@ -0,0 +1,221 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// apiRateLimit is the rate limiting policy of a bot as seen by API clients.
+type apiRateLimit struct {
+	Burst int64 `json:"burst"`
+	// Interval is a Go duration (e.g. "1m").
+	Interval string `json:"interval"`
+}
+
+// apiKey is an API key of a bot as seen by API clients. The secret is never shown again.
+type apiKey struct {
+	ID         string       `json:"id"`
+	Rooms      []string     `json:"rooms"`
+	Actions    []bot.Action `json:"actions"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time   `json:"revoked_at,omitempty"`
+}
+
+// apiBot is a bot as seen by API clients.
+type apiBot struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	CreatedBy string        `json:"created_by"`
+	CreatedAt time.Time     `json:"created_at"`
+	RateLimit *apiRateLimit `json:"rate_limit,omitempty"`
+	Keys      []apiKey      `json:"keys"`
+}
+
+func (a *api) listBots(w http.ResponseWriter, r *http.Request) {
+	bots, err := a.bots.List(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	res := make([]apiBot, 0, len(bots))
+	for _, b := range bots {
+		res = append(res, newAPIBot(b))
+	}
+	slices.SortFunc(res, func(a, b apiBot) int { return cmp.Compare(a.Name, b.Name) })
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// createBot creates a bot without any key. Bots without a rate limit are not rate limited.
+func (a *api) createBot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Name      string        `json:"name"`
+		RateLimit *apiRateLimit `json:"rate_limit"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	var limit *bot.RateLimit
+	if body.RateLimit != nil {
+		interval, err := time.ParseDuration(body.RateLimit.Interval)
+		if err != nil {
+			apiError(w, r, bot.ErrInvalidLimit)
+			return
+		}
+		limit = &bot.RateLimit{Burst: body.RateLimit.Burst, Interval: interval}
+	}
+
+	b, err := bot.New(body.Name, user.FromContext(ctx).ID, limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	// Bots post alongside the users of the rooms so they cannot impersonate them.
+	for _, room := range a.rooms {
+		if room.IsNameTaken(b.Name, xid.NilID()) {
+			apiError(w, r, chat.ErrNameTaken)
+			return
+		}
+	}
+
+	if err := a.bots.Create(ctx, b); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, newAPIBot(b))
+}
+
+// createKey adds an API key to a bot. The response holds the only copy of the key.
+func (a *api) createKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Rooms   []string     `json:"rooms"`
+		Actions []bot.Action `json:"actions"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	for _, room := range body.Rooms {
+		if _, found := a.rooms[room]; !found && room != bot.AllRooms {
+			apiError(w, r, errRoomNotFound)
+			return
+		}
+	}
+
+	b, err := a.getBot(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	k, token, err := b.NewKey(bot.Scope{Rooms: body.Rooms, Actions: body.Actions})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := a.bots.Update(ctx, b); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, map[string]any{
+		"key":   newAPIKey(k),
+		"token": token,
+	})
+}
+
+func (a *api) revokeKey(w http.ResponseWriter, r *http.Request) {
+	b, err := a.getBot(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	id, err := xid.FromString(chi.URLParam(r, "key"))
+	if err != nil {
+		apiError(w, r, bot.ErrKeyNotFound)
+		return
+	}
+
+	if err := b.RevokeKey(id); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := a.bots.Update(r.Context(), b); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBot finds the bot of the request.
+func (a *api) getBot(r *http.Request) (*bot.Bot, error) {
+	id, err := xid.FromString(chi.URLParam(r, "bot"))
+	if err != nil {
+		return nil, bot.ErrNotFound
+	}
+
+	return a.bots.Get(r.Context(), id)
+}
+
+func newAPIBot(b *bot.Bot) apiBot {
+	res := apiBot{
+		ID:        b.ID.String(),
+		Name:      b.Name,
+		CreatedBy: b.CreatedBy.String(),
+		CreatedAt: b.CreatedAt,
+		Keys:      make([]apiKey, 0, len(b.Keys)),
+	}
+	if b.RateLimit != nil {
+		res.RateLimit = &apiRateLimit{Burst: b.RateLimit.Burst, Interval: b.RateLimit.Interval.String()}
+	}
+	for _, k := range b.Keys {
+		res.Keys = append(res.Keys, newAPIKey(k))
+	}
+
+	return res
+}
+
+func newAPIKey(k *bot.Key) apiKey {
+	res := apiKey{
+		ID:        k.ID.String(),
+		Rooms:     k.Scope.Rooms,
+		Actions:   k.Scope.Actions,
+		CreatedAt: k.CreatedAt,
+	}
+	if !k.LastUsedAt.IsZero() {
+		res.LastUsedAt = &k.LastUsedAt
+	}
+	if k.IsRevoked() {
+		res.RevokedAt = &k.RevokedAt
+	}
+
+	return res
+}
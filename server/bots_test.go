@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// apiCall calls the API with a bearer token and returns the status and error code of the response.
+func apiCall(t *testing.T, srv *chattest.Server, token, method, path, body string, out any) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error protocol.ErrorPayload `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return resp.StatusCode, apiErr.Error.Code
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+
+	return resp.StatusCode, ""
+}
+
+func TestBots(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleAdmin}},
+	})
+	admin := srv.LoginAccount("Carol", password).Token()
+	member := srv.Login("Alice").Connect()
+
+	var relay struct {
+		ID string `json:"id"`
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodPost, "/api/v1/bots", `{"name":"Relay"}`, &relay); status != http.StatusCreated {
+		t.Fatalf("create bot: got status %d (%s)", status, code)
+	}
+	keys := "/api/v1/bots/" + relay.ID + "/keys"
+
+	tests := []struct {
+		name string
+		// token is the admin token when empty, "member" for the token of Alice.
+		token      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "member lists bots", token: "member", method: http.MethodGet, path: "/api/v1/bots", wantStatus: http.StatusForbidden, wantCode: "forbidden"},
+		{name: "look-alike bot", method: http.MethodPost, path: "/api/v1/bots", body: `{"name":"re1ay"}`, wantStatus: http.StatusConflict, wantCode: "bot_exists"},
+		{name: "name of a connected user", method: http.MethodPost, path: "/api/v1/bots", body: `{"name":"ALICE"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_request"},
+		{name: "reserved name", method: http.MethodPost, path: "/api/v1/bots", body: `{"name":"Admin"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_name"},
+		{name: "invalid rate limit", method: http.MethodPost, path: "/api/v1/bots", body: `{"name":"Echo","rate_limit":{"burst":1,"interval":"soon"}}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_rate_limit"},
+		{name: "unknown field", method: http.MethodPost, path: "/api/v1/bots", body: `{"name":"Echo","admin":true}`, wantStatus: http.StatusBadRequest},
+		{name: "key without action", method: http.MethodPost, path: keys, body: `{"rooms":["general"]}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_scope"},
+		{name: "key with unknown action", method: http.MethodPost, path: keys, body: `{"rooms":["general"],"actions":["delete"]}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_scope"},
+		{name: "key for unknown room", method: http.MethodPost, path: keys, body: `{"rooms":["nope"],"actions":["read"]}`, wantStatus: http.StatusNotFound, wantCode: "room_not_found"},
+		{name: "key of unknown bot", method: http.MethodPost, path: "/api/v1/bots/nope/keys", body: `{"rooms":["general"],"actions":["read"]}`, wantStatus: http.StatusNotFound, wantCode: "bot_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := admin
+			if tt.token == "member" {
+				token = member.Token()
+			}
+
+			status, code := apiCall(t, srv, token, tt.method, tt.path, tt.body, nil)
+			if status != tt.wantStatus || (tt.wantCode != "" && code != tt.wantCode) {
+				t.Fatalf("got status %d (%s), want %d (%s)", status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+
+	// A read-only key can read the room but neither post nor manage bots.
+	var created struct {
+		Key struct {
+			ID string `json:"id"`
+		} `json:"key"`
+		Token string `json:"token"`
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodPost, keys, `{"rooms":["general"],"actions":["read"]}`, &created); status != http.StatusCreated {
+		t.Fatalf("create key: got status %d (%s)", status, code)
+	}
+	key := created.Token
+
+	var me protocol.User
+	if status, _ := apiCall(t, srv, key, http.MethodGet, "/api/v1/me", "", &me); status != http.StatusOK || me.Name != "Relay" {
+		t.Fatalf("me: got status %d and user %+v", status, me)
+	}
+	for _, call := range []struct {
+		method, path, body string
+		wantStatus         int
+	}{
+		{http.MethodGet, "/api/v1/rooms/general/messages", "", http.StatusOK},
+		{http.MethodPost, "/api/v1/rooms/general/messages", `{"content":"hello"}`, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/bots", "", http.StatusForbidden},
+	} {
+		if status, code := apiCall(t, srv, key, call.method, call.path, call.body, nil); status != call.wantStatus {
+			t.Fatalf("%s %s: got status %d (%s), want %d", call.method, call.path, status, code, call.wantStatus)
+		}
+	}
+
+	// Revoked keys are rejected right away, and only revoked once.
+	revoke := keys + "/" + created.Key.ID
+	if status, code := apiCall(t, srv, admin, http.MethodDelete, revoke, "", nil); status != http.StatusNoContent {
+		t.Fatalf("revoke: got status %d (%s)", status, code)
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodDelete, revoke, "", nil); status != http.StatusConflict || code != "key_revoked" {
+		t.Fatalf("revoke twice: got status %d (%s)", status, code)
+	}
+	if status, _ := apiCall(t, srv, key, http.MethodGet, "/api/v1/me", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("revoked key: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, code := apiCall(t, srv, key+"x", http.MethodGet, "/api/v1/me", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("forged key: got status %d (%s), want %d", status, code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+)
+
+// browser is a client keeping cookies and submitting forms along with their csrf token.
+// It does not follow redirects so that they can be asserted on.
+type browser struct {
+	t   *testing.T
+	srv *chattest.Server
+	hc  *http.Client
+}
+
+func newBrowser(t *testing.T, srv *chattest.Server) *browser {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("create cookie jar: %v", err)
+	}
+
+	hc := *srv.Client()
+	hc.Jar = jar
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	return &browser{t: t, srv: srv, hc: &hc}
+}
+
+// get navigates to a URL, relative to the server when it is a path.
+func (b *browser) get(rawURL string) *http.Response {
+	b.t.Helper()
+
+	if strings.HasPrefix(rawURL, "/") {
+		rawURL = b.srv.URL + rawURL
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		b.t.Fatalf("create request: %v", err)
+	}
+	req.Header.Set("Accept", "text/html")
+
+	return b.do(req)
+}
+
+// post submits a form, fetching a csrf token first if the browser has none.
+func (b *browser) post(path string, values url.Values) *http.Response {
+	b.t.Helper()
+
+	token := b.cookie("csrf")
+	if token == "" {
+		b.get("/login")
+		token = b.cookie("csrf")
+	}
+
+	form := url.Values{"csrf_token": {token}}
+	for k, v := range values {
+		form[k] = v
+	}
+
+	req, err := http.NewRequest(http.MethodPost, b.srv.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatalf("create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "text/html")
+
+	return b.do(req)
+}
+
+func (b *browser) do(req *http.Request) *http.Response {
+	b.t.Helper()
+
+	resp, err := b.hc.Do(req)
+	if err != nil {
+		b.t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	b.t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// cookie returns the value of a cookie of the server, empty if the browser has none.
+func (b *browser) cookie(name string) string {
+	u, _ := url.Parse(b.srv.URL)
+	for _, c := range b.hc.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+// body reads the body of a response.
+func body(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	return string(data)
+}
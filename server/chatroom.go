@@ -0,0 +1,607 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/metrics"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/templates"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/websocket"
+)
+
+// typingInterval is the minimum interval between two typing notifications of a user.
+const typingInterval = 2 * time.Second
+
+// keyCheckInterval is how often the connections of bots check that their API key is still valid.
+const keyCheckInterval = time.Minute
+
+// maxFrameSize is the maximum size of the frames sent by clients.
+const maxFrameSize = 2 << 10 // 2KB
+
+// errHandled reports that a handler already informed the client about the error.
+var errHandled = errors.New("handled")
+
+// negotiate rejects websocket upgrades requesting an unsupported protocol version or subprotocol.
+func negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := protocol.Negotiate(r.URL.Query().Get("v")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := protocol.NegotiateSubprotocol(subprotocols(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowBots lets bots connect with an API key allowed to read the room.
+// Other requests go through the protection of sessions.
+func allowBots(bots bot.Store, room string, protected func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		sessions := protected(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := jwtauth.TokenFromHeader(r)
+			if !bot.IsToken(token) {
+				sessions.ServeHTTP(w, r)
+				return
+			}
+
+			b, k, err := bot.Authenticate(r.Context(), bots, token)
+			if err != nil {
+				slog.InfoContext(r.Context(), "unauthenticated bot", "err", err, "path", r.URL.Path)
+				unauthorized(w, r, err)
+				return
+			}
+
+			if !k.Scope.Allows(room, bot.ActionRead) {
+				http.Error(w, errOutOfScope.Error(), http.StatusForbidden)
+				return
+			}
+
+			ctx := bot.AddToContext(r.Context(), b, k)
+			ctx = user.AddToContext(ctx, b.User())
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// subprotocols returns the subprotocols requested by a client, by order of preference.
+// Event streams cannot set headers so they request them in the query (e.g. ?protocol=chat-demo.json).
+func subprotocols(r *http.Request) []string {
+	protocols := r.URL.Query()["protocol"]
+	for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+
+	return protocols
+}
+
+func chatroom(room *chat.Room, a *auth, bots bot.Store, lims *limiters, cmds *command.Registry) func(ws *websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = maxFrameSize
+		defer ws.Close()
+
+		ctx := ws.Request().Context()
+
+		// The transport of the client picks how events are encoded.
+		var enc chat.Encoder = htmlEncoder{metrics: metrics.FromContext(ctx)}
+		if slices.Equal(ws.Config().Protocol, []string{protocol.SubprotocolJSON}) {
+			enc = protocol.JSONEncoder{}
+		}
+
+		c := newConn(ctx, chat.NewConn(ctx, user.FromContext(ctx), ws), room, a, enc, cmds)
+		leave, err := c.join(ctx, bots, lims)
+		if err != nil {
+			return
+		}
+		defer leave()
+
+		// Receiving and processing client requests.
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+					break
+				}
+
+				c.logger.ErrorContext(ctx, "receive message", "err", err)
+
+				// Inform user something went wrong.
+				if err := c.send(ctx, chat.ErrorEvent{Err: chat.ErrUnknown}); err != nil {
+					c.logger.ErrorContext(ctx, "send error", "err", err)
+					break
+				}
+
+				continue
+			}
+
+			if err := c.dispatch(ctx, data); err != nil {
+				c.logger.ErrorContext(ctx, "reply error", "err", err)
+				break
+			}
+		}
+	}
+}
+
+// conn is the connection of a user to the chatroom.
+type conn struct {
+	// w is the transport of the client (e.g. a websocket or an event stream).
+	w      chat.Conn
+	room   *chat.Room
+	auth   *auth
+	enc    chat.Encoder
+	claims *session.Claims
+	// key is the API key of bots, nil for users.
+	key    *bot.Key
+	env    *command.Env
+	lim    *limiter
+	logger *slog.Logger
+
+	frames *protocol.Registry
+	// muDispatch processes the frames one at a time, even when they are sent
+	// with concurrent requests (e.g. over an event stream).
+	muDispatch sync.Mutex
+	lastTyping time.Time
+
+	// renewed receives the new expiry of the session on re-authentication.
+	renewed chan time.Time
+	// stopped is closed once the connection stops watching the expiry of the session.
+	stopped chan struct{}
+}
+
+// newConn creates the connection of a client, authenticated by the session or the API key of the context.
+func newConn(ctx context.Context, w chat.Conn, room *chat.Room, a *auth, enc chat.Encoder, cmds *command.Registry) *conn {
+	usr := w.User()
+
+	c := &conn{
+		w:      w,
+		room:   room,
+		auth:   a,
+		enc:    enc,
+		claims: session.FromContext(ctx),
+		logger: slog.Default().With("user.id", usr.ID),
+		env: &command.Env{
+			User:      usr,
+			Room:      room,
+			Registry:  cmds,
+			Responder: &responder{w: w, room: room, enc: enc},
+		},
+		renewed: make(chan time.Time),
+		stopped: make(chan struct{}),
+	}
+
+	c.frames = protocol.NewRegistry()
+	c.frames.Handle(protocol.TypeMessage, c.message)
+	c.frames.Handle(protocol.TypeCommand, c.command)
+	c.frames.Handle(protocol.TypeTyping, c.typing)
+	c.frames.Handle(protocol.TypeReaction, c.reaction)
+	c.frames.Handle(protocol.TypeRead, c.read)
+	c.frames.Handle(protocol.TypeReauth, c.reauth)
+
+	return c
+}
+
+// join adds the client to the room and informs the users about its arrival.
+// The returned function removes the client from the room once it disconnects.
+func (c *conn) join(ctx context.Context, bots bot.Store, lims *limiters) (func(), error) {
+	usr := c.env.User
+	if err := c.room.AddClient(c.w, c.enc); err != nil {
+		// Inform the current user about the error.
+		if err := c.send(ctx, chat.ErrorEvent{Err: err}); err != nil {
+			c.logger.ErrorContext(ctx, "send error", "err", err)
+		}
+
+		return nil, err
+	}
+	if b, k := bot.FromContext(ctx); b != nil {
+		// Bots share their rate limit with the API, if any.
+		c.key = k
+		if b.RateLimit != nil {
+			c.lim = lims.get("bot:"+b.ID.String(), b.RateLimit.Interval, b.RateLimit.Burst)
+		}
+	} else {
+		c.lim = lims.add(usr, 5*time.Second, 3)
+	}
+
+	// The token is only checked on connection so close it when the session
+	// is revoked or expires, unless the client re-authenticates in time.
+	// Bots have no session, their API key is checked regularly instead.
+	done := make(chan struct{})
+	var (
+		revoked <-chan struct{}
+		unwatch = func() {}
+	)
+	if c.key != nil {
+		revoked = c.watchKey(ctx, bots, done)
+	} else {
+		revoked, unwatch = c.auth.sessions.Watch(c.claims.ID)
+	}
+	go c.expire(ctx, revoked, done)
+
+	// Remove client from room when user disconnects.
+	leave := func() {
+		unwatch()
+		close(done)
+
+		c.room.RemoveClient(usr.ID)
+		lims.remove(usr)
+
+		// Inform all users about the departure.
+		c.room.Broadcast(ctx, chat.LeaveEvent{User: usr, Time: time.Now().UTC()})
+		c.room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: c.room.NumUsers()})
+	}
+
+	// Inform all users about the arrival.
+	c.room.Broadcast(ctx, chat.JoinEvent{User: usr, Time: time.Now().UTC()}, usr.ID)
+	c.room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: c.room.NumUsers()})
+
+	// Unlock global lock.
+	if err := c.send(ctx, chat.ReadyEvent{User: usr}); err != nil {
+		c.logger.ErrorContext(ctx, "send ready", "err", err)
+		leave()
+		return nil, err
+	}
+
+	return leave, nil
+}
+
+// dispatch processes a frame sent by the client. Failed requests are replied to the client,
+// only failing to reply is returned.
+func (c *conn) dispatch(ctx context.Context, data []byte) error {
+	c.muDispatch.Lock()
+	defer c.muDispatch.Unlock()
+
+	env, err := protocol.Decode(data)
+	if err == nil {
+		err = c.frames.Dispatch(ctx, env)
+	}
+	if err != nil && !errors.Is(err, errHandled) {
+		return c.replyError(ctx, env, err)
+	}
+
+	return nil
+}
+
+// expire closes the connection when the session is revoked or expires.
+// The API keys of bots never expire.
+func (c *conn) expire(ctx context.Context, revoked <-chan struct{}, done <-chan struct{}) {
+	defer close(c.stopped)
+
+	var expiry <-chan time.Time
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	if c.claims != nil {
+		// Claims without expiry are rejected on decoding, never expire otherwise.
+		if !c.claims.ExpiresAt.IsZero() {
+			timer.Reset(time.Until(c.claims.ExpiresAt))
+		}
+		expiry = timer.C
+	}
+
+	for {
+		var cErr chat.Error
+		select {
+		case <-done:
+			return
+		case exp := <-c.renewed:
+			if !exp.IsZero() {
+				timer.Reset(time.Until(exp))
+			}
+			continue
+		case <-revoked:
+			cErr = chat.ErrSessionRevoked
+		case <-expiry:
+			cErr = chat.ErrSessionExpired
+		}
+
+		if err := c.send(ctx, chat.ErrorEvent{Err: cErr}); err != nil {
+			c.logger.ErrorContext(ctx, "send error", "err", err)
+		}
+		c.w.Close()
+		return
+	}
+}
+
+// watchKey returns a channel closed once the API key of the bot is revoked.
+func (c *conn) watchKey(ctx context.Context, bots bot.Store, done <-chan struct{}) <-chan struct{} {
+	revoked := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(keyCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			b, err := bots.FindByKey(ctx, c.key.ID)
+			if err != nil && !errors.Is(err, bot.ErrNotFound) {
+				c.logger.ErrorContext(ctx, "check api key", "err", err)
+				continue
+			}
+
+			if err != nil {
+				close(revoked)
+				return
+			}
+			if k, found := b.Key(c.key.ID); !found || k.IsRevoked() {
+				close(revoked)
+				return
+			}
+		}
+	}()
+
+	return revoked
+}
+
+// allow checks that the API key of bots allows an action in the room.
+func (c *conn) allow(a bot.Action) error {
+	if c.key != nil && !c.key.Scope.Allows(defaultRoom, a) {
+		return chat.ErrNotAllowed
+	}
+
+	return nil
+}
+
+// send sends an event to the client only.
+func (c *conn) send(ctx context.Context, e chat.Event) error {
+	return c.enc.Encode(ctx, c.w, c.env.User, e)
+}
+
+func (c *conn) message(ctx context.Context, env *protocol.Envelope) error {
+	var p protocol.MessagePayload
+	if err := env.DecodePayload(&p); err != nil {
+		return err
+	}
+
+	if err := c.allow(bot.ActionPost); err != nil {
+		return err
+	}
+
+	if err := c.throttle(ctx, env); err != nil {
+		return err
+	}
+
+	if c.room.IsMuted(c.env.User.ID) {
+		return chat.ErrMuted
+	}
+
+	// Create and add the message to the room.
+	// Could fail with a validation error.
+	msg, err := chat.NewMessage(c.env.User, p.Content)
+	if err != nil {
+		return err
+	}
+
+	// Broadcast personalized message to all clients including the current user.
+	broadcast(ctx, c.room, msg)
+
+	return c.resetForm(ctx, env)
+}
+
+func (c *conn) command(ctx context.Context, env *protocol.Envelope) error {
+	var p protocol.CommandPayload
+	if err := env.DecodePayload(&p); err != nil {
+		return err
+	}
+
+	if err := c.allow(bot.ActionPost); err != nil {
+		return err
+	}
+
+	if err := c.throttle(ctx, env); err != nil {
+		return err
+	}
+
+	if err := c.env.Registry.Execute(ctx, c.env, p.Input); err != nil {
+		return err
+	}
+
+	return c.resetForm(ctx, env)
+}
+
+// typing notifies the other users that the user is typing.
+func (c *conn) typing(ctx context.Context, env *protocol.Envelope) error {
+	if err := env.DecodePayload(&struct{}{}); err != nil {
+		return err
+	}
+
+	// Silently drop notifications sent too often.
+	if time.Since(c.lastTyping) < typingInterval {
+		return nil
+	}
+	c.lastTyping = time.Now()
+
+	c.room.Broadcast(ctx, chat.TypingEvent{User: c.env.User}, c.env.User.ID)
+
+	return nil
+}
+
+// reaction toggles a reaction of the user to a message.
+func (c *conn) reaction(ctx context.Context, env *protocol.Envelope) error {
+	var p protocol.ReactionPayload
+	if err := env.DecodePayload(&p); err != nil {
+		return err
+	}
+
+	if err := c.allow(bot.ActionReact); err != nil {
+		return err
+	}
+
+	if err := c.throttle(ctx, env); err != nil {
+		return err
+	}
+
+	msg, err := c.findMessage(p.MessageID)
+	if err != nil {
+		return err
+	}
+
+	if err := msg.ToggleReaction(c.env.User.ID, p.Emoji); err != nil {
+		return err
+	}
+
+	c.room.Broadcast(ctx, chat.ReactionEvent{Message: msg})
+
+	return nil
+}
+
+// read records a read receipt and informs the author of the message.
+func (c *conn) read(ctx context.Context, env *protocol.Envelope) error {
+	var p protocol.ReadPayload
+	if err := env.DecodePayload(&p); err != nil {
+		return err
+	}
+
+	msg, err := c.findMessage(p.MessageID)
+	if err != nil {
+		return err
+	}
+
+	if !msg.MarkRead(c.env.User.ID) || msg.User == nil {
+		return nil
+	}
+
+	// The author may have left the room.
+	_ = c.room.Send(ctx, msg.User.ID, chat.ReadEvent{Message: msg})
+
+	return nil
+}
+
+// reauth extends the connection with a renewed access token of the session.
+func (c *conn) reauth(ctx context.Context, env *protocol.Envelope) error {
+	var p protocol.ReauthPayload
+	if err := env.DecodePayload(&p); err != nil {
+		return err
+	}
+
+	// API keys of bots never expire.
+	if c.key != nil {
+		return chat.ErrNotAllowed
+	}
+
+	claims, err := c.auth.reauthenticate(c.claims, p.Token)
+	if err != nil {
+		c.logger.WarnContext(ctx, "reauthenticate websocket", "err", err)
+		if err := c.send(ctx, chat.ErrorEvent{Err: chat.ErrSessionExpired}); err != nil {
+			c.logger.ErrorContext(ctx, "send error", "err", err)
+		}
+		c.w.Close()
+
+		return errHandled
+	}
+
+	select {
+	case c.renewed <- claims.ExpiresAt:
+	case <-c.stopped:
+	}
+
+	return nil
+}
+
+// throttle rate limits the requests of the user to prevent abuse.
+// Bots without a rate limit are not throttled.
+func (c *conn) throttle(ctx context.Context, env *protocol.Envelope) error {
+	if c.lim == nil {
+		return nil
+	}
+
+	wait, err := c.lim.Limit(ctx)
+	if !errors.Is(err, mlimiters.ErrLimitExhausted) {
+		return nil
+	}
+
+	if !env.IsForm() {
+		return chat.ErrRateLimited
+	}
+
+	// Inform the current user to slow down and
+	// disable the form until limiter allows.
+	if err := render(ctx, c.w, "ChatForm", templates.ChatForm(&chat.ErrRateLimited)); err != nil {
+		return err
+	}
+
+	// Wait until user is no more rate-limited
+	<-time.After(wait)
+
+	// Re-enable the form.
+	// Clear the error for the current user.
+	if err := render(ctx, c.w, "ChatForm", templates.ChatForm(nil)); err != nil {
+		return err
+	}
+
+	return errHandled
+}
+
+// resetForm resets the form and clears the error of htmx clients.
+func (c *conn) resetForm(ctx context.Context, env *protocol.Envelope) error {
+	if !env.IsForm() {
+		return nil
+	}
+
+	if err := render(ctx, c.w, "ChatForm", templates.ChatForm(nil)); err != nil {
+		return err
+	}
+
+	return render(ctx, c.w, "ChatGlobalError", templates.ChatGlobalError(nil))
+}
+
+func (c *conn) findMessage(rawID string) (*chat.Message, error) {
+	id, err := xid.FromString(rawID)
+	if err != nil {
+		return nil, chat.ErrMessageNotFound
+	}
+
+	msg, found := c.room.Message(id)
+	if !found {
+		return nil, chat.ErrMessageNotFound
+	}
+
+	return msg, nil
+}
+
+// replyError informs the client about an error: htmx clients get the error rendered
+// while other clients get an error frame referencing their request.
+func (c *conn) replyError(ctx context.Context, env *protocol.Envelope, err error) error {
+	if env != nil && env.IsForm() {
+		return c.send(ctx, chat.ErrorEvent{Err: err})
+	}
+
+	e := chat.ErrorEvent{Err: err}
+	if env != nil {
+		e.RequestID = env.ID
+	}
+
+	if code, _ := protocol.ErrorCode(err); code == "internal" {
+		c.logger.ErrorContext(ctx, "unexpected error", "err", err)
+	}
+
+	return protocol.JSONEncoder{}.Encode(ctx, c.w, c.env.User, e)
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+func TestFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		// send sends a frame from Bob about the message posted by Alice and returns its ID.
+		send func(bob *chattest.Client, messageID string) string
+		// wantError is the code of the error replied to Bob, if any.
+		wantError string
+		// expect asserts on the frames received by Alice and Bob when the frame is valid.
+		expect func(t *testing.T, alice, bob *chattest.Client, messageID string)
+	}{
+		{
+			name: "reaction",
+			send: func(bob *chattest.Client, messageID string) string {
+				return bob.SendFrame(protocol.TypeReaction, protocol.ReactionPayload{MessageID: messageID, Emoji: "👍"})
+			},
+			expect: func(t *testing.T, alice, bob *chattest.Client, messageID string) {
+				for _, c := range []*chattest.Client{alice, bob} {
+					var e protocol.ReactionEvent
+					if err := json.Unmarshal(c.ExpectEvent(protocol.TypeReaction).Payload, &e); err != nil {
+						t.Fatalf("decode reaction: %v", err)
+					}
+					if e.MessageID != messageID || len(e.Reactions) != 1 || e.Reactions[0].Emoji != "👍" || len(e.Reactions[0].Users) != 1 {
+						t.Fatalf("%s got reaction %+v", c.Name(), e)
+					}
+				}
+			},
+		},
+		{
+			name: "read receipt",
+			send: func(bob *chattest.Client, messageID string) string {
+				return bob.SendFrame(protocol.TypeRead, protocol.ReadPayload{MessageID: messageID})
+			},
+			expect: func(t *testing.T, alice, _ *chattest.Client, messageID string) {
+				var e protocol.ReadEvent
+				if err := json.Unmarshal(alice.ExpectEvent(protocol.TypeRead).Payload, &e); err != nil {
+					t.Fatalf("decode read: %v", err)
+				}
+				if e.MessageID != messageID || e.ReadCount != 1 {
+					t.Fatalf("got read %+v, want 1 read of %s", e, messageID)
+				}
+			},
+		},
+		{
+			name: "unknown emoji",
+			send: func(bob *chattest.Client, messageID string) string {
+				return bob.SendFrame(protocol.TypeReaction, protocol.ReactionPayload{MessageID: messageID, Emoji: "🍕"})
+			},
+			wantError: "invalid_request",
+		},
+		{
+			name: "unknown message",
+			send: func(bob *chattest.Client, _ string) string {
+				return bob.SendFrame(protocol.TypeRead, protocol.ReadPayload{MessageID: "unknown"})
+			},
+			wantError: "message_not_found",
+		},
+		{
+			name: "unknown type",
+			send: func(bob *chattest.Client, _ string) string {
+				return bob.SendFrame("dance", struct{}{})
+			},
+			wantError: "unknown_type",
+		},
+		{
+			name: "unknown field",
+			send: func(bob *chattest.Client, messageID string) string {
+				return bob.SendFrame(protocol.TypeRead, map[string]string{"message_id": messageID, "emoji": "👍"})
+			},
+			wantError: "invalid_payload",
+		},
+		{
+			name: "invalid frame",
+			send: func(bob *chattest.Client, _ string) string {
+				bob.SendRaw([]byte(`{"payload":{}}`))
+				return ""
+			},
+			wantError: "invalid_frame",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{})
+			alice := srv.Login("Alice").Connect()
+			bob := srv.Login("Bob").Connect()
+
+			alice.Send("hello")
+			messageID := bob.ExpectMessage("hello").ID
+
+			id := tt.send(bob, messageID)
+			if tt.wantError == "" {
+				tt.expect(t, alice, bob, messageID)
+				return
+			}
+
+			var env protocol.Envelope
+			bob.Expect("error "+tt.wantError, func(frame []byte) bool {
+				var p protocol.ErrorPayload
+				return json.Unmarshal(frame, &env) == nil && env.Type == protocol.TypeError &&
+					json.Unmarshal(env.Payload, &p) == nil && p.Code == tt.wantError
+			})
+			if env.ID != id {
+				t.Fatalf("got error replying to %q, want %q", env.ID, id)
+			}
+
+			// The connection is still usable.
+			bob.Send("still there")
+			bob.ExpectMessage("still there")
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	c := srv.Login("Alice")
+
+	tests := []struct {
+		name        string
+		query       string
+		subprotocol string
+		wantStatus  int
+	}{
+		{name: "unsupported version", query: "?v=2", wantStatus: http.StatusBadRequest},
+		{name: "invalid version", query: "?v=latest", wantStatus: http.StatusBadRequest},
+		{name: "unsupported subprotocol", query: "?v=1", subprotocol: "chat-demo.xml", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/chatroom"+tt.query, nil)
+			if err != nil {
+				t.Fatalf("create request: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+c.Token())
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			if tt.subprotocol != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", tt.subprotocol)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("upgrade: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	browser := srv.Login("Alice").ConnectHTML()
+	browser.ExpectFragment(`id="form"`)
+	client := srv.Login("Bob").Connect()
+
+	client.Send("hello <b>world</b>")
+
+	// Browsers get HTML fragments, escaped, while other clients get JSON frames.
+	fragment := browser.ExpectFragment("hello &lt;b&gt;world&lt;/b&gt;")
+	if strings.Contains(fragment, "<b>") {
+		t.Fatalf("got unescaped fragment %s", fragment)
+	}
+	client.ExpectMessage("hello <b>world</b>")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cookieConfig configures the attributes of the cookies set by the server.
+type cookieConfig struct {
+	secure bool
+	// httpOnly hides the access token cookie from scripts.
+	// Other cookies are always hidden.
+	httpOnly bool
+	sameSite http.SameSite
+	domain   string
+	// prefix is prepended to all cookie names (e.g. __Host-).
+	prefix string
+}
+
+// loadCookieConfig reads the cookie attributes from the environment.
+func loadCookieConfig(getenv env) (*cookieConfig, error) {
+	c := &cookieConfig{
+		secure:   getenv("COOKIE_SECURE") != "false",
+		httpOnly: getenv("COOKIE_HTTPONLY") != "false",
+		domain:   getenv("COOKIE_DOMAIN"),
+		prefix:   getenv("COOKIE_PREFIX"),
+	}
+
+	switch v := strings.ToLower(getenv.or("COOKIE_SAMESITE", "lax")); v {
+	case "lax":
+		c.sameSite = http.SameSiteLaxMode
+	case "strict":
+		c.sameSite = http.SameSiteStrictMode
+	case "none":
+		if !c.secure {
+			return nil, errors.New("COOKIE_SAMESITE=none requires secure cookies")
+		}
+		c.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid COOKIE_SAMESITE %q", v)
+	}
+
+	// Browsers reject prefixed cookies which do not match the requirements of the prefix.
+	switch {
+	case strings.HasPrefix(c.prefix, "__Host-"):
+		if !c.secure || c.domain != "" {
+			return nil, errors.New("COOKIE_PREFIX __Host- requires secure cookies without COOKIE_DOMAIN")
+		}
+	case strings.HasPrefix(c.prefix, "__Secure-"):
+		if !c.secure {
+			return nil, errors.New("COOKIE_PREFIX __Secure- requires secure cookies")
+		}
+	}
+
+	return c, nil
+}
+
+// name returns the prefixed name of a cookie.
+func (c *cookieConfig) name(name string) string {
+	return c.prefix + name
+}
+
+// cookie returns a cookie with the configured attributes.
+func (c *cookieConfig) cookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.name(name),
+		Value:    value,
+		Expires:  expires,
+		Path:     "/",
+		Domain:   c.domain,
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: c.sameSite,
+	}
+}
+
+// set sets a cookie hidden from scripts.
+func (c *cookieConfig) set(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, c.cookie(name, value, expires))
+}
+
+// clear removes a cookie.
+func (c *cookieConfig) clear(w http.ResponseWriter, name string) {
+	cookie := c.cookie(name, "", time.Time{})
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// value returns the value of a cookie of the request.
+func (c *cookieConfig) value(r *http.Request, name string) (string, bool) {
+	cookie, err := r.Cookie(c.name(name))
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
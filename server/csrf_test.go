@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+)
+
+func TestCSRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin string
+		// token is the csrf token submitted, the one of the browser if "cookie".
+		token      string
+		wantStatus int
+	}{
+		{name: "token of the browser", token: "cookie", wantStatus: http.StatusFound},
+		{name: "token of the browser from the same origin", token: "cookie", origin: "same", wantStatus: http.StatusFound},
+		{name: "token of the browser from another origin", token: "cookie", origin: "https://evil.example.com", wantStatus: http.StatusForbidden},
+		{name: "allowed origin", token: "cookie", origin: "https://app.example.com", wantStatus: http.StatusFound},
+		{name: "forged token", token: "forged", wantStatus: http.StatusForbidden},
+		{name: "missing token", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Env: map[string]string{"ALLOWED_ORIGINS": "https://app.example.com"},
+			})
+			b := newBrowser(t, srv)
+			b.get("/login")
+
+			token := tt.token
+			if token == "cookie" {
+				token = b.cookie("csrf")
+			}
+			form := url.Values{"name": {"Carol"}, "csrf_token": {token}}
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/login", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatalf("create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			switch tt.origin {
+			case "":
+			case "same":
+				req.Header.Set("Origin", srv.URL)
+			default:
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			resp := b.do(req)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body(t, resp))
+			}
+			if got := b.cookie("jwt") != ""; got != (tt.wantStatus == http.StatusFound) {
+				t.Fatalf("got logged in %t", got)
+			}
+		})
+	}
+}
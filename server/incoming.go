@@ -0,0 +1,272 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/mgjules/chat-demo/webhook"
+	"github.com/rs/xid"
+)
+
+// List of limits of the messages posted through incoming webhooks.
+// Each webhook has its own limit, separate from the one of its creator.
+const (
+	incomingBurst    = 10
+	incomingInterval = 6 * time.Second
+)
+
+// incomingPath is the path prefix of the URLs of incoming webhooks.
+const incomingPath = "/hooks/"
+
+// apiIncoming is an incoming webhook as seen by API clients. The URL is never shown again.
+type apiIncoming struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	Name      string    `json:"name"`
+	Icon      string    `json:"icon,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// postIncoming posts the message of a payload through an incoming webhook.
+// The secret of the URL authenticates the request, which is why it is not behind the API authentication.
+// Slack clients may send the payload as JSON or as a "payload" form field.
+func (a *api) postIncoming(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := xid.FromString(chi.URLParam(r, "incoming"))
+	if err != nil {
+		apiError(w, r, webhook.ErrIncomingNotFound)
+		return
+	}
+
+	in, err := a.incoming.GetIncoming(ctx, id)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	// Wrong secrets look like unknown webhooks so that webhooks cannot be guessed.
+	if !in.Verify(chi.URLParam(r, "secret")) {
+		apiError(w, r, webhook.ErrIncomingNotFound)
+		return
+	}
+
+	if in.Disabled {
+		apiError(w, r, webhook.ErrDisabled)
+		return
+	}
+
+	room, found := a.rooms[in.Room]
+	if !found {
+		apiError(w, r, errRoomNotFound)
+		return
+	}
+
+	var p webhook.Payload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		err = json.Unmarshal([]byte(r.PostFormValue("payload")), &p)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&p)
+	}
+	if err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	usr, err := in.User(p)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	// Messages are marked as coming from a webhook but should still not be mistaken for a user.
+	if room.IsNameTaken(usr.Name, usr.ID) {
+		apiError(w, r, chat.ErrNameTaken)
+		return
+	}
+
+	if a.exhausted(w, r, a.lims.get("hook:"+in.ID.String(), incomingInterval, incomingBurst)) {
+		return
+	}
+
+	if room.IsMuted(usr.ID) {
+		apiError(w, r, chat.ErrMuted)
+		return
+	}
+
+	msg, err := chat.NewMessage(usr, p.Content())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	broadcast(ctx, room, msg)
+
+	writeJSON(w, r, http.StatusCreated, protocol.NewMessageEvent(msg))
+}
+
+func (a *api) listIncoming(w http.ResponseWriter, r *http.Request) {
+	incoming, err := a.incoming.ListIncoming(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	res := make([]apiIncoming, 0, len(incoming))
+	for _, in := range incoming {
+		res = append(res, newAPIIncoming(in))
+	}
+	slices.SortFunc(res, func(a, b apiIncoming) int { return cmp.Compare(a.Name, b.Name) })
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// createIncoming creates an incoming webhook posting into a room.
+// The response holds the only copy of the URL of the webhook.
+func (a *api) createIncoming(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Room string `json:"room"`
+		Name string `json:"name"`
+		Icon string `json:"icon"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	if _, found := a.rooms[body.Room]; !found {
+		apiError(w, r, errRoomNotFound)
+		return
+	}
+
+	in, secret, err := webhook.NewIncoming(body.Room, body.Name, body.Icon, user.FromContext(ctx).ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := a.incoming.CreateIncoming(ctx, in); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, map[string]any{
+		"webhook": newAPIIncoming(in),
+		"url":     incomingURL(r, in, secret),
+	})
+}
+
+// updateIncoming disables or enables an incoming webhook.
+func (a *api) updateIncoming(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Disabled bool `json:"disabled"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	in, err := a.getIncoming(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	in.Disabled = body.Disabled
+
+	if err := a.incoming.UpdateIncoming(r.Context(), in); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, newAPIIncoming(in))
+}
+
+// rotateIncoming replaces the URL of an incoming webhook. The previous URL stops working right away.
+func (a *api) rotateIncoming(w http.ResponseWriter, r *http.Request) {
+	in, err := a.getIncoming(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	secret, err := in.Rotate()
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := a.incoming.UpdateIncoming(r.Context(), in); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]any{
+		"webhook": newAPIIncoming(in),
+		"url":     incomingURL(r, in, secret),
+	})
+}
+
+func (a *api) deleteIncoming(w http.ResponseWriter, r *http.Request) {
+	id, err := xid.FromString(chi.URLParam(r, "incoming"))
+	if err != nil {
+		apiError(w, r, webhook.ErrIncomingNotFound)
+		return
+	}
+
+	if err := a.incoming.DeleteIncoming(r.Context(), id); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	a.lims.delete("hook:" + id.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getIncoming finds the incoming webhook of the request.
+func (a *api) getIncoming(r *http.Request) (*webhook.Incoming, error) {
+	id, err := xid.FromString(chi.URLParam(r, "incoming"))
+	if err != nil {
+		return nil, webhook.ErrIncomingNotFound
+	}
+
+	return a.incoming.GetIncoming(r.Context(), id)
+}
+
+// incomingURL returns the URL of an incoming webhook on the host of the request.
+func incomingURL(r *http.Request, in *webhook.Incoming, secret string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + incomingPath + in.ID.String() + "/" + secret
+}
+
+func newAPIIncoming(in *webhook.Incoming) apiIncoming {
+	return apiIncoming{
+		ID:        in.ID.String(),
+		Room:      in.Room,
+		Name:      in.Name,
+		Icon:      in.Icon,
+		Disabled:  in.Disabled,
+		CreatedBy: in.CreatedBy.String(),
+		CreatedAt: in.CreatedAt,
+		RotatedAt: in.RotatedAt,
+	}
+}
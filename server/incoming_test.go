@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// postHook posts a payload through the URL of an incoming webhook and returns the status and error code of the response.
+func postHook(t *testing.T, srv *chattest.Server, hookURL, contentType, body string) (int, string) {
+	t.Helper()
+
+	resp, err := srv.Client().Post(hookURL, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post %s: %v", hookURL, err)
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Error protocol.ErrorPayload `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+
+	return resp.StatusCode, apiErr.Error.Code
+}
+
+// createHook creates an incoming webhook named Deploys in the general room and returns its ID and URL.
+func createHook(t *testing.T, srv *chattest.Server, admin string) (string, string) {
+	t.Helper()
+
+	var created struct {
+		Webhook struct {
+			ID string `json:"id"`
+		} `json:"webhook"`
+		URL string `json:"url"`
+	}
+	body := `{"room":"general","name":"Deploys","icon":":rocket:"}`
+	if status, code := apiCall(t, srv, admin, http.MethodPost, "/api/v1/webhooks/incoming", body, &created); status != http.StatusCreated {
+		t.Fatalf("create incoming webhook: got status %d (%s)", status, code)
+	}
+
+	return created.Webhook.ID, created.URL
+}
+
+func TestIncomingWebhook(t *testing.T) {
+	const jsonType = "application/json"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// hookURL changes the URL of the webhook, returning it as is when nil.
+		hookURL    func(hookURL string) string
+		wantStatus int
+		wantCode   string
+		wantName   string
+		wantIcon   string
+		wantText   string
+	}{
+		{name: "text", contentType: jsonType, body: `{"text":"deployed"}`, wantStatus: http.StatusCreated, wantName: "Deploys", wantIcon: "🚀", wantText: "deployed"},
+		{
+			name:        "overrides",
+			contentType: jsonType,
+			body:        `{"text":"deployed","username":"CI Bot","icon_emoji":":robot:"}`,
+			wantStatus:  http.StatusCreated, wantName: "CI Bot", wantIcon: "🤖", wantText: "deployed",
+		},
+		{
+			name:        "blocks",
+			contentType: jsonType,
+			body:        `{"text":"fallback","blocks":[{"type":"section","text":{"type":"mrkdwn","text":"deployed *v1*"}}]}`,
+			wantStatus:  http.StatusCreated, wantName: "Deploys", wantIcon: "🚀", wantText: "deployed *v1*",
+		},
+		{
+			name:        "form payload",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"payload": {`{"text":"deployed"}`}}.Encode(),
+			wantStatus:  http.StatusCreated, wantName: "Deploys", wantIcon: "🚀", wantText: "deployed",
+		},
+		{name: "empty", contentType: jsonType, body: `{"text":" "}`, wantStatus: http.StatusBadRequest, wantCode: "message_empty"},
+		{name: "invalid body", contentType: jsonType, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "name of a connected user", contentType: jsonType, body: `{"text":"hi","username":"alice"}`, wantStatus: http.StatusBadRequest},
+		{name: "reserved name", contentType: jsonType, body: `{"text":"hi","username":"Admin"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_name"},
+		{name: "invalid icon", contentType: jsonType, body: `{"text":"hi","icon_emoji":"robot"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_icon"},
+		{
+			name:        "wrong secret",
+			contentType: jsonType,
+			body:        `{"text":"hi"}`,
+			hookURL:     func(hookURL string) string { return hookURL + "x" },
+			wantStatus:  http.StatusNotFound, wantCode: "webhook_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleAdmin}},
+			})
+			admin := srv.LoginAccount("Carol", password).Token()
+			alice := srv.Login("Alice").Connect()
+			_, hookURL := createHook(t, srv, admin)
+			if tt.hookURL != nil {
+				hookURL = tt.hookURL(hookURL)
+			}
+
+			status, code := postHook(t, srv, hookURL, tt.contentType, tt.body)
+			if status != tt.wantStatus || (tt.wantCode != "" && code != tt.wantCode) {
+				t.Fatalf("got status %d (%s), want %d (%s)", status, code, tt.wantStatus, tt.wantCode)
+			}
+			if status != http.StatusCreated {
+				return
+			}
+
+			msg := alice.ExpectMessage(tt.wantText)
+			if msg.User.Name != tt.wantName || msg.User.Icon != tt.wantIcon || !msg.User.Webhook || msg.User.Bot {
+				t.Fatalf("got user %+v", msg.User)
+			}
+		})
+	}
+}
+
+func TestIncomingWebhookLifecycle(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleAdmin}},
+	})
+	admin := srv.LoginAccount("Carol", password).Token()
+	id, hookURL := createHook(t, srv, admin)
+	path := "/api/v1/webhooks/incoming/" + id
+	post := func() (int, string) { return postHook(t, srv, hookURL, "application/json", `{"text":"deployed"}`) }
+
+	// Disabled webhooks reject messages until they are enabled again.
+	if status, code := apiCall(t, srv, admin, http.MethodPatch, path, `{"disabled":true}`, nil); status != http.StatusOK {
+		t.Fatalf("disable: got status %d (%s)", status, code)
+	}
+	if status, code := post(); status != http.StatusForbidden || code != "webhook_disabled" {
+		t.Fatalf("disabled: got status %d (%s)", status, code)
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodPatch, path, `{"disabled":false}`, nil); status != http.StatusOK {
+		t.Fatalf("enable: got status %d (%s)", status, code)
+	}
+
+	// Webhooks have their own rate limit.
+	var limited bool
+	for i := 0; i < 20 && !limited; i++ {
+		status, code := post()
+		switch {
+		case status == http.StatusTooManyRequests && code == "rate_limited":
+			limited = true
+		case status != http.StatusCreated:
+			t.Fatalf("post %d: got status %d (%s)", i, status, code)
+		}
+	}
+	if !limited {
+		t.Fatal("webhook not rate limited")
+	}
+
+	// Rotating the secret replaces the URL right away.
+	var rotated struct {
+		URL string `json:"url"`
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodPost, path+"/rotate", "", &rotated); status != http.StatusOK {
+		t.Fatalf("rotate: got status %d (%s)", status, code)
+	}
+	if status, code := post(); status != http.StatusNotFound || code != "webhook_not_found" {
+		t.Fatalf("previous url: got status %d (%s)", status, code)
+	}
+	hookURL = rotated.URL
+	if status, code := post(); status != http.StatusTooManyRequests {
+		t.Fatalf("rotated url: got status %d (%s), want the rate limit to be kept", status, code)
+	}
+
+	// Only admins manage webhooks, and deleted webhooks are gone.
+	member := srv.Login("Alice").Token()
+	if status, _ := apiCall(t, srv, member, http.MethodPost, path+"/rotate", "", nil); status != http.StatusForbidden {
+		t.Fatalf("member rotates: got status %d, want %d", status, http.StatusForbidden)
+	}
+	if status, code := apiCall(t, srv, admin, http.MethodDelete, path, "", nil); status != http.StatusNoContent {
+		t.Fatalf("delete: got status %d (%s)", status, code)
+	}
+	if status, code := post(); status != http.StatusNotFound {
+		t.Fatalf("deleted: got status %d (%s)", status, code)
+	}
+}
@@ -0,0 +1,716 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+)
+
+// ircServerName is the name of the IRC server, the source of its replies.
+const ircServerName = "chat-demo"
+
+// List of limits of the IRC server.
+const (
+	// ircRegisterTimeout is how long clients have to register (PASS, NICK and USER).
+	ircRegisterTimeout = 10 * time.Second
+	// ircPingInterval is how long a connection can stay idle before being pinged, then closed.
+	ircPingInterval = 2 * time.Minute
+	// ircMaxLine is the maximum length of the lines sent by clients.
+	ircMaxLine = 4 << 10 // 4KB
+)
+
+// List of IRC numeric replies.
+const (
+	rplWelcome          = "001"
+	rplYourHost         = "002"
+	rplNoTopic          = "331"
+	rplNamReply         = "353"
+	rplEndOfNames       = "366"
+	errNoSuchNick       = "401"
+	errNoSuchChannel    = "403"
+	errCannotSendToChan = "404"
+	errUnknownCommand   = "421"
+	errNoMOTD           = "422"
+	errErroneusNickname = "432"
+	errNicknameInUse    = "433"
+	errNotOnChannel     = "442"
+	errUserOnChannel    = "443"
+	errNotRegistered    = "451"
+	errNeedMoreParams   = "461"
+	errAlreadyRegistred = "462"
+	errPasswdMismatch   = "464"
+	errChannelIsFull    = "471"
+	errInviteOnlyChan   = "473"
+	errBannedFromChan   = "474"
+	errChanOPrivsNeeded = "482"
+)
+
+// ircServer serves the rooms over IRC, each room being a channel (e.g. #general).
+//
+// Clients authenticate with the API key of a bot as server password (PASS)
+// and the scope of the key restricts the channels they can join and post to.
+type ircServer struct {
+	rooms map[string]*chat.Room
+	bots  bot.Store
+	lims  *limiters
+}
+
+// newIRCServer creates a new IRC server for the rooms.
+func newIRCServer(rooms map[string]*chat.Room, bots bot.Store, lims *limiters) *ircServer {
+	return &ircServer{
+		rooms: rooms,
+		bots:  bots,
+		lims:  lims,
+	}
+}
+
+// ListenAndServe accepts IRC connections on addr.
+func (s *ircServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serve(nc)
+	}
+}
+
+// serve registers the client then handles its commands until it quits.
+func (s *ircServer) serve(nc net.Conn) {
+	defer nc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &ircConn{
+		srv:      s,
+		nc:       nc,
+		nick:     "*",
+		channels: make(map[string]*ircChannel),
+		logger:   slog.Default().With("transport", "irc", "addr", nc.RemoteAddr()),
+	}
+	// Leave the channels silently when the client disconnects.
+	defer c.partAll(ctx, "")
+
+	sc := bufio.NewScanner(nc)
+	sc.Buffer(make([]byte, 0, 512), ircMaxLine)
+	nc.SetReadDeadline(time.Now().Add(ircRegisterTimeout))
+	pinged := false
+	for {
+		if !sc.Scan() {
+			var nErr net.Error
+			if errors.As(sc.Err(), &nErr) && nErr.Timeout() && c.usr != nil && !pinged {
+				// Idle clients must answer a ping to stay connected.
+				pinged = true
+				c.send(ircServerName, "PING", ircServerName)
+				nc.SetReadDeadline(time.Now().Add(ircPingInterval))
+				sc = bufio.NewScanner(nc)
+				sc.Buffer(make([]byte, 0, 512), ircMaxLine)
+				continue
+			}
+			return
+		}
+		pinged = false
+		if c.usr != nil {
+			nc.SetReadDeadline(time.Now().Add(ircPingInterval))
+		}
+
+		cmd, params := parseIRC(sc.Text())
+		if cmd == "" {
+			continue
+		}
+
+		if err := c.handle(ctx, cmd, params); err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.logger.InfoContext(ctx, "close irc connection", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// ircConn is the connection of an IRC client.
+type ircConn struct {
+	srv *ircServer
+	nc  net.Conn
+	// muWrite prevents the lines sent by the rooms and the replies from interleaving.
+	muWrite sync.Mutex
+
+	pass, nick, username string
+	// usr is the user of the bot owning the key, nil until the client is registered.
+	usr    *user.User
+	key    *bot.Key
+	lim    *limiter
+	logger *slog.Logger
+
+	muChannels sync.Mutex
+	channels   map[string]*ircChannel
+}
+
+// handle runs a command of the client. io.EOF is returned when the client must be disconnected.
+func (c *ircConn) handle(ctx context.Context, cmd string, params []string) error {
+	switch cmd {
+	case "CAP":
+		// No capabilities are supported, clients waiting for the list end the negotiation.
+		if len(params) > 0 && strings.EqualFold(params[0], "LS") {
+			c.send(ircServerName, "CAP", "*", "LS", "")
+		}
+		return nil
+	case "PING":
+		c.send(ircServerName, "PONG", append([]string{ircServerName}, params...)...)
+		return nil
+	case "PONG":
+		return nil
+	case "QUIT":
+		c.send("", "ERROR", "Closing link")
+		return io.EOF
+	}
+
+	if c.usr == nil {
+		return c.register(ctx, cmd, params)
+	}
+
+	switch cmd {
+	case "PASS", "USER":
+		c.reply(errAlreadyRegistred, "You may not reregister")
+	case "NICK":
+		c.reply(errErroneusNickname, c.nick, "Nickname changes are not supported")
+	case "JOIN":
+		if len(params) == 0 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+		if params[0] == "0" {
+			c.partAll(ctx, "PART")
+			return nil
+		}
+		for _, name := range strings.Split(params[0], ",") {
+			c.join(ctx, name)
+		}
+	case "PART":
+		if len(params) == 0 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+		for _, name := range strings.Split(params[0], ",") {
+			ch, found := c.channel(name)
+			if !found {
+				c.reply(errNotOnChannel, name, "You're not on that channel")
+				continue
+			}
+			c.part(ctx, ch, "PART")
+		}
+	case "PRIVMSG", "NOTICE":
+		if len(params) < 2 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+		c.privmsg(ctx, params[0], params[1])
+	case "NAMES":
+		if len(params) == 0 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+		for _, name := range strings.Split(params[0], ",") {
+			c.names(name)
+		}
+	case "TOPIC":
+		if len(params) == 0 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+		if _, found := c.srv.room(params[0]); !found {
+			c.reply(errNoSuchChannel, params[0], "No such channel")
+			return nil
+		}
+		if len(params) > 1 {
+			c.reply(errChanOPrivsNeeded, params[0], "Topics cannot be changed")
+			return nil
+		}
+		c.reply(rplNoTopic, params[0], "No topic is set")
+	default:
+		c.reply(errUnknownCommand, cmd, "Unknown command")
+	}
+
+	return nil
+}
+
+// register records the PASS, NICK and USER commands of the client
+// then authenticates it once all of them were sent.
+func (c *ircConn) register(ctx context.Context, cmd string, params []string) error {
+	switch cmd {
+	case "PASS", "NICK", "USER":
+		if len(params) == 0 {
+			c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+			return nil
+		}
+	default:
+		c.reply(errNotRegistered, "You have not registered")
+		return nil
+	}
+
+	switch cmd {
+	case "PASS":
+		c.pass = params[0]
+	case "NICK":
+		c.nick = params[0]
+	case "USER":
+		c.username = params[0]
+	}
+	if c.nick == "*" || c.username == "" {
+		return nil
+	}
+
+	b, k, err := bot.Authenticate(ctx, c.srv.bots, c.pass)
+	if err != nil {
+		if !errors.Is(err, bot.ErrInvalidKey) {
+			c.logger.ErrorContext(ctx, "authenticate irc client", "err", err)
+		}
+		c.reply(errPasswdMismatch, "Password incorrect, use the API key of a bot")
+		c.send("", "ERROR", "Closing link")
+		return io.EOF
+	}
+
+	c.usr, c.key = b.User(), k
+	c.nick = ircNick(c.usr.Name)
+	c.logger = c.logger.With("user.id", c.usr.ID)
+	// Bots share their rate limit with the API and the websocket, if any.
+	if b.RateLimit != nil {
+		c.lim = c.srv.lims.get("bot:"+b.ID.String(), b.RateLimit.Interval, b.RateLimit.Burst)
+	}
+	c.pass = ""
+	c.nc.SetReadDeadline(time.Now().Add(ircPingInterval))
+
+	var channels []string
+	for name := range c.srv.rooms {
+		if k.Scope.Allows(name, bot.ActionRead) {
+			channels = append(channels, "#"+name)
+		}
+	}
+	slices.Sort(channels)
+
+	c.reply(rplWelcome, "Welcome to "+ircServerName+" "+c.nick+", channels: "+strings.Join(channels, " "))
+	c.reply(rplYourHost, "Your host is "+ircServerName)
+	c.reply(errNoMOTD, "MOTD File is missing")
+
+	go c.watchKey(ctx)
+
+	return nil
+}
+
+// watchKey disconnects the client once the API key of its bot is revoked.
+func (c *ircConn) watchKey(ctx context.Context) {
+	ticker := time.NewTicker(keyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		b, err := c.srv.bots.FindByKey(ctx, c.key.ID)
+		if err != nil && !errors.Is(err, bot.ErrNotFound) {
+			c.logger.ErrorContext(ctx, "check api key", "err", err)
+			continue
+		}
+
+		if err == nil {
+			if k, found := b.Key(c.key.ID); found && !k.IsRevoked() {
+				continue
+			}
+		}
+
+		c.send("", "ERROR", "API key revoked")
+		c.nc.Close()
+		return
+	}
+}
+
+// join joins the room of a channel.
+func (c *ircConn) join(ctx context.Context, name string) {
+	room, found := c.srv.room(name)
+	if !found {
+		c.reply(errNoSuchChannel, name, "No such channel")
+		return
+	}
+	name = strings.ToLower(name)
+
+	if !c.key.Scope.Allows(strings.TrimPrefix(name, "#"), bot.ActionRead) {
+		c.reply(errInviteOnlyChan, name, "Cannot join channel, the API key does not allow it")
+		return
+	}
+
+	if _, found := c.channel(name); found {
+		return
+	}
+
+	ch := &ircChannel{conn: c, name: name, room: room}
+	if err := room.AddClient(chat.NewConn(ctx, c.usr, ch), ircEncoder{channel: name}); err != nil {
+		switch {
+		case errors.Is(err, chat.ErrKicked):
+			c.reply(errBannedFromChan, name, err.Error())
+		case errors.Is(err, chat.ErrRoomFull):
+			c.reply(errChannelIsFull, name, err.Error())
+		case errors.Is(err, chat.ErrExistingSession):
+			c.reply(errUserOnChannel, c.nick, name, err.Error())
+		case errors.Is(err, chat.ErrNameTaken):
+			c.reply(errNicknameInUse, c.nick, err.Error())
+		default:
+			c.logger.ErrorContext(ctx, "join room", "err", err)
+			c.reply(errNoSuchChannel, name, err.Error())
+		}
+		return
+	}
+
+	c.muChannels.Lock()
+	c.channels[name] = ch
+	c.muChannels.Unlock()
+
+	c.send(ircSource(c.usr), "JOIN", name)
+	c.reply(rplNoTopic, name, "No topic is set")
+	c.names(name)
+
+	// Inform all users about the arrival.
+	room.Broadcast(ctx, chat.JoinEvent{User: c.usr, Time: time.Now().UTC()}, c.usr.ID)
+	room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: room.NumUsers()})
+}
+
+// part leaves the room of a channel, cmd (PART or KICK) being echoed to the client.
+// An empty cmd leaves silently.
+func (c *ircConn) part(ctx context.Context, ch *ircChannel, cmd string) {
+	c.muChannels.Lock()
+	_, found := c.channels[ch.name]
+	delete(c.channels, ch.name)
+	c.muChannels.Unlock()
+	if !found {
+		return
+	}
+
+	ch.room.RemoveClient(c.usr.ID)
+
+	switch cmd {
+	case "PART":
+		c.send(ircSource(c.usr), "PART", ch.name)
+	case "KICK":
+		c.send(ircServerName, "KICK", ch.name, c.nick, "You have been kicked")
+	}
+
+	// Inform all users about the departure.
+	ch.room.Broadcast(ctx, chat.LeaveEvent{User: c.usr, Time: time.Now().UTC()})
+	ch.room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: ch.room.NumUsers()})
+}
+
+// partAll leaves all the channels.
+func (c *ircConn) partAll(ctx context.Context, cmd string) {
+	c.muChannels.Lock()
+	channels := make([]*ircChannel, 0, len(c.channels))
+	for _, ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	c.muChannels.Unlock()
+
+	for _, ch := range channels {
+		c.part(ctx, ch, cmd)
+	}
+}
+
+// privmsg sends a message to the room of a channel.
+// CTCP actions (e.g. /me) are sent as actions, other CTCP requests are ignored.
+func (c *ircConn) privmsg(ctx context.Context, target, text string) {
+	if !strings.HasPrefix(target, "#") {
+		c.reply(errNoSuchNick, target, "Private messages are not supported")
+		return
+	}
+
+	ch, found := c.channel(target)
+	if !found {
+		c.reply(errCannotSendToChan, target, "Cannot send to channel, join it first")
+		return
+	}
+
+	if err := c.post(ctx, ch, text); err != nil {
+		var cErr chat.Error
+		if !errors.As(err, &cErr) {
+			c.logger.ErrorContext(ctx, "send message", "err", err)
+			err = chat.ErrUnknown
+		}
+		c.reply(errCannotSendToChan, ch.name, err.Error())
+	}
+}
+
+// post sends a message to a room, like the websocket of the room.
+func (c *ircConn) post(ctx context.Context, ch *ircChannel, text string) error {
+	if !c.key.Scope.Allows(strings.TrimPrefix(ch.name, "#"), bot.ActionPost) {
+		return chat.ErrNotAllowed
+	}
+
+	newMessage := chat.NewMessage
+	if ctcp, ok := strings.CutPrefix(text, "\x01"); ok {
+		action, ok := strings.CutPrefix(strings.TrimSuffix(ctcp, "\x01"), "ACTION ")
+		if !ok {
+			return nil
+		}
+		text, newMessage = action, chat.NewAction
+	}
+
+	if c.lim != nil {
+		if _, err := c.lim.Limit(ctx); errors.Is(err, mlimiters.ErrLimitExhausted) {
+			return chat.ErrRateLimited
+		}
+	}
+
+	if ch.room.IsMuted(c.usr.ID) {
+		return chat.ErrMuted
+	}
+
+	msg, err := newMessage(c.usr, text)
+	if err != nil {
+		return err
+	}
+	broadcast(ctx, ch.room, msg)
+
+	return nil
+}
+
+// names lists the users of a channel.
+func (c *ircConn) names(name string) {
+	room, found := c.srv.room(name)
+	if !found {
+		c.reply(errNoSuchChannel, name, "No such channel")
+		return
+	}
+	name = strings.ToLower(name)
+
+	if c.key.Scope.Allows(strings.TrimPrefix(name, "#"), bot.ActionRead) {
+		users := room.Users()
+		nicks := make([]string, 0, len(users))
+		for _, u := range users {
+			nicks = append(nicks, ircNick(u.Name))
+		}
+		slices.Sort(nicks)
+
+		// Keep the replies well under the limit of 512 bytes per line.
+		for chunk := range slices.Chunk(nicks, 20) {
+			c.reply(rplNamReply, "=", name, strings.Join(chunk, " "))
+		}
+	}
+	c.reply(rplEndOfNames, name, "End of /NAMES list")
+}
+
+// channel returns a channel joined by the client.
+func (c *ircConn) channel(name string) (*ircChannel, bool) {
+	c.muChannels.Lock()
+	defer c.muChannels.Unlock()
+
+	ch, found := c.channels[strings.ToLower(name)]
+	return ch, found
+}
+
+// reply sends a numeric reply to the client.
+func (c *ircConn) reply(code string, params ...string) {
+	c.send(ircServerName, code, append([]string{c.nick}, params...)...)
+}
+
+// send sends a command to the client, the last parameter being sent as trailing parameter.
+func (c *ircConn) send(source, cmd string, params ...string) {
+	c.write([]byte(formatIRC(source, cmd, params...)))
+}
+
+// write sends raw lines to the client.
+func (c *ircConn) write(p []byte) (int, error) {
+	c.muWrite.Lock()
+	defer c.muWrite.Unlock()
+
+	return c.nc.Write(p)
+}
+
+// room returns the room of a channel.
+func (s *ircServer) room(channel string) (*chat.Room, bool) {
+	name, ok := strings.CutPrefix(strings.ToLower(channel), "#")
+	if !ok {
+		return nil, false
+	}
+
+	room, found := s.rooms[name]
+	return room, found
+}
+
+// ircChannel is the client of a room joined by an IRC connection.
+// Closing it (e.g. on kicks) only leaves the channel.
+type ircChannel struct {
+	conn *ircConn
+	name string
+	room *chat.Room
+}
+
+// Write implements the io.Writer interface.
+func (ch *ircChannel) Write(p []byte) (int, error) {
+	return ch.conn.write(p)
+}
+
+// Close implements the io.Closer interface.
+func (ch *ircChannel) Close() error {
+	ch.conn.part(context.Background(), ch, "KICK")
+	return nil
+}
+
+// ircEncoder encodes events as IRC commands in a channel.
+type ircEncoder struct {
+	channel string
+}
+
+// Encode implements the chat.Encoder interface.
+func (enc ircEncoder) Encode(_ context.Context, w io.Writer, to *user.User, e chat.Event) error {
+	var lines []string
+	switch e := e.(type) {
+	case chat.MessageEvent:
+		msg := e.Message
+		switch {
+		case msg.IsSystem():
+			lines = enc.split(ircServerName, "NOTICE", msg.Content, "")
+		case to != nil && msg.User.ID == to.ID:
+			// IRC clients echo their own messages.
+		case msg.IsAction():
+			lines = enc.split(ircSource(msg.User), "PRIVMSG", msg.Content, "\x01ACTION ")
+		default:
+			lines = enc.split(ircSource(msg.User), "PRIVMSG", msg.Content, "")
+		}
+	case chat.JoinEvent:
+		lines = []string{formatIRC(ircSource(e.User), "JOIN", enc.channel)}
+	case chat.LeaveEvent:
+		lines = []string{formatIRC(ircSource(e.User), "PART", enc.channel)}
+	case chat.NoticeEvent:
+		for _, l := range e.Lines {
+			lines = append(lines, enc.split(ircServerName, "NOTICE", l, "")...)
+		}
+	case chat.ModerationEvent:
+		// Kicks are sent when leaving the channel, others are told by system messages.
+		if to == nil || e.Target.ID != to.ID || e.Action == chat.ModerationKick {
+			return nil
+		}
+		text := "you were " + string(e.Action) + "d by " + e.Moderator.Name
+		if e.Duration > 0 {
+			text += " for " + e.Duration.String()
+		}
+		lines = enc.split(ircServerName, "NOTICE", text, "")
+	case chat.ErrorEvent:
+		lines = enc.split(ircServerName, "NOTICE", e.Err.Error(), "")
+	default:
+		// Typing notifications, reactions and read receipts have no IRC equivalent.
+		return nil
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, ""))
+	return err
+}
+
+// split sends every line of a text as a command to the channel, IRC commands being single lines.
+// Texts starting with a CTCP marker are wrapped in it.
+func (enc ircEncoder) split(source, cmd, text, ctcp string) []string {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		if ctcp != "" {
+			l = ctcp + l + "\x01"
+		}
+		lines = append(lines, formatIRC(source, cmd, enc.channel, l))
+	}
+
+	return lines
+}
+
+// ircSource returns the source of the commands of a user (nick!user@host).
+func ircSource(u *user.User) string {
+	return ircNick(u.Name) + "!" + u.ID.String() + "@" + ircServerName
+}
+
+// ircNick converts a name to a valid nickname, the names of the chat allowing spaces.
+func ircNick(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || strings.ContainsRune(",*?!@:#&()", r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// parseIRC parses a line sent by a client, ignoring its source and tags.
+func parseIRC(line string) (string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+
+	var params []string
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		if trailing, ok := strings.CutPrefix(line, ":"); ok {
+			params = append(params, trailing)
+			break
+		}
+
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		params = append(params, param)
+	}
+
+	if len(params) == 0 {
+		return "", nil
+	}
+
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// formatIRC formats a command sent to a client, the last parameter being sent as trailing parameter.
+// Line breaks of the parameters are removed so they cannot inject commands.
+func formatIRC(source, cmd string, params ...string) string {
+	var b strings.Builder
+	if source != "" {
+		b.WriteString(":" + source + " ")
+	}
+	b.WriteString(cmd)
+	for i, p := range params {
+		p = strings.NewReplacer("\r", "", "\n", " ", "\x00", "").Replace(p)
+		if i == len(params)-1 {
+			b.WriteString(" :" + p)
+			continue
+		}
+		b.WriteString(" " + p)
+	}
+	b.WriteString("\r\n")
+
+	return b.String()
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// ircClient is the client side of a connection to an IRC server.
+type ircClient struct {
+	t     *testing.T
+	nc    net.Conn
+	lines chan string
+}
+
+// dialIRC serves a new connection over a pipe.
+func dialIRC(t *testing.T, s *ircServer) *ircClient {
+	t.Helper()
+
+	server, client := net.Pipe()
+	go s.serve(server)
+
+	c := &ircClient{t: t, nc: client, lines: make(chan string, 100)}
+	// The server writes synchronously on the pipe: lines are read right away so that it never blocks.
+	go func() {
+		defer close(c.lines)
+		sc := bufio.NewScanner(client)
+		for sc.Scan() {
+			c.lines <- strings.TrimRight(sc.Text(), "\r")
+		}
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	return c
+}
+
+func (c *ircClient) send(lines ...string) {
+	c.t.Helper()
+
+	for _, l := range lines {
+		if _, err := c.nc.Write([]byte(l + "\r\n")); err != nil {
+			c.t.Fatalf("send %q: %v", l, err)
+		}
+	}
+}
+
+// expect skips lines until one contains substr and returns it.
+func (c *ircClient) expect(substr string) string {
+	c.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	var skipped []string
+	for {
+		select {
+		case l, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("connection closed, expected %q\nskipped lines:\n%s", substr, strings.Join(skipped, "\n"))
+			}
+			if strings.Contains(l, substr) {
+				return l
+			}
+			skipped = append(skipped, l)
+		case <-timeout:
+			c.t.Fatalf("expected %q\nskipped lines:\n%s", substr, strings.Join(skipped, "\n"))
+		}
+	}
+}
+
+// expectClosed skips lines until the server closes the connection.
+func (c *ircClient) expectClosed() {
+	c.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.lines:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			c.t.Fatal("connection still open")
+		}
+	}
+}
+
+// nopWriteCloser is the connection of the users joining the rooms directly.
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+// newTestIRCServer serves the rooms "general" and "random" to a bot allowed to read and post in "general".
+// It returns the token of the bot along with its user.
+func newTestIRCServer(t *testing.T) (*ircServer, string, *user.User) {
+	t.Helper()
+
+	bots, err := bot.NewFileStore("")
+	if err != nil {
+		t.Fatalf("create bot store: %v", err)
+	}
+	b, err := bot.New("Relay", xid.New(), nil)
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	_, token, err := b.NewKey(bot.Scope{Rooms: []string{"general"}, Actions: []bot.Action{bot.ActionRead, bot.ActionPost}})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := bots.Create(context.Background(), b); err != nil {
+		t.Fatalf("store bot: %v", err)
+	}
+
+	rooms := map[string]*chat.Room{
+		"general": chat.NewRoom(chat.RoomOptions{}),
+		"random":  chat.NewRoom(chat.RoomOptions{}),
+	}
+	lims := newLimiters(&clock{now: time.Now()}, func(string) {})
+
+	return newIRCServer(rooms, bots, lims), token, b.User()
+}
+
+func TestIRCRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines func(token string) []string
+		// want is the reply expected once the lines were sent.
+		want       string
+		wantClosed bool
+	}{
+		{
+			name:  "registered",
+			lines: func(token string) []string { return []string{"PASS " + token, "NICK relay", "USER relay 0 * :Relay"} },
+			want:  " 001 Relay :Welcome to chat-demo Relay, channels: #general",
+		},
+		{
+			name:  "registered in another order",
+			lines: func(token string) []string { return []string{"USER relay 0 * :Relay", "PASS " + token, "NICK relay"} },
+			want:  " 001 Relay ",
+		},
+		{
+			name: "bad password",
+			lines: func(token string) []string {
+				return []string{"PASS " + token + "x", "NICK relay", "USER relay 0 * :Relay"}
+			},
+			want:       " 464 relay ",
+			wantClosed: true,
+		},
+		{
+			name:       "no password",
+			lines:      func(string) []string { return []string{"NICK relay", "USER relay 0 * :Relay"} },
+			want:       " 464 relay ",
+			wantClosed: true,
+		},
+		{
+			name:  "command before registering",
+			lines: func(string) []string { return []string{"JOIN #general"} },
+			want:  " 451 * ",
+		},
+		{
+			name:  "missing parameter",
+			lines: func(string) []string { return []string{"NICK"} },
+			want:  " 461 * NICK ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, token, _ := newTestIRCServer(t)
+			c := dialIRC(t, s)
+
+			c.send(tt.lines(token)...)
+			c.expect(tt.want)
+			if tt.wantClosed {
+				c.expect("ERROR :Closing link")
+				c.expectClosed()
+			}
+		})
+	}
+}
+
+func TestIRCChannels(t *testing.T) {
+	s, token, relay := newTestIRCServer(t)
+	general := s.rooms["general"]
+
+	received := make(chan *chat.Message, 10)
+	general.Observe(func(_ context.Context, e chat.Event) {
+		if e, ok := e.(chat.MessageEvent); ok {
+			received <- e.Message
+		}
+	})
+
+	c := dialIRC(t, s)
+	c.send("PASS "+token, "NICK relay", "USER relay 0 * :Relay")
+	c.expect(" 001 ")
+
+	// Channels are the rooms the key can read.
+	c.send("JOIN #General")
+	c.expect(":" + ircSource(relay) + " JOIN :#general")
+	c.expect(" 366 Relay #general ")
+	if users := general.Users(); !slices.ContainsFunc(users, func(u *user.User) bool { return u.ID == relay.ID && u.Bot }) {
+		t.Fatalf("bot is not in the room: %v", users)
+	}
+	c.send("JOIN #random")
+	c.expect(" 473 Relay #random ")
+	c.send("JOIN #nope")
+	c.expect(" 403 Relay #nope ")
+
+	// Messages and actions are posted to the room.
+	c.send("PRIVMSG #general :hello from irc", "PRIVMSG #general :\x01ACTION waves\x01")
+	for _, want := range []struct {
+		content string
+		action  bool
+	}{{"hello from irc", false}, {"waves", true}} {
+		select {
+		case msg := <-received:
+			if msg.Content != want.content || msg.IsAction() != want.action || msg.User.ID != relay.ID {
+				t.Fatalf("got message %q by %s, want %q", msg.Content, msg.User.Name, want.content)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %q not posted", want.content)
+		}
+	}
+
+	// Messages of the room are sent to the channel.
+	alice := user.NewNamed("Alice")
+	msg, err := chat.NewMessage(alice, "hello from the web")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	broadcast(context.Background(), general, msg)
+	c.expect(":" + ircSource(alice) + " PRIVMSG #general :hello from the web")
+
+	c.send("PART #general")
+	c.expect(":" + ircSource(relay) + " PART :#general")
+	if users := general.Users(); len(users) != 0 {
+		t.Fatalf("users still in the room: %v", users)
+	}
+	c.send("PRIVMSG #general :still there?")
+	c.expect(" 404 Relay #general ")
+	c.send("PART #general")
+	c.expect(" 442 Relay #general ")
+
+	// Users cannot join while another one has a name which looks like theirs.
+	impostor := user.NewNamed("Re1ay")
+	if err := general.AddClient(chat.NewConn(context.Background(), impostor, nopWriteCloser{}), textEncoder{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	c.send("JOIN #general")
+	c.expect(" 433 Relay ")
+}
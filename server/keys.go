@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/mgjules/chat-demo/keyset"
+	"golang.org/x/exp/slog"
+)
+
+// loadKeys builds the jwt keyset from the environment.
+//
+// The first key of JWT_KEY_FILES signs new tokens while the others only verify tokens.
+// JWT_SECRET signs new tokens when no key files are given, otherwise it only verifies tokens
+// so that sessions survive a migration to key files.
+func loadKeys(ctx context.Context, getenv env) (*keyset.KeySet, error) {
+	ttl, err := time.ParseDuration(getenv.or("JWT_TTL", "15m"))
+	if err != nil {
+		return nil, fmt.Errorf("parse JWT_TTL: %w", err)
+	}
+
+	keys, err := keyset.New(jwa.SignatureAlgorithm(getenv.or("JWT_ALG", "HS256")), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if v := getenv("JWT_KEY_FILES"); v != "" {
+		files = strings.Split(v, ",")
+	}
+
+	if secret := getenv("JWT_SECRET"); secret != "" {
+		if err := keys.AddSecret([]byte(secret), len(files) == 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add the verification keys first since the last signing key wins.
+	for i := len(files) - 1; i >= 0; i-- {
+		key, err := keyset.LoadFile(strings.TrimSpace(files[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := keys.Add(key, i == 0); err != nil {
+			return nil, fmt.Errorf("add key %s: %w", files[i], err)
+		}
+	}
+
+	if keys.SigningKeyID() == "" {
+		if getenv("JWT_ROTATE_EVERY") == "" {
+			return nil, errors.New("missing JWT_SECRET, JWT_KEY_FILES or JWT_ROTATE_EVERY environment variable")
+		}
+
+		// Generated keys are lost on restart which logs everyone out.
+		slog.Warn("no jwt key configured, generating one")
+		if err := keys.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := getenv("JWT_ROTATE_EVERY"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT_ROTATE_EVERY: %w", err)
+		}
+
+		go keys.RotateEvery(ctx, interval)
+	}
+
+	return keys, nil
+}
+
+// jwks publishes the public keys so that other services can verify our tokens.
+func jwks(keys *keyset.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set, err := keys.PublicSet()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "public keyset", "err", err)
+			http.Error(w, "failed to load keys", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(set); err != nil {
+			slog.ErrorContext(r.Context(), "encode public keyset", "err", err)
+		}
+	}
+}
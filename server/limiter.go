@@ -0,0 +1,166 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// maxLimiters caps the number of limiters kept, as their keys may come from clients (e.g. names, IPs).
+const maxLimiters = 100_000
+
+// limiters holds token buckets by key.
+//
+// Buckets left idle until they are full again are evicted: they are no different from new ones.
+// Beyond maxLimiters, the least recently used bucket is evicted even if it is not full.
+// Buckets held by open connections are never evicted, lest their users get another one.
+type limiters struct {
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	// lru lists the limiters from the most to the least recently used.
+	lru   *list.List
+	max   int
+	clock Clock
+	// rejected is called with the scope of the limiters (e.g. user, bot, ip) rejecting a request.
+	rejected func(scope string)
+}
+
+func newLimiters(clock Clock, rejected func(scope string)) *limiters {
+	return &limiters{
+		limiters: make(map[string]*list.Element),
+		lru:      list.New(),
+		max:      maxLimiters,
+		clock:    clock,
+		rejected: rejected,
+	}
+}
+
+// limiter is a token bucket reporting its rejections.
+type limiter struct {
+	*mlimiters.TokenBucket
+	key   string
+	scope string
+	// full is how long the bucket takes to refill completely.
+	full time.Duration
+	// used is when the bucket was last used, guarded by the limiters.
+	used time.Time
+	// refs is the number of connections holding the bucket, guarded by the limiters.
+	refs int
+	lims *limiters
+}
+
+// Limit takes a token, reporting the rejection if the bucket is exhausted.
+func (l *limiter) Limit(ctx context.Context) (time.Duration, error) {
+	l.lims.touch(l)
+
+	wait, err := l.TokenBucket.Limit(ctx)
+	if errors.Is(err, mlimiters.ErrLimitExhausted) {
+		l.lims.rejected(l.scope)
+	}
+
+	return wait, err
+}
+
+// add returns the limiter of a user, held until removed so it is kept while the user is connected.
+func (l *limiters) add(u *user.User, d time.Duration, b int64) *limiter {
+	lim := l.get(u.ID.String(), d, b)
+
+	l.mu.Lock()
+	lim.refs++
+	l.mu.Unlock()
+
+	return lim
+}
+
+// remove releases the limiter of a user, left to be evicted like the others
+// so that reconnecting does not refill it.
+func (l *limiters) remove(u *user.User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, found := l.limiters[u.ID.String()]; found {
+		if lim := e.Value.(*limiter); lim.refs > 0 {
+			lim.refs--
+		}
+	}
+}
+
+// get returns the limiter for an arbitrary key, creating it if needed.
+// The scope of the limiter is the prefix of the key (e.g. "ip:"), users having none.
+func (l *limiters) get(key string, d time.Duration, b int64) *limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if e, found := l.limiters[key]; found {
+		lim := e.Value.(*limiter)
+		lim.used = now
+		l.lru.MoveToFront(e)
+		return lim
+	}
+
+	l.evict(now)
+
+	scope, _, found := strings.Cut(key, ":")
+	if !found {
+		scope = "user"
+	}
+
+	lim := &limiter{
+		TokenBucket: mlimiters.NewTokenBucket(b, d, mlimiters.NewLockNoop(), mlimiters.NewTokenBucketInMemory(), l.clock, mlimiters.NewStdLogger()),
+		key:         key,
+		scope:       scope,
+		full:        d * time.Duration(b),
+		used:        now,
+		lims:        l,
+	}
+	l.limiters[key] = l.lru.PushFront(lim)
+
+	return lim
+}
+
+// evict removes the least recently used limiters which are full again,
+// then the least recently used one if there is no room left for another.
+// Held limiters are skipped, even beyond the cap.
+// It must be called with the limiters locked.
+func (l *limiters) evict(now time.Time) {
+	for e := l.lru.Back(); e != nil; {
+		lim, prev := e.Value.(*limiter), e.Prev()
+		if now.Sub(lim.used) < lim.full && l.lru.Len() < l.max {
+			break
+		}
+
+		if lim.refs == 0 {
+			l.lru.Remove(e)
+			delete(l.limiters, lim.key)
+		}
+		e = prev
+	}
+}
+
+// touch marks a limiter as used, if it is still kept.
+func (l *limiters) touch(lim *limiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, found := l.limiters[lim.key]; found && e.Value == lim {
+		lim.used = l.clock.Now()
+		l.lru.MoveToFront(e)
+	}
+}
+
+func (l *limiters) delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, found := l.limiters[key]; found {
+		l.lru.Remove(e)
+		delete(l.limiters, key)
+	}
+}
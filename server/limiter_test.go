@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// clock is a clock which only moves when told to.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// keys returns the keys of the limiters from the most to the least recently used.
+func (l *limiters) keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, l.lru.Len())
+	for e := l.lru.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*limiter).key)
+	}
+
+	return keys
+}
+
+func TestLimitersEviction(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		// steps are run in order: a key is got, "+d" advances the clock, "!key" uses the limiter of key,
+		// "&key" holds the limiter of the user named key and "-key" releases it.
+		steps []string
+		want  []string
+	}{
+		{
+			name:  "kept while not full again",
+			max:   10,
+			steps: []string{"a", "+59s", "b"},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "evicted once full again",
+			max:   10,
+			steps: []string{"a", "+1m", "b"},
+			want:  []string{"b"},
+		},
+		{
+			name:  "kept while used",
+			max:   10,
+			steps: []string{"a", "+50s", "!a", "+50s", "b"},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "least recently used evicted beyond the cap",
+			max:   2,
+			steps: []string{"a", "b", "!a", "c"},
+			want:  []string{"c", "a"},
+		},
+		{
+			name:  "got again",
+			max:   2,
+			steps: []string{"a", "b", "a", "c"},
+			want:  []string{"c", "a"},
+		},
+		{
+			name:  "kept while held",
+			max:   10,
+			steps: []string{"&a", "+1m", "b"},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "kept while held beyond the cap",
+			max:   2,
+			steps: []string{"&a", "b", "c"},
+			want:  []string{"c", "a"},
+		},
+		{
+			name:  "held by several connections",
+			max:   10,
+			steps: []string{"&a", "&a", "-a", "+1m", "b"},
+			want:  []string{"b", "a"},
+		},
+		{
+			name:  "evicted once released",
+			max:   10,
+			steps: []string{"&a", "-a", "+1m", "b"},
+			want:  []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clock{}
+			lims := newLimiters(c, func(string) {})
+			lims.max = tt.max
+
+			got := make(map[string]*limiter)
+			users := make(map[string]*user.User)
+			for _, step := range tt.steps {
+				switch step[0] {
+				case '+':
+					d, err := time.ParseDuration(step[1:])
+					if err != nil {
+						t.Fatalf("parse step %s: %v", step, err)
+					}
+					c.advance(d)
+				case '!':
+					got[step[1:]].Limit(context.Background())
+				case '&':
+					u, found := users[step[1:]]
+					if !found {
+						u = user.NewNamed(step[1:])
+						users[step[1:]] = u
+					}
+					lims.add(u, 6*time.Second, 10)
+				case '-':
+					lims.remove(users[step[1:]])
+				default:
+					// A full bucket of 10 tokens refilled every 6s takes a minute to refill.
+					got[step] = lims.get(step, 6*time.Second, 10)
+				}
+			}
+
+			// The limiters of users are keyed by their IDs, named back for the comparison.
+			keys := lims.keys()
+			for i, key := range keys {
+				for name, u := range users {
+					if key == u.ID.String() {
+						keys[i] = name
+					}
+				}
+			}
+			if !slices.Equal(keys, tt.want) {
+				t.Fatalf("got limiters %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitersCap(t *testing.T) {
+	lims := newLimiters(&clock{}, func(string) {})
+	lims.max = 100
+
+	// Keys sprayed by clients never grow the limiters beyond the cap.
+	for i := 0; i < 1000; i++ {
+		lims.get("account:"+strconv.Itoa(i), time.Minute/2, 5)
+	}
+
+	if n := len(lims.keys()); n != lims.max {
+		t.Fatalf("got %d limiters, want %d", n, lims.max)
+	}
+}
+
+func TestLimitersSameBucket(t *testing.T) {
+	lims := newLimiters(&clock{}, func(string) {})
+
+	a := lims.get("ip:1.2.3.4", time.Minute, 1)
+	if _, err := a.Limit(context.Background()); err != nil {
+		t.Fatalf("limit: %v", err)
+	}
+
+	b := lims.get("ip:1.2.3.4", time.Minute, 1)
+	if _, err := b.Limit(context.Background()); !errors.Is(err, mlimiters.ErrLimitExhausted) {
+		t.Fatalf("got error %v, want %v", err, mlimiters.ErrLimitExhausted)
+	}
+
+	lims.delete("ip:1.2.3.4")
+	if len(lims.keys()) != 0 {
+		t.Fatal("limiter not deleted")
+	}
+}
+
+func TestLimitersRejectedScope(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "ip:1.2.3.4", want: "ip"},
+		{key: "account:alice", want: "account"},
+		{key: "bot:cn9l4sd3q5e8f8gs8ud0", want: "bot"},
+		{key: "cn9l4sd3q5e8f8gs8ud0", want: "user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			var rejected []string
+			lims := newLimiters(&clock{}, func(scope string) { rejected = append(rejected, scope) })
+
+			lim := lims.get(tt.key, time.Minute, 1)
+			lim.Limit(context.Background())
+			lim.Limit(context.Background())
+
+			if want := []string{tt.want}; !slices.Equal(rejected, want) {
+				t.Fatalf("got rejections %q, want %q", rejected, want)
+			}
+		})
+	}
+}
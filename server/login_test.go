@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/chattest"
+)
+
+const password = "correct horse battery"
+
+func TestGuestLoginNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		login string
+		taken bool
+	}{
+		{name: "free name", login: "Carol"},
+		{name: "name of an offline account", login: "Alice", taken: true},
+		{name: "look-alike of an offline account", login: "ALlCE", taken: true},
+		{name: "name of a connected guest", login: "bob", taken: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+			})
+			srv.Login("Bob").Connect()
+
+			t.Run("form", func(t *testing.T) {
+				resp := newBrowser(t, srv).post("/login", url.Values{"name": {tt.login}})
+				want := http.StatusFound
+				if tt.taken {
+					want = http.StatusUnprocessableEntity
+				}
+				if resp.StatusCode != want {
+					t.Fatalf("got status %d, want %d", resp.StatusCode, want)
+				}
+			})
+
+			t.Run("api", func(t *testing.T) {
+				_, err := chatclient.Login(context.Background(), srv.URL, tt.login, "", srv.Client())
+				var apiErr *chatclient.APIError
+				if tt.taken != errors.As(err, &apiErr) {
+					t.Fatalf("got error %v, want taken %t", err, tt.taken)
+				}
+			})
+		})
+	}
+}
+
+func TestAccountLogin(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+	})
+
+	// The owner of the account still logs in with its name, as guests are turned away.
+	if name := srv.LoginAccount("alice", password).Connect().Name(); name != "Alice" {
+		t.Fatalf("logged in as %q, want Alice", name)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+	})
+
+	// Five attempts per account, whatever their outcome.
+	for i := 0; i < 5; i++ {
+		_, err := chatclient.Login(context.Background(), srv.URL, "Alice", "wrong password", srv.Client())
+		var apiErr *chatclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.Code != "invalid_credentials" {
+			t.Fatalf("attempt %d: got error %v, want invalid_credentials", i, err)
+		}
+	}
+
+	_, err := chatclient.Login(context.Background(), srv.URL, "Alice", password, srv.Client())
+	var apiErr *chatclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "rate_limited" {
+		t.Fatalf("got error %v, want rate_limited", err)
+	}
+
+	// One attempt every 30s.
+	srv.Clock.Advance(30 * time.Second)
+	srv.LoginAccount("Alice", password)
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	srv := chattest.NewServer(t, chattest.Options{})
+
+	session, err := chatclient.Login(ctx, srv.URL, "Alice", "", srv.Client())
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	c, err := chatclient.New(chatclient.Config{URL: srv.URL, TokenSource: session.Token, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	if err := session.Logout(ctx); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+
+	// The session is revoked along with its access token.
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "access token", call: func() error { _, err := c.Me(ctx); return err }},
+		{name: "logout again", call: func() error { return session.Logout(ctx) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, chatclient.ErrUnauthorized) {
+				t.Fatalf("got error %v, want %v", err, chatclient.ErrUnauthorized)
+			}
+		})
+	}
+}
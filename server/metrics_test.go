@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mgjules/chat-demo/chattest"
+)
+
+// scrape gets the metrics of the server with an authorization header, if any.
+func scrape(t *testing.T, srv *chattest.Server, authorization string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "public", wantStatus: http.StatusOK},
+		{name: "token", env: map[string]string{"METRICS_TOKEN": "secret"}, authorization: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "missing token", env: map[string]string{"METRICS_TOKEN": "secret"}, wantStatus: http.StatusUnauthorized},
+		// The metrics are served on their own listener instead.
+		{name: "own listener", env: map[string]string{"METRICS_ADDR": "127.0.0.1:0"}, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{Env: tt.env})
+
+			if status, _ := scrape(t, srv, tt.authorization); status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	alice := srv.Login("Alice").Connect()
+
+	// The fourth message within 5 seconds is rate limited.
+	for _, content := range []string{"one", "two", "three"} {
+		alice.Send(content)
+		alice.ExpectMessage(content)
+	}
+	alice.Send("four")
+	alice.ExpectError("rate_limited")
+
+	_, body := scrape(t, srv, "")
+	for _, want := range []string{
+		`chat_clients{room="general"} 1`,
+		`chat_connects_total{room="general"} 1`,
+		`chat_messages_received_total{room="general"} 3`,
+		`chat_broadcasts_total{event="message",room="general"} 3`,
+		`chat_rate_limit_rejections_total{scope="user"} 1`,
+		// Requests are recorded by route once served, the websocket once closed.
+		`chat_http_request_duration_seconds_count{method="POST",route="/api/v1/sessions",status="201"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("got metrics without %s:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/metrics"
+	"github.com/mgjules/chat-demo/templates"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+)
+
+// responder sends command output over the connection of a client (e.g. a websocket or an SSH session).
+type responder struct {
+	w    io.Writer
+	room *chat.Room
+	enc  chat.Encoder
+}
+
+// Reply implements the command.Responder interface.
+func (r *responder) Reply(ctx context.Context, lines ...string) error {
+	if err := r.enc.Encode(ctx, r.w, nil, chat.NoticeEvent{Lines: lines}); err != nil {
+		return fmt.Errorf("send notice: %w", err)
+	}
+
+	return nil
+}
+
+// Broadcast implements the command.Responder interface.
+func (r *responder) Broadcast(ctx context.Context, msg *chat.Message) error {
+	broadcast(ctx, r.room, msg)
+	return nil
+}
+
+// UpdateUser implements the command.Responder interface.
+func (r *responder) UpdateUser(ctx context.Context, u *user.User) error {
+	if err := r.enc.Encode(ctx, r.w, u, chat.UserEvent{User: u}); err != nil {
+		return fmt.Errorf("send user: %w", err)
+	}
+
+	return nil
+}
+
+// broadcast adds the message to the room and sends it to all clients.
+func broadcast(ctx context.Context, room *chat.Room, msg *chat.Message) {
+	room.AddMessage(msg)
+	room.Broadcast(ctx, chat.MessageEvent{Message: msg})
+}
+
+// htmlEncoder encodes events as HTML fragments swapped by htmx.
+type htmlEncoder struct {
+	// metrics records the renders, whatever the context of the events (e.g. an SSH session).
+	metrics *metrics.Metrics
+}
+
+// fragment is a template rendered as a fragment of the page.
+type fragment struct {
+	name string
+	c    templ.Component
+}
+
+// Encode implements the chat.Encoder interface.
+func (enc htmlEncoder) Encode(ctx context.Context, w io.Writer, to *user.User, e chat.Event) error {
+	ctx = metrics.AddToContext(ctx, enc.metrics)
+
+	var fragments []fragment
+	switch e := e.(type) {
+	case chat.MessageEvent:
+		fragments = append(fragments, fragment{"ChatMessageWrapped", templates.ChatMessageWrapped(to, e.Message)})
+	case chat.ReadyEvent:
+		// Unlock global lock.
+		fragments = append(fragments, fragment{"ChatGlobalError", templates.ChatGlobalError(nil)}, fragment{"ChatForm", templates.ChatForm(nil)})
+	case chat.NumUsersEvent:
+		fragments = append(fragments, fragment{"ChatHeaderNumUsers", templates.ChatHeaderNumUsers(e.NumUsers)})
+	case chat.UserEvent:
+		// The session cookie cannot be set over the websocket.
+		fragments = append(fragments, fragment{"ChatHeaderUserName", templates.ChatHeaderUserName(e.User.Name)}, fragment{"ChatSessionRefresh", templates.ChatSessionRefresh()})
+	case chat.NoticeEvent:
+		fragments = append(fragments, fragment{"ChatNotice", templates.ChatNotice(e.Lines)})
+	case chat.TypingEvent:
+		fragments = append(fragments, fragment{"ChatTyping", templates.ChatTyping(e.User.Name)})
+	case chat.ReactionEvent:
+		fragments = append(fragments, fragment{"ChatReactions", templates.ChatReactions(to, e.Message)})
+	case chat.ReadEvent:
+		fragments = append(fragments, fragment{"ChatMessageRead", templates.ChatMessageRead(e.Message)})
+	case chat.ErrorEvent:
+		return renderError(ctx, w, e.Err)
+	}
+
+	for _, f := range fragments {
+		if err := render(ctx, w, f.name, f.c); err != nil {
+			return fmt.Errorf("render %T template: %w", e, err)
+		}
+	}
+
+	return nil
+}
+
+// render renders a template, recording how long it took in the metrics of the context.
+func render(ctx context.Context, w io.Writer, name string, c templ.Component) error {
+	start := time.Now()
+	err := c.Render(ctx, w)
+	metrics.FromContext(ctx).ObserveRender(name, time.Since(start))
+
+	return err
+}
+
+// renderError informs the user about an error either globally or at the form level.
+// Errors which are not chat errors are logged and reported as unknown errors.
+func renderError(ctx context.Context, w io.Writer, err error) error {
+	var cErr chat.Error
+	if !errors.As(err, &cErr) {
+		slog.ErrorContext(ctx, "unexpected error", "err", err)
+		cErr = chat.ErrUnknown
+	}
+
+	if cErr.IsGlobal() {
+		if err := render(ctx, w, "ChatGlobalError", templates.ChatGlobalError(&cErr)); err != nil {
+			return fmt.Errorf("render global error template: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := render(ctx, w, "ChatForm", templates.ChatForm(&cErr)); err != nil {
+		return fmt.Errorf("render form template: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/a-h/templ"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/websocket"
+)
+
+var errUntrustedOrigin = errors.New("untrusted origin")
+
+// origins is the allowlist of origins trusted on top of the origin of the server.
+type origins []string
+
+// loadOrigins reads the allowlist of trusted origins from the environment.
+func loadOrigins(getenv env) origins {
+	var o origins
+	for _, origin := range strings.Split(getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			o = append(o, origin)
+		}
+	}
+
+	return o
+}
+
+// trusted checks if the Origin header of a request is the origin of the server or is allowlisted.
+func (o origins) trusted(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	u, err := url.Parse(origin)
+	if origin == "" || err != nil {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range o {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// websocketServer serves a websocket handler, rejecting handshakes from untrusted origins
+// to prevent cross-site websocket hijacking. It also selects the subprotocol of the connection.
+func (o origins) websocketServer(handler websocket.Handler) websocket.Server {
+	return websocket.Server{
+		Handler: handler,
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			if !o.trusted(r) {
+				slog.WarnContext(r.Context(), "rejected websocket handshake", "origin", r.Header.Get("Origin"))
+				return errUntrustedOrigin
+			}
+
+			// Only answer with a subprotocol if the client requested one.
+			if len(cfg.Protocol) > 0 {
+				p, err := protocol.NegotiateSubprotocol(cfg.Protocol)
+				if err != nil {
+					return err
+				}
+				cfg.Protocol = []string{p}
+			}
+
+			var err error
+			cfg.Origin, err = websocket.Origin(cfg, r)
+			return err
+		},
+	}
+}
+
+// contentSecurityPolicy allows the CDNs the pages load their scripts and styles from.
+// Alpine and htmx evaluate expressions from attributes which requires unsafe-eval
+// and UnoCSS injects its styles at runtime which requires unsafe-inline styles.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-%s' 'unsafe-eval' https://cdn.jsdelivr.net https://unpkg.com https://esm.sh; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"connect-src 'self' https://esm.sh; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// securityHeaders sets the security headers of all responses.
+// Inline scripts are allowed with a nonce available to templates through templ.GetNonce.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, "failed to generate nonce", http.StatusInternalServerError)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(b)
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", fmt.Sprintf(contentSecurityPolicy, nonce))
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		if r.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=63072000")
+		}
+
+		next.ServeHTTP(w, r.WithContext(templ.WithNonce(r.Context(), nonce)))
+	})
+}
+
+// except skips a middleware for the requests whose path has one of the prefixes
+// (e.g. incoming webhooks, which are authenticated by their URL rather than a cookie).
+func except(mw func(http.Handler) http.Handler, prefixes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
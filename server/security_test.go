@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/net/websocket"
+)
+
+// mapEnv looks up environment variables in a map.
+func mapEnv(vars map[string]string) env {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoadCookieConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		want    cookieConfig
+		wantErr bool
+	}{
+		{name: "defaults", want: cookieConfig{secure: true, httpOnly: true, sameSite: http.SameSiteLaxMode}},
+		{
+			name: "local development",
+			vars: map[string]string{"COOKIE_SECURE": "false", "COOKIE_HTTPONLY": "false", "COOKIE_SAMESITE": "Strict"},
+			want: cookieConfig{sameSite: http.SameSiteStrictMode},
+		},
+		{
+			name: "host prefix",
+			vars: map[string]string{"COOKIE_PREFIX": "__Host-", "COOKIE_SAMESITE": "none"},
+			want: cookieConfig{secure: true, httpOnly: true, sameSite: http.SameSiteNoneMode, prefix: "__Host-"},
+		},
+		{
+			name: "secure prefix with a domain",
+			vars: map[string]string{"COOKIE_PREFIX": "__Secure-", "COOKIE_DOMAIN": "example.com"},
+			want: cookieConfig{secure: true, httpOnly: true, sameSite: http.SameSiteLaxMode, prefix: "__Secure-", domain: "example.com"},
+		},
+		{name: "invalid same site", vars: map[string]string{"COOKIE_SAMESITE": "sometimes"}, wantErr: true},
+		{name: "same site none without secure", vars: map[string]string{"COOKIE_SAMESITE": "none", "COOKIE_SECURE": "false"}, wantErr: true},
+		{name: "host prefix with a domain", vars: map[string]string{"COOKIE_PREFIX": "__Host-", "COOKIE_DOMAIN": "example.com"}, wantErr: true},
+		{name: "host prefix without secure", vars: map[string]string{"COOKIE_PREFIX": "__Host-", "COOKIE_SECURE": "false"}, wantErr: true},
+		{name: "secure prefix without secure", vars: map[string]string{"COOKIE_PREFIX": "__Secure-", "COOKIE_SECURE": "false"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := loadCookieConfig(mapEnv(tt.vars))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && *c != tt.want {
+				t.Fatalf("got config %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieConfig(t *testing.T) {
+	c := &cookieConfig{secure: true, sameSite: http.SameSiteStrictMode, prefix: "__Host-"}
+
+	rec := httptest.NewRecorder()
+	c.set(rec, "refresh", "secret", time.Now().Add(time.Hour))
+	c.clear(rec, "jwt")
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("got %d cookies, want 2", len(cookies))
+	}
+	set, cleared := cookies[0], cookies[1]
+	if set.Name != "__Host-refresh" || set.Value != "secret" || set.Path != "/" || !set.Secure || !set.HttpOnly || set.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("got cookie %v", set)
+	}
+	if cleared.Name != "__Host-jwt" || cleared.MaxAge != -1 {
+		t.Fatalf("got cookie %v, want it cleared", cleared)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh", Value: "unprefixed"})
+	req.AddCookie(&http.Cookie{Name: "__Host-jwt", Value: ""})
+	if v, found := c.value(req, "refresh"); found {
+		t.Fatalf("got value %q of a cookie without the prefix", v)
+	}
+	if _, found := c.value(req, "jwt"); found {
+		t.Fatal("found an empty cookie")
+	}
+}
+
+func TestOriginsTrusted(t *testing.T) {
+	o := loadOrigins(mapEnv(map[string]string{"ALLOWED_ORIGINS": " https://app.example.com/ ,,http://localhost:3000"}))
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://chat.example.com", want: true},
+		{origin: "http://CHAT.example.com", want: true},
+		{origin: "https://app.example.com", want: true},
+		{origin: "http://localhost:3000", want: true},
+		{origin: "https://chat.example.com.evil.com"},
+		{origin: "http://app.example.com"},
+		{origin: "http://localhost:3001"},
+		{origin: "null"},
+		{origin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://chat.example.com/login", nil)
+			req.Header.Set("Origin", tt.origin)
+
+			if got := o.trusted(req); got != tt.want {
+				t.Fatalf("got trusted %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	o := loadOrigins(mapEnv(map[string]string{"ALLOWED_ORIGINS": "https://app.example.com"}))
+	ts := httptest.NewServer(o.websocketServer(func(ws *websocket.Conn) {
+		websocket.Message.Send(ws, strings.Join(ws.Config().Protocol, ","))
+		ws.Close()
+	}))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	tests := []struct {
+		name         string
+		origin       string
+		protocols    []string
+		wantProtocol string
+		wantErr      bool
+	}{
+		{name: "same origin", origin: ts.URL},
+		{name: "allowed origin", origin: "https://app.example.com"},
+		{name: "subprotocol", origin: ts.URL, protocols: []string{"unknown", protocol.SubprotocolJSON}, wantProtocol: protocol.SubprotocolJSON},
+		{name: "unknown subprotocol", origin: ts.URL, protocols: []string{"unknown"}, wantErr: true},
+		{name: "untrusted origin", origin: "https://evil.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := websocket.NewConfig(wsURL, tt.origin)
+			if err != nil {
+				t.Fatalf("create config: %v", err)
+			}
+			cfg.Protocol = tt.protocols
+
+			ws, err := websocket.DialConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+
+			var got string
+			if err := websocket.Message.Receive(ws, &got); err != nil {
+				t.Fatalf("receive: %v", err)
+			}
+			if got != tt.wantProtocol {
+				t.Fatalf("got subprotocol %q, want %q", got, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	for _, secure := range []bool{false, true} {
+		var nonce string
+		h := securityHeaders(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			nonce = templ.GetNonce(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		hdr := rec.Header()
+		if nonce == "" || !strings.Contains(hdr.Get("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+			t.Fatalf("got policy %q, want the nonce %q of the templates", hdr.Get("Content-Security-Policy"), nonce)
+		}
+		for name, want := range map[string]string{
+			"X-Frame-Options":        "DENY",
+			"X-Content-Type-Options": "nosniff",
+			"Referrer-Policy":        "same-origin",
+		} {
+			if got := hdr.Get(name); got != want {
+				t.Fatalf("got %s %q, want %q", name, got, want)
+			}
+		}
+		if got := hdr.Get("Strict-Transport-Security") != ""; got != secure {
+			t.Fatalf("got HSTS %t over TLS %t", got, secure)
+		}
+	}
+}
+
+func TestExcept(t *testing.T) {
+	deny := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusForbidden) })
+	}
+	h := except(deny, "/hooks/", "/api/v1/sessions")(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for path, want := range map[string]int{
+		"/hooks/abc":               http.StatusOK,
+		"/api/v1/sessions/refresh": http.StatusOK,
+		"/login":                   http.StatusForbidden,
+		"/api/v1/hooks/abc":        http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: got status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-faker/faker/v4"
+	"github.com/go-faker/faker/v4/pkg/options"
+	"github.com/joho/godotenv"
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/csrf"
+	"github.com/mgjules/chat-demo/metrics"
+	"github.com/mgjules/chat-demo/oidc"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/templates"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/mgjules/chat-demo/webhook"
+	"golang.org/x/exp/slog"
+)
+
+// Options configures a server on top of its environment.
+type Options struct {
+	// Getenv looks up the environment variables configuring the server, os.Getenv when nil.
+	Getenv func(key string) string
+	// Clock is the clock of the rate limits and of the SSO login attempts, the system clock when nil.
+	Clock Clock
+	// SeedMessages is the number of random messages the room starts with.
+	SeedMessages int
+	// MaxClients is the number of clients the room holds, the default of the rooms when zero.
+	MaxClients uint64
+}
+
+// Clock tells the time to the rate limits and SSO login attempts so that tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// Server is the chat along with its web, SSH and IRC interfaces.
+type Server struct {
+	handler http.Handler
+	port    string
+
+	ssh     *sshServer
+	sshPort string
+	irc     *ircServer
+	ircPort string
+
+	metrics     http.Handler
+	metricsAddr string
+}
+
+// metricsPath is the path of the Prometheus metrics.
+const metricsPath = "/metrics"
+
+// Run serves the chat configured by the environment (and the .env file if present).
+func Run() error {
+	// Load .env file is present.
+	godotenv.Load()
+
+	s, err := New(context.Background(), Options{SeedMessages: 1000})
+	if err != nil {
+		return err
+	}
+
+	return s.ListenAndServe()
+}
+
+// New creates the server of the chat.
+// Its background work (e.g. webhook deliveries, key rotations) stops once ctx is done.
+func New(ctx context.Context, o Options) (*Server, error) {
+	getenv := env(os.Getenv)
+	if o.Getenv != nil {
+		getenv = o.Getenv
+	}
+
+	var clock Clock = mlimiters.NewSystemClock()
+	if o.Clock != nil {
+		clock = o.Clock
+	}
+
+	keys, err := loadKeys(ctx, getenv)
+	if err != nil {
+		return nil, fmt.Errorf("load jwt keys: %w", err)
+	}
+
+	port := getenv("HTTP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	m := metrics.New()
+	opts := &authOptions{
+		guests:   getenv("AUTH_GUESTS") != "false",
+		throttle: newLimiters(clock, m.RateLimited),
+	}
+	if path := getenv("AUTH_ACCOUNTS_FILE"); path != "" {
+		accounts, err := account.NewFileStore(path)
+		if err != nil {
+			return nil, fmt.Errorf("load accounts: %w", err)
+		}
+		opts.accounts = accounts
+	}
+	if admins := getenv("AUTH_ADMINS"); admins != "" {
+		opts.admins = strings.Split(admins, ",")
+	}
+	if moderators := getenv("AUTH_MODERATORS"); moderators != "" {
+		opts.moderators = strings.Split(moderators, ",")
+	}
+	if issuer := getenv("OIDC_ISSUER"); issuer != "" {
+		provider, err := oidc.NewProvider(ctx, oidc.Config{
+			Issuer:          issuer,
+			ClientID:        getenv("OIDC_CLIENT_ID"),
+			ClientSecret:    getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:     getenv("OIDC_REDIRECT_URL"),
+			Scopes:          strings.Fields(getenv.or("OIDC_SCOPES", "profile email")),
+			RoleClaim:       getenv("OIDC_ROLE_CLAIM"),
+			AdminValues:     strings.Fields(getenv("OIDC_ADMIN_VALUES")),
+			ModeratorValues: strings.Fields(getenv("OIDC_MODERATOR_VALUES")),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create oidc provider: %w", err)
+		}
+		opts.sso = provider
+		opts.ssoName = getenv.or("OIDC_NAME", "SSO")
+	}
+	if !opts.guests && opts.accounts == nil && opts.sso == nil {
+		return nil, errors.New("one of AUTH_GUESTS, AUTH_ACCOUNTS_FILE or OIDC_ISSUER must be enabled")
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(m.Middleware)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.CleanPath)
+	r.Use(middleware.StripSlashes)
+	r.Use(middleware.Compress(5))
+	r.Use(middleware.RequestSize(32000))
+	r.Use(middleware.Heartbeat("/ping"))
+	r.Use(securityHeaders)
+
+	room := chat.NewRoom(chat.RoomOptions{MaxClients: o.MaxClients, NameOwner: opts.nameOwner})
+	// Seeding random messages in room.
+	for i := 0; i < o.SeedMessages; i++ {
+		msg, _ := chat.NewMessage(
+			user.New(),
+			faker.Sentence(options.WithGenerateUniqueValues(false)),
+		)
+		room.AddMessage(msg)
+	}
+	room.Instrument(m.Room(defaultRoom))
+
+	refreshTTL, err := time.ParseDuration(getenv.or("JWT_REFRESH_TTL", "168h"))
+	if err != nil {
+		return nil, fmt.Errorf("parse JWT_REFRESH_TTL: %w", err)
+	}
+
+	cookies, err := loadCookieConfig(getenv)
+	if err != nil {
+		return nil, fmt.Errorf("load cookie config: %w", err)
+	}
+
+	lims := newLimiters(clock, m.RateLimited)
+	a := &auth{
+		keys:     keys,
+		sessions: session.NewManager(keys.TTL(), refreshTTL),
+		cookies:  cookies,
+	}
+	r.Use(a.verifier)
+
+	allowed := loadOrigins(getenv)
+	r.Use(except(csrf.Protect(*cookies.cookie("csrf", "", time.Time{}), allowed.trusted), incomingPath, sessionsPath))
+
+	cmds := command.NewRegistry()
+	if err := cmds.Register(command.Builtins()...); err != nil {
+		return nil, fmt.Errorf("register builtin commands: %w", err)
+	}
+
+	bots, err := bot.NewFileStore(getenv("BOTS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("load bots: %w", err)
+	}
+
+	// Protected routes.
+	r.Group(func(r chi.Router) {
+		r.Use(protected(a, room))
+
+		r.Get("/", index(room))
+		r.Post("/session", refreshSession(a, room))
+		r.Get("/sessions", listSessions(a.sessions))
+		r.Post("/sessions/{id}/revoke", revokeSession(a))
+		r.Post("/logout", logout(a))
+	})
+
+	// Bots connect to the chatroom with their API key rather than a session.
+	r.With(allowBots(bots, defaultRoom, protected(a, room)), negotiate).
+		Handle("/chatroom", allowed.websocketServer(chatroom(room, a, bots, lims, cmds)))
+
+	// Clients which cannot open a websocket fall back to an event stream.
+	ss := newStreams()
+	r.With(allowBots(bots, defaultRoom, protected(a, room)), negotiate).
+		Get(eventsPath, chatroomEvents(room, a, bots, lims, cmds, ss))
+	r.With(allowBots(bots, defaultRoom, protected(a, room))).
+		Post(eventsPath, chatroomFrames(ss))
+
+	r.Post("/session/refresh", renewSession(a, room))
+
+	webhooks, err := webhook.NewFileStore(getenv("WEBHOOKS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+
+	// Deliveries queued before a restart are resumed.
+	dispatcher := webhook.NewDispatcher(webhooks, webhook.Options{})
+	go dispatcher.Run(ctx)
+	room.Observe(dispatcher.Observer(defaultRoom))
+
+	v1 := &api{
+		auth:       a,
+		opts:       opts,
+		bots:       bots,
+		webhooks:   webhooks,
+		incoming:   webhooks,
+		dispatcher: dispatcher,
+		rooms:      map[string]*chat.Room{defaultRoom: room},
+		lims:       lims,
+	}
+	r.Route("/api/v1", v1.routes)
+	r.Post(incomingPath+"{incoming}/{secret}", v1.postIncoming)
+
+	r.Get("/login", login(a, room, opts))
+	r.Post("/login", login(a, room, opts))
+	if opts.accounts != nil {
+		r.Post("/login/account", loginAccount(a, opts))
+		r.Get("/register", register(a, opts))
+		r.Post("/register", register(a, opts))
+	}
+	if opts.sso != nil {
+		r.Get("/login/oidc", ssoLogin(cookies, opts.sso, clock))
+		r.Get("/login/oidc/callback", ssoCallback(a, room, opts.sso, clock))
+	}
+	r.Get("/.well-known/jwks.json", jwks(keys))
+
+	s := &Server{
+		handler:     r,
+		port:        port,
+		sshPort:     getenv("SSH_PORT"),
+		ircPort:     getenv("IRC_PORT"),
+		metricsAddr: getenv("METRICS_ADDR"),
+	}
+
+	// Metrics are served on their own listener, out of reach of the public, when it is set.
+	s.metrics = m.Handler(getenv("METRICS_TOKEN"))
+	if s.metricsAddr == "" {
+		r.Get(metricsPath, s.metrics.ServeHTTP)
+	}
+
+	if s.sshPort != "" {
+		s.ssh, err = newSSHServer(getenv("SSH_AUTHORIZED_KEYS"), getenv("SSH_HOST_KEY_FILE"), room, opts, lims, cmds)
+		if err != nil {
+			return nil, fmt.Errorf("create ssh server: %w", err)
+		}
+	}
+
+	if s.ircPort != "" {
+		s.irc = newIRCServer(v1.rooms, bots, lims)
+	}
+
+	return s, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the chat on the HTTP port as well as on the SSH, IRC and metrics ports if set.
+func (s *Server) ListenAndServe() error {
+	if s.ssh != nil {
+		slog.Info("Running SSH server...", "addr", ":"+s.sshPort)
+		go func() {
+			if err := s.ssh.ListenAndServe(":" + s.sshPort); err != nil {
+				slog.Error("Failed to run SSH server", "err", err)
+			}
+		}()
+	}
+
+	if s.irc != nil {
+		slog.Info("Running IRC server...", "addr", ":"+s.ircPort)
+		go func() {
+			if err := s.irc.ListenAndServe(":" + s.ircPort); err != nil {
+				slog.Error("Failed to run IRC server", "err", err)
+			}
+		}()
+	}
+
+	if s.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(metricsPath, s.metrics)
+		srv := &http.Server{
+			Addr:         s.metricsAddr,
+			Handler:      mux,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		slog.Info("Running metrics server...", "addr", "http://"+srv.Addr+metricsPath)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				slog.Error("Failed to run metrics server", "err", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:         ":" + s.port,
+		Handler:      s.handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	slog.Info("Running server...", "addr", "http://"+server.Addr)
+	return server.ListenAndServe()
+}
+
+// defaultRoom is the ID of the room of the chat.
+const defaultRoom = "general"
+
+// authOptions configures how users can log in.
+type authOptions struct {
+	// guests allows anyone to join by only picking a display name.
+	guests bool
+	// accounts enables registration and password login when set.
+	accounts account.Store
+	// admins is the list of account names granted the admin role.
+	admins []string
+	// moderators is the list of account names granted the moderator role.
+	moderators []string
+	// sso enables single sign-on with an OpenID Connect provider when set.
+	sso *oidc.Provider
+	// ssoName is the name of the identity provider shown to users.
+	ssoName string
+	// throttle limits login attempts per account and per IP.
+	throttle *limiters
+}
+
+// env looks up the environment variables configuring the server.
+type env func(key string) string
+
+// or returns the value of the environment variable key or def if it is empty.
+func (getenv env) or(key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
+
+func login(a *auth, room *chat.Room, opts *authOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Only skip the login if the session would be accepted by protected routes
+		// otherwise both would redirect to each other.
+		if _, err := a.authenticate(r); err == nil {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		view := templates.LoginView{
+			Guests:   opts.guests,
+			Accounts: opts.accounts != nil,
+			SSOName:  opts.ssoName,
+		}
+
+		// Let the user pick a name, suggesting a random one.
+		if r.Method != http.MethodPost {
+			view.Name = user.RandomName()
+			renderLogin(w, r, http.StatusOK, view)
+			return
+		}
+
+		if !opts.guests {
+			http.Error(w, "guest login is disabled", http.StatusForbidden)
+			return
+		}
+
+		view.Name = r.PostFormValue("name")
+		name, err := user.NormalizeName(view.Name)
+		if err != nil {
+			view.GuestError = err.Error()
+			renderLogin(w, r, http.StatusUnprocessableEntity, view)
+			return
+		}
+
+		u := user.NewNamed(name)
+		if room.IsNameTaken(u.Name, u.ID) {
+			view.GuestError = chat.ErrNameTaken.Error()
+			renderLogin(w, r, http.StatusUnprocessableEntity, view)
+			return
+		}
+
+		if err := a.startSession(w, r, u); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, view templates.LoginView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := render(r.Context(), w, "LoginPage", templates.LoginPage(view)); err != nil {
+		slog.ErrorContext(r.Context(), "render login template", "err", err)
+		w.Write([]byte("failed to render login template"))
+	}
+}
+
+// refreshSession reissues the session cookie of a user whose
+// information changed while connected to the room.
+func refreshSession(a *auth, room *chat.Room) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := session.FromContext(r.Context())
+		u, found := room.User(claims.User.ID)
+		if !found {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Keep the same session, only its user changed.
+		if _, _, err := a.issueToken(w, session.NewClaims(claims.ID, u)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func protected(a *auth, room *chat.Room) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := a.authenticate(r)
+
+			// Silently renew expired sessions of browsers navigating to a page.
+			if (errors.Is(err, session.ErrExpired) || errors.Is(err, session.ErrMissing)) && isNavigation(r) {
+				if renewed, _, rerr := a.renew(w, r, room); rerr == nil {
+					claims, err = renewed, nil
+				}
+			}
+
+			if err != nil {
+				slog.InfoContext(r.Context(), "unauthenticated request", "err", err, "path", r.URL.Path)
+				unauthorized(w, r, err)
+				return
+			}
+
+			a.sessions.Touch(claims, r.UserAgent(), clientIP(r))
+
+			// Add the session and its user to the request context.
+			ctx := session.AddToContext(r.Context(), claims)
+			ctx = user.AddToContext(ctx, claims.User)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// unauthorized redirects browsers navigating to a page to the login page.
+// Other requests (e.g. websocket, htmx or API requests) cannot follow
+// a redirect to a login page and receive a 401 instead.
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if isNavigation(r) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	reason := sessionReason(err)
+	if reason == nil || reason == session.ErrMissing {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+reason.Error()+`"`)
+	http.Error(w, reason.Error(), http.StatusUnauthorized)
+}
+
+// sessionReason returns the session error which caused err if any.
+func sessionReason(err error) error {
+	for _, e := range []error{session.ErrMissing, session.ErrExpired, session.ErrUnsupportedVersion, session.ErrRevoked, session.ErrRefreshInvalid, session.ErrMalformed} {
+		if errors.Is(err, e) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// isNavigation checks if the request is a browser navigating to a page.
+// Other requests (e.g. websocket, htmx or API requests) cannot follow a redirect to a login page.
+func isNavigation(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Upgrade") == "" &&
+		r.Header.Get("HX-Request") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func index(room *chat.Room) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := user.FromContext(ctx)
+
+		// We lock the chat until we get a web socket connection.
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := render(ctx, w, "Page", templates.Page(user, room, session.FromContext(ctx).ExpiresAt, &chat.ErrLoading)); err != nil {
+			slog.ErrorContext(ctx, "render index template", "err", err, "user.id", user.ID)
+			w.Write([]byte("failed to render index template"))
+		}
+	}
+}
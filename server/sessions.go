@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/templates"
+	"golang.org/x/exp/slog"
+)
+
+// renewSession exchanges the refresh token for a new access token.
+// The access token is also returned so that the page can re-authenticate its websocket.
+func renewSession(a *auth, room *chat.Room) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		claims, t, err := a.renew(w, r, room)
+		if err != nil {
+			if !errors.Is(err, session.ErrMissing) && !errors.Is(err, session.ErrRefreshInvalid) {
+				slog.WarnContext(ctx, "renew session", "err", err)
+			}
+			a.clearCookies(w)
+			unauthorized(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"token":      t,
+			"expires_at": claims.ExpiresAt.Unix(),
+		}); err != nil {
+			slog.ErrorContext(ctx, "encode renewed session", "err", err)
+		}
+	}
+}
+
+func logout(a *auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.sessions.Revoke(session.FromContext(r.Context()).ID)
+		a.clearCookies(w)
+
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+}
+
+func listSessions(sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		claims := session.FromContext(ctx)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := render(ctx, w, "SessionsPage", templates.SessionsPage(claims.ID, sessions.Sessions(claims.User.ID))); err != nil {
+			slog.ErrorContext(ctx, "render sessions template", "err", err)
+			w.Write([]byte("failed to render sessions template"))
+		}
+	}
+}
+
+// revokeSession revokes one of the sessions of the user.
+// Open websockets of the session are closed by the chatroom handler.
+func revokeSession(a *auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := session.FromContext(r.Context())
+
+		// Do not reveal the sessions of other users.
+		s, found := a.sessions.Get(chi.URLParam(r, "id"))
+		if !found || s.User.ID != claims.User.ID {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		a.sessions.Revoke(s.ID)
+		slog.InfoContext(r.Context(), "revoked session", "user.id", s.User.ID, "session.id", s.ID)
+
+		if s.ID == claims.ID {
+			a.clearCookies(w)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		http.Redirect(w, r, "/sessions", http.StatusFound)
+	}
+}
@@ -0,0 +1,329 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/net/websocket"
+)
+
+// loginBrowser logs in a new browser with an account.
+func loginBrowser(t *testing.T, srv *chattest.Server, name string) *browser {
+	t.Helper()
+
+	b := newBrowser(t, srv)
+	resp := b.post("/login/account", url.Values{"name": {name}, "password": {password}})
+	if resp.StatusCode != http.StatusFound || b.cookie("jwt") == "" {
+		t.Fatalf("log in as %s: got status %d: %s", name, resp.StatusCode, body(t, resp))
+	}
+
+	return b
+}
+
+// sessionID returns the ID of the session of the access token of the browser.
+func sessionID(t *testing.T, b *browser) string {
+	t.Helper()
+
+	tok, err := jwt.ParseInsecure([]byte(b.cookie("jwt")))
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+
+	return tok.JwtID()
+}
+
+// dialBrowser opens the websocket of the room with the access token of the browser.
+func dialBrowser(t *testing.T, b *browser) *websocket.Conn {
+	t.Helper()
+
+	ws, err := chatclient.Dial(context.Background(), b.srv.URL, b.cookie("jwt"), protocol.SubprotocolJSON)
+	if err != nil {
+		t.Fatalf("open websocket: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
+
+// sendWS sends a JSON frame on a websocket.
+func sendWS(t *testing.T, ws *websocket.Conn, typ protocol.Type, payload any) {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal %s payload: %v", typ, err)
+	}
+	if err := websocket.JSON.Send(ws, protocol.Envelope{Type: typ, Payload: data}); err != nil {
+		t.Fatalf("send %s frame: %v", typ, err)
+	}
+}
+
+// expectWS reads the frames of a websocket until one of type typ matches.
+func expectWS(t *testing.T, ws *websocket.Conn, typ protocol.Type, match func(payload []byte) bool) {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var env protocol.Envelope
+		if err := websocket.JSON.Receive(ws, &env); err != nil {
+			t.Fatalf("expected a %s frame: %v", typ, err)
+		}
+		if env.Type == typ && match(env.Payload) {
+			return
+		}
+	}
+}
+
+// expectWSClosed reads the frames of a websocket until the server closes it.
+func expectWSClosed(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame []byte
+	for {
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			if strings.Contains(err.Error(), "timeout") {
+				t.Fatal("websocket still open")
+			}
+			return
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoke revokes the session of the phone from the laptop or from another user.
+		revoke       func(t *testing.T, srv *chattest.Server, laptop, phone *browser) *http.Response
+		wantStatus   int
+		wantLocation string
+		wantRevoked  bool
+	}{
+		{
+			name: "from another session",
+			revoke: func(t *testing.T, _ *chattest.Server, laptop, phone *browser) *http.Response {
+				return laptop.post("/sessions/"+sessionID(t, phone)+"/revoke", nil)
+			},
+			wantStatus: http.StatusFound, wantLocation: "/sessions", wantRevoked: true,
+		},
+		{
+			name: "the current session",
+			revoke: func(t *testing.T, _ *chattest.Server, _, phone *browser) *http.Response {
+				return phone.post("/sessions/"+sessionID(t, phone)+"/revoke", nil)
+			},
+			wantStatus: http.StatusFound, wantLocation: "/login", wantRevoked: true,
+		},
+		{
+			name: "logout",
+			revoke: func(_ *testing.T, _ *chattest.Server, _, phone *browser) *http.Response {
+				return phone.post("/logout", nil)
+			},
+			wantStatus: http.StatusFound, wantLocation: "/login", wantRevoked: true,
+		},
+		{
+			name: "session of another user",
+			revoke: func(t *testing.T, srv *chattest.Server, _, phone *browser) *http.Response {
+				return loginBrowser(t, srv, "Bob").post("/sessions/"+sessionID(t, phone)+"/revoke", nil)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "unknown session",
+			revoke: func(_ *testing.T, _ *chattest.Server, laptop, _ *browser) *http.Response {
+				return laptop.post("/sessions/unknown/revoke", nil)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Alice", Password: password}, {Name: "Bob", Password: password}},
+			})
+			laptop := loginBrowser(t, srv, "Alice")
+			phone := loginBrowser(t, srv, "Alice")
+
+			// Both sessions are listed.
+			page := body(t, laptop.get("/sessions"))
+			for _, b := range []*browser{laptop, phone} {
+				if !strings.Contains(page, "/sessions/"+sessionID(t, b)+"/revoke") {
+					t.Fatalf("session %s not listed:\n%s", sessionID(t, b), page)
+				}
+			}
+
+			token, refresh := phone.cookie("jwt"), phone.cookie("refresh")
+			ws := dialBrowser(t, phone)
+
+			resp := tt.revoke(t, srv, laptop, phone)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body(t, resp))
+			}
+			if loc := resp.Header.Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("redirected to %q, want %q", loc, tt.wantLocation)
+			}
+
+			// The access token of a revoked session is rejected before it expires.
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/me", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("me: %v", err)
+			}
+			resp.Body.Close()
+			wantStatus := http.StatusOK
+			if tt.wantRevoked {
+				wantStatus = http.StatusUnauthorized
+			}
+			if resp.StatusCode != wantStatus {
+				t.Fatalf("me: got status %d, want %d", resp.StatusCode, wantStatus)
+			}
+
+			if !tt.wantRevoked {
+				return
+			}
+
+			// Its websocket is closed and it cannot be renewed, even with a refresh token kept aside.
+			expectWSClosed(t, ws)
+			u, _ := url.Parse(srv.URL)
+			phone.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "refresh", Value: refresh, Path: "/"}})
+			if resp := phone.post("/session/refresh", nil); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("refresh: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+			}
+
+			// The other session is untouched.
+			if resp := laptop.get("/sessions"); resp.StatusCode != http.StatusOK {
+				t.Fatalf("sessions: got status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+	})
+	b := loginBrowser(t, srv, "Alice")
+	u, _ := url.Parse(srv.URL)
+	id, rotated := sessionID(t, b), b.cookie("refresh")
+
+	resp := b.post("/session/refresh", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh: got status %d, want %d: %s", resp.StatusCode, http.StatusOK, body(t, resp))
+	}
+	var renewed struct {
+		Token     string `json:"token"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&renewed); err != nil {
+		t.Fatalf("decode renewed session: %v", err)
+	}
+	if renewed.Token != b.cookie("jwt") || renewed.ExpiresAt <= time.Now().Unix() {
+		t.Fatalf("got token %q expiring at %d, want the new cookie", renewed.Token, renewed.ExpiresAt)
+	}
+	if sessionID(t, b) != id {
+		t.Fatal("the session changed")
+	}
+	refresh := b.cookie("refresh")
+	if refresh == "" || refresh == rotated {
+		t.Fatal("the refresh token was not rotated")
+	}
+
+	// A rotated refresh token is rejected, without revoking the session right away
+	// since two tabs may have raced to renew it.
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "refresh", Value: rotated, Path: "/"}})
+	if resp := b.post("/session/refresh", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("reuse: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "refresh", Value: refresh, Path: "/"}})
+
+	// Browsers navigating to a page without an access token are renewed silently.
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "jwt", Path: "/", MaxAge: -1}})
+	if resp := b.get("/sessions"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("navigate: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if b.cookie("jwt") == "" || sessionID(t, b) != id {
+		t.Fatal("the session was not renewed")
+	}
+
+	// Other requests are not.
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "jwt", Path: "/", MaxAge: -1}})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sessions", nil)
+	req.Header.Set("HX-Request", "true")
+	if resp := b.do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("htmx request: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Neither are browsers without a refresh token.
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "refresh", Path: "/", MaxAge: -1}})
+	if resp := b.get("/sessions"); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Fatalf("navigate: got status %d to %q, want a redirect to the login page", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestReauth(t *testing.T) {
+	tests := []struct {
+		name string
+		// token returns the token sent to re-authenticate the websocket of the browser.
+		token     func(t *testing.T, srv *chattest.Server, b *browser) string
+		wantValid bool
+	}{
+		{
+			name: "renewed token",
+			token: func(t *testing.T, _ *chattest.Server, b *browser) string {
+				if resp := b.post("/session/refresh", nil); resp.StatusCode != http.StatusOK {
+					t.Fatalf("refresh: got status %d, want %d", resp.StatusCode, http.StatusOK)
+				}
+				return b.cookie("jwt")
+			},
+			wantValid: true,
+		},
+		{
+			name: "token of another session",
+			token: func(t *testing.T, srv *chattest.Server, _ *browser) string {
+				return loginBrowser(t, srv, "Alice").cookie("jwt")
+			},
+		},
+		{
+			name:  "invalid token",
+			token: func(*testing.T, *chattest.Server, *browser) string { return "invalid" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+			})
+			b := loginBrowser(t, srv, "Alice")
+			ws := dialBrowser(t, b)
+			expectWS(t, ws, protocol.TypeReady, func([]byte) bool { return true })
+
+			sendWS(t, ws, protocol.TypeReauth, protocol.ReauthPayload{Token: tt.token(t, srv, b)})
+
+			if !tt.wantValid {
+				expectWS(t, ws, protocol.TypeError, func(payload []byte) bool {
+					var p protocol.ErrorPayload
+					return json.Unmarshal(payload, &p) == nil && p.Code == "session_expired"
+				})
+				expectWSClosed(t, ws)
+				return
+			}
+
+			sendWS(t, ws, protocol.TypeMessage, protocol.MessagePayload{Content: "still there"})
+			expectWS(t, ws, protocol.TypeMessage, func(payload []byte) bool {
+				var msg protocol.MessageEvent
+				return json.Unmarshal(payload, &msg) == nil && msg.Content == "still there"
+			})
+		})
+	}
+}
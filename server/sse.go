@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgjules/chat-demo/bot"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/metrics"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+)
+
+// eventsPath is the path of the event streams of the room and of the frames sent by their clients.
+const eventsPath = "/chatroom/events"
+
+// sseKeepAlive is how often idle event streams send a comment so that proxies keep them open.
+const sseKeepAlive = 15 * time.Second
+
+// errNoStream is returned to clients sending frames without an event stream open.
+var errNoStream = errors.New("no event stream open for this session")
+
+// streams holds the connections of the clients of event streams,
+// which send their frames with separate requests.
+type streams struct {
+	mu    sync.RWMutex
+	conns map[string]*conn
+}
+
+func newStreams() *streams {
+	return &streams{
+		conns: make(map[string]*conn),
+	}
+}
+
+func (s *streams) add(c *conn) {
+	s.mu.Lock()
+	s.conns[c.env.User.ID.String()] = c
+	s.mu.Unlock()
+}
+
+func (s *streams) remove(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c.env.User.ID.String())
+	s.mu.Unlock()
+}
+
+// get returns the connection of the session or the API key authenticating the request.
+func (s *streams) get(r *http.Request) (*conn, bool) {
+	ctx := r.Context()
+
+	s.mu.RLock()
+	c, found := s.conns[user.FromContext(ctx).ID.String()]
+	s.mu.RUnlock()
+	if !found {
+		return nil, false
+	}
+
+	if _, k := bot.FromContext(ctx); k != nil || c.key != nil {
+		return c, k != nil && c.key != nil && k.ID == c.key.ID
+	}
+
+	claims := session.FromContext(ctx)
+	return c, claims != nil && claims.ID == c.claims.ID
+}
+
+// chatroomEvents streams the events of the room to clients which cannot open a websocket
+// (e.g. behind proxies breaking upgrades). Events are encoded like the frames of the websocket
+// and the room treats both the same way.
+func chatroomEvents(room *chat.Room, a *auth, bots bot.Store, lims *limiters, cmds *command.Registry, ss *streams) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// The stream outlives the write timeout of the server.
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			slog.ErrorContext(ctx, "clear write deadline", "err", err)
+			http.Error(w, "event streams are not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var enc chat.Encoder = htmlEncoder{metrics: metrics.FromContext(ctx)}
+		if p, _ := protocol.NegotiateSubprotocol(subprotocols(r)); p == protocol.SubprotocolJSON {
+			enc = protocol.JSONEncoder{}
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		// Prevent reverse proxies (e.g. nginx) from buffering the stream.
+		h.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		s := &eventStream{w: w, rc: rc, closed: make(chan struct{})}
+		defer s.Close()
+
+		c := newConn(ctx, chat.NewConn(ctx, user.FromContext(ctx), s), room, a, enc, cmds)
+		leave, err := c.join(ctx, bots, lims)
+		if err != nil {
+			return
+		}
+		defer leave()
+
+		ss.add(c)
+		defer ss.remove(c)
+
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.closed:
+				return
+			case <-ticker.C:
+				if err := s.keepAlive(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// chatroomFrames processes a frame sent by the client of an event stream.
+// Replies (e.g. errors) are sent over the event stream, like over a websocket.
+func chatroomFrames(ss *streams) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, found := ss.get(r)
+		if !found {
+			http.Error(w, errNoStream.Error(), http.StatusConflict)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFrameSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err := c.dispatch(r.Context(), data); err != nil {
+			c.logger.ErrorContext(r.Context(), "reply error", "err", err)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// eventStream is the connection of a client over server-sent events.
+// Every write is sent as a message event and closing it ends the stream.
+type eventStream struct {
+	mu     sync.Mutex
+	w      io.Writer
+	rc     *http.ResponseController
+	closed chan struct{}
+}
+
+// Write implements the io.Writer interface.
+func (s *eventStream) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+
+	if err := s.write(b.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close implements the io.Closer interface.
+// Clients are told not to reconnect (e.g. once kicked) with a close event.
+func (s *eventStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil
+	default:
+	}
+
+	// The client may already be gone.
+	_, _ = io.WriteString(s.w, "event: close\ndata:\n\n")
+	_ = s.rc.Flush()
+	close(s.closed)
+
+	return nil
+}
+
+// keepAlive sends a comment, ignored by clients.
+func (s *eventStream) keepAlive() error {
+	return s.write([]byte(":\n\n"))
+}
+
+func (s *eventStream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return net.ErrClosed
+	default:
+	}
+
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+
+	return s.rc.Flush()
+}
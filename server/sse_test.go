@@ -0,0 +1,201 @@
+package server_test
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/user"
+)
+
+// sseEvent is an event received over an event stream.
+type sseEvent struct {
+	name string
+	data string
+}
+
+// eventStream is the event stream of a chattest client.
+type eventStream struct {
+	t      *testing.T
+	events chan sseEvent
+}
+
+// openStream opens the event stream of the client with the subprotocol, the default one when empty.
+func openStream(t *testing.T, c *chattest.Client, subprotocol string) *eventStream {
+	t.Helper()
+
+	path := "/chatroom/events"
+	if subprotocol != "" {
+		path += "?protocol=" + subprotocol
+	}
+	resp := c.Do(http.MethodGet, path, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+
+	s := &eventStream{t: t, events: make(chan sseEvent, 100)}
+	go func() {
+		defer close(s.events)
+
+		var e sseEvent
+		var data []string
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				e.data = strings.Join(data, "\n")
+				s.events <- e
+				e, data = sseEvent{}, nil
+			case strings.HasPrefix(line, ":"):
+				// Keep-alive comments are ignored.
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data:"):
+				data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			default:
+				t.Errorf("got invalid line %q", line)
+			}
+		}
+	}()
+
+	return s
+}
+
+// expect skips events until the data of one contains substr and returns it.
+func (s *eventStream) expect(substr string) sseEvent {
+	s.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				s.t.Fatalf("stream closed, expected %q", substr)
+			}
+			if strings.Contains(e.data, substr) {
+				return e
+			}
+		case <-timeout:
+			s.t.Fatalf("expected %q", substr)
+		}
+	}
+}
+
+// expectClosed skips events until the close event, then expects the stream to end.
+func (s *eventStream) expectClosed() {
+	s.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				s.t.Fatal("stream ended without a close event")
+			}
+			if e.name != "close" {
+				continue
+			}
+			if _, open := <-s.events; open {
+				s.t.Fatal("events received after the close event")
+			}
+			return
+		case <-timeout:
+			s.t.Fatal("stream still open")
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{
+		Accounts: []chattest.Account{{Name: "Carol", Password: password, Role: user.RoleModerator}},
+	})
+	alice := srv.Login("Alice")
+	stream := openStream(t, alice, protocol.SubprotocolJSON)
+	stream.expect(`"type":"ready"`)
+
+	// The clients of event streams and websockets share the room.
+	bob := srv.Login("Bob").Connect()
+	bob.Send("hello")
+	stream.expect(`"content":"hello"`)
+
+	resp := alice.Do(http.MethodPost, "/chatroom/events", strings.NewReader(`{"type":"message","id":"1","payload":{"content":"from the stream"}}`))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	bob.ExpectMessage("from the stream")
+
+	// Kicked clients are told not to reconnect.
+	srv.LoginAccount("Carol", password).Connect().Command("/kick Alice")
+	stream.expectClosed()
+}
+
+func TestEventStreamHTML(t *testing.T) {
+	srv := chattest.NewServer(t, chattest.Options{})
+	stream := openStream(t, srv.Login("Alice"), "")
+
+	// Fragments spanning several lines are sent as a single event.
+	bob := srv.Login("Bob").Connect()
+	bob.Send("hello <b>world</b>")
+	e := stream.expect("hello &lt;b&gt;world&lt;/b&gt;")
+	if e.name != "" || strings.Contains(e.data, "<b>") {
+		t.Fatalf("got event %q with data %s", e.name, e.data)
+	}
+}
+
+func TestEventFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		// otherSession sends the frame with another session of the user of the stream.
+		otherSession bool
+		wantStatus   int
+		// wantError is the code of the error sent over the stream, if any.
+		wantError string
+	}{
+		{name: "message", frame: `{"type":"message","id":"1","payload":{"content":"hello"}}`, wantStatus: http.StatusAccepted},
+		{name: "empty message", frame: `{"type":"message","id":"1","payload":{"content":" "}}`, wantStatus: http.StatusAccepted, wantError: "message_empty"},
+		{name: "invalid frame", frame: `{"payload":{}}`, wantStatus: http.StatusAccepted, wantError: "invalid_frame"},
+		{name: "too large", frame: `{"type":"message","id":"1","payload":{"content":"` + strings.Repeat("a", 4096) + `"}}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "other session", frame: `{"type":"message","id":"1","payload":{"content":"hello"}}`, otherSession: true, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := chattest.NewServer(t, chattest.Options{
+				Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+			})
+			alice := srv.LoginAccount("Alice", password)
+			stream := openStream(t, alice, protocol.SubprotocolJSON)
+			stream.expect(`"type":"ready"`)
+
+			sender := alice
+			if tt.otherSession {
+				sender = srv.LoginAccount("Alice", password)
+			}
+			resp := sender.Do(http.MethodPost, "/chatroom/events", strings.NewReader(tt.frame))
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantError != "" {
+				stream.expect(`"code":"` + tt.wantError + `"`)
+			}
+		})
+	}
+
+	t.Run("no stream", func(t *testing.T) {
+		srv := chattest.NewServer(t, chattest.Options{})
+		resp := srv.Login("Alice").Do(http.MethodPost, "/chatroom/events", strings.NewReader(`{"type":"message","payload":{"content":"hello"}}`))
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusConflict)
+		}
+	})
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	mlimiters "github.com/mennanov/limiters"
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+	"golang.org/x/term"
+)
+
+// List of limits of the SSH server.
+const (
+	// sshHandshakeTimeout is how long clients have to authenticate and open a shell.
+	sshHandshakeTimeout = 10 * time.Second
+	// sshHistory is the number of recent messages shown to users joining over SSH.
+	sshHistory = 20
+)
+
+// sshKeyExtension is the permission extension holding the fingerprint of the key of a connection.
+const sshKeyExtension = "key-fingerprint"
+
+// errUnknownKey is returned to SSH clients whose public key is not authorized.
+var errUnknownKey = errors.New("unknown public key")
+
+// sshServer serves the room over SSH with a line-based interface.
+//
+// Users authenticate with a public key listed in an authorized_keys file
+// whose comment is their name: the account with that name when accounts are enabled,
+// a guest otherwise. SSH users join the same room as websocket users.
+type sshServer struct {
+	config *ssh.ServerConfig
+	// keys maps the fingerprints of the authorized keys to their user.
+	// Guests keep the same user, and so the same restrictions (e.g. kicks), across connections.
+	keys map[string]*user.User
+	room *chat.Room
+	opts *authOptions
+	lims *limiters
+	cmds *command.Registry
+}
+
+// newSSHServer loads the authorized keys and the host key of the SSH server.
+// Without a host key file, an ephemeral host key is generated on every start.
+func newSSHServer(keysPath, hostKeyPath string, room *chat.Room, opts *authOptions, lims *limiters, cmds *command.Registry) (*sshServer, error) {
+	s := &sshServer{
+		keys: make(map[string]*user.User),
+		room: room,
+		opts: opts,
+		lims: lims,
+		cmds: cmds,
+	}
+
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		return nil, fmt.Errorf("read authorized keys: %w", err)
+	}
+	for len(strings.TrimSpace(string(data))) > 0 {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse authorized keys: %w", err)
+		}
+		data = rest
+
+		name, err := user.NormalizeName(comment)
+		if err != nil {
+			return nil, fmt.Errorf("authorized key %s: %w", ssh.FingerprintSHA256(key), err)
+		}
+		s.keys[ssh.FingerprintSHA256(key)] = user.NewNamed(name)
+	}
+
+	var signer ssh.Signer
+	if hostKeyPath != "" {
+		pem, err := os.ReadFile(hostKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read host key: %w", err)
+		}
+		if signer, err = ssh.ParsePrivateKey(pem); err != nil {
+			return nil, fmt.Errorf("parse host key: %w", err)
+		}
+	} else {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate host key: %w", err)
+		}
+		if signer, err = ssh.NewSignerFromKey(key); err != nil {
+			return nil, fmt.Errorf("create host key signer: %w", err)
+		}
+		slog.Warn("Generated an ephemeral SSH host key, set SSH_HOST_KEY_FILE to keep it across restarts",
+			"fingerprint", ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+	}
+	s.config.AddHostKey(signer)
+
+	return s, nil
+}
+
+// authenticate accepts the authorized keys, whatever the user name of the client.
+func (s *sshServer) authenticate(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	fp := ssh.FingerprintSHA256(key)
+	if _, found := s.keys[fp]; !found {
+		return nil, errUnknownKey
+	}
+
+	return &ssh.Permissions{Extensions: map[string]string{sshKeyExtension: fp}}, nil
+}
+
+// ListenAndServe accepts SSH connections on addr.
+func (s *sshServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serve(conn)
+	}
+}
+
+// serve runs the chat in the first shell session of a connection.
+func (s *sshServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		slog.Info("SSH handshake failed", "err", err, "addr", conn.RemoteAddr())
+		return
+	}
+	defer sconn.Close()
+	conn.SetDeadline(time.Time{})
+
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			slog.Warn("accept SSH channel", "err", err)
+			continue
+		}
+
+		go func() {
+			// The connection only lives as long as its chat.
+			defer sconn.Close()
+			s.session(sconn, ch, chReqs)
+		}()
+	}
+}
+
+// session waits for the client to open a shell then runs the chat in its terminal.
+func (s *sshServer) session(sconn *ssh.ServerConn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	t := term.NewTerminal(ch, "> ")
+	shell := make(chan struct{})
+	go func() {
+		for req := range reqs {
+			ok := true
+			switch req.Type {
+			case "pty-req":
+				var p struct {
+					Term          string
+					Columns, Rows uint32
+					Width, Height uint32
+					Modes         string
+				}
+				if ssh.Unmarshal(req.Payload, &p) == nil {
+					t.SetSize(int(p.Columns), int(p.Rows))
+				}
+			case "window-change":
+				var p struct{ Columns, Rows, Width, Height uint32 }
+				if ssh.Unmarshal(req.Payload, &p) == nil {
+					t.SetSize(int(p.Columns), int(p.Rows))
+				}
+			case "shell":
+				select {
+				case <-shell:
+					ok = false
+				default:
+					close(shell)
+				}
+			default:
+				// Commands (e.g. exec, subsystem) are not supported.
+				ok = false
+			}
+
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+		}
+	}()
+
+	select {
+	case <-shell:
+	case <-time.After(sshHandshakeTimeout):
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	usr, err := s.user(ctx, sconn.Permissions.Extensions[sshKeyExtension])
+	if err != nil {
+		fmt.Fprintf(t, "%s\n", err)
+		return
+	}
+
+	s.chat(ctx, &sshConn{Terminal: t, ch: ch}, usr)
+}
+
+// user returns the user of an authorized key.
+func (s *sshServer) user(ctx context.Context, fp string) (*user.User, error) {
+	guest, found := s.keys[fp]
+	if !found {
+		return nil, errUnknownKey
+	}
+
+	if s.opts.accounts != nil {
+		acc, err := s.opts.accounts.FindByName(ctx, guest.Name)
+		if err == nil {
+			return accountUser(acc, s.opts), nil
+		}
+		if !errors.Is(err, account.ErrNotFound) {
+			return nil, fmt.Errorf("find account: %w", err)
+		}
+	}
+
+	if !s.opts.guests {
+		return nil, fmt.Errorf("no account named %s", guest.Name)
+	}
+
+	return guest, nil
+}
+
+// chat joins the room and sends the lines of the user until they leave or are kicked.
+func (s *sshServer) chat(ctx context.Context, c *sshConn, usr *user.User) {
+	logger := slog.Default().With("user.id", usr.ID, "transport", "ssh")
+	enc := textEncoder{}
+
+	if err := s.room.AddClient(chat.NewConn(ctx, usr, c), enc); err != nil {
+		if err := enc.Encode(ctx, c, usr, chat.ErrorEvent{Err: err}); err != nil {
+			logger.ErrorContext(ctx, "send error", "err", err)
+		}
+		return
+	}
+	lim := s.lims.add(usr, 5*time.Second, 3)
+
+	// Remove client from room when user disconnects.
+	defer func() {
+		s.room.RemoveClient(usr.ID)
+		s.lims.remove(usr)
+
+		s.room.Broadcast(ctx, chat.LeaveEvent{User: usr, Time: time.Now().UTC()})
+		s.room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: s.room.NumUsers()})
+	}()
+
+	env := &command.Env{
+		User:      usr,
+		Room:      s.room,
+		Registry:  s.cmds,
+		Responder: &responder{w: c, room: s.room, enc: enc},
+	}
+
+	// Catch up with the latest messages, the terminal having no history to scroll.
+	msgs := s.room.Messages()
+	for _, msg := range msgs[max(len(msgs)-sshHistory, 0):] {
+		if err := enc.Encode(ctx, c, usr, chat.MessageEvent{Message: msg}); err != nil {
+			logger.ErrorContext(ctx, "send history", "err", err)
+			return
+		}
+	}
+
+	s.room.Broadcast(ctx, chat.JoinEvent{User: usr, Time: time.Now().UTC()}, usr.ID)
+	s.room.Broadcast(ctx, chat.NumUsersEvent{NumUsers: s.room.NumUsers()})
+
+	if err := enc.Encode(ctx, c, usr, chat.ReadyEvent{User: usr}); err != nil {
+		logger.ErrorContext(ctx, "send ready", "err", err)
+		return
+	}
+
+	for {
+		// Ctrl+C, Ctrl+D and kicks (which close the channel) end the chat.
+		line, err := c.ReadLine()
+		if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "/quit" {
+			return
+		}
+
+		if err := s.handle(ctx, env, lim, line); err != nil {
+			if err := enc.Encode(ctx, c, env.User, chat.ErrorEvent{Err: err}); err != nil {
+				logger.ErrorContext(ctx, "send error", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// handle sends a message or runs a slash command, like the websocket of the room.
+func (s *sshServer) handle(ctx context.Context, env *command.Env, lim *limiter, line string) error {
+	if _, err := lim.Limit(ctx); errors.Is(err, mlimiters.ErrLimitExhausted) {
+		return chat.ErrRateLimited
+	}
+
+	if strings.HasPrefix(line, "/") {
+		return env.Registry.Execute(ctx, env, line)
+	}
+
+	if s.room.IsMuted(env.User.ID) {
+		return chat.ErrMuted
+	}
+
+	msg, err := chat.NewMessage(env.User, line)
+	if err != nil {
+		return err
+	}
+	broadcast(ctx, s.room, msg)
+
+	return nil
+}
+
+// sshConn is the terminal of an SSH session. Closing it ends the session (e.g. on kicks).
+type sshConn struct {
+	*term.Terminal
+	ch ssh.Channel
+}
+
+// Close implements the io.Closer interface.
+func (c *sshConn) Close() error {
+	return c.ch.Close()
+}
+
+// textEncoder encodes events as lines of text for terminals.
+type textEncoder struct{}
+
+// Encode implements the chat.Encoder interface.
+func (textEncoder) Encode(_ context.Context, w io.Writer, to *user.User, e chat.Event) error {
+	var line string
+	switch e := e.(type) {
+	case chat.MessageEvent:
+		msg := e.Message
+		prefix := msg.Time.Local().Format("15:04") + " "
+		switch {
+		case msg.IsSystem():
+			line = prefix + "-- " + msg.Content
+		case msg.IsAction():
+			line = prefix + "* " + msg.User.Name + " " + msg.Content
+		default:
+			line = prefix + msg.User.Name + ": " + msg.Content
+		}
+	case chat.ReadyEvent:
+		line = "-- Welcome " + e.User.Name + "! /help lists the commands, /quit leaves."
+	case chat.JoinEvent:
+		line = "-- " + e.User.Name + " joined"
+	case chat.LeaveEvent:
+		line = "-- " + e.User.Name + " left"
+	case chat.UserEvent:
+		line = "-- you are now known as " + e.User.Name
+	case chat.NoticeEvent:
+		line = "-- " + strings.Join(e.Lines, "\n-- ")
+	case chat.ModerationEvent:
+		// Kicks and mutes are also announced by system messages, only the target is told about unmutes.
+		if to == nil || e.Target.ID != to.ID {
+			return nil
+		}
+		var verb string
+		switch e.Action {
+		case chat.ModerationKick:
+			verb = "kicked"
+		case chat.ModerationMute:
+			verb = "muted"
+		case chat.ModerationUnmute:
+			verb = "unmuted"
+		default:
+			return nil
+		}
+		line = "-- you were " + verb + " by " + e.Moderator.Name
+		if e.Duration > 0 {
+			line += " for " + e.Duration.String()
+		}
+	case chat.ErrorEvent:
+		line = "!! " + e.Err.Error()
+	default:
+		// Typing notifications, reactions and read receipts do not fit a line-based interface.
+		return nil
+	}
+
+	_, err := io.WriteString(w, sanitize(line)+"\n")
+	return err
+}
+
+// sanitize removes the control characters of a line (e.g. escape sequences sent by other users),
+// keeping line breaks.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
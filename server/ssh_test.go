@@ -0,0 +1,397 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/command"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshClient is the client side of a shell session on an SSH server.
+type sshClient struct {
+	t      *testing.T
+	stdin  io.Writer
+	output chan []byte
+	// buf holds the output received but not expected yet.
+	buf []byte
+}
+
+// newSSHKey generates a key and returns it along with its authorized_keys line.
+func newSSHKey(t *testing.T, name string) (ssh.Signer, string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("create signer: %v", err)
+	}
+
+	return signer, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + name + "\n"
+}
+
+// newTestSSHServer serves a room to the authorized keys lines.
+func newTestSSHServer(t *testing.T, opts *authOptions, authorized ...string) *sshServer {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(path, []byte(strings.Join(authorized, "")), 0o600); err != nil {
+		t.Fatalf("write authorized keys: %v", err)
+	}
+
+	cmds := command.NewRegistry()
+	if err := cmds.Register(command.Builtins()...); err != nil {
+		t.Fatalf("register commands: %v", err)
+	}
+	lims := newLimiters(&clock{now: time.Now()}, func(string) {})
+
+	s, err := newSSHServer(path, "", chat.NewRoom(chat.RoomOptions{}), opts, lims, cmds)
+	if err != nil {
+		t.Fatalf("create ssh server: %v", err)
+	}
+
+	return s
+}
+
+// dialSSH serves a new connection over a pipe and opens a shell with the key.
+func dialSSH(t *testing.T, s *sshServer, signer ssh.Signer) (*sshClient, error) {
+	t.Helper()
+
+	// Both ends of an SSH connection send their version first: a synchronous pipe would deadlock.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			s.serve(conn)
+		}
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn, chans, reqs, err := ssh.NewClientConn(client, "pipe", &ssh.ClientConfig{
+		User:            "anyone",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sess, err := ssh.NewClient(conn, chans, reqs).NewSession()
+	if err != nil {
+		t.Fatalf("open session: %v", err)
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout: %v", err)
+	}
+	if err := sess.RequestPty("xterm", 24, 120, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("request pty: %v", err)
+	}
+	if err := sess.Shell(); err != nil {
+		t.Fatalf("open shell: %v", err)
+	}
+
+	c := &sshClient{t: t, stdin: stdin, output: make(chan []byte, 100)}
+	go func() {
+		defer close(c.output)
+		for {
+			b := make([]byte, 4096)
+			n, err := stdout.Read(b)
+			if n > 0 {
+				c.output <- b[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+func (c *sshClient) send(lines ...string) {
+	c.t.Helper()
+
+	for _, l := range lines {
+		if _, err := c.stdin.Write([]byte(l + "\r")); err != nil {
+			c.t.Fatalf("send %q: %v", l, err)
+		}
+	}
+}
+
+// expect waits for the output to contain substr and skips the output until the end of it.
+func (c *sshClient) expect(substr string) {
+	c.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		if i := bytes.Index(c.buf, []byte(substr)); i != -1 {
+			c.buf = c.buf[i+len(substr):]
+			return
+		}
+
+		select {
+		case b, ok := <-c.output:
+			if !ok {
+				c.t.Fatalf("session closed, expected %q\noutput: %q", substr, c.buf)
+			}
+			c.buf = append(c.buf, b...)
+		case <-timeout:
+			c.t.Fatalf("expected %q\noutput: %q", substr, c.buf)
+		}
+	}
+}
+
+// expectClosed skips the output until the server ends the session.
+func (c *sshClient) expectClosed() {
+	c.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.output:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			c.t.Fatal("session still open")
+		}
+	}
+}
+
+func TestNewSSHServer(t *testing.T) {
+	_, alice := newSSHKey(t, "Alice")
+	_, admin := newSSHKey(t, "Admin")
+
+	tests := []struct {
+		name       string
+		authorized string
+		wantErr    bool
+	}{
+		{name: "keys", authorized: "# comment\n\n" + alice},
+		{name: "no keys", authorized: ""},
+		{name: "invalid key", authorized: "ssh-ed25519 nope Alice\n", wantErr: true},
+		{name: "invalid name", authorized: admin, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "authorized_keys")
+			if err := os.WriteFile(path, []byte(tt.authorized), 0o600); err != nil {
+				t.Fatalf("write authorized keys: %v", err)
+			}
+
+			_, err := newSSHServer(path, "", chat.NewRoom(chat.RoomOptions{}), &authOptions{guests: true}, nil, command.NewRegistry())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		if _, err := newSSHServer(path, "", chat.NewRoom(chat.RoomOptions{}), &authOptions{guests: true}, nil, command.NewRegistry()); err == nil {
+			t.Fatal("server created without authorized keys")
+		}
+	})
+}
+
+func TestSSHAuthenticate(t *testing.T) {
+	signer, authorized := newSSHKey(t, "Alice")
+	unknown, _ := newSSHKey(t, "Alice")
+
+	accounts, err := account.NewFileStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("create account store: %v", err)
+	}
+	acc, err := account.New("Alice", "correct horse battery")
+	if err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	if err := accounts.Create(context.Background(), acc); err != nil {
+		t.Fatalf("store account: %v", err)
+	}
+	noAccounts, err := account.NewFileStore(filepath.Join(t.TempDir(), "none.json"))
+	if err != nil {
+		t.Fatalf("create account store: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts *authOptions
+		key  ssh.Signer
+		// want is the output expected once the shell is open, the handshake failing when empty.
+		want string
+		// wantClosed is whether the session ends once the output is shown.
+		wantClosed bool
+		wantRole   user.Role
+		wantID     bool
+	}{
+		{name: "guest", opts: &authOptions{guests: true}, key: signer, want: "-- Welcome Alice!", wantRole: user.RoleMember},
+		{name: "account", opts: &authOptions{accounts: accounts, admins: []string{"alice"}}, key: signer, want: "-- Welcome Alice!", wantRole: user.RoleAdmin, wantID: true},
+		{name: "no account", opts: &authOptions{accounts: noAccounts}, key: signer, want: "no account named Alice", wantClosed: true},
+		{name: "unknown key", opts: &authOptions{guests: true}, key: unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSSHServer(t, tt.opts, authorized)
+
+			c, err := dialSSH(t, s, tt.key)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatal("handshake succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+
+			c.expect(tt.want)
+			if tt.wantClosed {
+				c.expectClosed()
+				return
+			}
+
+			users := s.room.Users()
+			if len(users) != 1 || users[0].Role != tt.wantRole || (users[0].ID == acc.ID) != tt.wantID {
+				t.Fatalf("got users %+v", users)
+			}
+		})
+	}
+}
+
+func TestSSHChat(t *testing.T) {
+	signer, authorized := newSSHKey(t, "Alice")
+	s := newTestSSHServer(t, &authOptions{guests: true}, authorized)
+
+	// The latest messages are shown on joining.
+	bob := user.NewNamed("Bob")
+	earlier, err := chat.NewMessage(bob, "earlier")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	s.room.AddMessage(earlier)
+
+	received := make(chan *chat.Message, 10)
+	s.room.Observe(func(_ context.Context, e chat.Event) {
+		if e, ok := e.(chat.MessageEvent); ok {
+			received <- e.Message
+		}
+	})
+
+	c, err := dialSSH(t, s, signer)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	c.expect("Bob: earlier")
+	c.expect("-- Welcome Alice!")
+
+	// Lines are posted as messages, and messages of the room are shown without control characters.
+	c.send("hello from ssh")
+	select {
+	case msg := <-received:
+		if msg.Content != "hello from ssh" || msg.User.Name != "Alice" {
+			t.Fatalf("got message %q of %s", msg.Content, msg.User.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not posted")
+	}
+	msg, err := chat.NewMessage(bob, "hello \x1b[2Jfrom the web")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	broadcast(context.Background(), s.room, msg)
+	c.expect("Bob: hello [2Jfrom the web")
+
+	// Commands run like on the websocket, within the same rate limit.
+	c.send("/nick Alicia")
+	c.expect("-- you are now known as Alicia")
+	c.send("one", "two")
+	c.expect("!! " + chat.ErrRateLimited.Error())
+
+	c.send("/quit")
+	c.expectClosed()
+	if n := s.room.NumUsers(); n != 0 {
+		t.Fatalf("%d users still in the room", n)
+	}
+}
+
+func TestTextEncoder(t *testing.T) {
+	alice, bob := user.NewNamed("Alice"), user.NewNamed("Bob")
+	msg, _ := chat.NewMessage(bob, "hi\x07 there")
+	action, _ := chat.NewAction(bob, "waves")
+	system, _ := chat.NewSystemMessage("Bob was kicked")
+	at := func(m *chat.Message) string { return m.Time.Local().Format("15:04") + " " }
+
+	tests := []struct {
+		name string
+		e    chat.Event
+		want string
+	}{
+		{name: "message", e: chat.MessageEvent{Message: msg}, want: at(msg) + "Bob: hi there\n"},
+		{name: "action", e: chat.MessageEvent{Message: action}, want: at(action) + "* Bob waves\n"},
+		{name: "system message", e: chat.MessageEvent{Message: system}, want: at(system) + "-- Bob was kicked\n"},
+		{name: "join", e: chat.JoinEvent{User: bob}, want: "-- Bob joined\n"},
+		{name: "notice", e: chat.NoticeEvent{Lines: []string{"one", "two"}}, want: "-- one\n-- two\n"},
+		{
+			name: "muted",
+			e:    chat.ModerationEvent{Action: chat.ModerationMute, Target: alice, Moderator: bob, Duration: time.Minute},
+			want: "-- you were muted by Bob for 1m0s\n",
+		},
+		{
+			name: "kicked",
+			e:    chat.ModerationEvent{Action: chat.ModerationKick, Target: alice, Moderator: bob, Duration: 5 * time.Minute},
+			want: "-- you were kicked by Bob for 5m0s\n",
+		},
+		{
+			name: "unmuted",
+			e:    chat.ModerationEvent{Action: chat.ModerationUnmute, Target: alice, Moderator: bob},
+			want: "-- you were unmuted by Bob\n",
+		},
+		{name: "other user muted", e: chat.ModerationEvent{Action: chat.ModerationMute, Target: bob, Moderator: alice}},
+		{name: "error", e: chat.ErrorEvent{Err: errors.New("boom")}, want: "!! boom\n"},
+		{name: "typing", e: chat.TypingEvent{User: bob}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (textEncoder{}).Encode(context.Background(), &buf, alice, tt.e); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/oidc"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	ssoCookieName = "oidc"
+	// ssoTTL is how long users have to log in with the identity provider.
+	ssoTTL = 10 * time.Minute
+)
+
+// ssoAttempt is a login attempt, kept by the client between the redirect and the callback.
+type ssoAttempt struct {
+	oidc.Session
+	// Expires is checked on the callback as clients may keep expired cookies.
+	Expires time.Time
+}
+
+// ssoLogin redirects the user to the identity provider.
+// The secrets of the login attempt are kept in a short-lived cookie until the callback.
+func ssoLogin(cookies *cookieConfig, provider *oidc.Provider, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := oidc.NewSession()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(ssoAttempt{Session: *s, Expires: clock.Now().Add(ssoTTL)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The identity provider redirects back with a top-level navigation
+		// so the cookie must not be restricted to same-site requests.
+		cookie := cookies.cookie(ssoCookieName, base64.RawURLEncoding.EncodeToString(data), time.Now().Add(ssoTTL))
+		if cookie.SameSite == http.SameSiteStrictMode {
+			cookie.SameSite = http.SameSiteLaxMode
+		}
+		http.SetCookie(w, cookie)
+
+		http.Redirect(w, r, provider.AuthCodeURL(s), http.StatusFound)
+	}
+}
+
+// ssoCallback exchanges the authorization code and logs the user in.
+// Like guests, users of the identity provider cannot take the name of another user.
+func ssoCallback(a *auth, room *chat.Room, provider *oidc.Provider, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// The login attempt is single use.
+		a.cookies.clear(w, ssoCookieName)
+
+		var s ssoAttempt
+		value, found := a.cookies.value(r, ssoCookieName)
+		if !found {
+			http.Error(w, "missing login attempt", http.StatusBadRequest)
+			return
+		}
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || json.Unmarshal(data, &s) != nil {
+			http.Error(w, "invalid login attempt", http.StatusBadRequest)
+			return
+		}
+
+		if !clock.Now().Before(s.Expires) {
+			http.Error(w, "expired login attempt", http.StatusBadRequest)
+			return
+		}
+
+		q := r.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(s.State)) != 1 {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		if e := q.Get("error"); e != "" {
+			slog.WarnContext(ctx, "identity provider error", "err", e, "description", q.Get("error_description"))
+			http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+			return
+		}
+
+		tok, err := provider.Exchange(ctx, &s.Session, q.Get("code"))
+		if err != nil {
+			slog.ErrorContext(ctx, "exchange authorization code", "err", err)
+			http.Error(w, "login failed", http.StatusUnauthorized)
+			return
+		}
+
+		u := provider.User(tok)
+		if room.IsNameTaken(u.Name, u.ID) {
+			http.Error(w, chat.ErrNameTaken.Error(), http.StatusConflict)
+			return
+		}
+
+		if err := a.startSession(w, r, u); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
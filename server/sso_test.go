@@ -0,0 +1,197 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chattest"
+	"github.com/mgjules/chat-demo/oidc/oidctest"
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+// ssoRedirect starts a login with the identity provider and returns the callback it redirects to.
+func ssoRedirect(t *testing.T, b *browser) *url.URL {
+	t.Helper()
+
+	resp := b.get("/login/oidc")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	resp = b.get(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse callback: %v", err)
+	}
+
+	return callback
+}
+
+// tamperAttempt changes the login attempt kept in the cookie of the browser.
+func tamperAttempt(t *testing.T, b *browser, change func(attempt map[string]any)) {
+	t.Helper()
+
+	data, err := base64.RawURLEncoding.DecodeString(b.cookie("oidc"))
+	if err != nil {
+		t.Fatalf("decode login attempt: %v", err)
+	}
+	var attempt map[string]any
+	if err := json.Unmarshal(data, &attempt); err != nil {
+		t.Fatalf("decode login attempt: %v", err)
+	}
+
+	change(attempt)
+
+	if data, err = json.Marshal(attempt); err != nil {
+		t.Fatalf("encode login attempt: %v", err)
+	}
+	u, _ := url.Parse(b.srv.URL)
+	b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "oidc", Value: base64.RawURLEncoding.EncodeToString(data), Path: "/"}})
+}
+
+func TestSSOLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		// tamper changes the browser or the callback before the browser is redirected to it.
+		tamper     func(t *testing.T, srv *chattest.Server, b *browser, callback *url.URL)
+		wantStatus int
+		wantName   string
+		wantRole   string
+	}{
+		{
+			name:       "member",
+			claims:     map[string]any{"name": "Carol"},
+			wantStatus: http.StatusFound, wantName: "Carol", wantRole: "member",
+		},
+		{
+			name:       "moderator",
+			claims:     map[string]any{"preferred_username": "Carol", "groups": []string{"chat-mods"}},
+			wantStatus: http.StatusFound, wantName: "Carol", wantRole: "moderator",
+		},
+		{
+			name:       "admin",
+			claims:     map[string]any{"email": "carol@example.com", "groups": []string{"chat-admins", "chat-mods"}},
+			wantStatus: http.StatusFound, wantName: "carol", wantRole: "admin",
+		},
+		{
+			name:   "state mismatch",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(_ *testing.T, _ *chattest.Server, _ *browser, callback *url.URL) {
+				q := callback.Query()
+				q.Set("state", "forged")
+				callback.RawQuery = q.Encode()
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "pkce mismatch",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(t *testing.T, _ *chattest.Server, b *browser, _ *url.URL) {
+				tamperAttempt(t, b, func(attempt map[string]any) { attempt["Verifier"] = "forged-verifier-forged-verifier-forged-verifier" })
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "nonce mismatch",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(t *testing.T, _ *chattest.Server, b *browser, _ *url.URL) {
+				tamperAttempt(t, b, func(attempt map[string]any) { attempt["Nonce"] = "forged" })
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "expired attempt",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(_ *testing.T, srv *chattest.Server, _ *browser, _ *url.URL) {
+				srv.Clock.Advance(10 * time.Minute)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "missing attempt",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(_ *testing.T, _ *chattest.Server, b *browser, _ *url.URL) {
+				u, _ := url.Parse(b.srv.URL)
+				b.hc.Jar.SetCookies(u, []*http.Cookie{{Name: "oidc", Path: "/", MaxAge: -1}})
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "denied by the provider",
+			claims: map[string]any{"name": "Carol"},
+			tamper: func(_ *testing.T, _ *chattest.Server, _ *browser, callback *url.URL) {
+				callback.RawQuery = url.Values{"state": {callback.Query().Get("state")}, "error": {"access_denied"}}.Encode()
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "name of an account",
+			claims:     map[string]any{"name": "ALlCE"},
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := oidctest.NewProvider(tt.claims)
+			t.Cleanup(p.Close)
+
+			srv := chattest.NewServer(t, chattest.Options{
+				Env: map[string]string{
+					"OIDC_ISSUER":           p.Issuer(),
+					"OIDC_CLIENT_ID":        oidctest.ClientID,
+					"OIDC_ROLE_CLAIM":       "groups",
+					"OIDC_ADMIN_VALUES":     "chat-admins",
+					"OIDC_MODERATOR_VALUES": "chat-mods",
+				},
+				Accounts: []chattest.Account{{Name: "Alice", Password: password}},
+			})
+
+			b := newBrowser(t, srv)
+			callback := ssoRedirect(t, b)
+			if tt.tamper != nil {
+				tt.tamper(t, srv, b, callback)
+			}
+
+			resp := b.get(callback.String())
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", resp.StatusCode, tt.wantStatus, body(t, resp))
+			}
+			if b.cookie("oidc") != "" {
+				t.Fatal("the login attempt was kept")
+			}
+			if tt.wantStatus != http.StatusFound {
+				if b.cookie("jwt") != "" {
+					t.Fatal("got a session")
+				}
+				return
+			}
+
+			resp = b.get("/api/v1/me")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("me: got status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			var me protocol.User
+			if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+				t.Fatalf("decode me: %v", err)
+			}
+			if me.Name != tt.wantName || me.Role != tt.wantRole {
+				t.Fatalf("logged in as %s (%s), want %s (%s)", me.Name, me.Role, tt.wantName, tt.wantRole)
+			}
+
+			// The login attempt is used up.
+			if resp := b.get(callback.String()); resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("replay: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
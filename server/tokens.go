@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/mgjules/chat-demo/account"
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/mgjules/chat-demo/session"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+)
+
+// sessionsPath is the path prefix of the API routes starting sessions.
+// They only accept JSON bodies, which browsers cannot send to another origin
+// without a preflight, so they are not protected against csrf.
+const sessionsPath = "/api/v1/sessions"
+
+// List of errors of the API routes of sessions.
+var (
+	errGuestsDisabled   = errors.New("guest login is disabled")
+	errAccountsDisabled = errors.New("account login is disabled")
+	errNoSession        = errors.New("api keys have no session")
+)
+
+// apiSession is the tokens of a session started by an API client which cannot keep cookies (e.g. a terminal client).
+type apiSession struct {
+	Token        string        `json:"token"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+	RefreshToken string        `json:"refresh_token"`
+	User         protocol.User `json:"user"`
+}
+
+// startSession logs a user in as a guest, or with an account when a password is given.
+func (a *api) startSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(r, &body) {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	var u *user.User
+	if body.Password == "" {
+		if !a.opts.guests {
+			apiError(w, r, errGuestsDisabled)
+			return
+		}
+
+		name, err := user.NormalizeName(body.Name)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+
+		u = user.NewNamed(name)
+		if a.rooms[defaultRoom].IsNameTaken(u.Name, u.ID) {
+			apiError(w, r, chat.ErrNameTaken)
+			return
+		}
+	} else {
+		if a.opts.accounts == nil {
+			apiError(w, r, errAccountsDisabled)
+			return
+		}
+
+		// Same limits as the login form, which they share.
+		if throttled(r, a.opts.throttle, "ip:"+clientIP(r), time.Minute/10, 10) ||
+			throttled(r, a.opts.throttle, "account:"+user.Skeleton(body.Name), time.Minute/2, 5) {
+			w.Header().Set("Retry-After", "60")
+			apiError(w, r, errTooManyAttempts)
+			return
+		}
+
+		acc, err := account.Authenticate(ctx, a.opts.accounts, body.Name, body.Password)
+		if err != nil {
+			if !errors.Is(err, account.ErrInvalidCredentials) {
+				slog.ErrorContext(ctx, "authenticate account", "err", err)
+			}
+			apiError(w, r, account.ErrInvalidCredentials)
+			return
+		}
+		u = accountUser(acc, a.opts)
+	}
+
+	s, refresh, err := a.auth.sessions.Start(u, r.UserAgent(), clientIP(r))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	a.writeSession(w, r, http.StatusCreated, s.ID, u, refresh)
+}
+
+// refreshSession exchanges a refresh token for new tokens.
+// Like the session cookies, the refresh token is rotated on every call.
+func (a *api) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !decodeJSON(r, &body) {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	s, refresh, err := a.auth.sessions.Refresh(body.RefreshToken, r.UserAgent(), clientIP(r))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	// The user may have changed (e.g. renamed) while connected to the room.
+	u, found := a.rooms[defaultRoom].User(s.User.ID)
+	if !found {
+		u = s.User
+	}
+
+	a.writeSession(w, r, http.StatusOK, s.ID, u, refresh)
+}
+
+// endSession revokes the session of the token, logging the client out.
+// Browsers cannot send a DELETE to another origin without a preflight either.
+func (a *api) endSession(w http.ResponseWriter, r *http.Request) {
+	claims := session.FromContext(r.Context())
+	if claims == nil {
+		apiError(w, r, errNoSession)
+		return
+	}
+
+	a.auth.sessions.Revoke(claims.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSession issues an access token for the session and writes it along with its refresh token.
+func (a *api) writeSession(w http.ResponseWriter, r *http.Request, status int, id string, u *user.User, refresh string) {
+	token, t, err := a.auth.encode(session.NewClaims(id, u))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, status, apiSession{
+		Token:        t,
+		ExpiresAt:    token.Expiration(),
+		RefreshToken: refresh,
+		User:         protocol.NewUser(u),
+	})
+}
+
+// decodeJSON strictly decodes a JSON body, rejecting other content types.
+func decodeJSON(r *http.Request, v any) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		return false
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v) == nil
+}
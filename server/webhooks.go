@@ -0,0 +1,221 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/mgjules/chat-demo/webhook"
+	"github.com/rs/xid"
+)
+
+// apiWebhook is a webhook as seen by API clients. The secret is never shown again.
+type apiWebhook struct {
+	ID        string              `json:"id"`
+	Room      string              `json:"room"`
+	URL       string              `json:"url"`
+	Events    []webhook.EventType `json:"events"`
+	CreatedBy string              `json:"created_by"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// apiDelivery is a delivery of an event to a webhook as seen by API clients.
+type apiDelivery struct {
+	ID          string            `json:"id"`
+	WebhookID   string            `json:"webhook_id"`
+	Event       webhook.EventType `json:"event"`
+	Body        json.RawMessage   `json:"body"`
+	Attempts    int               `json:"attempts"`
+	LastStatus  int               `json:"last_status,omitempty"`
+	LastError   string            `json:"last_error,omitempty"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// apiAttempt is an entry of the delivery log as seen by API clients.
+type apiAttempt struct {
+	DeliveryID string            `json:"delivery_id"`
+	Event      webhook.EventType `json:"event"`
+	Number     int               `json:"attempt"`
+	Status     int               `json:"status,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	// Duration is a Go duration (e.g. "120ms").
+	Duration string    `json:"duration"`
+	Time     time.Time `json:"time"`
+}
+
+func (a *api) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := a.webhooks.List(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	res := make([]apiWebhook, 0, len(webhooks))
+	for _, wh := range webhooks {
+		res = append(res, newAPIWebhook(wh))
+	}
+	slices.SortFunc(res, func(a, b apiWebhook) int { return cmp.Compare(a.CreatedAt.UnixNano(), b.CreatedAt.UnixNano()) })
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// createWebhook creates a webhook receiving the events of a room.
+// The response holds the only copy of the secret signing the deliveries.
+func (a *api) createWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Room   string              `json:"room"`
+		URL    string              `json:"url"`
+		Events []webhook.EventType `json:"events"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		apiError(w, r, errInvalidBody)
+		return
+	}
+
+	if _, found := a.rooms[body.Room]; !found {
+		apiError(w, r, errRoomNotFound)
+		return
+	}
+
+	wh, err := webhook.New(body.Room, body.URL, body.Events, user.FromContext(ctx).ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if err := a.webhooks.Create(ctx, wh); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, map[string]any{
+		"webhook": newAPIWebhook(wh),
+		"secret":  wh.Secret,
+	})
+}
+
+// deleteWebhook removes a webhook along with its pending deliveries.
+func (a *api) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := xid.FromString(chi.URLParam(r, "webhook"))
+	if err != nil {
+		apiError(w, r, webhook.ErrNotFound)
+		return
+	}
+
+	if err := a.webhooks.Delete(r.Context(), id); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAttempts returns the delivery log of a webhook, the newest attempt first.
+func (a *api) listAttempts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := xid.FromString(chi.URLParam(r, "webhook"))
+	if err != nil {
+		apiError(w, r, webhook.ErrNotFound)
+		return
+	}
+
+	if _, err := a.webhooks.Get(ctx, id); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	attempts, err := a.webhooks.Log(ctx, id)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	res := make([]apiAttempt, 0, len(attempts))
+	for _, at := range attempts {
+		res = append(res, apiAttempt{
+			DeliveryID: at.DeliveryID.String(),
+			Event:      at.Event,
+			Number:     at.Number,
+			Status:     at.Status,
+			Error:      at.Error,
+			Duration:   at.Duration.String(),
+			Time:       at.Time,
+		})
+	}
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// listDead returns the deliveries which ran out of attempts.
+func (a *api) listDead(w http.ResponseWriter, r *http.Request) {
+	dead, err := a.webhooks.Dead(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	res := make([]apiDelivery, 0, len(dead))
+	for _, d := range dead {
+		res = append(res, newAPIDelivery(d))
+	}
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// retryDead moves a dead delivery back to the queue for another round of attempts.
+func (a *api) retryDead(w http.ResponseWriter, r *http.Request) {
+	id, err := xid.FromString(chi.URLParam(r, "delivery"))
+	if err != nil {
+		apiError(w, r, webhook.ErrDeliveryNotFound)
+		return
+	}
+
+	d, err := a.webhooks.Requeue(r.Context(), id)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	a.dispatcher.Wake()
+
+	writeJSON(w, r, http.StatusAccepted, newAPIDelivery(d))
+}
+
+func newAPIWebhook(wh *webhook.Webhook) apiWebhook {
+	events := wh.Events
+	if len(events) == 0 {
+		events = webhook.EventTypes
+	}
+
+	return apiWebhook{
+		ID:        wh.ID.String(),
+		Room:      wh.Room,
+		URL:       wh.URL,
+		Events:    events,
+		CreatedBy: wh.CreatedBy.String(),
+		CreatedAt: wh.CreatedAt,
+	}
+}
+
+func newAPIDelivery(d *webhook.Delivery) apiDelivery {
+	return apiDelivery{
+		ID:          d.ID.String(),
+		WebhookID:   d.WebhookID.String(),
+		Event:       d.Event,
+		Body:        d.Body,
+		Attempts:    d.Attempts,
+		LastStatus:  d.LastStatus,
+		LastError:   d.LastError,
+		NextAttempt: d.NextAttempt,
+		CreatedAt:   d.CreatedAt,
+	}
+}
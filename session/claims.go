@@ -0,0 +1,182 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// Version is the current schema version of the claims.
+//
+// Versions:
+//   - 1: {"user": {"ID": "...", "Name": "...", "Role": 0}} without a "ver" claim.
+//   - 2: {"ver": 2, "user": {"id": "...", "name": "...", "role": "member"}}.
+const Version = 2
+
+// List of claims errors.
+var (
+	ErrMissing            = errors.New("missing token")
+	ErrExpired            = errors.New("token expired")
+	ErrMalformed          = errors.New("malformed token")
+	ErrUnsupportedVersion = errors.New("unsupported token version")
+	ErrRevoked            = errors.New("token revoked")
+	ErrRefreshInvalid     = errors.New("invalid refresh token")
+)
+
+// Claims holds the typed claims of a session token.
+type Claims struct {
+	Version int
+	// ID is the ID of the session the token belongs to (jti).
+	ID        string
+	User      *user.User
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewClaims creates the claims of a session for a user.
+func NewClaims(id string, u *user.User) *Claims {
+	return &Claims{
+		Version: Version,
+		ID:      id,
+		User:    u,
+	}
+}
+
+// userClaims is the encoded form of a user in the current version.
+type userClaims struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// userClaimsV1 is the encoded form of a user in version 1.
+type userClaimsV1 struct {
+	ID   string
+	Name string
+	Role user.Role
+}
+
+// Map returns the claims to encode in a token.
+// Registered claims (e.g. iat, exp) are left to the signer.
+func (c *Claims) Map() map[string]any {
+	return map[string]any{
+		"ver": Version,
+		"jti": c.ID,
+		"user": userClaims{
+			ID:   c.User.ID.String(),
+			Name: c.User.Name,
+			Role: c.User.Role.String(),
+		},
+	}
+}
+
+// Decode validates a token and decodes its claims, migrating older versions.
+func Decode(token jwt.Token) (*Claims, error) {
+	if token == nil {
+		return nil, ErrMissing
+	}
+
+	if err := jwt.Validate(token); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired()) {
+			return nil, ErrExpired
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	version := 1
+	if v, found := token.Get("ver"); found {
+		// JSON numbers are decoded as float64.
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) {
+			return nil, fmt.Errorf("%w: invalid version %v", ErrMalformed, v)
+		}
+		version = int(f)
+	}
+
+	raw, found := token.Get("user")
+	if !found {
+		return nil, fmt.Errorf("%w: missing user claim", ErrMalformed)
+	}
+
+	// Private claims are decoded as generic maps, round-trip them through JSON to type them.
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	var u *user.User
+	switch version {
+	case 1:
+		u, err = decodeUserV1(data)
+	case 2:
+		u, err = decodeUser(data)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens issued before sessions were tracked never expire and cannot be revoked.
+	if token.Expiration().IsZero() || token.JwtID() == "" {
+		return nil, ErrExpired
+	}
+
+	return &Claims{
+		Version:   Version,
+		ID:        token.JwtID(),
+		User:      u,
+		IssuedAt:  token.IssuedAt(),
+		ExpiresAt: token.Expiration(),
+	}, nil
+}
+
+func decodeUser(data []byte) (*user.User, error) {
+	var uc userClaims
+	if err := json.Unmarshal(data, &uc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	role, ok := user.ParseRole(uc.Role)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid role %q", ErrMalformed, uc.Role)
+	}
+
+	return newUser(uc.ID, uc.Name, role)
+}
+
+func decodeUserV1(data []byte) (*user.User, error) {
+	var uc userClaimsV1
+	if err := json.Unmarshal(data, &uc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	if uc.Role > user.RoleAdmin {
+		return nil, fmt.Errorf("%w: invalid role %d", ErrMalformed, uc.Role)
+	}
+
+	return newUser(uc.ID, uc.Name, uc.Role)
+}
+
+func newUser(rawID, name string, role user.Role) (*user.User, error) {
+	id, err := xid.FromString(rawID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user id: %w", ErrMalformed, err)
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing user name", ErrMalformed)
+	}
+
+	return &user.User{
+		ID:   id,
+		Name: name,
+		Role: role,
+	}, nil
+}
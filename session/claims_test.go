@@ -0,0 +1,179 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+var testSecret = []byte("a shared secret of at least 32 bytes")
+
+// token signs a token with the claims and parses it back, as done for the tokens sent by clients.
+func token(t *testing.T, claims map[string]any) jwt.Token {
+	t.Helper()
+
+	tok := jwt.New()
+	for k, v := range claims {
+		if err := tok.Set(k, v); err != nil {
+			t.Fatalf("set claim %q: %v", k, err)
+		}
+	}
+
+	signed, err := jwt.Sign(tok, jwt.WithKey(jwa.HS256, testSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	tok, err = jwt.ParseString(string(signed), jwt.WithKey(jwa.HS256, testSecret), jwt.WithValidate(false))
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+
+	return tok
+}
+
+func TestClaimsRoundTrip(t *testing.T) {
+	alice := &user.User{ID: xid.New(), Name: "Alice", Role: user.RoleModerator}
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	claims := NewClaims("session-id", alice).Map()
+	claims[jwt.ExpirationKey] = exp
+
+	c, err := Decode(token(t, claims))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if c.Version != Version || c.ID != "session-id" || !c.ExpiresAt.Equal(exp) {
+		t.Fatalf("got claims %+v", c)
+	}
+	if *c.User != *alice {
+		t.Fatalf("got user %+v, want %+v", c.User, alice)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	id := xid.New()
+	exp := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		wantUser *user.User
+		wantErr  error
+	}{
+		{
+			name:     "current version",
+			claims:   map[string]any{"ver": 2, "jti": "session-id", "exp": exp, "user": map[string]any{"id": id.String(), "name": "Alice", "role": "admin"}},
+			wantUser: &user.User{ID: id, Name: "Alice", Role: user.RoleAdmin},
+		},
+		{
+			name:     "version 1",
+			claims:   map[string]any{"jti": "session-id", "exp": exp, "user": map[string]any{"ID": id.String(), "Name": "Alice", "Role": 1}},
+			wantUser: &user.User{ID: id, Name: "Alice", Role: user.RoleModerator},
+		},
+		{
+			// Tokens were first issued without expiry nor session.
+			name:    "baseline",
+			claims:  map[string]any{"user": map[string]any{"ID": id.String(), "Name": "Alice", "Role": 0}},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "missing expiry",
+			claims:  map[string]any{"ver": 2, "jti": "session-id", "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "missing session",
+			claims:  map[string]any{"ver": 2, "exp": exp, "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "expired",
+			claims:  map[string]any{"ver": 2, "exp": time.Now().Add(-time.Hour), "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "not yet valid",
+			claims:  map[string]any{"ver": 2, "nbf": time.Now().Add(time.Hour), "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "future version",
+			claims:  map[string]any{"ver": 3, "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "invalid version",
+			claims:  map[string]any{"ver": "2", "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "fractional version",
+			claims:  map[string]any{"ver": 1.5, "user": map[string]any{"id": id.String(), "name": "Alice", "role": "member"}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "missing user",
+			claims:  map[string]any{"ver": 2},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "user of the wrong type",
+			claims:  map[string]any{"ver": 2, "user": "Alice"},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "invalid role",
+			claims:  map[string]any{"ver": 2, "user": map[string]any{"id": id.String(), "name": "Alice", "role": "owner"}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "invalid role in version 1",
+			claims:  map[string]any{"user": map[string]any{"ID": id.String(), "Name": "Alice", "Role": 3}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "role of version 1 in the current version",
+			claims:  map[string]any{"ver": 2, "user": map[string]any{"id": id.String(), "name": "Alice", "role": 2}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "invalid user id",
+			claims:  map[string]any{"ver": 2, "user": map[string]any{"id": "alice", "name": "Alice", "role": "member"}},
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "missing name",
+			claims:  map[string]any{"ver": 2, "user": map[string]any{"id": id.String(), "role": "member"}},
+			wantErr: ErrMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Decode(token(t, tt.claims))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.Version != Version {
+				t.Fatalf("got version %d, want %d", c.Version, Version)
+			}
+			if *c.User != *tt.wantUser {
+				t.Fatalf("got user %+v, want %+v", c.User, tt.wantUser)
+			}
+		})
+	}
+
+	if _, err := Decode(nil); !errors.Is(err, ErrMissing) {
+		t.Fatalf("got error %v, want %v", err, ErrMissing)
+	}
+}
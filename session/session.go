@@ -0,0 +1,324 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+type sessionContextKey string
+
+const claimsCtxKey sessionContextKey = "claims"
+
+// Session is a login of a user on a device.
+type Session struct {
+	ID        string
+	User      *user.User
+	Device    string
+	IP        string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	// RefreshExpiresAt is when the refresh token of the session expires.
+	RefreshExpiresAt time.Time
+
+	// refresh and previous are the hashes of the current and the last rotated refresh tokens.
+	refresh   [sha256.Size]byte
+	previous  [sha256.Size]byte
+	rotatedAt time.Time
+}
+
+// Manager keeps track of the active sessions and of the revoked ones.
+//
+// Sessions are kept in memory: sessions started before a restart are
+// tracked again on their next request but their revocations are lost.
+type Manager struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	refreshTTL time.Duration
+	sessions   map[string]*Session
+	// revoked holds the revocation time of the revoked sessions.
+	revoked  map[string]time.Time
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// NewManager creates a new Manager for sessions whose access tokens are valid for ttl
+// and whose refresh tokens are valid for refreshTTL.
+func NewManager(ttl, refreshTTL time.Duration) *Manager {
+	return &Manager{
+		ttl:        ttl,
+		refreshTTL: refreshTTL,
+		sessions:   make(map[string]*Session),
+		revoked:    make(map[string]time.Time),
+		watchers:   make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// RefreshTTL returns the lifetime of the refresh tokens.
+func (m *Manager) RefreshTTL() time.Duration {
+	return m.refreshTTL
+}
+
+// Start starts a new session for a user and returns its refresh token.
+func (m *Manager) Start(u *user.User, device, ip string) (Session, string, error) {
+	now := time.Now()
+	s := &Session{
+		ID:        xid.New().String(),
+		User:      u,
+		Device:    device,
+		IP:        ip,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	refresh, err := s.rotate(m.refreshTTL)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	m.sessions[s.ID] = s
+
+	return *s, refresh, nil
+}
+
+// reuseGrace is how long a rotated refresh token is rejected without revoking
+// its session, since concurrent requests (e.g. two tabs) can race to refresh.
+const reuseGrace = 10 * time.Second
+
+// Refresh exchanges a refresh token for a new one.
+//
+// Refresh tokens are single use: presenting a rotated refresh token again
+// means it has been stolen, so the session is revoked.
+func (m *Manager) Refresh(token, device, ip string) (Session, string, error) {
+	id, _, found := strings.Cut(token, ".")
+	if !found {
+		return Session{}, "", ErrRefreshInvalid
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, found := m.sessions[id]
+	if !found {
+		if _, revoked := m.revoked[id]; revoked {
+			return Session{}, "", ErrRevoked
+		}
+		return Session{}, "", ErrRefreshInvalid
+	}
+
+	if subtle.ConstantTimeCompare(sum[:], s.previous[:]) == 1 {
+		if time.Since(s.rotatedAt) < reuseGrace {
+			return Session{}, "", ErrRefreshInvalid
+		}
+
+		m.revoke(id)
+		return Session{}, "", fmt.Errorf("%w: refresh token reused", ErrRevoked)
+	}
+
+	if subtle.ConstantTimeCompare(sum[:], s.refresh[:]) != 1 || time.Now().After(s.RefreshExpiresAt) {
+		return Session{}, "", ErrRefreshInvalid
+	}
+
+	refresh, err := s.rotate(m.refreshTTL)
+	if err != nil {
+		return Session{}, "", err
+	}
+	s.Device = device
+	s.IP = ip
+	s.LastSeen = time.Now()
+
+	return *s, refresh, nil
+}
+
+// rotate generates a new refresh token for the session.
+func (s *Session) rotate(ttl time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate refresh token: %w", err)
+	}
+
+	// The session ID prefix allows finding the session without storing the token.
+	token := s.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	s.previous = s.refresh
+	s.refresh = sha256.Sum256([]byte(token))
+	s.rotatedAt = time.Now()
+	s.RefreshExpiresAt = s.rotatedAt.Add(ttl)
+
+	return token, nil
+}
+
+// Touch records the activity of a session.
+// Unknown sessions (e.g. started before a restart) are tracked again.
+func (m *Manager) Touch(c *Claims, device, ip string) {
+	if c.ID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, revoked := m.revoked[c.ID]; revoked {
+		return
+	}
+
+	s, found := m.sessions[c.ID]
+	if !found {
+		s = &Session{
+			ID:        c.ID,
+			CreatedAt: c.IssuedAt,
+		}
+		m.sessions[c.ID] = s
+	}
+
+	s.User = c.User
+	s.Device = device
+	s.IP = ip
+	s.LastSeen = time.Now()
+}
+
+// Get returns a session.
+func (m *Manager) Get(id string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, found := m.sessions[id]
+	if !found {
+		return Session{}, false
+	}
+
+	return *s, true
+}
+
+// Sessions returns the active sessions of a user, most recently seen first.
+func (m *Manager) Sessions(userID xid.ID) []Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []Session
+	for _, s := range m.sessions {
+		if s.User.ID == userID && !m.expired(s) {
+			sessions = append(sessions, *s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+
+	return sessions
+}
+
+// Revoke revokes a session and notifies its watchers.
+func (m *Manager) Revoke(id string) {
+	if id == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	m.revoke(id)
+}
+
+// revoke revokes a session and notifies its watchers.
+// It must be called with the lock held.
+func (m *Manager) revoke(id string) {
+	delete(m.sessions, id)
+	m.revoked[id] = time.Now()
+
+	for ch := range m.watchers[id] {
+		close(ch)
+	}
+	delete(m.watchers, id)
+}
+
+// IsRevoked checks if a session has been revoked.
+func (m *Manager) IsRevoked(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, revoked := m.revoked[id]
+	return revoked
+}
+
+// Watch returns a channel closed when the session is revoked.
+// The returned function stops watching the session and must be called.
+func (m *Manager) Watch(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, revoked := m.revoked[id]; revoked {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if m.watchers[id] == nil {
+		m.watchers[id] = make(map[chan struct{}]struct{})
+	}
+	m.watchers[id][ch] = struct{}{}
+
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.watchers[id], ch)
+		if len(m.watchers[id]) == 0 {
+			delete(m.watchers, id)
+		}
+	}
+}
+
+// expired checks if all the tokens of a session have expired.
+// An access token is never valid for longer than the ttl after the last activity of its session.
+// It must be called with the lock held.
+func (m *Manager) expired(s *Session) bool {
+	return time.Since(s.LastSeen) >= m.ttl && time.Now().After(s.RefreshExpiresAt)
+}
+
+// prune removes the sessions and revocations whose tokens have all expired.
+// It must be called with the lock held.
+func (m *Manager) prune() {
+	for id, s := range m.sessions {
+		if m.expired(s) {
+			delete(m.sessions, id)
+		}
+	}
+
+	for id, at := range m.revoked {
+		if time.Since(at) >= m.ttl {
+			delete(m.revoked, id)
+		}
+	}
+}
+
+// AddToContext adds the claims of a session to the context.
+func AddToContext(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsCtxKey, c)
+}
+
+// FromContext retrieves the claims of a session from the context.
+func FromContext(ctx context.Context) *Claims {
+	c, ok := ctx.Value(claimsCtxKey).(*Claims)
+	if !ok {
+		return nil
+	}
+
+	return c
+}
@@ -0,0 +1,242 @@
+package session
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// closed checks if a channel returned by Watch is closed.
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestManagerSessions(t *testing.T) {
+	m := NewManager(time.Hour, 24*time.Hour)
+	alice := user.NewNamed("Alice")
+	bob := user.NewNamed("Bob")
+
+	laptop, _, err := m.Start(alice, "laptop", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	phone, _, err := m.Start(alice, "phone", "192.0.2.2")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, _, err := m.Start(bob, "laptop", "192.0.2.3"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	// Sessions are listed per user, most recently seen first.
+	m.Touch(&Claims{ID: laptop.ID, User: alice}, "laptop (updated)", "192.0.2.4")
+	sessions := m.Sessions(alice.ID)
+	if got := []string{sessions[0].ID, sessions[1].ID}; len(sessions) != 2 || !slices.Equal(got, []string{laptop.ID, phone.ID}) {
+		t.Fatalf("got sessions %v, want %v", sessions, []string{laptop.ID, phone.ID})
+	}
+	if s := sessions[0]; s.Device != "laptop (updated)" || s.IP != "192.0.2.4" {
+		t.Fatalf("got device %q from %s, want the last seen one", s.Device, s.IP)
+	}
+	if s, found := m.Get(phone.ID); !found || s.Device != "phone" {
+		t.Fatalf("got session %+v (%t), want the phone", s, found)
+	}
+
+	// Sessions started before a restart are tracked again.
+	restarted := &Claims{ID: xid.New().String(), User: alice, IssuedAt: time.Now().Add(-time.Minute)}
+	m.Touch(restarted, "tablet", "192.0.2.5")
+	if s, found := m.Get(restarted.ID); !found || s.User != alice || !s.CreatedAt.Equal(restarted.IssuedAt) {
+		t.Fatalf("got session %+v (%t), want it tracked again", s, found)
+	}
+
+	// Tokens issued before sessions were tracked are ignored.
+	m.Touch(&Claims{User: alice}, "legacy", "192.0.2.6")
+	if _, found := m.Get(""); found {
+		t.Fatal("tracked a token without a session")
+	}
+
+	// Sessions whose tokens all expired are not listed.
+	m.mu.Lock()
+	m.sessions[phone.ID].LastSeen = time.Now().Add(-time.Hour)
+	m.sessions[phone.ID].RefreshExpiresAt = time.Now().Add(-time.Second)
+	m.mu.Unlock()
+	for _, s := range m.Sessions(alice.ID) {
+		if s.ID == phone.ID {
+			t.Fatal("listed an expired session")
+		}
+	}
+}
+
+func TestManagerRevoke(t *testing.T) {
+	m := NewManager(time.Hour, 24*time.Hour)
+	alice := user.NewNamed("Alice")
+
+	s, _, err := m.Start(alice, "laptop", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	other, _, err := m.Start(alice, "phone", "192.0.2.2")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	revoked, unwatch := m.Watch(s.ID)
+	defer unwatch()
+	stopped, unwatchStopped := m.Watch(s.ID)
+	unwatchStopped()
+	untouched, unwatchOther := m.Watch(other.ID)
+	defer unwatchOther()
+
+	m.Revoke(s.ID)
+
+	if !m.IsRevoked(s.ID) || m.IsRevoked(other.ID) {
+		t.Fatal("revoked the wrong session")
+	}
+	if !closed(revoked) {
+		t.Fatal("watcher not notified")
+	}
+	if closed(stopped) {
+		t.Fatal("stopped watcher notified")
+	}
+	if closed(untouched) {
+		t.Fatal("watcher of another session notified")
+	}
+	if _, found := m.Get(s.ID); found {
+		t.Fatal("revoked session still active")
+	}
+
+	// Revoked sessions are not tracked again by their remaining tokens.
+	m.Touch(&Claims{ID: s.ID, User: alice}, "laptop", "192.0.2.1")
+	if _, found := m.Get(s.ID); found {
+		t.Fatal("revoked session tracked again")
+	}
+
+	// Watching a revoked session returns right away.
+	ch, unwatchRevoked := m.Watch(s.ID)
+	defer unwatchRevoked()
+	if !closed(ch) {
+		t.Fatal("watcher of a revoked session not notified")
+	}
+
+	// Revocations are forgotten once the access tokens of the session expired.
+	m.mu.Lock()
+	m.revoked[s.ID] = time.Now().Add(-time.Hour)
+	m.mu.Unlock()
+	m.Revoke(other.ID)
+	if m.IsRevoked(s.ID) {
+		t.Fatal("revocation kept after the tokens expired")
+	}
+	if len(m.watchers) != 0 {
+		t.Fatalf("got %d sessions watched, want none", len(m.watchers))
+	}
+}
+
+func TestManagerRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// token returns the refresh token presented, given the current and the rotated ones.
+		token       func(m *Manager, id, current, rotated string) string
+		wantErr     error
+		wantRevoked bool
+	}{
+		{
+			name:  "current token",
+			token: func(_ *Manager, _, current, _ string) string { return current },
+		},
+		{
+			name:    "rotated token within the grace period",
+			token:   func(_ *Manager, _, _, rotated string) string { return rotated },
+			wantErr: ErrRefreshInvalid,
+		},
+		{
+			name: "rotated token reused",
+			token: func(m *Manager, id, _, rotated string) string {
+				m.sessions[id].rotatedAt = time.Now().Add(-reuseGrace)
+				return rotated
+			},
+			wantErr: ErrRevoked, wantRevoked: true,
+		},
+		{
+			name: "expired token",
+			token: func(m *Manager, id, current, _ string) string {
+				m.sessions[id].RefreshExpiresAt = time.Now().Add(-time.Second)
+				return current
+			},
+			wantErr: ErrRefreshInvalid,
+		},
+		{
+			name:    "forged token",
+			token:   func(_ *Manager, id, _, _ string) string { return id + ".forged" },
+			wantErr: ErrRefreshInvalid,
+		},
+		{
+			name:    "unknown session",
+			token:   func(_ *Manager, _, current, _ string) string { return xid.New().String() + current[20:] },
+			wantErr: ErrRefreshInvalid,
+		},
+		{
+			name:    "malformed token",
+			token:   func(_ *Manager, _, _, _ string) string { return "malformed" },
+			wantErr: ErrRefreshInvalid,
+		},
+		{
+			name: "revoked session",
+			token: func(m *Manager, id, current, _ string) string {
+				m.revoke(id)
+				return current
+			},
+			wantErr: ErrRevoked, wantRevoked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(time.Hour, 24*time.Hour)
+			s, rotated, err := m.Start(user.NewNamed("Alice"), "laptop", "192.0.2.1")
+			if err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			_, current, err := m.Refresh(rotated, "laptop", "192.0.2.1")
+			if err != nil {
+				t.Fatalf("refresh: %v", err)
+			}
+			if current == rotated {
+				t.Fatal("refresh token not rotated")
+			}
+
+			m.mu.Lock()
+			token := tt.token(m, s.ID, current, rotated)
+			m.mu.Unlock()
+
+			renewed, next, err := m.Refresh(token, "phone", "192.0.2.2")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if m.IsRevoked(s.ID) != tt.wantRevoked {
+				t.Fatalf("got revoked %t, want %t", m.IsRevoked(s.ID), tt.wantRevoked)
+			}
+			if err != nil {
+				return
+			}
+
+			if renewed.ID != s.ID || renewed.Device != "phone" || renewed.IP != "192.0.2.2" {
+				t.Fatalf("got session %+v, want %s renewed from the phone", renewed, s.ID)
+			}
+			if !strings.HasPrefix(next, s.ID+".") || next == current {
+				t.Fatalf("got refresh token %q, want a new one of the session", next)
+			}
+			if d := time.Until(renewed.RefreshExpiresAt); d < 23*time.Hour {
+				t.Fatalf("refresh token expires in %s, want %s", d, 24*time.Hour)
+			}
+		})
+	}
+}
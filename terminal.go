@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/mgjules/chat-demo/tui"
+	"github.com/mgjules/chat-demo/user"
+	"golang.org/x/exp/slog"
+	"golang.org/x/term"
+)
+
+// runTUI runs the terminal client of the chat.
+func runTUI(args []string) error {
+	// Load .env file is present.
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:"+cmp.Or(os.Getenv("HTTP_PORT"), "8080"), "base URL of the chat")
+	name := fs.String("name", "", "name of the guest or of the account (default a random guest name)")
+	withAccount := fs.Bool("account", false, "log in with an account, prompting for its password")
+	logFile := fs.String("log", "", "file to write the logs to (default none)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	opts := tui.Options{URL: *url, Name: *name}
+	if *withAccount {
+		if opts.Name == "" {
+			return errors.New("-account requires -name")
+		}
+
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("read password: %w", err)
+		}
+		opts.Password = string(password)
+	} else if opts.Name == "" {
+		opts.Name = user.RandomName()
+	}
+
+	// Logs would garble the screen.
+	var w io.Writer = io.Discard
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	opts.Logger = slog.New(slog.NewTextHandler(w, nil))
+	slog.SetDefault(opts.Logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return tui.Run(ctx, opts)
+}
@@ -0,0 +1,446 @@
+// Package tui is a full-screen terminal client of the chat.
+//
+// It speaks the JSON protocol of the chat through chatclient: the history of the room
+// is paged with the REST API while live events stream over the websocket.
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/exp/slog"
+)
+
+// List of limits of the client.
+const (
+	// historyPageSize is the number of messages fetched when scrolling past the top of the history.
+	historyPageSize = 100
+	// typingInterval is how often typing notifications are sent while typing.
+	typingInterval = 3 * time.Second
+	// typingTimeout is how long other users are shown typing after their last notification.
+	typingTimeout = 5 * time.Second
+	// statusTimeout is how long the status line shows the result of a request.
+	statusTimeout = 5 * time.Second
+)
+
+// globalCodes are the codes of the errors which concern the connection rather than a request.
+// They stay on screen until the client connects again.
+var globalCodes = []string{"room_full", "kicked", "existing_session", "session_revoked", "session_expired"}
+
+// quitCommands leave the client instead of being sent to the server.
+var quitCommands = []string{"/quit", "/exit"}
+
+// moderationVerbs describe the moderation actions.
+var moderationVerbs = map[string]string{"kick": "kicked", "mute": "muted", "unmute": "unmuted"}
+
+// Options configures the terminal client.
+type Options struct {
+	// URL is the base URL of the chat (e.g. "http://localhost:8080").
+	URL string
+	// Name is the name of the guest or of the account to log in with.
+	Name string
+	// Password logs in with an account when set, as a guest otherwise.
+	Password string
+	// Logger logs what cannot be shown on screen. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// tui is the state of the terminal client. It is only used by the goroutine running the loop.
+type tui struct {
+	client *chatclient.Client
+	screen tcell.Screen
+	logger *slog.Logger
+
+	user      protocol.User
+	numUsers  uint64
+	connected bool
+	// state describes the connection when not connected (e.g. "reconnecting in 2s").
+	state string
+
+	// entries are the messages and notices of the log, from the oldest to the newest.
+	entries []entry
+	// before is the cursor of the previous page of the history, empty once all of it is loaded.
+	before  string
+	loaded  bool
+	loading bool
+	pages   chan page
+
+	// scroll is the number of lines scrolled up from the bottom of the log.
+	scroll int
+	// unread is the number of messages received while scrolled up.
+	unread int
+	// lines is the number of lines of the log as of the last draw.
+	lines int
+
+	input  []rune
+	cursor int
+	// lastID and lastInput are the request and the content of the last message sent,
+	// restored when it is rate limited.
+	lastID     string
+	lastInput  string
+	lastTyping time.Time
+	typing     map[string]time.Time
+
+	// banner shows a global error until the client connects again.
+	banner   string
+	status   string
+	isError  bool
+	statusAt time.Time
+}
+
+// page is a page of the history fetched in the background.
+type page struct {
+	chatclient.Page
+	// latest is true for the first page, reloaded on every connection.
+	latest bool
+	err    error
+}
+
+// Run logs in and runs the client until the user quits or the client gives up
+// (e.g. once the session is revoked).
+func Run(ctx context.Context, opts Options) error {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	s, err := chatclient.Login(ctx, opts.URL, opts.Name, opts.Password, nil)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	client, err := chatclient.New(chatclient.Config{
+		URL:         opts.URL,
+		TokenSource: s.Token,
+		Logger:      opts.Logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("create screen: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return fmt.Errorf("init screen: %w", err)
+	}
+	defer screen.Fini()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- client.Run(ctx) }()
+
+	t := &tui{
+		client: client,
+		screen: screen,
+		logger: opts.Logger,
+		user:   s.User(),
+		state:  "connecting...",
+		pages:  make(chan page, 1),
+		typing: make(map[string]time.Time),
+	}
+	t.notice(false, "PgUp/PgDn or ↑/↓ to scroll, Esc to jump back, /help for the commands, /quit or Ctrl+C to leave.")
+	t.loop(ctx)
+
+	// Run returns once the events it is sending are dropped.
+	cancel()
+	for range client.Events() {
+	}
+
+	if err := <-errc; err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
+
+// loop handles the keys and the events of the room until the user quits or the client gives up.
+func (t *tui) loop(ctx context.Context) {
+	keys := make(chan tcell.Event, 16)
+	quit := make(chan struct{})
+	defer close(quit)
+	go t.screen.ChannelEvents(keys, quit)
+
+	// Expires typing users and statuses.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	t.draw()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-keys:
+			if !ok || !t.handleKey(ev) {
+				return
+			}
+		case e, ok := <-t.client.Events():
+			if !ok {
+				return
+			}
+			t.handleEvent(ctx, e)
+		case p := <-t.pages:
+			t.handlePage(p)
+		case <-ticker.C:
+		}
+
+		if t.scroll > 0 && t.atTop() {
+			t.fetchHistory(ctx, false)
+		}
+		t.draw()
+	}
+}
+
+// handleKey handles a terminal event. It returns false when the user quits.
+func (t *tui) handleKey(ev tcell.Event) bool {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		t.screen.Sync()
+	case *tcell.EventKey:
+		switch ev.Key() {
+		case tcell.KeyCtrlC:
+			return false
+		case tcell.KeyEnter:
+			return t.submit()
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if t.cursor > 0 {
+				t.input = slices.Delete(t.input, t.cursor-1, t.cursor)
+				t.cursor--
+			}
+		case tcell.KeyDelete:
+			if t.cursor < len(t.input) {
+				t.input = slices.Delete(t.input, t.cursor, t.cursor+1)
+			}
+		case tcell.KeyLeft:
+			t.cursor = max(t.cursor-1, 0)
+		case tcell.KeyRight:
+			t.cursor = min(t.cursor+1, len(t.input))
+		case tcell.KeyHome, tcell.KeyCtrlA:
+			t.cursor = 0
+		case tcell.KeyEnd, tcell.KeyCtrlE:
+			t.cursor = len(t.input)
+		case tcell.KeyCtrlU:
+			t.input, t.cursor = nil, 0
+		case tcell.KeyUp:
+			t.scrollBy(1)
+		case tcell.KeyDown:
+			t.scrollBy(-1)
+		case tcell.KeyPgUp:
+			t.scrollBy(t.logHeight() / 2)
+		case tcell.KeyPgDn:
+			t.scrollBy(-t.logHeight() / 2)
+		case tcell.KeyEscape:
+			t.scrollBy(-t.scroll)
+		case tcell.KeyRune:
+			t.input = slices.Insert(t.input, t.cursor, ev.Rune())
+			t.cursor++
+			t.notifyTyping()
+		}
+	}
+
+	return true
+}
+
+// submit sends the input as a message or as a slash command. It returns false when the user quits.
+func (t *tui) submit() bool {
+	input := strings.TrimSpace(string(t.input))
+	if input == "" {
+		return true
+	}
+	if slices.Contains(quitCommands, input) {
+		return false
+	}
+
+	var (
+		id  string
+		err error
+	)
+	if strings.HasPrefix(input, "/") {
+		id, err = t.client.Command(input)
+	} else {
+		id, err = t.client.Send(input)
+	}
+	if err != nil {
+		// Keep the input to send it again once connected.
+		t.setStatus(true, err.Error())
+		return true
+	}
+
+	t.lastID, t.lastInput = id, input
+	t.input, t.cursor = nil, 0
+	t.scrollBy(-t.scroll)
+
+	return true
+}
+
+// notifyTyping tells the other users that the user is typing, at most once per typingInterval.
+func (t *tui) notifyTyping() {
+	if strings.HasPrefix(string(t.input), "/") || time.Since(t.lastTyping) < typingInterval {
+		return
+	}
+
+	if _, err := t.client.Typing(); err == nil {
+		t.lastTyping = time.Now()
+	}
+}
+
+// handleEvent updates the state with an event of the room.
+func (t *tui) handleEvent(ctx context.Context, e chatclient.Event) {
+	switch e := e.(type) {
+	case chatclient.ReadyEvent:
+		t.user, t.connected, t.banner = e.User, true, ""
+		// Messages may have been missed while disconnected.
+		t.fetchHistory(ctx, true)
+	case chatclient.DisconnectedEvent:
+		t.connected = false
+		t.state = "reconnecting in " + e.Retry.Round(time.Second).String()
+		t.logger.InfoContext(ctx, "disconnected", "err", e.Err, "retry", e.Retry)
+	case chatclient.UserEvent:
+		t.user = e.User
+	case chatclient.NumUsersEvent:
+		t.numUsers = e.Count
+	case chatclient.MessageEvent:
+		t.addMessages(e.MessageEvent)
+		if e.User != nil {
+			delete(t.typing, e.User.Name)
+		}
+		if t.scroll > 0 {
+			t.unread++
+		}
+	case chatclient.ReactionEvent:
+		if i := t.find(e.MessageID); i != -1 {
+			t.entries[i].msg.Reactions = e.Reactions
+		}
+	case chatclient.TypingEvent:
+		t.typing[e.User.Name] = time.Now()
+	case chatclient.JoinEvent:
+		t.notice(false, e.User.Name+" joined")
+	case chatclient.LeaveEvent:
+		delete(t.typing, e.User.Name)
+		t.notice(false, e.User.Name+" left")
+	case chatclient.NoticeEvent:
+		for _, line := range e.Lines {
+			t.notice(false, line)
+		}
+	case chatclient.ModerationEvent:
+		// Kicks and mutes are also announced by system messages, only the user is told about unmutes.
+		if e.Target.ID == t.user.ID {
+			t.notice(e.Action != "unmute", moderationText(e.ModerationEvent))
+		}
+	case chatclient.ErrorEvent:
+		t.handleError(e)
+	}
+}
+
+// handleError shows the error of a request, or of the connection for global errors.
+func (t *tui) handleError(e chatclient.ErrorEvent) {
+	if slices.Contains(globalCodes, e.Code) {
+		t.banner = e.Message
+		return
+	}
+
+	// Rate limited messages are not lost: they are put back in the input to be sent again.
+	if e.Code == "rate_limited" && e.RequestID != "" && e.RequestID == t.lastID && len(t.input) == 0 {
+		t.input = []rune(t.lastInput)
+		t.cursor = len(t.input)
+	}
+
+	t.setStatus(true, e.Message)
+}
+
+// fetchHistory fetches the latest page of the history or the previous page of the one loaded.
+func (t *tui) fetchHistory(ctx context.Context, latest bool) {
+	if t.loading || (!latest && (!t.loaded || t.before == "")) {
+		return
+	}
+
+	before := t.before
+	if latest {
+		before = ""
+	}
+
+	t.loading = true
+	go func() {
+		p, err := t.client.Messages(ctx, before, historyPageSize)
+		select {
+		case t.pages <- page{Page: p, latest: latest, err: err}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// handlePage merges a page of the history into the log.
+func (t *tui) handlePage(p page) {
+	t.loading = false
+	if p.err != nil {
+		t.setStatus(true, "load history: "+p.err.Error())
+		return
+	}
+
+	t.addMessages(p.Messages...)
+	// The cursor of the latest page only matters the first time, older pages are kept on reconnection.
+	if !p.latest || !t.loaded {
+		t.before = p.Before
+		t.loaded = true
+	}
+}
+
+// addMessages adds or updates messages in the log, keeping it ordered by time.
+func (t *tui) addMessages(msgs ...protocol.MessageEvent) {
+	for _, msg := range msgs {
+		if i := t.find(msg.ID); i != -1 {
+			t.entries[i].msg = &msg
+			continue
+		}
+
+		t.entries = append(t.entries, entry{time: msg.Time, msg: &msg})
+	}
+
+	slices.SortStableFunc(t.entries, func(a, b entry) int { return a.time.Compare(b.time) })
+}
+
+// find returns the index of the entry of a message, -1 if it is not loaded.
+func (t *tui) find(id string) int {
+	return slices.IndexFunc(t.entries, func(e entry) bool { return e.msg != nil && e.msg.ID == id })
+}
+
+// notice adds a line to the log which is not a message of the room.
+func (t *tui) notice(isError bool, text string) {
+	t.entries = append(t.entries, entry{time: time.Now(), notice: text, isError: isError})
+}
+
+func (t *tui) setStatus(isError bool, text string) {
+	t.status, t.isError, t.statusAt = text, isError, time.Now()
+}
+
+// scrollBy scrolls the log up by n lines, down when negative.
+func (t *tui) scrollBy(n int) {
+	t.scroll = max(t.scroll+n, 0)
+	if t.scroll == 0 {
+		t.unread = 0
+	}
+}
+
+// moderationText describes a moderation event to its target.
+func moderationText(e protocol.ModerationEvent) string {
+	verb, found := moderationVerbs[e.Action]
+	if !found {
+		verb = e.Action
+	}
+
+	text := "you were " + verb + " by " + e.Moderator.Name
+	if d, err := time.ParseDuration(e.Duration); err == nil && d > 0 {
+		text += " for " + d.String()
+	}
+
+	return text
+}
@@ -0,0 +1,321 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+	"golang.org/x/exp/slog"
+)
+
+// newTestTUI creates a client drawing on a simulated screen, whose chat client is never connected.
+func newTestTUI(t *testing.T, w, h int) *tui {
+	t.Helper()
+
+	client, err := chatclient.New(chatclient.Config{URL: "http://localhost", Token: "token"})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("init screen: %v", err)
+	}
+	screen.SetSize(w, h)
+	t.Cleanup(screen.Fini)
+
+	return &tui{
+		client: client,
+		screen: screen,
+		logger: slog.Default(),
+		user:   protocol.User{ID: "1", Name: "Alice"},
+		pages:  make(chan page, 1),
+		typing: make(map[string]time.Time),
+	}
+}
+
+// screenLines returns the text of the lines of the screen, without trailing spaces.
+func screenLines(t *tui) []string {
+	s := t.screen.(tcell.SimulationScreen)
+	cells, w, h := s.GetContents()
+
+	lines := make([]string, h)
+	for y := range h {
+		var sb strings.Builder
+		for x := 0; x < w; x++ {
+			c := cells[y*w+x]
+			if len(c.Runes) > 0 && c.Runes[0] != 0 {
+				sb.WriteString(string(c.Runes))
+			}
+		}
+		lines[y] = strings.TrimRight(sb.String(), " ")
+	}
+
+	return lines
+}
+
+// text renders lines of glyphs.
+func text(lines [][]glyph) []string {
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		var sb strings.Builder
+		for _, g := range line {
+			sb.WriteRune(g.r)
+			sb.WriteString(string(g.comb))
+		}
+		res = append(res, sb.String())
+	}
+
+	return res
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{name: "fits", text: "15:04 hello", width: 20, want: []string{"15:04 hello"}},
+		{name: "between words", text: "15:04 hello wide world", width: 17, want: []string{"15:04 hello wide", "      world"}},
+		{name: "long word", text: "15:04 abcdefghijkl", width: 12, want: []string{"15:04 abcdef", "      ghijkl"}},
+		{name: "line break", text: "15:04 a\nb", width: 20, want: []string{"15:04 a", "      b"}},
+		{name: "narrow screen", text: "15:04 abc", width: 5, want: []string{"15:04", " abc"}},
+		{name: "wide runes", text: "15:04 日本語です", width: 14, want: []string{"15:04 日本語で", "      す"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := text(wrap(toGlyphs(tt.text, styleDefault), tt.width))
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToGlyphs(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		want      string
+		wantWidth int
+	}{
+		{name: "ascii", text: "hi", want: "hi", wantWidth: 2},
+		{name: "control characters", text: "a\x1b[31mb\x07", want: "a[31mb", wantWidth: 6},
+		{name: "tab", text: "a\tb", want: "a b", wantWidth: 3},
+		{name: "combining", text: "é", want: "é", wantWidth: 1},
+		{name: "wide", text: "日本", want: "日本", wantWidth: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := toGlyphs(tt.text, styleDefault)
+			if got := text([][]glyph{gs})[0]; got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if w := width(gs); w != tt.wantWidth {
+				t.Fatalf("got width %d, want %d", w, tt.wantWidth)
+			}
+		})
+	}
+}
+
+func TestModerationText(t *testing.T) {
+	tests := []struct {
+		name string
+		e    protocol.ModerationEvent
+		want string
+	}{
+		{name: "kick", e: protocol.ModerationEvent{Action: "kick", Moderator: protocol.User{Name: "Carol"}, Duration: "1m0s"}, want: "you were kicked by Carol for 1m0s"},
+		{name: "unmute", e: protocol.ModerationEvent{Action: "unmute", Moderator: protocol.User{Name: "Carol"}}, want: "you were unmuted by Carol"},
+		{name: "unknown action", e: protocol.ModerationEvent{Action: "ban", Moderator: protocol.User{Name: "Carol"}, Duration: "nope"}, want: "you were ban by Carol"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := moderationText(tt.e); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	tests := []struct {
+		name  string
+		e     chatclient.ErrorEvent
+		input string
+		// wantBanner is the banner shown, the status being the error otherwise.
+		wantBanner string
+		wantInput  string
+	}{
+		{
+			name:       "global error",
+			e:          chatclient.ErrorEvent{ErrorPayload: protocol.ErrorPayload{Code: "kicked", Message: "you were kicked"}},
+			wantBanner: "you were kicked",
+		},
+		{
+			name:      "rate limited message",
+			e:         chatclient.ErrorEvent{RequestID: "7", ErrorPayload: protocol.ErrorPayload{Code: "rate_limited", Message: "slow down"}},
+			wantInput: "hello",
+		},
+		{
+			name:      "rate limited while typing",
+			e:         chatclient.ErrorEvent{RequestID: "7", ErrorPayload: protocol.ErrorPayload{Code: "rate_limited", Message: "slow down"}},
+			input:     "next",
+			wantInput: "next",
+		},
+		{
+			name: "rate limited other request",
+			e:    chatclient.ErrorEvent{RequestID: "6", ErrorPayload: protocol.ErrorPayload{Code: "rate_limited", Message: "slow down"}},
+		},
+		{
+			name: "request error",
+			e:    chatclient.ErrorEvent{RequestID: "7", ErrorPayload: protocol.ErrorPayload{Code: "message_empty", Message: "empty"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tu := newTestTUI(t, 40, 10)
+			tu.lastID, tu.lastInput = "7", "hello"
+			tu.input = []rune(tt.input)
+			tu.cursor = len(tu.input)
+
+			tu.handleError(tt.e)
+
+			if tu.banner != tt.wantBanner {
+				t.Fatalf("got banner %q, want %q", tu.banner, tt.wantBanner)
+			}
+			if tt.wantBanner == "" && (tu.status != tt.e.Message || !tu.isError) {
+				t.Fatalf("got status %q, want the error %q", tu.status, tt.e.Message)
+			}
+			if got := string(tu.input); got != tt.wantInput || tu.cursor != len(tu.input) {
+				t.Fatalf("got input %q at %d, want %q", got, tu.cursor, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantQuit  bool
+		wantError bool
+	}{
+		{name: "empty", input: "  "},
+		{name: "quit", input: "/quit", wantQuit: true},
+		{name: "exit", input: " /exit ", wantQuit: true},
+		{name: "message while disconnected", input: "hello", wantError: true},
+		{name: "command while disconnected", input: "/who", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tu := newTestTUI(t, 40, 10)
+			tu.input = []rune(tt.input)
+
+			if quit := !tu.submit(); quit != tt.wantQuit {
+				t.Fatalf("quit %t, want %t", quit, tt.wantQuit)
+			}
+			if tt.wantError && (tu.status != chatclient.ErrNotConnected.Error() || string(tu.input) != tt.input) {
+				t.Fatalf("got status %q and input %q, want the input kept", tu.status, string(tu.input))
+			}
+		})
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bob := &protocol.User{ID: "2", Name: "Bob"}
+	now := time.Now()
+	tu := newTestTUI(t, 40, 10)
+	tu.banner = "you were kicked"
+
+	events := []chatclient.Event{
+		chatclient.ReadyEvent{ReadyEvent: protocol.ReadyEvent{User: protocol.User{ID: "1", Name: "Alice"}}},
+		chatclient.NumUsersEvent{NumUsersEvent: protocol.NumUsersEvent{Count: 2}},
+		chatclient.TypingEvent{TypingEvent: protocol.TypingEvent{User: *bob}},
+		chatclient.MessageEvent{MessageEvent: protocol.MessageEvent{ID: "m2", User: bob, Kind: "text", Content: "second", Time: now}},
+		chatclient.MessageEvent{MessageEvent: protocol.MessageEvent{ID: "m1", User: bob, Kind: "text", Content: "first", Time: now.Add(-time.Minute)}},
+		chatclient.ReactionEvent{ReactionEvent: protocol.ReactionEvent{MessageID: "m1", Reactions: []protocol.Reaction{{Emoji: "👍", Users: []string{"1"}}}}},
+		chatclient.DisconnectedEvent{Err: errors.New("lost"), Retry: 2 * time.Second},
+	}
+	for _, e := range events {
+		tu.handleEvent(ctx, e)
+	}
+
+	if !tu.loading || tu.banner != "" || tu.numUsers != 2 {
+		t.Fatalf("got loading %t, banner %q and %d users after connecting", tu.loading, tu.banner, tu.numUsers)
+	}
+	if _, typing := tu.typing["Bob"]; typing {
+		t.Fatal("Bob still typing after posting")
+	}
+	if tu.connected || tu.state != "reconnecting in 2s" {
+		t.Fatalf("got state %q after the disconnection", tu.state)
+	}
+
+	// Messages are kept in order, and updated in place.
+	var contents []string
+	for _, e := range tu.entries {
+		if e.msg != nil {
+			contents = append(contents, e.msg.Content)
+		}
+	}
+	if strings.Join(contents, ",") != "first,second" {
+		t.Fatalf("got messages %v", contents)
+	}
+	if i := tu.find("m1"); len(tu.entries[i].msg.Reactions) != 1 {
+		t.Fatal("reaction not added")
+	}
+}
+
+func TestDraw(t *testing.T) {
+	tu := newTestTUI(t, 50, 6)
+	tu.numUsers, tu.connected = 2, true
+	for i, content := range []string{"one", "two", "three", "four"} {
+		tu.addMessages(protocol.MessageEvent{
+			ID:      content,
+			User:    &protocol.User{ID: "2", Name: "Bob"},
+			Kind:    "text",
+			Content: content,
+			Time:    time.Date(2024, 1, 1, 10, i, 0, 0, time.Local),
+		})
+	}
+	tu.input, tu.cursor = []rune("hi"), 2
+
+	tu.draw()
+	want := []string{
+		" #general │ 2 online │ Alice",
+		"10:01 Bob: two",
+		"10:02 Bob: three",
+		"10:03 Bob: four",
+		"",
+		"> hi",
+	}
+	got := screenLines(tu)
+	if !strings.HasPrefix(got[0], want[0]) || !strings.HasSuffix(got[0], "connected") {
+		t.Fatalf("got bar %q", got[0])
+	}
+	if strings.Join(got[1:], "|") != strings.Join(want[1:], "|") {
+		t.Fatalf("got lines %q, want %q", got[1:], want[1:])
+	}
+
+	// Scrolling up shows older messages, and how far up the log is.
+	tu.scrollBy(1)
+	tu.unread = 1
+	tu.draw()
+	got = screenLines(tu)
+	if got[1] != "10:00 Bob: one" || !strings.HasPrefix(got[4], "↑ 1 lines up, 1 new messages") {
+		t.Fatalf("got lines %q after scrolling", got)
+	}
+}
@@ -0,0 +1,324 @@
+package tui
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/mgjules/chat-demo/chatclient"
+	"github.com/mgjules/chat-demo/protocol"
+)
+
+// indent is the width of the time prefix, which wrapped lines are aligned after.
+const indent = len("15:04 ")
+
+// List of styles of the client.
+var (
+	styleDefault = tcell.StyleDefault
+	styleDim     = styleDefault.Foreground(tcell.ColorGray)
+	styleBar     = styleDefault.Reverse(true)
+	styleError   = styleDefault.Foreground(tcell.ColorRed)
+	styleBanner  = styleDefault.Background(tcell.ColorDarkRed).Foreground(tcell.ColorWhite).Bold(true)
+	styleSelf    = styleDefault.Bold(true)
+)
+
+// nameColors are the colors of the names of the other users, picked from their ID.
+var nameColors = []tcell.Color{
+	tcell.ColorTeal, tcell.ColorGreen, tcell.ColorOlive, tcell.ColorPurple,
+	tcell.ColorNavy, tcell.ColorMaroon, tcell.ColorFuchsia, tcell.ColorAqua,
+}
+
+// entry is a line of the log: a message of the room or a notice of the client.
+type entry struct {
+	time time.Time
+	// msg is nil for notices.
+	msg     *protocol.MessageEvent
+	notice  string
+	isError bool
+}
+
+// glyph is a character of the screen along with its style.
+type glyph struct {
+	r rune
+	// comb are the combining characters following r (e.g. accents, emoji modifiers).
+	comb  []rune
+	width int
+	style tcell.Style
+}
+
+// draw renders the whole screen.
+func (t *tui) draw() {
+	t.screen.Clear()
+	w, h := t.screen.Size()
+
+	t.drawBar(w)
+
+	var lines [][]glyph
+	for _, e := range t.entries {
+		lines = append(lines, wrap(t.glyphs(e), w)...)
+	}
+	t.lines = len(lines)
+
+	// The log is aligned to the bottom, like the page of the chat.
+	height := t.logHeight()
+	t.scroll = min(t.scroll, max(len(lines)-height, 0))
+	end := len(lines) - t.scroll
+	start := max(end-height, 0)
+	y := 1 + height - (end - start)
+	for _, line := range lines[start:end] {
+		drawGlyphs(t.screen, 0, y, w, line)
+		y++
+	}
+
+	t.drawStatus(w, h-2)
+	t.drawInput(w, h-1)
+	t.screen.Show()
+}
+
+// drawBar renders the top bar with the room, its number of users and the state of the connection.
+func (t *tui) drawBar(w int) {
+	for x := range w {
+		t.screen.SetContent(x, 0, ' ', nil, styleBar)
+	}
+
+	left := " #" + chatclient.DefaultRoom + " │ " + strconv.FormatUint(t.numUsers, 10) + " online │ " + t.user.Name
+	drawGlyphs(t.screen, 0, 0, w, toGlyphs(left, styleBar))
+
+	state := "connected "
+	if !t.connected {
+		state = t.state + " "
+	}
+	if x := w - runewidth.StringWidth(state); x > runewidth.StringWidth(left) {
+		drawGlyphs(t.screen, x, 0, w, toGlyphs(state, styleBar))
+	}
+}
+
+// drawStatus renders the global error, the result of the last request, the scroll position
+// or the users typing, whichever matters most.
+func (t *tui) drawStatus(w, y int) {
+	var gs []glyph
+	switch {
+	case t.banner != "":
+		gs = toGlyphs(" "+t.banner+" ", styleBanner)
+	case t.status != "" && time.Since(t.statusAt) < statusTimeout:
+		style := styleDim
+		if t.isError {
+			style = styleError
+		}
+		gs = toGlyphs(t.status, style)
+	case t.scroll > 0:
+		text := "↑ " + strconv.Itoa(t.scroll) + " lines up"
+		switch {
+		case t.loading:
+			text += ", loading history..."
+		case t.atTop() && t.loaded && t.before == "":
+			text += ", beginning of the history"
+		}
+		if t.unread > 0 {
+			text += ", " + strconv.Itoa(t.unread) + " new messages"
+		}
+		gs = toGlyphs(text+" (Esc to jump back)", styleDim)
+	default:
+		gs = toGlyphs(t.typingText(), styleDim)
+	}
+
+	drawGlyphs(t.screen, 0, y, w, gs)
+}
+
+// typingText describes the users typing, forgetting the ones which stopped.
+func (t *tui) typingText() string {
+	var names []string
+	for name, at := range t.typing {
+		if time.Since(at) > typingTimeout {
+			delete(t.typing, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0] + " is typing..."
+	case 2, 3:
+		return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1] + " are typing..."
+	default:
+		return "several people are typing..."
+	}
+}
+
+// drawInput renders the input line, scrolled horizontally to keep the cursor visible.
+func (t *tui) drawInput(w, y int) {
+	prompt := toGlyphs("> ", styleDim)
+	gs := toGlyphs(string(t.input), styleDefault)
+
+	// The input only holds printable runes so glyphs match runes.
+	start := 0
+	for width(gs[start:t.cursor]) > w-len(prompt)-1 {
+		start++
+	}
+
+	drawGlyphs(t.screen, 0, y, w, prompt)
+	drawGlyphs(t.screen, len(prompt), y, w, gs[start:])
+	t.screen.ShowCursor(len(prompt)+width(gs[start:t.cursor]), y)
+}
+
+// glyphs renders an entry of the log, before wrapping.
+func (t *tui) glyphs(e entry) []glyph {
+	gs := toGlyphs(e.time.Local().Format("15:04")+" ", styleDim)
+
+	if e.msg == nil {
+		style := styleDim
+		if e.isError {
+			style = styleError
+		}
+		return append(gs, toGlyphs("-- "+e.notice, style)...)
+	}
+
+	msg := e.msg
+	switch {
+	case msg.User == nil:
+		gs = append(gs, toGlyphs("-- "+msg.Content, styleDim)...)
+	case msg.Kind == "action":
+		gs = append(gs, toGlyphs("* ", styleDefault)...)
+		gs = append(gs, t.name(*msg.User)...)
+		gs = append(gs, toGlyphs(" "+msg.Content, styleDefault)...)
+	default:
+		gs = append(gs, t.name(*msg.User)...)
+		gs = append(gs, toGlyphs(": "+msg.Content, styleDefault)...)
+	}
+
+	if len(msg.Reactions) > 0 {
+		reactions := make([]string, 0, len(msg.Reactions))
+		for _, r := range msg.Reactions {
+			reactions = append(reactions, r.Emoji+" "+strconv.Itoa(len(r.Users)))
+		}
+		gs = append(gs, toGlyphs("\n"+strings.Join(reactions, "  "), styleDim)...)
+	}
+
+	return gs
+}
+
+// name renders the name of the author of a message with its icon and badges.
+func (t *tui) name(u protocol.User) []glyph {
+	style := styleSelf
+	if u.ID != t.user.ID {
+		h := fnv.New32a()
+		h.Write([]byte(u.ID))
+		style = styleDefault.Foreground(nameColors[h.Sum32()%uint32(len(nameColors))]).Bold(true)
+	}
+
+	var gs []glyph
+	if u.Icon != "" {
+		gs = toGlyphs(u.Icon+" ", styleDefault)
+	}
+	gs = append(gs, toGlyphs(u.Name, style)...)
+
+	switch {
+	case u.Webhook:
+		gs = append(gs, toGlyphs(" [webhook]", styleDim)...)
+	case u.Bot:
+		gs = append(gs, toGlyphs(" [bot]", styleDim)...)
+	}
+
+	return gs
+}
+
+// logHeight returns the number of lines of the log on screen.
+func (t *tui) logHeight() int {
+	_, h := t.screen.Size()
+	return max(h-3, 1)
+}
+
+// atTop checks if the log is scrolled to the top of the history loaded.
+func (t *tui) atTop() bool {
+	return t.scroll >= t.lines-t.logHeight()
+}
+
+// toGlyphs converts a text to glyphs. Line breaks are kept for wrap, other control characters are dropped.
+func toGlyphs(text string, style tcell.Style) []glyph {
+	gs := make([]glyph, 0, len(text))
+	for _, r := range text {
+		switch w := runewidth.RuneWidth(r); {
+		case r == '\n':
+			gs = append(gs, glyph{r: r, style: style})
+		case r == '\t':
+			gs = append(gs, glyph{r: ' ', width: 1, style: style})
+		case r < ' ':
+		case w == 0 && len(gs) > 0:
+			gs[len(gs)-1].comb = append(gs[len(gs)-1].comb, r)
+		case w > 0:
+			gs = append(gs, glyph{r: r, width: w, style: style})
+		}
+	}
+
+	return gs
+}
+
+// wrap splits glyphs into lines fitting the width, breaking between words when possible.
+// Lines following the first one are aligned after the time prefix.
+func wrap(gs []glyph, w int) [][]glyph {
+	pad := indent
+	if w < 2*indent {
+		pad = 0
+	}
+	newLine := func() []glyph {
+		return slices.Repeat([]glyph{{r: ' ', width: 1}}, pad)
+	}
+
+	var (
+		lines [][]glyph
+		line  []glyph
+		space = -1
+	)
+	for _, g := range gs {
+		if g.r == '\n' {
+			lines, line, space = append(lines, line), newLine(), -1
+			continue
+		}
+
+		if width(line)+g.width > w && len(line) > pad {
+			if space > pad {
+				rest := slices.Clone(line[space+1:])
+				lines, line = append(lines, line[:space]), append(newLine(), rest...)
+			} else {
+				lines, line = append(lines, line), newLine()
+			}
+			space = -1
+		}
+
+		if g.r == ' ' {
+			space = len(line)
+		}
+		line = append(line, g)
+	}
+
+	return append(lines, line)
+}
+
+// width returns the number of cells taken by glyphs.
+func width(gs []glyph) int {
+	var w int
+	for _, g := range gs {
+		w += g.width
+	}
+
+	return w
+}
+
+// drawGlyphs draws glyphs from x, cutting them at the width of the screen.
+func drawGlyphs(s tcell.Screen, x, y, w int, gs []glyph) {
+	for _, g := range gs {
+		if x+g.width > w {
+			return
+		}
+		s.SetContent(x, y, g.r, g.comb, g.style)
+		x += g.width
+	}
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	goaway "github.com/TwiN/go-away"
+	"github.com/go-faker/faker/v4"
+	"github.com/go-faker/faker/v4/pkg/options"
+)
+
+const (
+	minNameLength = 3
+	maxNameLength = 32
+)
+
+// List of name validation errors.
+var (
+	ErrNameLength     = fmt.Errorf("name must be between %d and %d characters", minNameLength, maxNameLength)
+	ErrNameCharacters = errors.New("name can only contain letters, digits, spaces and - _ . '")
+	ErrNameScripts    = errors.New("name cannot mix letters from different alphabets")
+	ErrNameProfane    = errors.New("name cannot contain bad words")
+	ErrNameReserved   = errors.New("name is reserved")
+)
+
+// reservedNames cannot be used, nor can any name that looks like them.
+var reservedNames = []string{"admin", "administrator", "moderator", "mod", "system", "root", "staff", "support"}
+
+// confusables maps characters to the latin character they look like.
+var confusables = map[rune]rune{
+	// Cyrillic.
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'l', 'ї': 'l', 'ј': 'j', 'ԁ': 'd',
+	// Greek.
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+	// Digits and latin look-alikes.
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', 'i': 'l', '|': 'l',
+}
+
+// RandomName generates a random human-readable name which NormalizeName accepts.
+func RandomName() string {
+	// Prevents faker from tracking duplicates since it does that in a non-threadsafe manner.
+	nonunique := options.WithGenerateUniqueValues(false)
+	for {
+		// Some names are too long or caught by the profanity filter (e.g. "Kassulke").
+		name := faker.FirstName(nonunique) + " " + faker.LastName(nonunique)
+		if _, err := NormalizeName(name); err == nil {
+			return name
+		}
+	}
+}
+
+// NormalizeName trims and validates a display name.
+func NormalizeName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+
+	if n := len([]rune(name)); n < minNameLength || n > maxNameLength {
+		return "", ErrNameLength
+	}
+
+	var script *unicode.RangeTable
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r):
+			s := scriptOf(r)
+			if script != nil && s != script {
+				return "", ErrNameScripts
+			}
+			script = s
+		case unicode.IsDigit(r), strings.ContainsRune(" -_.'", r):
+		default:
+			return "", ErrNameCharacters
+		}
+	}
+
+	if goaway.IsProfane(name) {
+		return "", ErrNameProfane
+	}
+
+	skeleton := Skeleton(name)
+	for _, reserved := range reservedNames {
+		if skeleton == Skeleton(reserved) {
+			return "", ErrNameReserved
+		}
+	}
+
+	return name, nil
+}
+
+// Skeleton reduces a name to a form where look-alike names are equal
+// (e.g. "B0b", "bob" and "Вов" all have the same skeleton).
+func Skeleton(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if c, found := confusables[r]; found {
+			r = c
+		}
+
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+
+	skeleton := sb.String()
+	skeleton = strings.ReplaceAll(skeleton, "rn", "m")
+	skeleton = strings.ReplaceAll(skeleton, "vv", "w")
+
+	return skeleton
+}
+
+func scriptOf(r rune) *unicode.RangeTable {
+	// Japanese commonly mixes kanji and kana.
+	if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+		return unicode.Han
+	}
+
+	for _, s := range []*unicode.RangeTable{
+		unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Arabic, unicode.Hebrew,
+		unicode.Han, unicode.Hangul, unicode.Devanagari,
+	} {
+		if unicode.Is(s, r) {
+			return s
+		}
+	}
+
+	return nil
+}
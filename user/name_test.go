@@ -0,0 +1,77 @@
+package user
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "simple", input: "Alice", want: "Alice"},
+		{name: "extra spaces", input: "  Jean   Pierre ", want: "Jean Pierre"},
+		{name: "punctuation", input: "O'Brien-Smith_2.0", want: "O'Brien-Smith_2.0"},
+		{name: "other alphabet", input: "Алиса", want: "Алиса"},
+		{name: "kanji and kana", input: "山田たろう", want: "山田たろう"},
+		{name: "too short", input: " ab ", wantErr: ErrNameLength},
+		{name: "too long", input: "abcdefghijklmnopqrstuvwxyzabcdefg", wantErr: ErrNameLength},
+		{name: "symbols", input: "<script>", wantErr: ErrNameCharacters},
+		{name: "emoji", input: "Bob 🙂", wantErr: ErrNameCharacters},
+		{name: "mixed alphabets", input: "Вob", wantErr: ErrNameScripts},
+		{name: "profane", input: "shithead", wantErr: ErrNameProfane},
+		{name: "reserved", input: "Admin", wantErr: ErrNameReserved},
+		{name: "look-alike of a reserved name", input: "4dm1n", wantErr: ErrNameReserved},
+		{name: "reserved with separators", input: "s.y.s.t.e.m", wantErr: ErrNameReserved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeName(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got name %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{a: "Bob", b: "bob", same: true},
+		{a: "Bob", b: "B0b", same: true},
+		{a: "bob", b: "Ьоb"},
+		{a: "Alice", b: "A1ice", same: true},
+		{a: "Alice", b: "ALlCE", same: true},
+		{a: "Mary", b: "rnary", same: true},
+		{a: "Walter", b: "VValter", same: true},
+		{a: "Jean Pierre", b: "jean-pierre", same: true},
+		{a: "Carol", b: "Сarоl", same: true},
+		{a: "Alice", b: "Alicia"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if same := Skeleton(tt.a) == Skeleton(tt.b); same != tt.same {
+				t.Fatalf("Skeleton(%q) = %q, Skeleton(%q) = %q", tt.a, Skeleton(tt.a), tt.b, Skeleton(tt.b))
+			}
+		})
+	}
+}
+
+func TestRandomName(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		name := RandomName()
+		if _, err := NormalizeName(name); err != nil {
+			t.Fatalf("random name %q is invalid: %v", name, err)
+		}
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/go-faker/faker/v4"
-	"github.com/go-faker/faker/v4/pkg/options"
 	"github.com/rs/xid"
 )
 
@@ -13,26 +11,76 @@ type userContextKey string
 
 const userCtxKey userContextKey = "user"
 
+// Role is the role of a user within the chat.
+type Role uint8
+
+// List of roles, from the least to the most privileged.
+const (
+	RoleMember Role = iota
+	RoleModerator
+	RoleAdmin
+)
+
+// String implements the fmt.Stringer interface.
+func (r Role) String() string {
+	switch r {
+	case RoleModerator:
+		return "moderator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "member"
+	}
+}
+
+// ParseRole parses the string form of a role.
+func ParseRole(s string) (Role, bool) {
+	for _, r := range []Role{RoleMember, RoleModerator, RoleAdmin} {
+		if r.String() == s {
+			return r, true
+		}
+	}
+
+	return RoleMember, false
+}
+
 // User holds information about a user.
 type User struct {
 	ID   xid.ID
 	Name string
+	Role Role
+	// Bot marks automated users posting through the API.
+	Bot bool
+	// Webhook marks the users of the messages posted through incoming webhooks.
+	Webhook bool
+	// Icon is an emoji shown before the name, if any.
+	Icon string
 }
 
-// New creates a new User.
+// New creates a new User with a random name.
 func New() *User {
 	id := xid.New()
-	// Prevents faker from tracking duplicates since it does that in a non-threadsafe manner.
-	// Instead we seed the Name with sections of the ID.
-	nonunique := options.WithGenerateUniqueValues(false)
+	// Seed the Name with sections of the ID to make it unique.
+	return &User{
+		ID:   id,
+		Name: fmt.Sprintf("%s (%s%s)", RandomName(), id.String()[4:8], id.String()[15:]),
+	}
+}
+
+// NewNamed creates a new User with the given name.
+// The name is expected to be validated by NormalizeName.
+func NewNamed(name string) *User {
 	return &User{
-		ID: id,
-		Name: fmt.Sprintf("%s %s (%s%s)",
-			faker.FirstName(nonunique), faker.LastName(nonunique), id.String()[4:8], id.String()[15:],
-		),
+		ID:   xid.New(),
+		Name: name,
 	}
 }
 
+// HasRole checks if the user has at least the given role.
+func (u *User) HasRole(r Role) bool {
+	return u.Role >= r
+}
+
 // AddToContext adds a user to the context.
 func AddToContext(ctx context.Context, user *User) context.Context {
 	return context.WithValue(ctx, userCtxKey, user)
@@ -0,0 +1,328 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/protocol"
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+)
+
+// queueSize is the number of events waiting to be queued
+// before events are dropped to protect the rooms.
+const queueSize = 1024
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Client sends the deliveries. Defaults to a client with a 10s timeout.
+	Client *http.Client
+	// MaxAttempts is the number of attempts before a delivery is dead. Defaults to 8.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled after every attempt. Defaults to 10s.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts. Defaults to 1h.
+	MaxBackoff time.Duration
+	// Concurrency is the number of deliveries sent at the same time. Defaults to 4.
+	Concurrency int
+	// PollInterval is how often the queue is checked for retries. Defaults to 1s.
+	PollInterval time.Duration
+}
+
+// Dispatcher queues the events of rooms for their webhooks and delivers them in the background.
+type Dispatcher struct {
+	store  Store
+	opts   Options
+	events chan queued
+	wake   chan struct{}
+	sem    chan struct{}
+
+	mu       sync.Mutex
+	inflight map[xid.ID]struct{}
+}
+
+// queued is an event of a room waiting to be queued for its webhooks.
+type queued struct {
+	room string
+	typ  EventType
+	data any
+	time time.Time
+}
+
+// NewDispatcher creates a new Dispatcher.
+func NewDispatcher(s Store, opts Options) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 10 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	return &Dispatcher{
+		store:    s,
+		opts:     opts,
+		events:   make(chan queued, queueSize),
+		wake:     make(chan struct{}, 1),
+		sem:      make(chan struct{}, opts.Concurrency),
+		inflight: make(map[xid.ID]struct{}),
+	}
+}
+
+// Observer returns the observer forwarding the events of a room to its webhooks.
+// Events are handed over to the dispatcher without waiting for them to be queued.
+func (d *Dispatcher) Observer(room string) chat.Observer {
+	return func(ctx context.Context, e chat.Event) {
+		q := queued{room: room, time: time.Now().UTC()}
+		switch e := e.(type) {
+		case chat.MessageEvent:
+			q.typ, q.data, q.time = EventMessageCreated, protocol.NewMessageEvent(e.Message), e.Message.Time
+		case chat.MessageEditedEvent:
+			q.typ, q.data, q.time = EventMessageEdited, protocol.NewMessageEvent(e.Message), e.Message.Edited
+		case chat.MessageDeletedEvent:
+			q.typ, q.data, q.time = EventMessageDeleted, protocol.NewMessageDeletedEvent(e), e.Time
+		case chat.JoinEvent:
+			q.typ, q.data, q.time = EventUserJoined, protocol.PresenceEvent{User: protocol.NewUser(e.User), Time: e.Time}, e.Time
+		case chat.LeaveEvent:
+			q.typ, q.data, q.time = EventUserLeft, protocol.PresenceEvent{User: protocol.NewUser(e.User), Time: e.Time}, e.Time
+		case chat.ModerationEvent:
+			q.typ, q.data, q.time = EventType("moderation."+string(e.Action)), protocol.NewModerationEvent(e), e.Time
+		default:
+			return
+		}
+
+		select {
+		case d.events <- q:
+		default:
+			slog.WarnContext(ctx, "webhook queue full, dropping event", "event", q.typ, "room", room)
+		}
+	}
+}
+
+// Wake makes the dispatcher check its queue right away (e.g. after a requeue).
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run queues the events of the rooms and delivers the due deliveries until the context is done.
+// Deliveries queued before a restart are resumed.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-d.events:
+			if err := d.enqueue(ctx, q); err != nil {
+				slog.ErrorContext(ctx, "queue webhook deliveries", "err", err, "event", q.typ, "room", q.room)
+			}
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		if err := d.dispatch(ctx); err != nil {
+			slog.ErrorContext(ctx, "dispatch webhook deliveries", "err", err)
+		}
+	}
+}
+
+// enqueue queues a delivery of the event for each webhook of the room accepting it.
+func (d *Dispatcher) enqueue(ctx context.Context, q queued) error {
+	webhooks, err := d.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(q.data)
+	if err != nil {
+		return fmt.Errorf("marshal %s data: %w", q.typ, err)
+	}
+	body, err := json.Marshal(Event{
+		ID:   xid.New().String(),
+		Type: q.typ,
+		Room: q.room,
+		Time: q.time,
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal %s event: %w", q.typ, err)
+	}
+
+	var ds []*Delivery
+	now := time.Now().UTC()
+	for _, w := range webhooks {
+		if w.Room != q.room || !w.Accepts(q.typ) {
+			continue
+		}
+
+		ds = append(ds, &Delivery{
+			ID:          xid.New(),
+			WebhookID:   w.ID,
+			Event:       q.typ,
+			Body:        body,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+	if len(ds) == 0 {
+		return nil
+	}
+
+	return d.store.Enqueue(ctx, ds...)
+}
+
+// dispatch starts sending the due deliveries, as long as there are free workers.
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	pending, err := d.store.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, dl := range pending {
+		if dl.NextAttempt.After(now) || !d.claim(dl.ID) {
+			continue
+		}
+
+		select {
+		case d.sem <- struct{}{}:
+		default:
+			// All workers are busy, the remaining deliveries wait for the next check.
+			d.release(dl.ID)
+			return nil
+		}
+
+		go func(dl *Delivery) {
+			defer func() {
+				<-d.sem
+				d.release(dl.ID)
+			}()
+
+			if err := d.deliver(ctx, dl); err != nil {
+				slog.ErrorContext(ctx, "deliver webhook", "err", err, "delivery.id", dl.ID, "webhook.id", dl.WebhookID)
+			}
+		}(dl)
+	}
+
+	return nil
+}
+
+// deliver attempts to send a delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, dl *Delivery) error {
+	w, err := d.store.Get(ctx, dl.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	dl.Attempts++
+	a := Attempt{
+		DeliveryID: dl.ID,
+		WebhookID:  dl.WebhookID,
+		Event:      dl.Event,
+		Number:     dl.Attempts,
+		Time:       time.Now().UTC(),
+	}
+
+	a.Status, err = d.send(ctx, w, dl)
+	a.Duration = time.Since(a.Time)
+	if err != nil {
+		a.Error = err.Error()
+	}
+	dl.LastStatus, dl.LastError = a.Status, a.Error
+
+	st := StateDone
+	if err != nil {
+		st = StatePending
+		dl.NextAttempt = time.Now().UTC().Add(d.backoff(dl.Attempts))
+		if dl.Attempts >= d.opts.MaxAttempts {
+			st = StateDead
+			slog.WarnContext(ctx, "webhook delivery dead", "delivery.id", dl.ID, "webhook.id", w.ID, "err", err)
+		}
+	}
+
+	return d.store.Record(ctx, dl, a, st)
+}
+
+// send posts the signed delivery to its webhook and returns the status code of the response.
+// Responses which are not 2xx are errors.
+func (d *Dispatcher) send(ctx context.Context, w *Webhook, dl *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-demo-webhook/1")
+	req.Header.Set(HeaderEvent, string(dl.Event))
+	req.Header.Set(HeaderDelivery, dl.ID.String())
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(now.Unix()))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, now, dl.Body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain some of the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt of a delivery.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.Backoff
+	for i := 1; i < attempts && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, d.opts.MaxBackoff)
+}
+
+// claim marks a delivery as being sent so that it is not sent twice at the same time.
+func (d *Dispatcher) claim(id xid.ID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.inflight[id]; found {
+		return false
+	}
+	d.inflight[id] = struct{}{}
+
+	return true
+}
+
+func (d *Dispatcher) release(id xid.ID) {
+	d.mu.Lock()
+	delete(d.inflight, id)
+	d.mu.Unlock()
+}
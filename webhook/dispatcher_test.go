@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgjules/chat-demo/chat"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// receiver is a webhook endpoint answering with a list of statuses, the last one repeating.
+type receiver struct {
+	*httptest.Server
+	t        *testing.T
+	statuses []int
+
+	mu     sync.Mutex
+	secret string
+	events []Event
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	r := &receiver{t: t, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	if err := Verify(r.secret, req.Header, body, time.Minute); err != nil {
+		r.t.Errorf("verify delivery: %v", err)
+	}
+
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		r.t.Errorf("decode delivery: %v", err)
+	}
+	if got := req.Header.Get(HeaderEvent); got != string(e.Type) {
+		r.t.Errorf("got event header %q, want %q", got, e.Type)
+	}
+	r.events = append(r.events, e)
+
+	w.WriteHeader(r.statuses[min(len(r.events), len(r.statuses))-1])
+}
+
+func (r *receiver) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Event(nil), r.events...)
+}
+
+// register creates a webhook of the room "general" sending its events to the receiver.
+func (r *receiver) register(t *testing.T, store Store, events ...EventType) *Webhook {
+	t.Helper()
+
+	w, err := New("general", r.URL, events, xid.New())
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	if err := store.Create(context.Background(), w); err != nil {
+		t.Fatalf("store webhook: %v", err)
+	}
+
+	r.mu.Lock()
+	r.secret = w.Secret
+	r.mu.Unlock()
+
+	return w
+}
+
+// run runs a dispatcher which retries right away three times at most.
+func run(t *testing.T, store Store) *Dispatcher {
+	t.Helper()
+
+	d := NewDispatcher(store, Options{
+		MaxAttempts:  3,
+		Backoff:      time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return d
+}
+
+// waitAttempts waits for the webhook to be attempted n times with nothing left pending.
+func waitAttempts(t *testing.T, store Store, w *Webhook, n int) []Attempt {
+	t.Helper()
+
+	ctx := context.Background()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		attempts, _ := store.Log(ctx, w.ID)
+		pending, _ := store.Pending(ctx)
+		if len(attempts) >= n && len(pending) == 0 {
+			return attempts
+		}
+	}
+	t.Fatalf("webhook not attempted %d times", n)
+
+	return nil
+}
+
+func newStore(t *testing.T) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore("")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+
+	return store
+}
+
+func TestDispatcherEvents(t *testing.T) {
+	alice, bob := user.NewNamed("Alice"), user.NewNamed("Bob")
+	msg, err := chat.NewMessage(alice, "hello")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	edited := *msg
+	edited.Content, edited.Edited = "hello world", msg.Time.Add(time.Minute)
+	now := time.Now().UTC()
+
+	tests := []struct {
+		event chat.Event
+		want  EventType
+		// wantData is a field expected in the data of the event.
+		wantData string
+	}{
+		{event: chat.MessageEvent{Message: msg}, want: EventMessageCreated, wantData: "content"},
+		{event: chat.MessageEditedEvent{Message: &edited}, want: EventMessageEdited, wantData: "edited"},
+		{event: chat.MessageDeletedEvent{Message: msg, By: bob, Time: now}, want: EventMessageDeleted, wantData: "message_id"},
+		{event: chat.JoinEvent{User: alice, Time: now}, want: EventUserJoined, wantData: "user"},
+		{event: chat.LeaveEvent{User: alice, Time: now}, want: EventUserLeft, wantData: "user"},
+		{event: chat.ModerationEvent{Action: chat.ModerationMute, Target: alice, Moderator: bob, Duration: time.Minute, Time: now}, want: EventModerationMute, wantData: "duration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.want), func(t *testing.T) {
+			store := newStore(t)
+			r := newReceiver(t, http.StatusOK)
+			w := r.register(t, store)
+			d := run(t, store)
+
+			d.Observer("general")(context.Background(), tt.event)
+			waitAttempts(t, store, w, 1)
+
+			events := r.received()
+			if len(events) != 1 || events[0].Type != tt.want || events[0].Room != "general" {
+				t.Fatalf("received %+v, want one %s event", events, tt.want)
+			}
+			var data map[string]any
+			if err := json.Unmarshal(events[0].Data, &data); err != nil {
+				t.Fatalf("decode data: %v", err)
+			}
+			if _, found := data[tt.wantData]; !found {
+				t.Fatalf("data %s has no %s", events[0].Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantDead     bool
+	}{
+		{name: "delivered", statuses: []int{http.StatusNoContent}, wantAttempts: 1},
+		{name: "retried on 5xx", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, wantAttempts: 3},
+		{name: "retried on 4xx", statuses: []int{http.StatusNotFound, http.StatusOK}, wantAttempts: 2},
+		{name: "dead after max attempts", statuses: []int{http.StatusServiceUnavailable}, wantAttempts: 3, wantDead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			r := newReceiver(t, tt.statuses...)
+			w := r.register(t, store)
+			d := run(t, store)
+
+			d.Observer("general")(context.Background(), chat.JoinEvent{User: user.NewNamed("Alice"), Time: time.Now()})
+			attempts := waitAttempts(t, store, w, tt.wantAttempts)
+			if len(attempts) != tt.wantAttempts {
+				t.Fatalf("got %d attempts, want %d", len(attempts), tt.wantAttempts)
+			}
+
+			// The log is the newest first.
+			for i, a := range attempts {
+				want := tt.statuses[min(tt.wantAttempts-i, len(tt.statuses))-1]
+				if a.Status != want || a.Number != tt.wantAttempts-i {
+					t.Fatalf("attempt %d got status %d, want %d", a.Number, a.Status, want)
+				}
+			}
+
+			dead, _ := store.Dead(context.Background())
+			if (len(dead) == 1) != tt.wantDead {
+				t.Fatalf("got %d dead deliveries, want dead %t", len(dead), tt.wantDead)
+			}
+			if tt.wantDead && (dead[0].Attempts != tt.wantAttempts || dead[0].LastStatus != http.StatusServiceUnavailable) {
+				t.Fatalf("dead delivery has %d attempts and status %d", dead[0].Attempts, dead[0].LastStatus)
+			}
+		})
+	}
+}
+
+func TestDispatcherFilters(t *testing.T) {
+	store := newStore(t)
+	r := newReceiver(t, http.StatusOK)
+	w := r.register(t, store, EventMessageDeleted)
+	d := run(t, store)
+
+	msg, err := chat.NewMessage(user.NewNamed("Alice"), "hello")
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	deleted := chat.MessageDeletedEvent{Message: msg, By: msg.User, Time: time.Now()}
+
+	// Events are queued in order: once the last one is delivered, the others were dropped.
+	ctx := context.Background()
+	d.Observer("general")(ctx, chat.MessageEvent{Message: msg})
+	d.Observer("random")(ctx, deleted)
+	d.Observer("general")(ctx, chat.TypingEvent{User: msg.User})
+	d.Observer("general")(ctx, deleted)
+	waitAttempts(t, store, w, 1)
+
+	if events := r.received(); len(events) != 1 || events[0].Type != EventMessageDeleted {
+		t.Fatalf("received %+v, want one %s event", events, EventMessageDeleted)
+	}
+}
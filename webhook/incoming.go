@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/enescakir/emoji"
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+// maxIconLength is the maximum number of runes of an icon (some emojis are made of several runes).
+const maxIconLength = 8
+
+// List of incoming webhook errors.
+var (
+	ErrIncomingNotFound = errors.New("incoming webhook not found")
+	ErrDisabled         = errors.New("incoming webhook is disabled")
+	ErrInvalidIcon      = errors.New("icon must be a single emoji (e.g. \":robot:\")")
+)
+
+// Incoming is a secret URL posting messages into a room.
+// Only the hash of its secret is kept.
+type Incoming struct {
+	ID   xid.ID
+	Room string
+	// Name is the name of the messages, unless overridden by the payload.
+	Name string
+	// Icon is the icon of the messages, unless overridden by the payload.
+	Icon      string
+	Hash      []byte
+	Disabled  bool
+	CreatedBy xid.ID
+	CreatedAt time.Time
+	RotatedAt time.Time
+}
+
+// NewIncoming creates a new Incoming webhook.
+// The returned secret is the only time the secret of the webhook is available.
+func NewIncoming(room, name, icon string, createdBy xid.ID) (*Incoming, string, error) {
+	name, err := user.NormalizeName(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if icon, err = NormalizeIcon(icon); err != nil {
+		return nil, "", err
+	}
+
+	in := &Incoming{
+		ID:        xid.New(),
+		Room:      room,
+		Name:      name,
+		Icon:      icon,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	secret, err := in.Rotate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return in, secret, nil
+}
+
+// Rotate replaces the secret of the webhook, the previous URL stops working right away.
+func (in *Incoming) Rotate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate incoming webhook secret: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	in.Hash = hashSecret(secret)
+	in.RotatedAt = time.Now().UTC()
+
+	return secret, nil
+}
+
+// Verify checks the secret of a request posting through the webhook.
+func (in *Incoming) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare(in.Hash, hashSecret(secret)) == 1
+}
+
+// User returns the chat user of a message posted through the webhook
+// with the name and icon of the payload, if any.
+func (in *Incoming) User(p Payload) (*user.User, error) {
+	u := &user.User{
+		ID:      in.ID,
+		Name:    in.Name,
+		Role:    user.RoleMember,
+		Webhook: true,
+		Icon:    in.Icon,
+	}
+
+	if p.Username != "" {
+		name, err := user.NormalizeName(p.Username)
+		if err != nil {
+			return nil, err
+		}
+		u.Name = name
+	}
+
+	if p.IconEmoji != "" {
+		icon, err := NormalizeIcon(p.IconEmoji)
+		if err != nil {
+			return nil, err
+		}
+		u.Icon = icon
+	}
+
+	return u, nil
+}
+
+// NormalizeIcon converts an emoji code (e.g. ":robot:") to its emoji
+// and checks that the icon is a single emoji.
+func NormalizeIcon(icon string) (string, error) {
+	icon = strings.TrimSpace(emoji.Parse(strings.TrimSpace(icon)))
+	if icon == "" {
+		return "", nil
+	}
+
+	// Keycaps (e.g. "1️⃣") are the only emojis with ASCII runes.
+	ascii := func(r rune) bool { return r < unicode.MaxASCII }
+	keycap := func(r rune) bool { return unicode.IsDigit(r) || r == '#' || r == '*' }
+	if len([]rune(icon)) > maxIconLength ||
+		!strings.ContainsFunc(icon, func(r rune) bool { return !ascii(r) }) ||
+		strings.ContainsFunc(icon, func(r rune) bool { return ascii(r) && !keycap(r) }) {
+		return "", ErrInvalidIcon
+	}
+
+	return icon, nil
+}
+
+// Payload is the body of a request posting through an incoming webhook.
+// It is a subset of the payload of Slack incoming webhooks.
+type Payload struct {
+	Text      string `json:"text"`
+	Username  string `json:"username"`
+	IconEmoji string `json:"icon_emoji"`
+	// IconURL is accepted for compatibility but ignored: the chat only shows emojis.
+	IconURL string  `json:"icon_url"`
+	Blocks  []Block `json:"blocks"`
+}
+
+// Block is a Slack layout block. Only the text of header, section,
+// context and divider blocks is kept, other blocks are ignored.
+type Block struct {
+	Type     string       `json:"type"`
+	Text     *TextObject  `json:"text,omitempty"`
+	Fields   []TextObject `json:"fields,omitempty"`
+	Elements []TextObject `json:"elements,omitempty"`
+}
+
+// TextObject is a Slack text object (plain_text or mrkdwn).
+// Context elements which are not text (e.g. images) have no text.
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Content returns the content of the message of the payload.
+// Like Slack, the text is only a fallback when blocks have some text.
+func (p Payload) Content() string {
+	var (
+		lines   []string
+		hasText bool
+	)
+	add := func(text string) {
+		if text != "" {
+			lines = append(lines, text)
+			hasText = true
+		}
+	}
+
+	for _, b := range p.Blocks {
+		switch b.Type {
+		case "header", "section":
+			if b.Text != nil {
+				add(b.Text.Text)
+			}
+			for _, f := range b.Fields {
+				add(f.Text)
+			}
+		case "context":
+			var texts []string
+			for _, e := range b.Elements {
+				if e.Text != "" {
+					texts = append(texts, e.Text)
+				}
+			}
+			add(strings.Join(texts, " "))
+		case "divider":
+			lines = append(lines, "---")
+		}
+	}
+
+	if !hasText {
+		return p.Text
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// IncomingStore persists incoming webhooks.
+type IncomingStore interface {
+	// CreateIncoming adds a new incoming webhook.
+	CreateIncoming(ctx context.Context, in *Incoming) error
+	// GetIncoming finds an incoming webhook by ID.
+	GetIncoming(ctx context.Context, id xid.ID) (*Incoming, error)
+	// ListIncoming returns all the incoming webhooks.
+	ListIncoming(ctx context.Context) ([]*Incoming, error)
+	// UpdateIncoming replaces an existing incoming webhook.
+	UpdateIncoming(ctx context.Context, in *Incoming) error
+	// DeleteIncoming removes an incoming webhook.
+	DeleteIncoming(ctx context.Context, id xid.ID) error
+}
+
+// hashSecret hashes the secret of an incoming webhook. Secrets are random so a fast hash is enough.
+func hashSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mgjules/chat-demo/user"
+	"github.com/rs/xid"
+)
+
+func TestNewIncoming(t *testing.T) {
+	tests := []struct {
+		name     string
+		hookName string
+		icon     string
+		wantIcon string
+		wantErr  error
+	}{
+		{name: "no icon", hookName: "Deploys"},
+		{name: "emoji code", hookName: "Deploys", icon: " :rocket: ", wantIcon: "🚀"},
+		{name: "emoji", hookName: "Deploys", icon: "🤖", wantIcon: "🤖"},
+		{name: "keycap", hookName: "Deploys", icon: "1️⃣", wantIcon: "1️⃣"},
+		{name: "text icon", hookName: "Deploys", icon: "bot", wantErr: ErrInvalidIcon},
+		{name: "unknown emoji code", hookName: "Deploys", icon: ":nope:", wantErr: ErrInvalidIcon},
+		{name: "emoji with text", hookName: "Deploys", icon: "🚀 go", wantErr: ErrInvalidIcon},
+		{name: "several emojis", hookName: "Deploys", icon: "🚀🚀🚀🚀🚀🚀🚀🚀🚀", wantErr: ErrInvalidIcon},
+		{name: "reserved name", hookName: "System", wantErr: user.ErrNameReserved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, secret, err := NewIncoming("general", tt.hookName, tt.icon, xid.New())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if in.Icon != tt.wantIcon {
+				t.Fatalf("got icon %q, want %q", in.Icon, tt.wantIcon)
+			}
+			if !in.Verify(secret) || in.Verify(secret+"x") || in.Verify("") {
+				t.Fatal("secret not verified")
+			}
+		})
+	}
+}
+
+func TestIncomingRotate(t *testing.T) {
+	in, secret, err := NewIncoming("general", "Deploys", "", xid.New())
+	if err != nil {
+		t.Fatalf("create incoming webhook: %v", err)
+	}
+	created := in.RotatedAt
+
+	rotated, err := in.Rotate()
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if in.Verify(secret) || !in.Verify(rotated) {
+		t.Fatal("previous secret still verified")
+	}
+	if in.RotatedAt.Before(created) {
+		t.Fatalf("rotated at %v, before %v", in.RotatedAt, created)
+	}
+}
+
+func TestIncomingUser(t *testing.T) {
+	in, _, err := NewIncoming("general", "Deploys", ":rocket:", xid.New())
+	if err != nil {
+		t.Fatalf("create incoming webhook: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		payload  Payload
+		wantName string
+		wantIcon string
+		wantErr  error
+	}{
+		{name: "defaults", wantName: "Deploys", wantIcon: "🚀"},
+		{name: "username", payload: Payload{Username: " CI  Bot "}, wantName: "CI Bot", wantIcon: "🚀"},
+		{name: "icon", payload: Payload{IconEmoji: ":robot:"}, wantName: "Deploys", wantIcon: "🤖"},
+		{name: "icon url ignored", payload: Payload{IconURL: "https://example.com/icon.png"}, wantName: "Deploys", wantIcon: "🚀"},
+		{name: "reserved username", payload: Payload{Username: "admin"}, wantErr: user.ErrNameReserved},
+		{name: "invalid icon", payload: Payload{IconEmoji: "robot"}, wantErr: ErrInvalidIcon},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := in.User(tt.payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if u.Name != tt.wantName || u.Icon != tt.wantIcon {
+				t.Fatalf("got user %q with icon %q, want %q with %q", u.Name, u.Icon, tt.wantName, tt.wantIcon)
+			}
+			if !u.Webhook || u.Bot || u.ID != in.ID || u.Role != user.RoleMember {
+				t.Fatalf("got user %+v", u)
+			}
+		})
+	}
+}
+
+func TestPayloadContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    string
+	}{
+		{name: "text", payload: Payload{Text: "deployed"}, want: "deployed"},
+		{
+			name: "blocks",
+			payload: Payload{
+				Text: "fallback",
+				Blocks: []Block{
+					{Type: "header", Text: &TextObject{Type: "plain_text", Text: "Deploy"}},
+					{Type: "section", Text: &TextObject{Type: "mrkdwn", Text: "*v1.2.0*"}, Fields: []TextObject{{Text: "prod"}, {Text: "ok"}}},
+					{Type: "divider"},
+					{Type: "context", Elements: []TextObject{{Type: "image"}, {Text: "by"}, {Text: "Alice"}}},
+					{Type: "actions", Elements: []TextObject{{Text: "Rollback"}}},
+				},
+			},
+			want: "Deploy\n*v1.2.0*\nprod\nok\n---\nby Alice",
+		},
+		{
+			name:    "blocks without text",
+			payload: Payload{Text: "fallback", Blocks: []Block{{Type: "divider"}, {Type: "image"}}},
+			want:    "fallback",
+		},
+		{name: "empty", payload: Payload{}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.payload.Content(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncomingStore(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	in, _, err := NewIncoming("general", "Deploys", "", xid.New())
+	if err != nil {
+		t.Fatalf("create incoming webhook: %v", err)
+	}
+	if err := s.CreateIncoming(ctx, in); err != nil {
+		t.Fatalf("store incoming webhook: %v", err)
+	}
+
+	// Incoming webhooks are copied so that changes are only kept through UpdateIncoming.
+	got, err := s.GetIncoming(ctx, in.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	got.Disabled = true
+	if stored, _ := s.GetIncoming(ctx, in.ID); stored.Disabled {
+		t.Fatal("incoming webhook changed without an update")
+	}
+	if err := s.UpdateIncoming(ctx, got); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if stored, _ := s.GetIncoming(ctx, in.ID); !stored.Disabled {
+		t.Fatal("incoming webhook not updated")
+	}
+
+	tests := []struct {
+		name    string
+		call    func(id xid.ID) error
+		wantErr error
+	}{
+		{name: "get", call: func(id xid.ID) error { _, err := s.GetIncoming(ctx, id); return err }},
+		{name: "update", call: func(id xid.ID) error { return s.UpdateIncoming(ctx, &Incoming{ID: id}) }},
+		{name: "delete", call: func(id xid.ID) error { return s.DeleteIncoming(ctx, id) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" unknown", func(t *testing.T) {
+			if err := tt.call(xid.New()); !errors.Is(err, ErrIncomingNotFound) {
+				t.Fatalf("got error %v, want %v", err, ErrIncomingNotFound)
+			}
+		})
+	}
+
+	if err := s.DeleteIncoming(ctx, in.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if incoming, _ := s.ListIncoming(ctx); len(incoming) != 0 {
+		t.Fatalf("got %d incoming webhooks after the deletion", len(incoming))
+	}
+}
@@ -0,0 +1,464 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// maxLog is the number of delivery attempts kept in the log.
+	maxLog = 1000
+	// maxDead is the number of dead deliveries kept, the oldest being dropped first.
+	maxDead = 1000
+	// maxPending is the number of deliveries queued by webhook, lest a webhook
+	// failing for hours piles up every event of its room meanwhile.
+	maxPending = 1000
+	// saveDelay batches the changes of the deliveries made meanwhile into a single write.
+	saveDelay = time.Second
+)
+
+// State is the state of a delivery after an attempt.
+type State uint8
+
+// List of delivery states.
+const (
+	// StatePending deliveries are retried later.
+	StatePending State = iota
+	// StateDone deliveries were accepted by their webhook.
+	StateDone
+	// StateDead deliveries ran out of attempts and moved to the dead letters.
+	StateDead
+)
+
+// Delivery is an event queued for a webhook.
+type Delivery struct {
+	ID          xid.ID
+	WebhookID   xid.ID
+	Event       EventType
+	Body        []byte
+	Attempts    int
+	NextAttempt time.Time
+	LastStatus  int
+	LastError   string
+	CreatedAt   time.Time
+}
+
+// Attempt is an entry of the delivery log.
+type Attempt struct {
+	DeliveryID xid.ID
+	WebhookID  xid.ID
+	Event      EventType
+	Number     int
+	// Status is the status code of the response, zero if there was none.
+	Status   int
+	Error    string
+	Duration time.Duration
+	Time     time.Time
+}
+
+// Store persists webhooks along with their delivery queue, dead letters and log.
+type Store interface {
+	// Create adds a new webhook.
+	Create(ctx context.Context, w *Webhook) error
+	// Get finds a webhook by ID.
+	Get(ctx context.Context, id xid.ID) (*Webhook, error)
+	// List returns all the webhooks.
+	List(ctx context.Context) ([]*Webhook, error)
+	// Delete removes a webhook and its pending deliveries.
+	Delete(ctx context.Context, id xid.ID) error
+
+	// Enqueue adds deliveries to the queue.
+	Enqueue(ctx context.Context, ds ...*Delivery) error
+	// Pending returns the queued deliveries.
+	Pending(ctx context.Context) ([]*Delivery, error)
+	// Record logs an attempt of a delivery and updates it according to its new state.
+	Record(ctx context.Context, d *Delivery, a Attempt, s State) error
+	// Dead returns the deliveries which ran out of attempts.
+	Dead(ctx context.Context) ([]*Delivery, error)
+	// Requeue moves a dead delivery back to the queue for another round of attempts.
+	Requeue(ctx context.Context, id xid.ID) (*Delivery, error)
+	// Log returns the latest attempts of the deliveries of a webhook, the newest first.
+	Log(ctx context.Context, webhookID xid.ID) ([]Attempt, error)
+}
+
+// state is the content of the file of a FileStore.
+type state struct {
+	Webhooks []*Webhook
+	Pending  []*Delivery
+	Dead     []*Delivery
+	Log      []Attempt
+	Incoming []*Incoming
+}
+
+// FileStore is a Store and an IncomingStore which keeps its state
+// in memory and persists it as a JSON file on every change.
+//
+// Changes of the deliveries, as frequent as the events of the rooms, are written
+// at most every saveDelay: those made right before a crash are lost, at worst
+// delivering events twice or not at all.
+type FileStore struct {
+	mu    sync.RWMutex
+	path  string
+	state state
+	// dirty is whether the state has changes not saved yet.
+	dirty bool
+	// timer saves the changes batched meanwhile, nil if none is scheduled.
+	timer *time.Timer
+}
+
+var (
+	_ Store         = (*FileStore)(nil)
+	_ IncomingStore = (*FileStore)(nil)
+)
+
+// NewFileStore creates a new FileStore loading the existing state from path.
+// The file is created on the first change if it does not exist.
+// An empty path keeps the state in memory only.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read webhooks file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("decode webhooks file: %w", err)
+	}
+
+	return s, nil
+}
+
+// Create implements the Store interface.
+func (s *FileStore) Create(_ context.Context, w *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := *w
+	cp.Events = slices.Clone(w.Events)
+	s.state.Webhooks = append(s.state.Webhooks, &cp)
+
+	return s.save()
+}
+
+// Get implements the Store interface.
+func (s *FileStore) Get(_ context.Context, id xid.ID) (*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := slices.IndexFunc(s.state.Webhooks, func(w *Webhook) bool { return w.ID == id })
+	if i == -1 {
+		return nil, ErrNotFound
+	}
+
+	cp := *s.state.Webhooks[i]
+	cp.Events = slices.Clone(cp.Events)
+	return &cp, nil
+}
+
+// List implements the Store interface.
+func (s *FileStore) List(_ context.Context) ([]*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(s.state.Webhooks))
+	for _, w := range s.state.Webhooks {
+		cp := *w
+		cp.Events = slices.Clone(w.Events)
+		webhooks = append(webhooks, &cp)
+	}
+
+	return webhooks, nil
+}
+
+// Delete implements the Store interface.
+func (s *FileStore) Delete(_ context.Context, id xid.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Webhooks, func(w *Webhook) bool { return w.ID == id })
+	if i == -1 {
+		return ErrNotFound
+	}
+	s.state.Webhooks = slices.Delete(s.state.Webhooks, i, i+1)
+	s.state.Pending = slices.DeleteFunc(s.state.Pending, func(d *Delivery) bool { return d.WebhookID == id })
+
+	return s.save()
+}
+
+// Enqueue implements the Store interface.
+func (s *FileStore) Enqueue(_ context.Context, ds ...*Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := make(map[xid.ID]int)
+	for _, d := range s.state.Pending {
+		queued[d.WebhookID]++
+	}
+
+	var err error
+	for _, d := range ds {
+		if queued[d.WebhookID] >= maxPending {
+			err = fmt.Errorf("%w: %s", ErrQueueFull, d.WebhookID)
+			continue
+		}
+		queued[d.WebhookID]++
+
+		cp := *d
+		s.state.Pending = append(s.state.Pending, &cp)
+	}
+	s.saveLater()
+
+	return err
+}
+
+// Pending implements the Store interface.
+func (s *FileStore) Pending(_ context.Context) ([]*Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return cloneDeliveries(s.state.Pending), nil
+}
+
+// Record implements the Store interface.
+func (s *FileStore) Record(_ context.Context, d *Delivery, a Attempt, st State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Log = append(s.state.Log, a)
+	if len(s.state.Log) > maxLog {
+		s.state.Log = slices.Delete(s.state.Log, 0, len(s.state.Log)-maxLog)
+	}
+
+	defer s.saveLater()
+
+	// The webhook may have been deleted during the attempt.
+	i := slices.IndexFunc(s.state.Pending, func(p *Delivery) bool { return p.ID == d.ID })
+	if i == -1 {
+		return nil
+	}
+
+	cp := *d
+	switch st {
+	case StatePending:
+		s.state.Pending[i] = &cp
+	case StateDone:
+		s.state.Pending = slices.Delete(s.state.Pending, i, i+1)
+	case StateDead:
+		s.state.Pending = slices.Delete(s.state.Pending, i, i+1)
+		s.state.Dead = append(s.state.Dead, &cp)
+		if len(s.state.Dead) > maxDead {
+			s.state.Dead = slices.Delete(s.state.Dead, 0, len(s.state.Dead)-maxDead)
+		}
+	}
+
+	return nil
+}
+
+// Dead implements the Store interface.
+func (s *FileStore) Dead(_ context.Context) ([]*Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return cloneDeliveries(s.state.Dead), nil
+}
+
+// Requeue implements the Store interface.
+func (s *FileStore) Requeue(_ context.Context, id xid.ID) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Dead, func(d *Delivery) bool { return d.ID == id })
+	if i == -1 {
+		return nil, ErrDeliveryNotFound
+	}
+
+	d := s.state.Dead[i]
+	if !slices.ContainsFunc(s.state.Webhooks, func(w *Webhook) bool { return w.ID == d.WebhookID }) {
+		return nil, ErrNotFound
+	}
+
+	s.state.Dead = slices.Delete(s.state.Dead, i, i+1)
+	d.Attempts = 0
+	d.NextAttempt = time.Now().UTC()
+	s.state.Pending = append(s.state.Pending, d)
+
+	cp := *d
+	return &cp, s.save()
+}
+
+// Log implements the Store interface.
+func (s *FileStore) Log(_ context.Context, webhookID xid.ID) ([]Attempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attempts := make([]Attempt, 0)
+	for i := len(s.state.Log) - 1; i >= 0; i-- {
+		if s.state.Log[i].WebhookID == webhookID {
+			attempts = append(attempts, s.state.Log[i])
+		}
+	}
+
+	return attempts, nil
+}
+
+// CreateIncoming implements the IncomingStore interface.
+func (s *FileStore) CreateIncoming(_ context.Context, in *Incoming) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := *in
+	s.state.Incoming = append(s.state.Incoming, &cp)
+
+	return s.save()
+}
+
+// GetIncoming implements the IncomingStore interface.
+func (s *FileStore) GetIncoming(_ context.Context, id xid.ID) (*Incoming, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := slices.IndexFunc(s.state.Incoming, func(in *Incoming) bool { return in.ID == id })
+	if i == -1 {
+		return nil, ErrIncomingNotFound
+	}
+
+	cp := *s.state.Incoming[i]
+	return &cp, nil
+}
+
+// ListIncoming implements the IncomingStore interface.
+func (s *FileStore) ListIncoming(_ context.Context) ([]*Incoming, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	incoming := make([]*Incoming, 0, len(s.state.Incoming))
+	for _, in := range s.state.Incoming {
+		cp := *in
+		incoming = append(incoming, &cp)
+	}
+
+	return incoming, nil
+}
+
+// UpdateIncoming implements the IncomingStore interface.
+func (s *FileStore) UpdateIncoming(_ context.Context, in *Incoming) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Incoming, func(e *Incoming) bool { return e.ID == in.ID })
+	if i == -1 {
+		return ErrIncomingNotFound
+	}
+
+	cp := *in
+	s.state.Incoming[i] = &cp
+
+	return s.save()
+}
+
+// DeleteIncoming implements the IncomingStore interface.
+func (s *FileStore) DeleteIncoming(_ context.Context, id xid.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Incoming, func(in *Incoming) bool { return in.ID == id })
+	if i == -1 {
+		return ErrIncomingNotFound
+	}
+	s.state.Incoming = slices.Delete(s.state.Incoming, i, i+1)
+
+	return s.save()
+}
+
+func cloneDeliveries(ds []*Delivery) []*Delivery {
+	cp := make([]*Delivery, 0, len(ds))
+	for _, d := range ds {
+		dc := *d
+		cp = append(cp, &dc)
+	}
+
+	return cp
+}
+
+// saveLater schedules the saving of the state, unless already scheduled.
+// It must be called with the store locked.
+func (s *FileStore) saveLater() {
+	if s.path == "" {
+		return
+	}
+
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(saveDelay, func() {
+			if err := s.flush(); err != nil {
+				slog.Error("save webhooks", "err", err)
+			}
+		})
+	}
+}
+
+// flush saves the changes not saved yet, if any.
+func (s *FileStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = nil
+	if !s.dirty {
+		return nil
+	}
+
+	return s.save()
+}
+
+// save writes the state to a temporary file and renames it
+// so that the webhooks file is never partially written.
+// It must be called with the store locked.
+func (s *FileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode webhooks: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary webhooks file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary webhooks file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary webhooks file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename temporary webhooks file: %w", err)
+	}
+	s.dirty = false
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func TestFileStoreCaps(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// fill changes the store beyond its caps.
+		fill        func(s *FileStore, hookID xid.ID) error
+		wantErr     error
+		wantPending int
+		wantDead    int
+	}{
+		{
+			name: "pending by webhook",
+			fill: func(s *FileStore, hookID xid.ID) error {
+				for i := 0; i < maxPending; i++ {
+					if err := s.Enqueue(ctx, &Delivery{ID: xid.New(), WebhookID: hookID}); err != nil {
+						return err
+					}
+				}
+				// Other webhooks still get their deliveries.
+				return s.Enqueue(ctx, &Delivery{ID: xid.New(), WebhookID: hookID}, &Delivery{ID: xid.New(), WebhookID: xid.New()})
+			},
+			wantErr:     ErrQueueFull,
+			wantPending: maxPending + 1,
+		},
+		{
+			name: "dead letters",
+			fill: func(s *FileStore, hookID xid.ID) error {
+				for i := 0; i < maxDead+10; i++ {
+					d := &Delivery{ID: xid.New(), WebhookID: hookID}
+					if err := s.Enqueue(ctx, d); err != nil {
+						return err
+					}
+					if err := s.Record(ctx, d, Attempt{DeliveryID: d.ID, WebhookID: hookID}, StateDead); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantDead: maxDead,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			if err := tt.fill(s, xid.New()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			pending, _ := s.Pending(ctx)
+			dead, _ := s.Dead(ctx)
+			if len(pending) != tt.wantPending || len(dead) != tt.wantDead {
+				t.Fatalf("got %d pending and %d dead deliveries, want %d and %d", len(pending), len(dead), tt.wantPending, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestFileStoreSave(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		change func(s *FileStore) error
+		// loaded reports whether the change is found in a store loaded from the same file.
+		loaded func(s *FileStore) bool
+		// wantBatched is whether the change is only written once flushed.
+		wantBatched bool
+	}{
+		{
+			name:   "webhook",
+			change: func(s *FileStore) error { return s.Create(ctx, &Webhook{ID: xid.New()}) },
+			loaded: func(s *FileStore) bool { webhooks, _ := s.List(ctx); return len(webhooks) == 1 },
+		},
+		{
+			name:        "delivery",
+			change:      func(s *FileStore) error { return s.Enqueue(ctx, &Delivery{ID: xid.New()}) },
+			loaded:      func(s *FileStore) bool { pending, _ := s.Pending(ctx); return len(pending) == 1 },
+			wantBatched: true,
+		},
+		{
+			name: "attempt",
+			change: func(s *FileStore) error {
+				return s.Record(ctx, &Delivery{ID: xid.New()}, Attempt{WebhookID: xid.ID{1}}, StateDone)
+			},
+			loaded:      func(s *FileStore) bool { attempts, _ := s.Log(ctx, xid.ID{1}); return len(attempts) == 1 },
+			wantBatched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "webhooks.json")
+			s, err := NewFileStore(path)
+			if err != nil {
+				t.Fatalf("create store: %v", err)
+			}
+			t.Cleanup(func() { s.flush() })
+
+			if err := tt.change(s); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+
+			load := func() bool {
+				t.Helper()
+
+				loaded, err := NewFileStore(path)
+				if err != nil {
+					t.Fatalf("load store: %v", err)
+				}
+				return tt.loaded(loaded)
+			}
+			if got := load(); got == tt.wantBatched {
+				t.Fatalf("got change saved %t before the flush, want %t", got, !tt.wantBatched)
+			}
+
+			if err := s.flush(); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+			if !load() {
+				t.Fatal("change not saved once flushed")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// List of headers of the deliveries.
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+// signaturePrefix prefixes the hex encoded signature to name its algorithm.
+const signaturePrefix = "sha256="
+
+// List of webhook errors.
+var (
+	ErrNotFound         = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrQueueFull        = errors.New("webhook delivery queue full")
+	ErrInvalidURL       = errors.New("webhook url must be an absolute http or https url")
+	ErrUnknownEvent     = errors.New("unknown event type")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredSignature = errors.New("webhook signature timestamp is too old")
+)
+
+// EventType is the type of the events sent to webhooks.
+type EventType string
+
+// List of event types.
+const (
+	EventMessageCreated   EventType = "message.created"
+	EventMessageEdited    EventType = "message.edited"
+	EventMessageDeleted   EventType = "message.deleted"
+	EventUserJoined       EventType = "user.joined"
+	EventUserLeft         EventType = "user.left"
+	EventModerationKick   EventType = "moderation.kick"
+	EventModerationMute   EventType = "moderation.mute"
+	EventModerationUnmute EventType = "moderation.unmute"
+)
+
+// EventTypes is the list of all event types.
+var EventTypes = []EventType{
+	EventMessageCreated,
+	EventMessageEdited,
+	EventMessageDeleted,
+	EventUserJoined,
+	EventUserLeft,
+	EventModerationKick,
+	EventModerationMute,
+	EventModerationUnmute,
+}
+
+// Webhook is a URL receiving the events of a room.
+type Webhook struct {
+	ID   xid.ID
+	Room string
+	URL  string
+	// Secret signs the deliveries so that receivers can check they come from the chat.
+	Secret string
+	// Events filters the events sent to the webhook, all events when empty.
+	Events    []EventType
+	CreatedBy xid.ID
+	CreatedAt time.Time
+}
+
+// New creates a new Webhook with a random secret.
+func New(room, rawURL string, events []EventType, createdBy xid.ID) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	for _, e := range events {
+		if !slices.Contains(EventTypes, e) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, e)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate webhook secret: %w", err)
+	}
+
+	return &Webhook{
+		ID:        xid.New(),
+		Room:      room,
+		URL:       u.String(),
+		Secret:    base64.RawURLEncoding.EncodeToString(secret),
+		Events:    events,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Accepts checks if the webhook receives the events of a type.
+func (w *Webhook) Accepts(t EventType) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, t)
+}
+
+// Event is the body of a delivery.
+type Event struct {
+	ID   string          `json:"id"`
+	Type EventType       `json:"type"`
+	Room string          `json:"room"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Sign computes the signature of a delivery body sent at a timestamp.
+// The timestamp is signed along with the body to prevent replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery received by a webhook.
+// Deliveries signed more than tolerance ago are rejected.
+func Verify(secret string, h http.Header, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(h.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	timestamp := time.Unix(unix, 0)
+	if time.Since(timestamp) > tolerance {
+		return ErrExpiredSignature
+	}
+
+	signature := h.Get(HeaderSignature)
+	if !strings.HasPrefix(signature, signaturePrefix) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"message.created"}`)
+	now := time.Now()
+
+	// signed returns the headers of a delivery sent at a time.
+	signed := func(secret string, at time.Time, body []byte) http.Header {
+		h := http.Header{}
+		h.Set(HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		h.Set(HeaderSignature, Sign(secret, at, body))
+		return h
+	}
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		wantErr error
+	}{
+		{name: "valid", header: signed("secret", now, body)},
+		{name: "other secret", header: signed("other", now, body), wantErr: ErrInvalidSignature},
+		{name: "other body", header: signed("secret", now, []byte(`{}`)), wantErr: ErrInvalidSignature},
+		{name: "expired", header: signed("secret", now.Add(-10*time.Minute), body), wantErr: ErrExpiredSignature},
+		{
+			name: "replayed with a new timestamp",
+			header: func() http.Header {
+				h := signed("secret", now.Add(-time.Minute), body)
+				h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+				return h
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "missing algorithm",
+			header: func() http.Header {
+				h := signed("secret", now, body)
+				h.Set(HeaderSignature, h.Get(HeaderSignature)[len(signaturePrefix):])
+				return h
+			}(),
+			wantErr: ErrInvalidSignature,
+		},
+		{name: "missing headers", header: http.Header{}, wantErr: ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify("secret", tt.header, body, 5*time.Minute); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		events  []EventType
+		wantErr error
+	}{
+		{name: "all events", url: "https://example.com/hook"},
+		{name: "some events", url: "http://localhost:9000", events: []EventType{EventMessageEdited, EventMessageDeleted}},
+		{name: "unknown event", url: "https://example.com/hook", events: []EventType{"message.pinned"}, wantErr: ErrUnknownEvent},
+		{name: "relative url", url: "/hook", wantErr: ErrInvalidURL},
+		{name: "other scheme", url: "ftp://example.com/hook", wantErr: ErrInvalidURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New("general", tt.url, tt.events, xid.New())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && w.Secret == "" {
+				t.Fatal("webhook has no secret")
+			}
+		})
+	}
+}